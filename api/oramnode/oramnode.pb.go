@@ -334,6 +334,116 @@ func (x *JoinRaftVoterReply) GetSuccess() bool {
 	return false
 }
 
+type AddStorageRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	StorageId int32  `protobuf:"varint,1,opt,name=storage_id,json=storageId,proto3" json:"storage_id,omitempty"`
+	Ip        string `protobuf:"bytes,2,opt,name=ip,proto3" json:"ip,omitempty"`
+	Port      int32  `protobuf:"varint,3,opt,name=port,proto3" json:"port,omitempty"`
+}
+
+func (x *AddStorageRequest) Reset() {
+	*x = AddStorageRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_oramnode_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AddStorageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddStorageRequest) ProtoMessage() {}
+
+func (x *AddStorageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_oramnode_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddStorageRequest.ProtoReflect.Descriptor instead.
+func (*AddStorageRequest) Descriptor() ([]byte, []int) {
+	return file_oramnode_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *AddStorageRequest) GetStorageId() int32 {
+	if x != nil {
+		return x.StorageId
+	}
+	return 0
+}
+
+func (x *AddStorageRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *AddStorageRequest) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+type AddStorageReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Success bool `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+}
+
+func (x *AddStorageReply) Reset() {
+	*x = AddStorageReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_oramnode_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AddStorageReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddStorageReply) ProtoMessage() {}
+
+func (x *AddStorageReply) ProtoReflect() protoreflect.Message {
+	mi := &file_oramnode_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddStorageReply.ProtoReflect.Descriptor instead.
+func (*AddStorageReply) Descriptor() ([]byte, []int) {
+	return file_oramnode_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *AddStorageReply) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
 var File_oramnode_proto protoreflect.FileDescriptor
 
 var file_oramnode_proto_rawDesc = []byte{
@@ -364,21 +474,34 @@ var file_oramnode_proto_rawDesc = []byte{
 	0x01, 0x28, 0x09, 0x52, 0x08, 0x6e, 0x6f, 0x64, 0x65, 0x41, 0x64, 0x64, 0x72, 0x22, 0x2e, 0x0a,
 	0x12, 0x4a, 0x6f, 0x69, 0x6e, 0x52, 0x61, 0x66, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x72, 0x52, 0x65,
 	0x70, 0x6c, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x32, 0x9d, 0x01,
-	0x0a, 0x08, 0x4f, 0x72, 0x61, 0x6d, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x40, 0x0a, 0x08, 0x52, 0x65,
-	0x61, 0x64, 0x50, 0x61, 0x74, 0x68, 0x12, 0x19, 0x2e, 0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64,
-	0x65, 0x2e, 0x52, 0x65, 0x61, 0x64, 0x50, 0x61, 0x74, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
-	0x74, 0x1a, 0x17, 0x2e, 0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65, 0x2e, 0x52, 0x65, 0x61,
-	0x64, 0x50, 0x61, 0x74, 0x68, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x4f, 0x0a, 0x0d,
-	0x4a, 0x6f, 0x69, 0x6e, 0x52, 0x61, 0x66, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x72, 0x12, 0x1e, 0x2e,
-	0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65, 0x2e, 0x4a, 0x6f, 0x69, 0x6e, 0x52, 0x61, 0x66,
-	0x74, 0x56, 0x6f, 0x74, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e,
-	0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65, 0x2e, 0x4a, 0x6f, 0x69, 0x6e, 0x52, 0x61, 0x66,
-	0x74, 0x56, 0x6f, 0x74, 0x65, 0x72, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x42, 0x31, 0x5a,
-	0x2f, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x64, 0x73, 0x67, 0x2d,
-	0x75, 0x77, 0x61, 0x74, 0x65, 0x72, 0x6c, 0x6f, 0x6f, 0x2f, 0x6f, 0x62, 0x6c, 0x69, 0x73, 0x68,
-	0x61, 0x72, 0x64, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65,
-	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x56, 0x0a,
+	0x11, 0x41, 0x64, 0x64, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x5f, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x49,
+	0x64, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69,
+	0x70, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x04, 0x70, 0x6f, 0x72, 0x74, 0x22, 0x2b, 0x0a, 0x0f, 0x41, 0x64, 0x64, 0x53, 0x74, 0x6f, 0x72,
+	0x61, 0x67, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x75, 0x63, 0x63,
+	0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65,
+	0x73, 0x73, 0x32, 0xe5, 0x01, 0x0a, 0x08, 0x4f, 0x72, 0x61, 0x6d, 0x4e, 0x6f, 0x64, 0x65, 0x12,
+	0x40, 0x0a, 0x08, 0x52, 0x65, 0x61, 0x64, 0x50, 0x61, 0x74, 0x68, 0x12, 0x19, 0x2e, 0x6f, 0x72,
+	0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65, 0x2e, 0x52, 0x65, 0x61, 0x64, 0x50, 0x61, 0x74, 0x68, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64,
+	0x65, 0x2e, 0x52, 0x65, 0x61, 0x64, 0x50, 0x61, 0x74, 0x68, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22,
+	0x00, 0x12, 0x4f, 0x0a, 0x0d, 0x4a, 0x6f, 0x69, 0x6e, 0x52, 0x61, 0x66, 0x74, 0x56, 0x6f, 0x74,
+	0x65, 0x72, 0x12, 0x1e, 0x2e, 0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65, 0x2e, 0x4a, 0x6f,
+	0x69, 0x6e, 0x52, 0x61, 0x66, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65, 0x2e, 0x4a, 0x6f,
+	0x69, 0x6e, 0x52, 0x61, 0x66, 0x74, 0x56, 0x6f, 0x74, 0x65, 0x72, 0x52, 0x65, 0x70, 0x6c, 0x79,
+	0x22, 0x00, 0x12, 0x46, 0x0a, 0x0a, 0x41, 0x64, 0x64, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65,
+	0x12, 0x1b, 0x2e, 0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65, 0x2e, 0x41, 0x64, 0x64, 0x53,
+	0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x19, 0x2e,
+	0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65, 0x2e, 0x41, 0x64, 0x64, 0x53, 0x74, 0x6f, 0x72,
+	0x61, 0x67, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x42, 0x31, 0x5a, 0x2f, 0x67, 0x69,
+	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x64, 0x73, 0x67, 0x2d, 0x75, 0x77, 0x61,
+	0x74, 0x65, 0x72, 0x6c, 0x6f, 0x6f, 0x2f, 0x74, 0x72, 0x65, 0x65, 0x62, 0x65, 0x61, 0x72, 0x64,
+	0x2f, 0x61, 0x70, 0x69, 0x2f, 0x6f, 0x72, 0x61, 0x6d, 0x6e, 0x6f, 0x64, 0x65, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -393,7 +516,7 @@ func file_oramnode_proto_rawDescGZIP() []byte {
 	return file_oramnode_proto_rawDescData
 }
 
-var file_oramnode_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_oramnode_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_oramnode_proto_goTypes = []interface{}{
 	(*BlockRequest)(nil),         // 0: oramnode.BlockRequest
 	(*ReadPathRequest)(nil),      // 1: oramnode.ReadPathRequest
@@ -401,16 +524,20 @@ var file_oramnode_proto_goTypes = []interface{}{
 	(*ReadPathReply)(nil),        // 3: oramnode.ReadPathReply
 	(*JoinRaftVoterRequest)(nil), // 4: oramnode.JoinRaftVoterRequest
 	(*JoinRaftVoterReply)(nil),   // 5: oramnode.JoinRaftVoterReply
+	(*AddStorageRequest)(nil),    // 6: oramnode.AddStorageRequest
+	(*AddStorageReply)(nil),      // 7: oramnode.AddStorageReply
 }
 var file_oramnode_proto_depIdxs = []int32{
 	0, // 0: oramnode.ReadPathRequest.requests:type_name -> oramnode.BlockRequest
 	2, // 1: oramnode.ReadPathReply.responses:type_name -> oramnode.BlockResponse
 	1, // 2: oramnode.OramNode.ReadPath:input_type -> oramnode.ReadPathRequest
 	4, // 3: oramnode.OramNode.JoinRaftVoter:input_type -> oramnode.JoinRaftVoterRequest
-	3, // 4: oramnode.OramNode.ReadPath:output_type -> oramnode.ReadPathReply
-	5, // 5: oramnode.OramNode.JoinRaftVoter:output_type -> oramnode.JoinRaftVoterReply
-	4, // [4:6] is the sub-list for method output_type
-	2, // [2:4] is the sub-list for method input_type
+	6, // 4: oramnode.OramNode.AddStorage:input_type -> oramnode.AddStorageRequest
+	3, // 5: oramnode.OramNode.ReadPath:output_type -> oramnode.ReadPathReply
+	5, // 6: oramnode.OramNode.JoinRaftVoter:output_type -> oramnode.JoinRaftVoterReply
+	7, // 7: oramnode.OramNode.AddStorage:output_type -> oramnode.AddStorageReply
+	5, // [5:8] is the sub-list for method output_type
+	2, // [2:5] is the sub-list for method input_type
 	2, // [2:2] is the sub-list for extension type_name
 	2, // [2:2] is the sub-list for extension extendee
 	0, // [0:2] is the sub-list for field type_name
@@ -494,6 +621,30 @@ func file_oramnode_proto_init() {
 				return nil
 			}
 		}
+		file_oramnode_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AddStorageRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_oramnode_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AddStorageReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -501,7 +652,7 @@ func file_oramnode_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_oramnode_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   8,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -21,6 +21,7 @@ const _ = grpc.SupportPackageIsVersion7
 const (
 	OramNode_ReadPath_FullMethodName      = "/oramnode.OramNode/ReadPath"
 	OramNode_JoinRaftVoter_FullMethodName = "/oramnode.OramNode/JoinRaftVoter"
+	OramNode_AddStorage_FullMethodName    = "/oramnode.OramNode/AddStorage"
 )
 
 // OramNodeClient is the client API for OramNode service.
@@ -29,6 +30,7 @@ const (
 type OramNodeClient interface {
 	ReadPath(ctx context.Context, in *ReadPathRequest, opts ...grpc.CallOption) (*ReadPathReply, error)
 	JoinRaftVoter(ctx context.Context, in *JoinRaftVoterRequest, opts ...grpc.CallOption) (*JoinRaftVoterReply, error)
+	AddStorage(ctx context.Context, in *AddStorageRequest, opts ...grpc.CallOption) (*AddStorageReply, error)
 }
 
 type oramNodeClient struct {
@@ -57,12 +59,22 @@ func (c *oramNodeClient) JoinRaftVoter(ctx context.Context, in *JoinRaftVoterReq
 	return out, nil
 }
 
+func (c *oramNodeClient) AddStorage(ctx context.Context, in *AddStorageRequest, opts ...grpc.CallOption) (*AddStorageReply, error) {
+	out := new(AddStorageReply)
+	err := c.cc.Invoke(ctx, OramNode_AddStorage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // OramNodeServer is the server API for OramNode service.
 // All implementations must embed UnimplementedOramNodeServer
 // for forward compatibility
 type OramNodeServer interface {
 	ReadPath(context.Context, *ReadPathRequest) (*ReadPathReply, error)
 	JoinRaftVoter(context.Context, *JoinRaftVoterRequest) (*JoinRaftVoterReply, error)
+	AddStorage(context.Context, *AddStorageRequest) (*AddStorageReply, error)
 	mustEmbedUnimplementedOramNodeServer()
 }
 
@@ -76,6 +88,9 @@ func (UnimplementedOramNodeServer) ReadPath(context.Context, *ReadPathRequest) (
 func (UnimplementedOramNodeServer) JoinRaftVoter(context.Context, *JoinRaftVoterRequest) (*JoinRaftVoterReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method JoinRaftVoter not implemented")
 }
+func (UnimplementedOramNodeServer) AddStorage(context.Context, *AddStorageRequest) (*AddStorageReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddStorage not implemented")
+}
 func (UnimplementedOramNodeServer) mustEmbedUnimplementedOramNodeServer() {}
 
 // UnsafeOramNodeServer may be embedded to opt out of forward compatibility for this service.
@@ -125,6 +140,24 @@ func _OramNode_JoinRaftVoter_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _OramNode_AddStorage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddStorageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OramNodeServer).AddStorage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OramNode_AddStorage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OramNodeServer).AddStorage(ctx, req.(*AddStorageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // OramNode_ServiceDesc is the grpc.ServiceDesc for OramNode service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -140,6 +173,10 @@ var OramNode_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "JoinRaftVoter",
 			Handler:    _OramNode_JoinRaftVoter_Handler,
 		},
+		{
+			MethodName: "AddStorage",
+			Handler:    _OramNode_AddStorage_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "oramnode.proto",
@@ -19,8 +19,18 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Router_Read_FullMethodName  = "/router.Router/Read"
-	Router_Write_FullMethodName = "/router.Router/Write"
+	Router_Read_FullMethodName           = "/router.Router/Read"
+	Router_Write_FullMethodName          = "/router.Router/Write"
+	Router_AddShardNode_FullMethodName   = "/router.Router/AddShardNode"
+	Router_AddStorage_FullMethodName     = "/router.Router/AddStorage"
+	Router_Transaction_FullMethodName    = "/router.Router/Transaction"
+	Router_BatchRead_FullMethodName      = "/router.Router/BatchRead"
+	Router_BatchWrite_FullMethodName     = "/router.Router/BatchWrite"
+	Router_Stream_FullMethodName         = "/router.Router/Stream"
+	Router_Delete_FullMethodName         = "/router.Router/Delete"
+	Router_Exists_FullMethodName         = "/router.Router/Exists"
+	Router_CompareAndSwap_FullMethodName = "/router.Router/CompareAndSwap"
+	Router_Scan_FullMethodName           = "/router.Router/Scan"
 )
 
 // RouterClient is the client API for Router service.
@@ -29,6 +39,16 @@ const (
 type RouterClient interface {
 	Read(ctx context.Context, in *ReadRequest, opts ...grpc.CallOption) (*ReadReply, error)
 	Write(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteReply, error)
+	AddShardNode(ctx context.Context, in *AddShardNodeRequest, opts ...grpc.CallOption) (*AddShardNodeReply, error)
+	AddStorage(ctx context.Context, in *AddStorageRequest, opts ...grpc.CallOption) (*AddStorageReply, error)
+	Transaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*TransactionReply, error)
+	BatchRead(ctx context.Context, in *BatchReadRequest, opts ...grpc.CallOption) (*BatchReadReply, error)
+	BatchWrite(ctx context.Context, in *BatchWriteRequest, opts ...grpc.CallOption) (*BatchWriteReply, error)
+	Stream(ctx context.Context, opts ...grpc.CallOption) (Router_StreamClient, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteReply, error)
+	Exists(ctx context.Context, in *ExistsRequest, opts ...grpc.CallOption) (*ExistsReply, error)
+	CompareAndSwap(ctx context.Context, in *CompareAndSwapRequest, opts ...grpc.CallOption) (*CompareAndSwapReply, error)
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanReply, error)
 }
 
 type routerClient struct {
@@ -57,12 +77,134 @@ func (c *routerClient) Write(ctx context.Context, in *WriteRequest, opts ...grpc
 	return out, nil
 }
 
+func (c *routerClient) AddShardNode(ctx context.Context, in *AddShardNodeRequest, opts ...grpc.CallOption) (*AddShardNodeReply, error) {
+	out := new(AddShardNodeReply)
+	err := c.cc.Invoke(ctx, Router_AddShardNode_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) AddStorage(ctx context.Context, in *AddStorageRequest, opts ...grpc.CallOption) (*AddStorageReply, error) {
+	out := new(AddStorageReply)
+	err := c.cc.Invoke(ctx, Router_AddStorage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) Transaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*TransactionReply, error) {
+	out := new(TransactionReply)
+	err := c.cc.Invoke(ctx, Router_Transaction_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) BatchRead(ctx context.Context, in *BatchReadRequest, opts ...grpc.CallOption) (*BatchReadReply, error) {
+	out := new(BatchReadReply)
+	err := c.cc.Invoke(ctx, Router_BatchRead_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) BatchWrite(ctx context.Context, in *BatchWriteRequest, opts ...grpc.CallOption) (*BatchWriteReply, error) {
+	out := new(BatchWriteReply)
+	err := c.cc.Invoke(ctx, Router_BatchWrite_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) Stream(ctx context.Context, opts ...grpc.CallOption) (Router_StreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[0], Router_Stream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &routerStreamClient{stream}
+	return x, nil
+}
+
+type Router_StreamClient interface {
+	Send(*StreamRequest) error
+	Recv() (*StreamReply, error)
+	grpc.ClientStream
+}
+
+type routerStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *routerStreamClient) Send(m *StreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *routerStreamClient) Recv() (*StreamReply, error) {
+	m := new(StreamReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *routerClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteReply, error) {
+	out := new(DeleteReply)
+	err := c.cc.Invoke(ctx, Router_Delete_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) Exists(ctx context.Context, in *ExistsRequest, opts ...grpc.CallOption) (*ExistsReply, error) {
+	out := new(ExistsReply)
+	err := c.cc.Invoke(ctx, Router_Exists_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) CompareAndSwap(ctx context.Context, in *CompareAndSwapRequest, opts ...grpc.CallOption) (*CompareAndSwapReply, error) {
+	out := new(CompareAndSwapReply)
+	err := c.cc.Invoke(ctx, Router_CompareAndSwap_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanReply, error) {
+	out := new(ScanReply)
+	err := c.cc.Invoke(ctx, Router_Scan_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RouterServer is the server API for Router service.
 // All implementations must embed UnimplementedRouterServer
 // for forward compatibility
 type RouterServer interface {
 	Read(context.Context, *ReadRequest) (*ReadReply, error)
 	Write(context.Context, *WriteRequest) (*WriteReply, error)
+	AddShardNode(context.Context, *AddShardNodeRequest) (*AddShardNodeReply, error)
+	AddStorage(context.Context, *AddStorageRequest) (*AddStorageReply, error)
+	Transaction(context.Context, *TransactionRequest) (*TransactionReply, error)
+	BatchRead(context.Context, *BatchReadRequest) (*BatchReadReply, error)
+	BatchWrite(context.Context, *BatchWriteRequest) (*BatchWriteReply, error)
+	Stream(Router_StreamServer) error
+	Delete(context.Context, *DeleteRequest) (*DeleteReply, error)
+	Exists(context.Context, *ExistsRequest) (*ExistsReply, error)
+	CompareAndSwap(context.Context, *CompareAndSwapRequest) (*CompareAndSwapReply, error)
+	Scan(context.Context, *ScanRequest) (*ScanReply, error)
 	mustEmbedUnimplementedRouterServer()
 }
 
@@ -76,6 +218,36 @@ func (UnimplementedRouterServer) Read(context.Context, *ReadRequest) (*ReadReply
 func (UnimplementedRouterServer) Write(context.Context, *WriteRequest) (*WriteReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Write not implemented")
 }
+func (UnimplementedRouterServer) AddShardNode(context.Context, *AddShardNodeRequest) (*AddShardNodeReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddShardNode not implemented")
+}
+func (UnimplementedRouterServer) AddStorage(context.Context, *AddStorageRequest) (*AddStorageReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddStorage not implemented")
+}
+func (UnimplementedRouterServer) Transaction(context.Context, *TransactionRequest) (*TransactionReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Transaction not implemented")
+}
+func (UnimplementedRouterServer) BatchRead(context.Context, *BatchReadRequest) (*BatchReadReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchRead not implemented")
+}
+func (UnimplementedRouterServer) BatchWrite(context.Context, *BatchWriteRequest) (*BatchWriteReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchWrite not implemented")
+}
+func (UnimplementedRouterServer) Stream(Router_StreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method Stream not implemented")
+}
+func (UnimplementedRouterServer) Delete(context.Context, *DeleteRequest) (*DeleteReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedRouterServer) Exists(context.Context, *ExistsRequest) (*ExistsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Exists not implemented")
+}
+func (UnimplementedRouterServer) CompareAndSwap(context.Context, *CompareAndSwapRequest) (*CompareAndSwapReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompareAndSwap not implemented")
+}
+func (UnimplementedRouterServer) Scan(context.Context, *ScanRequest) (*ScanReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
 func (UnimplementedRouterServer) mustEmbedUnimplementedRouterServer() {}
 
 // UnsafeRouterServer may be embedded to opt out of forward compatibility for this service.
@@ -125,6 +297,194 @@ func _Router_Write_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Router_AddShardNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddShardNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).AddShardNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Router_AddShardNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).AddShardNode(ctx, req.(*AddShardNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_AddStorage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddStorageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).AddStorage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Router_AddStorage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).AddStorage(ctx, req.(*AddStorageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_Transaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).Transaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Router_Transaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).Transaction(ctx, req.(*TransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_BatchRead_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchReadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).BatchRead(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Router_BatchRead_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).BatchRead(ctx, req.(*BatchReadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_BatchWrite_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchWriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).BatchWrite(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Router_BatchWrite_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).BatchWrite(ctx, req.(*BatchWriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RouterServer).Stream(&routerStreamServer{stream})
+}
+
+type Router_StreamServer interface {
+	Send(*StreamReply) error
+	Recv() (*StreamRequest, error)
+	grpc.ServerStream
+}
+
+type routerStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *routerStreamServer) Send(m *StreamReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *routerStreamServer) Recv() (*StreamRequest, error) {
+	m := new(StreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Router_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Router_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_Exists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExistsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).Exists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Router_Exists_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).Exists(ctx, req.(*ExistsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_CompareAndSwap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareAndSwapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).CompareAndSwap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Router_CompareAndSwap_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).CompareAndSwap(ctx, req.(*CompareAndSwapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_Scan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).Scan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Router_Scan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).Scan(ctx, req.(*ScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Router_ServiceDesc is the grpc.ServiceDesc for Router service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -140,7 +500,50 @@ var Router_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Write",
 			Handler:    _Router_Write_Handler,
 		},
+		{
+			MethodName: "AddShardNode",
+			Handler:    _Router_AddShardNode_Handler,
+		},
+		{
+			MethodName: "AddStorage",
+			Handler:    _Router_AddStorage_Handler,
+		},
+		{
+			MethodName: "Transaction",
+			Handler:    _Router_Transaction_Handler,
+		},
+		{
+			MethodName: "BatchRead",
+			Handler:    _Router_BatchRead_Handler,
+		},
+		{
+			MethodName: "BatchWrite",
+			Handler:    _Router_BatchWrite_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Router_Delete_Handler,
+		},
+		{
+			MethodName: "Exists",
+			Handler:    _Router_Exists_Handler,
+		},
+		{
+			MethodName: "CompareAndSwap",
+			Handler:    _Router_CompareAndSwap_Handler,
+		},
+		{
+			MethodName: "Scan",
+			Handler:    _Router_Scan_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       _Router_Stream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "router.proto",
 }
@@ -19,10 +19,18 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	ShardNode_BatchQuery_FullMethodName    = "/shardnode.ShardNode/BatchQuery"
-	ShardNode_SendBlocks_FullMethodName    = "/shardnode.ShardNode/SendBlocks"
-	ShardNode_AckSentBlocks_FullMethodName = "/shardnode.ShardNode/AckSentBlocks"
-	ShardNode_JoinRaftVoter_FullMethodName = "/shardnode.ShardNode/JoinRaftVoter"
+	ShardNode_BatchQuery_FullMethodName            = "/shardnode.ShardNode/BatchQuery"
+	ShardNode_SendBlocks_FullMethodName            = "/shardnode.ShardNode/SendBlocks"
+	ShardNode_AckSentBlocks_FullMethodName         = "/shardnode.ShardNode/AckSentBlocks"
+	ShardNode_JoinRaftVoter_FullMethodName         = "/shardnode.ShardNode/JoinRaftVoter"
+	ShardNode_MigrateBlocks_FullMethodName         = "/shardnode.ShardNode/MigrateBlocks"
+	ShardNode_ReceiveMigratedBlocks_FullMethodName = "/shardnode.ShardNode/ReceiveMigratedBlocks"
+	ShardNode_FinishMigration_FullMethodName       = "/shardnode.ShardNode/FinishMigration"
+	ShardNode_DecideMigration_FullMethodName       = "/shardnode.ShardNode/DecideMigration"
+	ShardNode_AddStorage_FullMethodName            = "/shardnode.ShardNode/AddStorage"
+	ShardNode_DecideTransactions_FullMethodName    = "/shardnode.ShardNode/DecideTransactions"
+	ShardNode_DecideTransaction_FullMethodName     = "/shardnode.ShardNode/DecideTransaction"
+	ShardNode_Scan_FullMethodName                  = "/shardnode.ShardNode/Scan"
 )
 
 // ShardNodeClient is the client API for ShardNode service.
@@ -33,6 +41,14 @@ type ShardNodeClient interface {
 	SendBlocks(ctx context.Context, in *SendBlocksRequest, opts ...grpc.CallOption) (*SendBlocksReply, error)
 	AckSentBlocks(ctx context.Context, in *AckSentBlocksRequest, opts ...grpc.CallOption) (*AckSentBlocksReply, error)
 	JoinRaftVoter(ctx context.Context, in *JoinRaftVoterRequest, opts ...grpc.CallOption) (*JoinRaftVoterReply, error)
+	MigrateBlocks(ctx context.Context, in *MigrateBlocksRequest, opts ...grpc.CallOption) (ShardNode_MigrateBlocksClient, error)
+	ReceiveMigratedBlocks(ctx context.Context, opts ...grpc.CallOption) (ShardNode_ReceiveMigratedBlocksClient, error)
+	FinishMigration(ctx context.Context, in *FinishMigrationRequest, opts ...grpc.CallOption) (*FinishMigrationReply, error)
+	DecideMigration(ctx context.Context, in *DecideMigrationRequest, opts ...grpc.CallOption) (*DecideMigrationReply, error)
+	AddStorage(ctx context.Context, in *AddStorageRequest, opts ...grpc.CallOption) (*AddStorageReply, error)
+	DecideTransactions(ctx context.Context, in *TransactionDecisions, opts ...grpc.CallOption) (*DecideTransactionsReply, error)
+	DecideTransaction(ctx context.Context, in *DecideTransactionRequest, opts ...grpc.CallOption) (*DecideTransactionReply, error)
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanReply, error)
 }
 
 type shardNodeClient struct {
@@ -79,6 +95,126 @@ func (c *shardNodeClient) JoinRaftVoter(ctx context.Context, in *JoinRaftVoterRe
 	return out, nil
 }
 
+func (c *shardNodeClient) MigrateBlocks(ctx context.Context, in *MigrateBlocksRequest, opts ...grpc.CallOption) (ShardNode_MigrateBlocksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ShardNode_ServiceDesc.Streams[0], ShardNode_MigrateBlocks_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &shardNodeMigrateBlocksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ShardNode_MigrateBlocksClient interface {
+	Recv() (*MigratedBlock, error)
+	grpc.ClientStream
+}
+
+type shardNodeMigrateBlocksClient struct {
+	grpc.ClientStream
+}
+
+func (x *shardNodeMigrateBlocksClient) Recv() (*MigratedBlock, error) {
+	m := new(MigratedBlock)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *shardNodeClient) ReceiveMigratedBlocks(ctx context.Context, opts ...grpc.CallOption) (ShardNode_ReceiveMigratedBlocksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ShardNode_ServiceDesc.Streams[1], ShardNode_ReceiveMigratedBlocks_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &shardNodeReceiveMigratedBlocksClient{stream}
+	return x, nil
+}
+
+type ShardNode_ReceiveMigratedBlocksClient interface {
+	Send(*MigratedBlock) error
+	CloseAndRecv() (*ReceiveMigratedBlocksReply, error)
+	grpc.ClientStream
+}
+
+type shardNodeReceiveMigratedBlocksClient struct {
+	grpc.ClientStream
+}
+
+func (x *shardNodeReceiveMigratedBlocksClient) Send(m *MigratedBlock) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *shardNodeReceiveMigratedBlocksClient) CloseAndRecv() (*ReceiveMigratedBlocksReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ReceiveMigratedBlocksReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *shardNodeClient) FinishMigration(ctx context.Context, in *FinishMigrationRequest, opts ...grpc.CallOption) (*FinishMigrationReply, error) {
+	out := new(FinishMigrationReply)
+	err := c.cc.Invoke(ctx, ShardNode_FinishMigration_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardNodeClient) DecideMigration(ctx context.Context, in *DecideMigrationRequest, opts ...grpc.CallOption) (*DecideMigrationReply, error) {
+	out := new(DecideMigrationReply)
+	err := c.cc.Invoke(ctx, ShardNode_DecideMigration_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardNodeClient) AddStorage(ctx context.Context, in *AddStorageRequest, opts ...grpc.CallOption) (*AddStorageReply, error) {
+	out := new(AddStorageReply)
+	err := c.cc.Invoke(ctx, ShardNode_AddStorage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardNodeClient) DecideTransactions(ctx context.Context, in *TransactionDecisions, opts ...grpc.CallOption) (*DecideTransactionsReply, error) {
+	out := new(DecideTransactionsReply)
+	err := c.cc.Invoke(ctx, ShardNode_DecideTransactions_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardNodeClient) DecideTransaction(ctx context.Context, in *DecideTransactionRequest, opts ...grpc.CallOption) (*DecideTransactionReply, error) {
+	out := new(DecideTransactionReply)
+	err := c.cc.Invoke(ctx, ShardNode_DecideTransaction_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardNodeClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanReply, error) {
+	out := new(ScanReply)
+	err := c.cc.Invoke(ctx, ShardNode_Scan_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ShardNodeServer is the server API for ShardNode service.
 // All implementations must embed UnimplementedShardNodeServer
 // for forward compatibility
@@ -87,6 +223,14 @@ type ShardNodeServer interface {
 	SendBlocks(context.Context, *SendBlocksRequest) (*SendBlocksReply, error)
 	AckSentBlocks(context.Context, *AckSentBlocksRequest) (*AckSentBlocksReply, error)
 	JoinRaftVoter(context.Context, *JoinRaftVoterRequest) (*JoinRaftVoterReply, error)
+	MigrateBlocks(*MigrateBlocksRequest, ShardNode_MigrateBlocksServer) error
+	ReceiveMigratedBlocks(ShardNode_ReceiveMigratedBlocksServer) error
+	FinishMigration(context.Context, *FinishMigrationRequest) (*FinishMigrationReply, error)
+	DecideMigration(context.Context, *DecideMigrationRequest) (*DecideMigrationReply, error)
+	AddStorage(context.Context, *AddStorageRequest) (*AddStorageReply, error)
+	DecideTransactions(context.Context, *TransactionDecisions) (*DecideTransactionsReply, error)
+	DecideTransaction(context.Context, *DecideTransactionRequest) (*DecideTransactionReply, error)
+	Scan(context.Context, *ScanRequest) (*ScanReply, error)
 	mustEmbedUnimplementedShardNodeServer()
 }
 
@@ -106,6 +250,30 @@ func (UnimplementedShardNodeServer) AckSentBlocks(context.Context, *AckSentBlock
 func (UnimplementedShardNodeServer) JoinRaftVoter(context.Context, *JoinRaftVoterRequest) (*JoinRaftVoterReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method JoinRaftVoter not implemented")
 }
+func (UnimplementedShardNodeServer) MigrateBlocks(*MigrateBlocksRequest, ShardNode_MigrateBlocksServer) error {
+	return status.Errorf(codes.Unimplemented, "method MigrateBlocks not implemented")
+}
+func (UnimplementedShardNodeServer) ReceiveMigratedBlocks(ShardNode_ReceiveMigratedBlocksServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReceiveMigratedBlocks not implemented")
+}
+func (UnimplementedShardNodeServer) FinishMigration(context.Context, *FinishMigrationRequest) (*FinishMigrationReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FinishMigration not implemented")
+}
+func (UnimplementedShardNodeServer) DecideMigration(context.Context, *DecideMigrationRequest) (*DecideMigrationReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DecideMigration not implemented")
+}
+func (UnimplementedShardNodeServer) AddStorage(context.Context, *AddStorageRequest) (*AddStorageReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddStorage not implemented")
+}
+func (UnimplementedShardNodeServer) DecideTransactions(context.Context, *TransactionDecisions) (*DecideTransactionsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DecideTransactions not implemented")
+}
+func (UnimplementedShardNodeServer) DecideTransaction(context.Context, *DecideTransactionRequest) (*DecideTransactionReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DecideTransaction not implemented")
+}
+func (UnimplementedShardNodeServer) Scan(context.Context, *ScanRequest) (*ScanReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
 func (UnimplementedShardNodeServer) mustEmbedUnimplementedShardNodeServer() {}
 
 // UnsafeShardNodeServer may be embedded to opt out of forward compatibility for this service.
@@ -191,6 +359,161 @@ func _ShardNode_JoinRaftVoter_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ShardNode_MigrateBlocks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(MigrateBlocksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ShardNodeServer).MigrateBlocks(m, &shardNodeMigrateBlocksServer{stream})
+}
+
+type ShardNode_MigrateBlocksServer interface {
+	Send(*MigratedBlock) error
+	grpc.ServerStream
+}
+
+type shardNodeMigrateBlocksServer struct {
+	grpc.ServerStream
+}
+
+func (x *shardNodeMigrateBlocksServer) Send(m *MigratedBlock) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ShardNode_ReceiveMigratedBlocks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ShardNodeServer).ReceiveMigratedBlocks(&shardNodeReceiveMigratedBlocksServer{stream})
+}
+
+type ShardNode_ReceiveMigratedBlocksServer interface {
+	SendAndClose(*ReceiveMigratedBlocksReply) error
+	Recv() (*MigratedBlock, error)
+	grpc.ServerStream
+}
+
+type shardNodeReceiveMigratedBlocksServer struct {
+	grpc.ServerStream
+}
+
+func (x *shardNodeReceiveMigratedBlocksServer) SendAndClose(m *ReceiveMigratedBlocksReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *shardNodeReceiveMigratedBlocksServer) Recv() (*MigratedBlock, error) {
+	m := new(MigratedBlock)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ShardNode_FinishMigration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FinishMigrationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardNodeServer).FinishMigration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardNode_FinishMigration_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardNodeServer).FinishMigration(ctx, req.(*FinishMigrationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShardNode_DecideMigration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecideMigrationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardNodeServer).DecideMigration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardNode_DecideMigration_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardNodeServer).DecideMigration(ctx, req.(*DecideMigrationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShardNode_AddStorage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddStorageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardNodeServer).AddStorage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardNode_AddStorage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardNodeServer).AddStorage(ctx, req.(*AddStorageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShardNode_DecideTransactions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransactionDecisions)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardNodeServer).DecideTransactions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardNode_DecideTransactions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardNodeServer).DecideTransactions(ctx, req.(*TransactionDecisions))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShardNode_DecideTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecideTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardNodeServer).DecideTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardNode_DecideTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardNodeServer).DecideTransaction(ctx, req.(*DecideTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShardNode_Scan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardNodeServer).Scan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardNode_Scan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardNodeServer).Scan(ctx, req.(*ScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ShardNode_ServiceDesc is the grpc.ServiceDesc for ShardNode service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -214,7 +537,42 @@ var ShardNode_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "JoinRaftVoter",
 			Handler:    _ShardNode_JoinRaftVoter_Handler,
 		},
+		{
+			MethodName: "FinishMigration",
+			Handler:    _ShardNode_FinishMigration_Handler,
+		},
+		{
+			MethodName: "DecideMigration",
+			Handler:    _ShardNode_DecideMigration_Handler,
+		},
+		{
+			MethodName: "AddStorage",
+			Handler:    _ShardNode_AddStorage_Handler,
+		},
+		{
+			MethodName: "DecideTransactions",
+			Handler:    _ShardNode_DecideTransactions_Handler,
+		},
+		{
+			MethodName: "DecideTransaction",
+			Handler:    _ShardNode_DecideTransaction_Handler,
+		},
+		{
+			MethodName: "Scan",
+			Handler:    _ShardNode_Scan_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "MigrateBlocks",
+			Handler:       _ShardNode_MigrateBlocks_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReceiveMigratedBlocks",
+			Handler:       _ShardNode_ReceiveMigratedBlocks_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "shardnode.proto",
 }
@@ -23,27 +23,24 @@ func main() {
 	duration := flag.Int("duration", 10, "duration of the experiment in seconds")
 	outputFilePath := flag.String("output", "", "output file path")
 	flag.Parse()
-	parameters, err := config.ReadParameters(path.Join(*configsPath, "parameters.yaml"))
+	cluster, err := config.LoadClusterConfig(*configsPath)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read the configs from %s; %v\n", *configsPath, err)
 		os.Exit(1)
 	}
+	parameters := cluster.Parameters
 
 	utils.InitLogging(parameters.Log, *logPath)
-
-	routerEndpoints, err := config.ReadRouterEndpoints(path.Join(*configsPath, "router_endpoints.yaml"))
-	if err != nil {
-		log.Fatal().Msgf("Cannot read router endpoints from yaml file; %v", err)
-	}
-
-	redisEndpoints, err := config.ReadRedisEndpoints(path.Join(*configsPath, "redis_endpoints.yaml"))
+	err = parameters.Validate()
 	if err != nil {
-		log.Fatal().Msgf("Cannot read redis endpoints from yaml file; %v", err)
+		log.Fatal().Msgf("Invalid parameters; %v", err)
 	}
 
-	rpcClients, err := client.StartRouterRPCClients(routerEndpoints)
+	routers, err := client.StartRouterClient(cluster.Routers)
 	if err != nil {
 		log.Fatal().Msgf("Failed to start clients; %v", err)
 	}
+	defer routers.Close()
 
 	requests, err := client.ReadTraceFile(path.Join(*configsPath, "trace.txt"), parameters.BlockSize)
 	if err != nil {
@@ -62,8 +59,8 @@ func main() {
 
 	tracer := otel.Tracer("")
 
-	c := client.NewClient(client.NewRateLimit(parameters.MaxRequests), tracer, rpcClients, requests)
-	err = c.WaitForStorageToBeReady(redisEndpoints, parameters)
+	c := client.NewClient(client.NewRateLimit(parameters.MaxRequests), tracer, routers, requests)
+	err = c.WaitForStorageToBeReady(cluster.Redis, parameters)
 	if err != nil {
 		log.Fatal().Msgf("Failed to check if storages are ready; %v", err)
 	}
@@ -5,7 +5,6 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"path"
 
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
 	oramnode "github.com/dsg-uwaterloo/treebeard/pkg/oramnode"
@@ -26,30 +25,29 @@ func main() {
 	joinAddr := flag.String("joinaddr", "", "the address of the initial raft node, which bootstraped the cluster")
 	configsPath := flag.String("conf", "../../configs/default", "configs directory path")
 	logPath := flag.String("logpath", "", "path to write logs")
+	faultInjection := flag.Bool("faults", false, "enable fault injection with the fault rules of the configs and the admin rpcs, only for tests and failure experiments")
 	flag.Parse()
-	parameters, err := config.ReadParameters(path.Join(*configsPath, "parameters.yaml"))
+	cluster, err := config.LoadClusterConfig(*configsPath)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read the configs from %s; %v\n", *configsPath, err)
 		os.Exit(1)
 	}
+	parameters := cluster.Parameters
 	utils.InitLogging(parameters.Log, *logPath)
+	err = parameters.Validate()
+	if err != nil {
+		log.Fatal().Msgf("Invalid parameters; %v", err)
+	}
 	if *rpcPort == 0 {
 		log.Fatal().Msgf("The rpc port should be provided with the -rpcport flag")
 	}
 	if *raftPort == 0 {
 		log.Fatal().Msgf("The raft port should be provided with the -raftport flag")
 	}
-	shardNodeEndpoints, err := config.ReadShardNodeEndpoints(path.Join(*configsPath, "shardnode_endpoints.yaml"))
-	if err != nil {
-		log.Fatal().Msgf("Cannot read shard node endpoints from yaml file; %v", err)
-	}
-	rpcClients, err := oramnode.StartShardNodeRPCClients(shardNodeEndpoints)
+	rpcClients, err := oramnode.StartShardNodeRPCClients(cluster.ShardNodes)
 	if err != nil {
 		log.Fatal().Msgf("Failed to create client connections with shard node servers; %v", err)
 	}
-	redisEndpoints, err := config.ReadRedisEndpoints(path.Join(*configsPath, "redis_endpoints.yaml"))
-	if err != nil {
-		log.Fatal().Msgf("Cannot read redis endpoints from yaml file; %v", err)
-	}
 
 	tracingProvider, err := tracing.NewProvider(context.Background(), "oramnode", "localhost:4317", !parameters.Trace)
 	if err != nil {
@@ -68,5 +66,5 @@ func main() {
 		defer cpuProfile.Stop()
 	}
 
-	oramnode.StartServer(*oramNodeID, *bindIP, *advIP, *rpcPort, *replicaID, *raftPort, *joinAddr, rpcClients, redisEndpoints, parameters)
+	oramnode.StartServer(*oramNodeID, *bindIP, *advIP, *rpcPort, *replicaID, *raftPort, *joinAddr, rpcClients, cluster.Redis, parameters, config.ParametersPath(*configsPath), cluster.Faults, *faultInjection)
 }
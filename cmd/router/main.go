@@ -5,7 +5,6 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"path"
 
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
 	"github.com/dsg-uwaterloo/treebeard/pkg/profile"
@@ -24,20 +23,22 @@ func main() {
 	configsPath := flag.String("conf", "../../configs/default", "configs directory path")
 	logPath := flag.String("logpath", "", "path to write the logs")
 	flag.Parse()
-	parameters, err := config.ReadParameters(path.Join(*configsPath, "parameters.yaml"))
+	cluster, err := config.LoadClusterConfig(*configsPath)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read the configs from %s; %v\n", *configsPath, err)
 		os.Exit(1)
 	}
+	parameters := cluster.Parameters
 	utils.InitLogging(parameters.Log, *logPath)
+	err = parameters.Validate()
+	if err != nil {
+		log.Fatal().Msgf("Invalid parameters; %v", err)
+	}
 	if *port == 0 {
 		log.Fatal().Msgf("The port should be provided with the -port flag")
 	}
 
-	shardNodeEndpoints, err := config.ReadShardNodeEndpoints(path.Join(*configsPath, "shardnode_endpoints.yaml"))
-	if err != nil {
-		log.Fatal().Msgf("Cannot read shard node endpoints from yaml file; %v", err)
-	}
-	rpcClients, err := router.StartShardNodeRPCClients(shardNodeEndpoints)
+	rpcClients, err := router.StartShardNodeRPCClients(cluster.ShardNodes)
 	if err != nil {
 		log.Fatal().Msgf("Failed to create client connections with shard node servers; %v", err)
 	}
@@ -59,5 +60,5 @@ func main() {
 		defer cpuProfile.Stop()
 	}
 
-	router.StartRPCServer(*ip, rpcClients, *routerID, *port, parameters)
+	router.StartRPCServer(*ip, rpcClients, *routerID, *port, parameters, config.ParametersPath(*configsPath))
 }
@@ -5,7 +5,6 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"path"
 
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
 	"github.com/dsg-uwaterloo/treebeard/pkg/profile"
@@ -26,12 +25,19 @@ func main() {
 	joinAddr := flag.String("joinaddr", "", "the address of the initial raft node, which bootstraped the cluster")
 	configsPath := flag.String("conf", "../../configs/default", "configs directory path")
 	logPath := flag.String("logpath", "", "path to write logs")
+	faultInjection := flag.Bool("faults", false, "enable fault injection with the fault rules of the configs and the admin rpcs, only for tests and failure experiments")
 	flag.Parse()
-	parameters, err := config.ReadParameters(path.Join(*configsPath, "parameters.yaml"))
+	cluster, err := config.LoadClusterConfig(*configsPath)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read the configs from %s; %v\n", *configsPath, err)
 		os.Exit(1)
 	}
+	parameters := cluster.Parameters
 	utils.InitLogging(parameters.Log, *logPath)
+	err = parameters.Validate()
+	if err != nil {
+		log.Fatal().Msgf("Invalid parameters; %v", err)
+	}
 	if *rpcPort == 0 {
 		log.Fatal().Msgf("The rpc port should be provided with the -rpcport flag")
 	}
@@ -39,16 +45,7 @@ func main() {
 		log.Fatal().Msgf("The raft port should be provided with the -raftport flag")
 	}
 
-	oramNodeEndpoints, err := config.ReadOramNodeEndpoints(path.Join(*configsPath, "oramnode_endpoints.yaml"))
-	if err != nil {
-		log.Fatal().Msgf("Cannot read shard node endpoints from yaml file; %v", err)
-	}
-	redisEndpoints, err := config.ReadRedisEndpoints(path.Join(*configsPath, "redis_endpoints.yaml"))
-	if err != nil {
-		log.Fatal().Msgf("Cannot read redis endpoints from yaml file; %v", err)
-	}
-
-	rpcClients, err := shardnode.StartOramNodeRPCClients(oramNodeEndpoints)
+	rpcClients, err := shardnode.StartOramNodeRPCClients(cluster.OramNodes)
 	if err != nil {
 		log.Fatal().Msgf("Failed to create client connections with oarm node servers; %v", err)
 	}
@@ -70,5 +67,5 @@ func main() {
 		defer cpuProfile.Stop()
 	}
 
-	shardnode.StartServer(*shardNodeID, *bindIP, *advIP, *rpcPort, *replicaID, *raftPort, *joinAddr, rpcClients, parameters, redisEndpoints, *configsPath)
+	shardnode.StartServer(*shardNodeID, *bindIP, *advIP, *rpcPort, *replicaID, *raftPort, *joinAddr, rpcClients, parameters, cluster.Redis, *configsPath, cluster.Faults, *faultInjection)
 }
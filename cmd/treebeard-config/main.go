@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/rs/zerolog"
+)
+
+// Usage: ./treebeard-config check -h
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "check" {
+		fmt.Fprintf(os.Stderr, "Usage: %s check [-conf configs directory path] [-file cluster config file]\n", os.Args[0])
+		os.Exit(2)
+	}
+	checkFlags := flag.NewFlagSet("check", flag.ExitOnError)
+	configsPath := checkFlags.String("conf", "../../configs/default", "configs directory path, with a cluster.yaml or the separate config files")
+	clusterPath := checkFlags.String("file", "", "cluster config file, used instead of the configs directory if it is set")
+	checkFlags.Parse(os.Args[2:])
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	var cluster config.ClusterConfig
+	var err error
+	if *clusterPath != "" {
+		cluster, err = config.ReadClusterConfig(*clusterPath)
+	} else {
+		cluster, err = config.LoadClusterConfig(*configsPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read the cluster config; %s\n", err)
+		os.Exit(1)
+	}
+	err = cluster.Validate()
+	if err != nil {
+		problems := strings.Split(err.Error(), "\n")
+		fmt.Printf("The cluster config has %d problems:\n", len(problems))
+		for _, problem := range problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("The cluster config is valid: %d routers, %d shard node replicas, %d oram node replicas and %d storages\n",
+		len(cluster.Routers), len(cluster.ShardNodes), len(cluster.OramNodes), len(cluster.Redis))
+}
@@ -0,0 +1,183 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/admin"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Target is a node whose runtime parameters can change while it is running.
+// Each node only uses the runtime parameters that matter to it.
+type Target interface {
+	Parameters() config.Parameters
+	UpdateParameters(parameters config.Parameters) error
+}
+
+type adminServer struct {
+	pb.UnimplementedAdminServer
+	target Target
+	mu     sync.Mutex // updates from the rpc and the file watcher should not interleave
+	faults *faults.Injector
+}
+
+func newAdminServer(target Target) *adminServer {
+	return &adminServer{target: target}
+}
+
+// RegisterAdminServer adds the admin service to the grpc server of a node.
+// If parametersPath is not empty, the runtime parameters are also reloaded when the file changes.
+// The faults are injected with the injector, and the node does not support them if it is nil.
+func RegisterAdminServer(grpcServer *grpc.Server, target Target, parametersPath string, injector *faults.Injector) {
+	a := newAdminServer(target)
+	a.faults = injector
+	pb.RegisterAdminServer(grpcServer, a)
+	if parametersPath != "" {
+		go a.watchParametersFile(context.Background(), parametersPath, parametersWatchInterval)
+	}
+}
+
+// It returns the current parameters with the runtime parameters of the updated parameters.
+// The other parameters need a restart, so they are not copied.
+func mergeRuntimeParameters(current config.Parameters, updated config.Parameters) config.Parameters {
+	current.EvictionRate = updated.EvictionRate
+	current.EvictPathCount = updated.EvictPathCount
+	current.BatchTimout = updated.BatchTimout
+	current.EpochTime = updated.EpochTime
+	current.MaxBlocksToSend = updated.MaxBlocksToSend
+	return current
+}
+
+// It checks the runtime parameters that changed.
+// The unchanged ones are not checked, since a node does not set the parameters that it does not use.
+func validateRuntimeParameters(current config.Parameters, updated config.Parameters) error {
+	if updated.EvictionRate != current.EvictionRate && updated.EvictionRate <= 0 {
+		return fmt.Errorf("eviction-rate should be positive but is %d", updated.EvictionRate)
+	}
+	if updated.EvictPathCount != current.EvictPathCount && updated.EvictPathCount <= 0 {
+		return fmt.Errorf("evict-path-count should be positive but is %d", updated.EvictPathCount)
+	}
+	if updated.BatchTimout != current.BatchTimout && updated.BatchTimout <= 0 {
+		return fmt.Errorf("batch-timeout should be positive but is %f", updated.BatchTimout)
+	}
+	if updated.EpochTime != current.EpochTime && updated.EpochTime <= 0 {
+		return fmt.Errorf("epoch-time should be positive but is %f", updated.EpochTime)
+	}
+	if updated.MaxBlocksToSend != current.MaxBlocksToSend && updated.MaxBlocksToSend <= 0 {
+		return fmt.Errorf("max-blocks-to-send should be positive but is %d", updated.MaxBlocksToSend)
+	}
+	return nil
+}
+
+// It validates the runtime parameters of updated and applies them to the target.
+func (a *adminServer) update(updated config.Parameters) (config.Parameters, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	current := a.target.Parameters()
+	parameters := mergeRuntimeParameters(current, updated)
+	if parameters == current {
+		return parameters, nil
+	}
+	err := validateRuntimeParameters(current, parameters)
+	if err != nil {
+		return config.Parameters{}, status.Errorf(codes.InvalidArgument, "invalid runtime parameters; %s", err)
+	}
+	err = a.target.UpdateParameters(parameters)
+	if err != nil {
+		return config.Parameters{}, fmt.Errorf("could not update the parameters; %s", err)
+	}
+	// The target may skip some of them, for example a follower does not replicate the evict path count.
+	parameters = a.target.Parameters()
+	log.Debug().Msgf("Updated the runtime parameters to %v", parameters)
+	return parameters, nil
+}
+
+func toRuntimeParameters(parameters config.Parameters) *pb.RuntimeParameters {
+	evictionRate := int32(parameters.EvictionRate)
+	evictPathCount := int32(parameters.EvictPathCount)
+	batchTimeout := parameters.BatchTimout
+	epochTime := parameters.EpochTime
+	maxBlocksToSend := int32(parameters.MaxBlocksToSend)
+	return &pb.RuntimeParameters{
+		EvictionRate:    &evictionRate,
+		EvictPathCount:  &evictPathCount,
+		BatchTimeout:    &batchTimeout,
+		EpochTime:       &epochTime,
+		MaxBlocksToSend: &maxBlocksToSend,
+	}
+}
+
+// It returns the parameters with the fields that are set in the request.
+func fromRuntimeParameters(parameters config.Parameters, request *pb.RuntimeParameters) config.Parameters {
+	if request == nil {
+		return parameters
+	}
+	if request.EvictionRate != nil {
+		parameters.EvictionRate = int(request.GetEvictionRate())
+	}
+	if request.EvictPathCount != nil {
+		parameters.EvictPathCount = int(request.GetEvictPathCount())
+	}
+	if request.BatchTimeout != nil {
+		parameters.BatchTimout = request.GetBatchTimeout()
+	}
+	if request.EpochTime != nil {
+		parameters.EpochTime = request.GetEpochTime()
+	}
+	if request.MaxBlocksToSend != nil {
+		parameters.MaxBlocksToSend = int(request.GetMaxBlocksToSend())
+	}
+	return parameters
+}
+
+func (a *adminServer) GetParameters(ctx context.Context, request *pb.GetParametersRequest) (*pb.GetParametersReply, error) {
+	return &pb.GetParametersReply{Parameters: toRuntimeParameters(a.target.Parameters())}, nil
+}
+
+func (a *adminServer) UpdateParameters(ctx context.Context, request *pb.UpdateParametersRequest) (*pb.UpdateParametersReply, error) {
+	log.Debug().Msgf("Received update parameters request %v", request)
+	parameters, err := a.update(fromRuntimeParameters(a.target.Parameters(), request.Parameters))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateParametersReply{Parameters: toRuntimeParameters(parameters)}, nil
+}
+
+func (a *adminServer) InjectFault(ctx context.Context, request *pb.InjectFaultRequest) (*pb.InjectFaultReply, error) {
+	log.Debug().Msgf("Received inject fault request %v", request)
+	if a.faults == nil {
+		return nil, status.Errorf(codes.Unimplemented, "the node does not support fault injection")
+	}
+	fault := request.GetFault()
+	action, err := faults.ParseAction(fault.GetAction())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid fault; %s", err)
+	}
+	err = a.faults.Add(faults.Rule{
+		Point:  fault.GetPoint(),
+		Action: action,
+		Delay:  time.Duration(fault.GetDelay() * float64(time.Millisecond)),
+		Skip:   int(fault.GetSkip()),
+		Count:  int(fault.GetCount()),
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid fault; %s", err)
+	}
+	return &pb.InjectFaultReply{}, nil
+}
+
+func (a *adminServer) ClearFaults(ctx context.Context, request *pb.ClearFaultsRequest) (*pb.ClearFaultsReply, error) {
+	if a.faults == nil {
+		return nil, status.Errorf(codes.Unimplemented, "the node does not support fault injection")
+	}
+	a.faults.Clear()
+	return &pb.ClearFaultsReply{}, nil
+}
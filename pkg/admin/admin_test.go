@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/admin"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type mockTarget struct {
+	parameters         config.Parameters
+	updates            int
+	err                error
+	skipEvictPathCount bool // like an oram node follower, which does not replicate the evict path count
+	mu                 sync.Mutex
+}
+
+func (m *mockTarget) Parameters() config.Parameters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.parameters
+}
+
+func (m *mockTarget) UpdateParameters(parameters config.Parameters) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	if m.skipEvictPathCount {
+		parameters.EvictPathCount = m.parameters.EvictPathCount
+	}
+	m.parameters = parameters
+	m.updates++
+	return nil
+}
+
+func (m *mockTarget) getUpdates() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updates
+}
+
+func TestUpdateParametersChangesOnlySetFields(t *testing.T) {
+	target := &mockTarget{parameters: config.Parameters{EvictionRate: 4, EpochTime: 5, TreeHeight: 10}}
+	a := newAdminServer(target)
+	evictionRate := int32(8)
+	reply, err := a.UpdateParameters(context.Background(), &pb.UpdateParametersRequest{Parameters: &pb.RuntimeParameters{EvictionRate: &evictionRate}})
+	if err != nil {
+		t.Errorf("expected successful update but got %s", err)
+	}
+	if reply.Parameters.GetEvictionRate() != 8 || reply.Parameters.GetEpochTime() != 5 {
+		t.Errorf("expected eviction rate 8 and epoch time 5 but got %v", reply.Parameters)
+	}
+	if target.parameters.EvictionRate != 8 || target.parameters.TreeHeight != 10 {
+		t.Errorf("expected only the eviction rate to change but got %v", target.parameters)
+	}
+}
+
+func TestUpdateParametersReturnsInvalidArgumentForInvalidParameters(t *testing.T) {
+	target := &mockTarget{parameters: config.Parameters{EvictPathCount: 4}}
+	a := newAdminServer(target)
+	evictPathCount := int32(0)
+	_, err := a.UpdateParameters(context.Background(), &pb.UpdateParametersRequest{Parameters: &pb.RuntimeParameters{EvictPathCount: &evictPathCount}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an invalid argument error but got %v", err)
+	}
+	if target.updates != 0 {
+		t.Errorf("invalid parameters should not be applied")
+	}
+}
+
+func TestUpdateParametersReturnsErrorOfTarget(t *testing.T) {
+	target := &mockTarget{parameters: config.Parameters{EvictPathCount: 4}, err: fmt.Errorf("not the leader")}
+	a := newAdminServer(target)
+	evictPathCount := int32(6)
+	_, err := a.UpdateParameters(context.Background(), &pb.UpdateParametersRequest{Parameters: &pb.RuntimeParameters{EvictPathCount: &evictPathCount}})
+	if err == nil {
+		t.Errorf("expected the error of the target")
+	}
+}
+
+func TestUpdateParametersRepliesWithTheParametersThatTheTargetApplied(t *testing.T) {
+	target := &mockTarget{parameters: config.Parameters{EvictionRate: 4, EvictPathCount: 4}, skipEvictPathCount: true}
+	a := newAdminServer(target)
+	evictionRate := int32(8)
+	evictPathCount := int32(6)
+	reply, err := a.UpdateParameters(context.Background(), &pb.UpdateParametersRequest{Parameters: &pb.RuntimeParameters{EvictionRate: &evictionRate, EvictPathCount: &evictPathCount}})
+	if err != nil {
+		t.Errorf("expected successful update but got %s", err)
+	}
+	if reply.Parameters.GetEvictionRate() != 8 || reply.Parameters.GetEvictPathCount() != 4 {
+		t.Errorf("expected eviction rate 8 and the skipped evict path count 4 but got %v", reply.Parameters)
+	}
+}
+
+func TestUpdateIgnoresNonRuntimeParameters(t *testing.T) {
+	target := &mockTarget{parameters: config.Parameters{EpochTime: 5, TreeHeight: 10}}
+	a := newAdminServer(target)
+	_, err := a.update(config.Parameters{EpochTime: 5, TreeHeight: 20})
+	if err != nil {
+		t.Errorf("expected no error but got %s", err)
+	}
+	if target.updates != 0 || target.parameters.TreeHeight != 10 {
+		t.Errorf("parameters that need a restart should not be updated")
+	}
+}
+
+func TestInjectFaultAddsRuleToInjector(t *testing.T) {
+	a := newAdminServer(&mockTarget{})
+	a.faults = faults.NewInjector(nil)
+	_, err := a.InjectFault(context.Background(), &pb.InjectFaultRequest{Fault: &pb.Fault{Point: faults.ReadPath, Action: "drop", Count: 1}})
+	if err != nil {
+		t.Fatalf("expected the fault to be injected but got %s", err)
+	}
+	if a.faults.Hit(faults.ReadPath) != faults.ErrInjected || a.faults.Hit(faults.ReadPath) != nil {
+		t.Errorf("expected only the first read path to be dropped")
+	}
+	a.InjectFault(context.Background(), &pb.InjectFaultRequest{Fault: &pb.Fault{Point: faults.ReadPath, Action: "drop"}})
+	a.ClearFaults(context.Background(), &pb.ClearFaultsRequest{})
+	if a.faults.Hit(faults.ReadPath) != nil {
+		t.Errorf("expected no fault after the faults are cleared")
+	}
+}
+
+func TestInjectFaultReturnsInvalidArgumentForUnknownAction(t *testing.T) {
+	a := newAdminServer(&mockTarget{})
+	a.faults = faults.NewInjector(nil)
+	_, err := a.InjectFault(context.Background(), &pb.InjectFaultRequest{Fault: &pb.Fault{Point: faults.ReadPath, Action: "explode"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an invalid argument error but got %v", err)
+	}
+}
+
+func TestInjectFaultIsUnimplementedWithoutInjector(t *testing.T) {
+	a := newAdminServer(&mockTarget{})
+	_, err := a.InjectFault(context.Background(), &pb.InjectFaultRequest{Fault: &pb.Fault{Point: faults.ReadPath, Action: "drop"}})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected an unimplemented error but got %v", err)
+	}
+}
+
+func TestWatchParametersFileReloadsChangedFile(t *testing.T) {
+	parametersPath := path.Join(t.TempDir(), "parameters.yaml")
+	err := os.WriteFile(parametersPath, []byte("epoch-time: 5\ntree-height: 10\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to write the parameters file; %s", err)
+	}
+	target := &mockTarget{parameters: config.Parameters{EpochTime: 5, TreeHeight: 10}}
+	a := newAdminServer(target)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.watchParametersFile(ctx, parametersPath, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	err = os.WriteFile(parametersPath, []byte("epoch-time: 20\ntree-height: 10\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to write the parameters file; %s", err)
+	}
+	os.Chtimes(parametersPath, time.Now().Add(time.Second), time.Now().Add(time.Second))
+	for i := 0; i < 100 && target.getUpdates() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if target.Parameters().EpochTime != 20 {
+		t.Errorf("expected the epoch time of the changed file but got %f", target.Parameters().EpochTime)
+	}
+}
@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+const parametersWatchInterval = time.Second
+
+// It reloads the runtime parameters whenever the modification time of the file changes.
+// A file that can not be read or has invalid runtime parameters is logged and skipped.
+func (a *adminServer) watchParametersFile(ctx context.Context, path string, interval time.Duration) {
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Error().Msgf("Could not stat the parameters file %s; %s", path, err)
+			continue
+		}
+		if !info.ModTime().After(lastModified) {
+			continue
+		}
+		lastModified = info.ModTime()
+		parameters, err := config.ReadParameters(path)
+		if err != nil {
+			log.Error().Msgf("Could not read the parameters file %s; %s", path, err)
+			continue
+		}
+		_, err = a.update(parameters)
+		if err != nil {
+			log.Error().Msgf("Could not reload the parameters from %s; %s", path, err)
+			continue
+		}
+		log.Info().Msgf("Reloaded the runtime parameters from %s", path)
+	}
+}
@@ -2,14 +2,18 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 	"time"
 
 	routerpb "github.com/dsg-uwaterloo/treebeard/api/router"
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/linearizability"
 	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"github.com/dsg-uwaterloo/treebeard/pkg/treebeard"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/trace"
@@ -31,15 +35,23 @@ type WriteResponse struct {
 	err     error
 }
 
+// The requests are sent through the treebeard client,
+// which sends them to the healthy router with the fewest outstanding requests and retries them on another router.
 type client struct {
-	rateLimit        *RateLimit
-	tracer           trace.Tracer
-	routerRPCClients RouterClients
-	requests         []Request
+	rateLimit *RateLimit
+	tracer    trace.Tracer
+	routers   *treebeard.Client
+	requests  []Request
+	history   *History // the requests are not recorded if it is nil
 }
 
-func NewClient(rateLimit *RateLimit, tracer trace.Tracer, routerRPCClients RouterClients, requests []Request) *client {
-	return &client{rateLimit: rateLimit, tracer: tracer, routerRPCClients: routerRPCClients, requests: requests}
+func NewClient(rateLimit *RateLimit, tracer trace.Tracer, routers *treebeard.Client, requests []Request) *client {
+	return &client{rateLimit: rateLimit, tracer: tracer, routers: routers, requests: requests}
+}
+
+// RecordHistory records the requests in history from now on.
+func (c *client) RecordHistory(history *History) {
+	c.history = history
 }
 
 func (c *client) WaitForStorageToBeReady(redisEndpoints []config.RedisEndpoint, parameters config.Parameters) error {
@@ -62,11 +74,21 @@ func (c *client) WaitForStorageToBeReady(redisEndpoints []config.RedisEndpoint,
 	return nil
 }
 
-func (c *client) asyncRead(block string, routerRPCClient RouterRPCClient, readResponseChannel chan ReadResponse) {
+func (c *client) asyncRead(block string, readResponseChannel chan ReadResponse) {
 	c.rateLimit.Acquire()
 	ctx, span := c.tracer.Start(context.Background(), "client read request")
 	startTime := time.Now()
-	value, err := routerRPCClient.Read(ctx, block)
+	historyIndex := -1
+	if c.history != nil {
+		historyIndex = c.history.Begin(linearizability.Read, block, "")
+	}
+	value, err := c.routers.Get(ctx, block)
+	if errors.Is(err, treebeard.ErrNotFound) {
+		err = nil
+	}
+	if historyIndex >= 0 {
+		c.history.End(historyIndex, value, err)
+	}
 	latency := time.Since(startTime)
 	log.Debug().Msgf("Got value %s for block %s", value, block)
 	span.End()
@@ -80,19 +102,26 @@ func (c *client) asyncRead(block string, routerRPCClient RouterRPCClient, readRe
 	}
 }
 
-func (c *client) asyncWrite(block string, newValue string, routerRPCClient RouterRPCClient, writeResponseChannel chan WriteResponse) {
+func (c *client) asyncWrite(block string, newValue string, writeResponseChannel chan WriteResponse) {
 	c.rateLimit.Acquire()
 	ctx, span := c.tracer.Start(context.Background(), "client write request")
 	startTime := time.Now()
-	value, err := routerRPCClient.Write(ctx, block, newValue)
+	historyIndex := -1
+	if c.history != nil {
+		historyIndex = c.history.Begin(linearizability.Write, block, newValue)
+	}
+	err := c.routers.Put(ctx, block, newValue)
+	if historyIndex >= 0 {
+		c.history.End(historyIndex, "", err)
+	}
 	latency := time.Since(startTime)
-	log.Debug().Msgf("Got success %v for block %s", value, block)
+	log.Debug().Msgf("Got success %v for block %s", err == nil, block)
 	span.End()
 	c.rateLimit.Release()
 	if err != nil {
 		writeResponseChannel <- WriteResponse{block: block, success: false, err: fmt.Errorf("failed to call Write block %s on router; %v", block, err)}
 	} else {
-		writeResponseChannel <- WriteResponse{block: block, success: value, latency: latency, err: nil}
+		writeResponseChannel <- WriteResponse{block: block, success: true, latency: latency, err: nil}
 	}
 }
 
@@ -105,11 +134,10 @@ func (c *client) SendRequestsForever(ctx context.Context, readResponseChannel ch
 		case <-ctx.Done():
 			return
 		default:
-			routerRPCClient := c.routerRPCClients.GetRandomRouter()
 			if request.OperationType == Read {
-				go c.asyncRead(request.Block, routerRPCClient, readResponseChannel)
+				go c.asyncRead(request.Block, readResponseChannel)
 			} else if request.OperationType == Write {
-				go c.asyncWrite(request.Block, request.NewValue, routerRPCClient, writeResponseChannel)
+				go c.asyncWrite(request.Block, request.NewValue, writeResponseChannel)
 			}
 		}
 	}
@@ -170,11 +198,23 @@ type RouterRPCClient struct {
 
 type RouterClients map[int]RouterRPCClient
 
+// The router IDs do not have to be dense, so it picks one of the keys of the map.
 func (r RouterClients) GetRandomRouter() RouterRPCClient {
-	routersLen := len(r)
-	randomRouterIndex := rand.Intn(routersLen)
-	randomRouter := r[randomRouterIndex]
-	return randomRouter
+	routerIDs := make([]int, 0, len(r))
+	for routerID := range r {
+		routerIDs = append(routerIDs, routerID)
+	}
+	sort.Ints(routerIDs)
+	return r[routerIDs[rand.Intn(len(routerIDs))]]
+}
+
+// StartRouterClient starts the treebeard client for the routers.
+func StartRouterClient(endpoints []config.RouterEndpoint) (*treebeard.Client, error) {
+	var addresses []string
+	for _, endpoint := range endpoints {
+		addresses = append(addresses, fmt.Sprintf("%s:%d", endpoint.IP, endpoint.Port))
+	}
+	return treebeard.NewClient(treebeard.DefaultConfig(addresses...))
 }
 
 func (c *RouterRPCClient) Read(ctx context.Context, block string) (value string, err error) {
@@ -197,6 +237,60 @@ func (c *RouterRPCClient) Write(ctx context.Context, block string, value string)
 	return reply.Success, nil
 }
 
+func (c *RouterRPCClient) Delete(ctx context.Context, block string) (success bool, err error) {
+	log.Debug().Msgf("Sending delete request for block %s", block)
+	reply, err := c.ClientAPI.Delete(ctx,
+		&routerpb.DeleteRequest{Block: block})
+	if err != nil {
+		return false, err
+	}
+	return reply.Success, nil
+}
+
+// ReadVersioned returns the value of the block with its version.
+func (c *RouterRPCClient) ReadVersioned(ctx context.Context, block string) (value string, version uint64, err error) {
+	log.Debug().Msgf("Sending versioned read request for block %s", block)
+	reply, err := c.ClientAPI.Read(ctx,
+		&routerpb.ReadRequest{Block: block})
+	if err != nil {
+		return "", 0, err
+	}
+	return reply.Value, reply.Version, nil
+}
+
+// CompareAndSwap writes newValue if the block has the expected value and, if expectedVersion is not zero, the expected version.
+// It returns the value and version of the block after the request.
+func (c *RouterRPCClient) CompareAndSwap(ctx context.Context, block string, expected string, newValue string, expectedVersion uint64) (swapped bool, value string, version uint64, err error) {
+	log.Debug().Msgf("Sending compare and swap request for block %s with value %s", block, newValue)
+	reply, err := c.ClientAPI.CompareAndSwap(ctx,
+		&routerpb.CompareAndSwapRequest{Block: block, Expected: expected, NewValue: newValue, ExpectedVersion: expectedVersion})
+	if err != nil {
+		return false, "", 0, err
+	}
+	return reply.Swapped, reply.Value, reply.Version, nil
+}
+
+// Scan returns up to limit blocks that exist and start with the prefix, in order.
+func (c *RouterRPCClient) Scan(ctx context.Context, prefix string, limit int) (blocks []string, err error) {
+	log.Debug().Msgf("Sending scan request for prefix %s with limit %d", prefix, limit)
+	reply, err := c.ClientAPI.Scan(ctx,
+		&routerpb.ScanRequest{Prefix: prefix, Limit: int32(limit)})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Blocks, nil
+}
+
+func (c *RouterRPCClient) Exists(ctx context.Context, block string) (exists bool, err error) {
+	log.Debug().Msgf("Sending exists request for block %s", block)
+	reply, err := c.ClientAPI.Exists(ctx,
+		&routerpb.ExistsRequest{Block: block})
+	if err != nil {
+		return false, err
+	}
+	return reply.Exists, nil
+}
+
 func StartRouterRPCClients(endpoints []config.RouterEndpoint) (RouterClients, error) {
 	log.Debug().Msgf("Starting router RPC clients with endpoints %v", endpoints)
 	clients := make(map[int]RouterRPCClient)
@@ -0,0 +1,17 @@
+package client
+
+import (
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestGetRandomRouterPicksFromSparseRouterIDs(t *testing.T) {
+	routers := RouterClients{3: {Conn: &grpc.ClientConn{}}, 7: {Conn: &grpc.ClientConn{}}}
+	for i := 0; i < 100; i++ {
+		router := routers.GetRandomRouter()
+		if router.Conn != routers[3].Conn && router.Conn != routers[7].Conn {
+			t.Fatalf("expected one of the routers but got %v", router)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/linearizability"
+)
+
+// History records the reads and writes of the client with the time of their call and return,
+// so that they can be checked with linearizability.CheckRegisters.
+// It is safe to use from many goroutines.
+type History struct {
+	mu         sync.Mutex
+	operations []linearizability.Operation
+}
+
+func NewHistory() *History {
+	return &History{}
+}
+
+// Begin records the call of an operation and returns its index for End.
+func (h *History) Begin(operationType linearizability.OperationType, block string, value string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.operations = append(h.operations, linearizability.Operation{Type: operationType, Block: block, Value: value, Call: time.Now()})
+	return len(h.operations) - 1
+}
+
+// End records the return of an operation. A failed operation does not return, since a failed write may still be applied.
+// The value is the value that a read returned.
+func (h *History) End(index int, value string, err error) {
+	returnTime := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		return
+	}
+	if h.operations[index].Type == linearizability.Read {
+		h.operations[index].Value = value
+	}
+	h.operations[index].Return = returnTime
+}
+
+// Operations returns a copy of the recorded operations.
+func (h *History) Operations() []linearizability.Operation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	operations := make([]linearizability.Operation, len(h.operations))
+	copy(operations, h.operations)
+	return operations
+}
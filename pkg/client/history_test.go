@@ -0,0 +1,23 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/linearizability"
+)
+
+func TestHistoryRecordsTheReadValueAndNoReturnForFailedOperations(t *testing.T) {
+	history := NewHistory()
+	read := history.Begin(linearizability.Read, "a", "")
+	write := history.Begin(linearizability.Write, "a", "1")
+	history.End(read, "0", nil)
+	history.End(write, "", errors.New("router is down"))
+	operations := history.Operations()
+	if operations[0].Value != "0" || operations[0].Return.IsZero() {
+		t.Errorf("expected the read to return 0 but got %v", operations[0])
+	}
+	if operations[1].Value != "1" || !operations[1].Return.IsZero() {
+		t.Errorf("expected the failed write not to return but got %v", operations[1])
+	}
+}
@@ -0,0 +1,299 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// ClusterConfigFileName is the name of the file that describes the whole cluster.
+// If it is not in the configs directory, the cluster is read from the separate endpoint and parameter files.
+const ClusterConfigFileName = "cluster.yaml"
+
+// ClusterConfig describes the topology and the parameters of a whole cluster.
+// The parameters are at the top level of the file, so the file can also be read with ReadParameters.
+type ClusterConfig struct {
+	Parameters Parameters          `yaml:",inline"`
+	Routers    []RouterEndpoint    `yaml:"routers"`
+	ShardNodes []ShardNodeEndpoint `yaml:"shardnodes"`
+	OramNodes  []OramNodeEndpoint  `yaml:"oramnodes"`
+	Redis      []RedisEndpoint     `yaml:"redis"`
+	Faults     []FaultRule         `yaml:"faults"`
+}
+
+func ReadClusterConfig(path string) (ClusterConfig, error) {
+	log.Debug().Msgf("Reading cluster config from the yaml file")
+	yamlFile, err := os.ReadFile(path)
+	if err != nil {
+		return ClusterConfig{}, err
+	}
+
+	var cluster ClusterConfig
+	err = yaml.Unmarshal(yamlFile, &cluster)
+	if err != nil {
+		return ClusterConfig{}, err
+	}
+	return cluster, nil
+}
+
+// ParametersPath returns the file that has the parameters in the configs directory.
+func ParametersPath(configsPath string) string {
+	clusterPath := path.Join(configsPath, ClusterConfigFileName)
+	if _, err := os.Stat(clusterPath); err == nil {
+		return clusterPath
+	}
+	return path.Join(configsPath, "parameters.yaml")
+}
+
+// LoadClusterConfig reads the cluster from the configs directory.
+// It uses the cluster file if it exists, and the separate files otherwise.
+func LoadClusterConfig(configsPath string) (ClusterConfig, error) {
+	clusterPath := path.Join(configsPath, ClusterConfigFileName)
+	if _, err := os.Stat(clusterPath); err == nil {
+		return ReadClusterConfig(clusterPath)
+	}
+	var cluster ClusterConfig
+	var err error
+	cluster.Parameters, err = ReadParameters(path.Join(configsPath, "parameters.yaml"))
+	if err != nil {
+		return ClusterConfig{}, fmt.Errorf("cannot read parameters; %s", err)
+	}
+	cluster.Routers, err = ReadRouterEndpoints(path.Join(configsPath, "router_endpoints.yaml"))
+	if err != nil {
+		return ClusterConfig{}, fmt.Errorf("cannot read router endpoints; %s", err)
+	}
+	cluster.ShardNodes, err = ReadShardNodeEndpoints(path.Join(configsPath, "shardnode_endpoints.yaml"))
+	if err != nil {
+		return ClusterConfig{}, fmt.Errorf("cannot read shard node endpoints; %s", err)
+	}
+	cluster.OramNodes, err = ReadOramNodeEndpoints(path.Join(configsPath, "oramnode_endpoints.yaml"))
+	if err != nil {
+		return ClusterConfig{}, fmt.Errorf("cannot read oram node endpoints; %s", err)
+	}
+	cluster.Redis, err = ReadRedisEndpoints(path.Join(configsPath, "redis_endpoints.yaml"))
+	if err != nil {
+		return ClusterConfig{}, fmt.Errorf("cannot read redis endpoints; %s", err)
+	}
+	cluster.Faults, err = ReadFaultRules(path.Join(configsPath, "faults.yaml"))
+	if err != nil {
+		return ClusterConfig{}, fmt.Errorf("cannot read fault rules; %s", err)
+	}
+	return cluster, nil
+}
+
+// The paths are stored in ints, so the number of leaves (2^(tree-height-1)) should fit in one.
+const maxTreeHeight = 62
+
+// Validate returns all the problems of the parameters joined in one error, or nil if there are none.
+func (p Parameters) Validate() error {
+	var errs []error
+	positiveInts := []struct {
+		name  string
+		value int
+	}{
+		{"max-blocks-to-send", p.MaxBlocksToSend},
+		{"eviction-rate", p.EvictionRate},
+		{"evict-path-count", p.EvictPathCount},
+		{"Z", p.Z},
+		{"S", p.S},
+		{"shift", p.Shift},
+		{"tree-height", p.TreeHeight},
+		{"redis-pipeline-size", p.RedisPipelineSize},
+		{"max-requests", p.MaxRequests},
+		{"block-size", p.BlockSize},
+	}
+	for _, parameter := range positiveInts {
+		if parameter.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s should be positive but is %d", parameter.name, parameter.value))
+		}
+	}
+	positiveFloats := []struct {
+		name  string
+		value float64
+	}{
+		{"batch-timeout", p.BatchTimout},
+		{"epoch-time", p.EpochTime},
+	}
+	for _, parameter := range positiveFloats {
+		if parameter.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s should be positive but is %v", parameter.name, parameter.value))
+		}
+	}
+	// These are optional and zero means the default
+	if p.EpochBatchSize < 0 {
+		errs = append(errs, fmt.Errorf("epoch-batch-size should not be negative but is %d", p.EpochBatchSize))
+	}
+	if p.EpochTimeout < 0 {
+		errs = append(errs, fmt.Errorf("epoch-timeout should not be negative but is %v", p.EpochTimeout))
+	}
+	if p.VirtualNodes < 0 {
+		errs = append(errs, fmt.Errorf("virtual-nodes should not be negative but is %d", p.VirtualNodes))
+	}
+	if p.StorageRampUp < 0 {
+		errs = append(errs, fmt.Errorf("storage-ramp-up should not be negative but is %v", p.StorageRampUp))
+	}
+	if p.EvictionTimeout < 0 {
+		errs = append(errs, fmt.Errorf("eviction-timeout should not be negative but is %v", p.EvictionTimeout))
+	}
+	if p.MigrationTimeout < 0 {
+		errs = append(errs, fmt.Errorf("migration-timeout should not be negative but is %v", p.MigrationTimeout))
+	}
+	if p.PrepareTimeout < 0 {
+		errs = append(errs, fmt.Errorf("prepare-timeout should not be negative but is %v", p.PrepareTimeout))
+	}
+	if p.IdempotencyWindow < 0 {
+		errs = append(errs, fmt.Errorf("idempotency-window should not be negative but is %v", p.IdempotencyWindow))
+	}
+	if p.TreeHeight > maxTreeHeight {
+		errs = append(errs, fmt.Errorf("tree-height should be at most %d but is %d", maxTreeHeight, p.TreeHeight))
+	}
+	return errors.Join(errs...)
+}
+
+// It checks that the ids start from zero and have no gaps.
+func validateConsecutiveIDs(kind string, ids map[int]bool) (errs []error) {
+	for _, id := range sortedKeys(ids) {
+		if id < 0 {
+			errs = append(errs, fmt.Errorf("%s id %d should not be negative", kind, id))
+		}
+	}
+	for id := 0; id < len(ids); id++ {
+		if !ids[id] {
+			errs = append(errs, fmt.Errorf("%s ids should start from zero without gaps, but %d is missing", kind, id))
+		}
+	}
+	return errs
+}
+
+type replicaEndpoint struct {
+	id        int
+	replicaID int
+}
+
+// It checks that no replica is repeated and that the nodes and their replicas have no gaps.
+func validateReplicatedNodes(kind string, endpoints []replicaEndpoint) (errs []error) {
+	if len(endpoints) == 0 {
+		return []error{fmt.Errorf("there should be at least one %s", kind)}
+	}
+	replicas := make(map[int]map[int]bool)
+	for _, endpoint := range endpoints {
+		if replicas[endpoint.id] == nil {
+			replicas[endpoint.id] = make(map[int]bool)
+		}
+		if replicas[endpoint.id][endpoint.replicaID] {
+			errs = append(errs, fmt.Errorf("%s %d has replica %d more than once", kind, endpoint.id, endpoint.replicaID))
+		}
+		replicas[endpoint.id][endpoint.replicaID] = true
+	}
+	ids := make(map[int]bool)
+	for id := range replicas {
+		ids[id] = true
+	}
+	errs = append(errs, validateConsecutiveIDs(kind, ids)...)
+	for _, id := range sortedKeys(ids) {
+		errs = append(errs, validateConsecutiveIDs(fmt.Sprintf("%s %d replica", kind, id), replicas[id])...)
+	}
+	return errs
+}
+
+// Validate returns all the problems of the cluster joined in one error, or nil if there are none.
+func (c ClusterConfig) Validate() error {
+	var errs []error
+	if err := c.Parameters.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(c.Routers) == 0 {
+		errs = append(errs, fmt.Errorf("there should be at least one router"))
+	}
+	routerIDs := make(map[int]bool)
+	for _, router := range c.Routers {
+		if routerIDs[router.ID] {
+			errs = append(errs, fmt.Errorf("router %d is defined more than once", router.ID))
+		}
+		routerIDs[router.ID] = true
+	}
+	errs = append(errs, validateConsecutiveIDs("router", routerIDs)...)
+
+	var shardNodes []replicaEndpoint
+	for _, shardNode := range c.ShardNodes {
+		shardNodes = append(shardNodes, replicaEndpoint{id: shardNode.ID, replicaID: shardNode.ReplicaID})
+	}
+	errs = append(errs, validateReplicatedNodes("shard node", shardNodes)...)
+
+	var oramNodes []replicaEndpoint
+	oramNodeIDs := make(map[int]bool)
+	for _, oramNode := range c.OramNodes {
+		oramNodes = append(oramNodes, replicaEndpoint{id: oramNode.ID, replicaID: oramNode.ReplicaID})
+		oramNodeIDs[oramNode.ID] = true
+	}
+	errs = append(errs, validateReplicatedNodes("oram node", oramNodes)...)
+
+	if len(c.Redis) == 0 {
+		errs = append(errs, fmt.Errorf("there should be at least one redis storage"))
+	}
+	storageIDs := make(map[int]bool)
+	oramNodesWithStorage := make(map[int]bool)
+	for _, redis := range c.Redis {
+		if storageIDs[redis.ID] {
+			errs = append(errs, fmt.Errorf("storage %d is defined more than once", redis.ID))
+		}
+		storageIDs[redis.ID] = true
+		if !oramNodeIDs[redis.ORAMNodeID] {
+			errs = append(errs, fmt.Errorf("storage %d belongs to oram node %d, which does not exist", redis.ID, redis.ORAMNodeID))
+		}
+		oramNodesWithStorage[redis.ORAMNodeID] = true
+	}
+	for _, oramNodeID := range sortedKeys(oramNodeIDs) {
+		if !oramNodesWithStorage[oramNodeID] {
+			errs = append(errs, fmt.Errorf("oram node %d has no storage", oramNodeID))
+		}
+	}
+
+	errs = append(errs, c.validateAddresses()...)
+	return errors.Join(errs...)
+}
+
+// It checks that no two endpoints listen on the same address.
+func (c ClusterConfig) validateAddresses() (errs []error) {
+	addresses := make(map[string]string)
+	add := func(endpoint string, ip string, port int) {
+		if port <= 0 {
+			errs = append(errs, fmt.Errorf("%s should have a positive port but has %d", endpoint, port))
+			return
+		}
+		address := fmt.Sprintf("%s:%d", ip, port)
+		if other, exists := addresses[address]; exists {
+			errs = append(errs, fmt.Errorf("%s and %s both use %s", other, endpoint, address))
+			return
+		}
+		addresses[address] = endpoint
+	}
+	for _, router := range c.Routers {
+		add(fmt.Sprintf("router %d", router.ID), router.IP, router.Port)
+	}
+	for _, shardNode := range c.ShardNodes {
+		add(fmt.Sprintf("shard node %d replica %d", shardNode.ID, shardNode.ReplicaID), shardNode.IP, shardNode.Port)
+	}
+	for _, oramNode := range c.OramNodes {
+		add(fmt.Sprintf("oram node %d replica %d", oramNode.ID, oramNode.ReplicaID), oramNode.IP, oramNode.Port)
+	}
+	for _, redis := range c.Redis {
+		add(fmt.Sprintf("storage %d", redis.ID), redis.IP, redis.Port)
+	}
+	return errs
+}
+
+func sortedKeys(m map[int]bool) []int {
+	var keys []int
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
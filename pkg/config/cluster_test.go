@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func getValidClusterConfig() ClusterConfig {
+	return ClusterConfig{
+		Parameters: Parameters{
+			MaxBlocksToSend:   10,
+			EvictionRate:      10,
+			EvictPathCount:    10,
+			BatchTimout:       5,
+			EpochTime:         5,
+			Z:                 1,
+			S:                 9,
+			Shift:             1,
+			TreeHeight:        10,
+			RedisPipelineSize: 1000,
+			MaxRequests:       100,
+			BlockSize:         1024,
+		},
+		Routers: []RouterEndpoint{{IP: "localhost", Port: 8745, ID: 0}},
+		ShardNodes: []ShardNodeEndpoint{
+			{IP: "localhost", Port: 8748, ID: 0, ReplicaID: 0},
+			{IP: "localhost", Port: 8749, ID: 0, ReplicaID: 1},
+		},
+		OramNodes: []OramNodeEndpoint{
+			{IP: "localhost", Port: 2751, ID: 0, ReplicaID: 0},
+			{IP: "localhost", Port: 2752, ID: 1, ReplicaID: 0},
+		},
+		Redis: []RedisEndpoint{
+			{IP: "localhost", Port: 6379, ID: 0, ORAMNodeID: 0},
+			{IP: "localhost", Port: 6380, ID: 1, ORAMNodeID: 1},
+		},
+	}
+}
+
+func TestValidateReturnsNilForValidCluster(t *testing.T) {
+	if err := getValidClusterConfig().Validate(); err != nil {
+		t.Errorf("expected a valid cluster but got %s", err)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cluster := getValidClusterConfig()
+	cluster.Parameters.Z = 0
+	cluster.Parameters.RedisPipelineSize = 0
+	cluster.Routers = append(cluster.Routers, RouterEndpoint{IP: "localhost", Port: 8746, ID: 0})
+	cluster.ShardNodes[1].ReplicaID = 2
+	cluster.Redis = append(cluster.Redis, RedisEndpoint{IP: "localhost", Port: 6381, ID: 2, ORAMNodeID: 5})
+	cluster.OramNodes[1].Port = 2751
+
+	err := cluster.Validate()
+	if err == nil {
+		t.Fatalf("expected the problems of the cluster")
+	}
+	expectedProblems := []string{
+		"Z should be positive",
+		"redis-pipeline-size should be positive",
+		"router 0 is defined more than once",
+		"shard node 0 replica ids should start from zero without gaps, but 1 is missing",
+		"storage 2 belongs to oram node 5, which does not exist",
+		"oram node 0 replica 0 and oram node 1 replica 0 both use localhost:2751",
+	}
+	for _, problem := range expectedProblems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected the problem %q in %s", problem, err)
+		}
+	}
+}
+
+func TestValidateReportsNodeGapsAndOramNodesWithoutStorage(t *testing.T) {
+	cluster := getValidClusterConfig()
+	cluster.OramNodes[1].ID = 2
+	cluster.Redis[1].ORAMNodeID = 0
+	err := cluster.Validate()
+	if err == nil {
+		t.Fatalf("expected the problems of the cluster")
+	}
+	for _, problem := range []string{"oram node ids should start from zero without gaps, but 1 is missing", "oram node 2 has no storage"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected the problem %q in %s", problem, err)
+		}
+	}
+}
+
+func TestParametersValidateAllowsZeroForOptionalParameters(t *testing.T) {
+	parameters := getValidClusterConfig().Parameters
+	parameters.EpochBatchSize = 0
+	parameters.EpochTimeout = 0
+	parameters.VirtualNodes = 0
+	parameters.StorageRampUp = 0
+	parameters.EvictionTimeout = 0
+	parameters.MigrationTimeout = 0
+	parameters.PrepareTimeout = 0
+	parameters.IdempotencyWindow = 0
+	if err := parameters.Validate(); err != nil {
+		t.Errorf("expected zero to be allowed for optional parameters but got %s", err)
+	}
+	parameters.VirtualNodes = -1
+	if err := parameters.Validate(); err == nil {
+		t.Errorf("expected an error for negative virtual nodes")
+	}
+}
+
+func TestLoadClusterConfigPrefersClusterFile(t *testing.T) {
+	configsPath := t.TempDir()
+	err := os.WriteFile(path.Join(configsPath, ClusterConfigFileName), []byte("tree-height: 7\nrouters:\n  - exposed_ip: localhost\n    port: 8745\n    id: 0\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to write the cluster file; %s", err)
+	}
+	cluster, err := LoadClusterConfig(configsPath)
+	if err != nil {
+		t.Errorf("expected to read the cluster file but got %s", err)
+	}
+	if cluster.Parameters.TreeHeight != 7 || len(cluster.Routers) != 1 || cluster.Routers[0].Port != 8745 {
+		t.Errorf("expected the parameters and endpoints of the cluster file but got %v", cluster)
+	}
+	if ParametersPath(configsPath) != path.Join(configsPath, ClusterConfigFileName) {
+		t.Errorf("expected the parameters to be watched in the cluster file")
+	}
+}
+
+func TestLoadClusterConfigReadsSeparateFiles(t *testing.T) {
+	cluster, err := LoadClusterConfig("../../configs/default")
+	if err != nil {
+		t.Errorf("expected to read the default configs but got %s", err)
+	}
+	if err := cluster.Validate(); err != nil {
+		t.Errorf("expected the default configs to be valid but got %s", err)
+	}
+}
+
+func TestLoadClusterConfigReadsFaultRules(t *testing.T) {
+	configsPath := t.TempDir()
+	err := os.WriteFile(path.Join(configsPath, ClusterConfigFileName), []byte("faults:\n  - point: oramnode.evict.afterBeginEviction\n    action: crash\n    count: 1\n    replica_id: 0\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to write the cluster file; %s", err)
+	}
+	cluster, err := LoadClusterConfig(configsPath)
+	if err != nil {
+		t.Fatalf("expected to read the cluster file but got %s", err)
+	}
+	if len(cluster.Faults) != 1 || cluster.Faults[0].Action != "crash" || cluster.Faults[0].Count != 1 {
+		t.Fatalf("expected the crash rule but got %v", cluster.Faults)
+	}
+	if cluster.Faults[0].ID != nil || cluster.Faults[0].ReplicaID == nil || *cluster.Faults[0].ReplicaID != 0 {
+		t.Errorf("expected the rule to select only replica 0")
+	}
+}
+
+func TestReadFaultRulesReturnsNoRulesWithoutFile(t *testing.T) {
+	rules, err := ReadFaultRules(path.Join(t.TempDir(), "faults.yaml"))
+	if err != nil || len(rules) != 0 {
+		t.Errorf("expected no rules and no error but got %v, %v", rules, err)
+	}
+}
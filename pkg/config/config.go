@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"strconv"
 
@@ -35,6 +36,23 @@ type RedisEndpoint struct {
 	ORAMNodeID int `yaml:"oramnode_id"`
 }
 
+// FaultRule injects a fault at a named point of the nodes, see the faults package for the points.
+// The rules are only used in tests and failure experiments.
+type FaultRule struct {
+	Point  string  `yaml:"point"`
+	Action string  `yaml:"action"` // drop, delay or crash
+	Delay  float64 `yaml:"delay"`  // in milliseconds
+	Skip   int     `yaml:"skip"`   // the first hits of the point that are not faulted
+	Count  int     `yaml:"count"`  // the number of faulted hits, zero faults all of them
+	// If they are set, only the nodes with the id and the replicas with the replica id are faulted.
+	ID        *int `yaml:"id"`
+	ReplicaID *int `yaml:"replica_id"`
+}
+
+type FaultConfig struct {
+	Faults []FaultRule
+}
+
 type RouterConfig struct {
 	Endpoints []RouterEndpoint
 }
@@ -57,6 +75,9 @@ type Parameters struct {
 	EvictPathCount    int     `yaml:"evict-path-count"`
 	BatchTimout       float64 `yaml:"batch-timeout"`
 	EpochTime         float64 `yaml:"epoch-time"`
+	EpochBatchSize    int     `yaml:"epoch-batch-size"`
+	EpochTimeout      float64 `yaml:"epoch-timeout"`
+	VirtualNodes      int     `yaml:"virtual-nodes"`
 	Trace             bool    `yaml:"trace"`
 	Z                 int     `yaml:"Z"`
 	S                 int     `yaml:"S"`
@@ -67,6 +88,12 @@ type Parameters struct {
 	BlockSize         int     `yaml:"block-size"`
 	Log               bool    `yaml:"log"`
 	Profile           bool    `yaml:"profile"`
+	XORRead           bool    `yaml:"xor-read"`
+	StorageRampUp     float64 `yaml:"storage-ramp-up"`
+	EvictionTimeout   float64 `yaml:"eviction-timeout"`
+	MigrationTimeout  float64 `yaml:"migration-timeout"`
+	PrepareTimeout    float64 `yaml:"prepare-timeout"`
+	IdempotencyWindow float64 `yaml:"idempotency-window"`
 }
 
 func (o Parameters) String() string {
@@ -76,13 +103,22 @@ func (o Parameters) String() string {
 	output += "EvictPathCount: " + strconv.Itoa(o.EvictPathCount) + "\n"
 	output += "BatchTimout: " + strconv.FormatFloat(o.BatchTimout, 'f', -1, 64) + "\n"
 	output += "EpochTime: " + strconv.FormatFloat(o.EpochTime, 'f', -1, 64) + "\n"
+	output += "EpochBatchSize: " + strconv.Itoa(o.EpochBatchSize) + "\n"
+	output += "EpochTimeout: " + strconv.FormatFloat(o.EpochTimeout, 'f', -1, 64) + "\n"
+	output += "VirtualNodes: " + strconv.Itoa(o.VirtualNodes) + "\n"
 	output += "Z: " + strconv.Itoa(o.Z) + "\n"
 	output += "S: " + strconv.Itoa(o.S) + "\n"
 	output += "Shift: " + strconv.Itoa(o.Shift) + "\n"
 	output += "TreeHeight: " + strconv.Itoa(o.TreeHeight) + "\n"
 	output += "RedisPipelineSize: " + strconv.Itoa(o.RedisPipelineSize) + "\n"
 	output += "MaxRequests: " + strconv.Itoa(o.MaxRequests) + "\n"
-	output += "BlockSize: " + strconv.Itoa(o.BlockSize)
+	output += "BlockSize: " + strconv.Itoa(o.BlockSize) + "\n"
+	output += "XORRead: " + strconv.FormatBool(o.XORRead) + "\n"
+	output += "StorageRampUp: " + strconv.FormatFloat(o.StorageRampUp, 'f', -1, 64) + "\n"
+	output += "EvictionTimeout: " + strconv.FormatFloat(o.EvictionTimeout, 'f', -1, 64) + "\n"
+	output += "MigrationTimeout: " + strconv.FormatFloat(o.MigrationTimeout, 'f', -1, 64) + "\n"
+	output += "PrepareTimeout: " + strconv.FormatFloat(o.PrepareTimeout, 'f', -1, 64) + "\n"
+	output += "IdempotencyWindow: " + strconv.FormatFloat(o.IdempotencyWindow, 'f', -1, 64)
 	return output
 }
 
@@ -146,6 +182,25 @@ func ReadRedisEndpoints(path string) ([]RedisEndpoint, error) {
 	return config.Endpoints, nil
 }
 
+// ReadFaultRules returns no rules if the file does not exist, since the faults are optional.
+func ReadFaultRules(path string) ([]FaultRule, error) {
+	log.Debug().Msgf("Reading fault rules from the yaml file")
+	yamlFile, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config FaultConfig
+	err = yaml.Unmarshal(yamlFile, &config)
+	if err != nil {
+		return nil, err
+	}
+	return config.Faults, nil
+}
+
 func ReadParameters(path string) (Parameters, error) {
 	log.Debug().Msgf("Reading parameters from the yaml file")
 	yamlFile, err := os.ReadFile(path)
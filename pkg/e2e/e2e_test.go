@@ -2,15 +2,20 @@ package e2e
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/dsg-uwaterloo/treebeard/pkg/client"
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/linearizability"
 	"github.com/dsg-uwaterloo/treebeard/pkg/oramnode"
 	"github.com/dsg-uwaterloo/treebeard/pkg/router"
 	"github.com/dsg-uwaterloo/treebeard/pkg/shardnode"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
 )
 
 func startRouter() {
@@ -22,7 +27,7 @@ func startRouter() {
 	if err != nil {
 		log.Fatal().Msgf("Failed to create client connections with shard node servers; %v", err)
 	}
-	router.StartRPCServer("localhost", rpcClients, 0, 8745, config.Parameters{EpochTime: 10})
+	router.StartRPCServer("localhost", rpcClients, 0, 8745, config.Parameters{EpochTime: 10}, "")
 }
 
 func startShardNode(replicaID int, rpcPort int, raftPort int, joinAddr string) {
@@ -39,7 +44,7 @@ func startShardNode(replicaID int, rpcPort int, raftPort int, joinAddr string) {
 		log.Fatal().Msgf("Failed to read parameters from yaml file; %v", err)
 	}
 	redisEndpoints := []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}}
-	shardnode.StartServer(0, "localhost", "localhost", rpcPort, replicaID, raftPort, joinAddr, rpcClients, parameters, redisEndpoints, "../../configs")
+	shardnode.StartServer(0, "localhost", "localhost", rpcPort, replicaID, raftPort, joinAddr, rpcClients, parameters, redisEndpoints, "../../configs", nil, false)
 }
 
 func startOramNode(replicaID int, rpcPort int, raftPort int, joinAddr string) {
@@ -55,7 +60,7 @@ func startOramNode(replicaID int, rpcPort int, raftPort int, joinAddr string) {
 	if err != nil {
 		log.Fatal().Msgf("Failed to read parameters from yaml file; %v", err)
 	}
-	oramnode.StartServer(0, "localhost", "localhost", rpcPort, replicaID, raftPort, joinAddr, rpcClients, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}}, parameters)
+	oramnode.StartServer(0, "localhost", "localhost", rpcPort, replicaID, raftPort, joinAddr, rpcClients, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}}, parameters, "", nil, false)
 }
 
 // It assumes that the redis service is running on the default port (6379)
@@ -72,9 +77,12 @@ func startTestSystem() {
 	// TODO: kill the go routines, maybe by using cancel contexts
 }
 
+// The tests share the system, since it uses fixed ports
+var startTestSystemOnce sync.Once
+
 // TODO: make the tests better
 func TestSimpleRequestsReturnCorrectResponses(t *testing.T) {
-	startTestSystem()
+	startTestSystemOnce.Do(startTestSystem)
 	routerEndpoints, err := config.ReadRouterEndpoints("./configs/router_endpoints.yaml")
 	if err != nil {
 		log.Fatal().Msgf("Cannot read router endpoints from yaml file; %v", err)
@@ -101,4 +109,83 @@ func TestSimpleRequestsReturnCorrectResponses(t *testing.T) {
 	if readValue != "meow" {
 		t.Errorf("expected read value to be meow, but it is %s", readValue)
 	}
+
+	exists, err := routerRPCClient.Exists(context.Background(), "cat")
+	if err != nil || !exists {
+		t.Errorf("expected cat to exist after it is written; %v", err)
+	}
+	deleted, err := routerRPCClient.Delete(context.Background(), "cat")
+	if err != nil || !deleted {
+		t.Errorf("delete should return success: true; %v", err)
+	}
+	exists, err = routerRPCClient.Exists(context.Background(), "cat")
+	if err != nil || exists {
+		t.Errorf("expected cat not to exist after it is deleted; %v", err)
+	}
+	readValue, err = routerRPCClient.Read(context.Background(), "cat")
+	if err != nil || readValue != "" {
+		t.Errorf("expected the read of a deleted block to be empty, but it is %s; %v", readValue, err)
+	}
+	swapped, _, version, err := routerRPCClient.CompareAndSwap(context.Background(), "counter", "", "1", 0)
+	if err != nil || !swapped {
+		t.Errorf("expected the swap of a missing block with an empty expected value; %v", err)
+	}
+	swapped, value, _, err := routerRPCClient.CompareAndSwap(context.Background(), "counter", "1", "2", version+1)
+	if err != nil || swapped || value != "1" {
+		t.Errorf("expected no swap for a stale version, but got swapped: %t, value: %s; %v", swapped, value, err)
+	}
+	swapped, _, _, err = routerRPCClient.CompareAndSwap(context.Background(), "counter", "1", "2", version)
+	if err != nil || !swapped {
+		t.Errorf("expected the swap for the current value and version; %v", err)
+	}
+	readValue, readVersion, err := routerRPCClient.ReadVersioned(context.Background(), "counter")
+	if err != nil || readValue != "2" || readVersion != version+1 {
+		t.Errorf("expected value 2 with version %d, but got %s with version %d; %v", version+1, readValue, readVersion, err)
+	}
+
+	blocks, err := routerRPCClient.Scan(context.Background(), "", 10)
+	if err != nil || len(blocks) != 1 || blocks[0] != "counter" {
+		t.Errorf("expected the scan to return only counter, but got %v; %v", blocks, err)
+	}
+}
+
+func TestConcurrentRequestsAreLinearizable(t *testing.T) {
+	startTestSystemOnce.Do(startTestSystem)
+	routerEndpoints, err := config.ReadRouterEndpoints("./configs/router_endpoints.yaml")
+	if err != nil {
+		log.Fatal().Msgf("Cannot read router endpoints from yaml file; %v", err)
+	}
+	routers, err := client.StartRouterClient(routerEndpoints)
+	if err != nil {
+		log.Fatal().Msgf("Failed to start clients; %v", err)
+	}
+	defer routers.Close()
+
+	// A few blocks get many concurrent requests, so that requests for the same block end up in the same epoch
+	prefix := "linearizability/" + uuid.New().String() + "/"
+	var requests []client.Request
+	for i := 0; i < 200; i++ {
+		block := fmt.Sprintf("%s%d", prefix, i%4)
+		if i%3 == 0 {
+			requests = append(requests, client.Request{Block: block, OperationType: client.Write, NewValue: fmt.Sprint(i)})
+		} else {
+			requests = append(requests, client.Request{Block: block, OperationType: client.Read})
+		}
+	}
+	c := client.NewClient(client.NewRateLimit(20), otel.Tracer(""), routers, requests)
+	history := client.NewHistory()
+	c.RecordHistory(history)
+	readResponseChannel := make(chan client.ReadResponse)
+	writeResponseChannel := make(chan client.WriteResponse)
+	go c.SendRequestsForever(context.Background(), readResponseChannel, writeResponseChannel)
+	for range requests {
+		select {
+		case <-readResponseChannel:
+		case <-writeResponseChannel:
+		}
+	}
+
+	if linearizable, block := linearizability.CheckRegisters(history.Operations()); !linearizable {
+		t.Errorf("expected the history to be linearizable, but the history of block %s is not", block)
+	}
 }
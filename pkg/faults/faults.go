@@ -0,0 +1,251 @@
+// Package faults injects faults at named points of a node, so that the failure and recovery paths can be tested.
+// A rule drops, delays or crashes the node at its point. The rules only fault the hits of the point that they select
+// with their skip and count, so the same rules fault the same operations in every run.
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The points of the RPCs are their full method names, and every RPC of a node with an injector can be faulted.
+const (
+	ReadPath      = "/oramnode.OramNode/ReadPath"
+	SendBlocks    = "/shardnode.ShardNode/SendBlocks"
+	AckSentBlocks = "/shardnode.ShardNode/AckSentBlocks"
+	// The transaction decisions that the router sends to every shard node.
+	DecideTransactions = "/shardnode.ShardNode/DecideTransactions"
+)
+
+// The named points inside the operations of the nodes.
+const (
+	// Between the replication of the begin eviction and the read of the buckets of the eviction.
+	AfterBeginEviction = "oramnode.evict.afterBeginEviction"
+	// Between the write of the buckets of the eviction and sending the acks and nacks to the shard node.
+	AfterWriteBuckets = "oramnode.evict.afterWriteBuckets"
+	// Between sending the acks and nacks to the shard node and the replication of the end eviction.
+	AfterSendAcks = "oramnode.evict.afterSendAcks"
+	// Between the read of a block on the ORAM node and the replication of its response on the shard node.
+	BeforeReplicateResponse = "shardnode.query.beforeReplicateResponse"
+	// Before every redis pipeline of the storage handler, or every batch call of the in-memory storage.
+	StoragePipeline = "storage.pipeline"
+)
+
+type Action int
+
+const (
+	Drop  Action = iota // the RPC or the operation fails with ErrInjected without running
+	Delay               // the RPC or the operation runs after the delay of the rule
+	Crash               // the node crashes and the operation fails with ErrCrashed
+)
+
+func (a Action) String() string {
+	switch a {
+	case Drop:
+		return "drop"
+	case Delay:
+		return "delay"
+	case Crash:
+		return "crash"
+	}
+	return "unknown"
+}
+
+func ParseAction(action string) (Action, error) {
+	switch action {
+	case "drop":
+		return Drop, nil
+	case "delay":
+		return Delay, nil
+	case "crash":
+		return Crash, nil
+	}
+	return 0, fmt.Errorf("unknown fault action %q, it should be drop, delay or crash", action)
+}
+
+var (
+	ErrInjected = errors.New("injected fault")
+	ErrCrashed  = errors.New("crashed by an injected fault")
+)
+
+type Rule struct {
+	Point  string
+	Action Action
+	Delay  time.Duration
+	Skip   int // the first Skip hits of the point are not faulted
+	Count  int // the number of hits that are faulted after the skipped ones, zero faults all of them
+}
+
+func (r Rule) validate() error {
+	if r.Point == "" {
+		return fmt.Errorf("the fault rule has no point")
+	}
+	if r.Action < Drop || r.Action > Crash {
+		return fmt.Errorf("unknown fault action %d", r.Action)
+	}
+	if r.Skip < 0 || r.Count < 0 || r.Delay < 0 {
+		return fmt.Errorf("the skip, count and delay of the fault rule should not be negative")
+	}
+	return nil
+}
+
+type activeRule struct {
+	Rule
+	hits    int
+	faulted int
+}
+
+// Injector keeps the rules of a node. A nil injector never faults, so the nodes can run without one.
+type Injector struct {
+	mu      sync.Mutex
+	rules   []*activeRule
+	faulted map[string]int // map of point to the number of faulted hits
+	crash   func(point string)
+}
+
+// NewInjector returns an injector without rules.
+// The crash function is called when a rule crashes the node, and it exits the process if it is nil.
+func NewInjector(crash func(point string)) *Injector {
+	if crash == nil {
+		crash = func(point string) {
+			log.Fatal().Msgf("Crashed at %s by an injected fault", point)
+		}
+	}
+	return &Injector{faulted: make(map[string]int), crash: crash}
+}
+
+func (i *Injector) Add(rule Rule) error {
+	err := rule.validate()
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	log.Debug().Msgf("Adding fault rule %v", rule)
+	i.rules = append(i.rules, &activeRule{Rule: rule})
+	return nil
+}
+
+// NewConfigInjector returns an injector with the rules of the config that select the node, or nil if fault injection is disabled.
+// The rules are an error if fault injection is disabled, so that a config does not silently leave them out.
+func NewConfigInjector(rules []config.FaultRule, enabled bool, id int, replicaID int) (*Injector, error) {
+	if !enabled {
+		if len(rules) != 0 {
+			return nil, fmt.Errorf("the config has fault rules but fault injection is disabled")
+		}
+		return nil, nil
+	}
+	injector := NewInjector(nil)
+	err := injector.AddConfigRules(rules, id, replicaID)
+	if err != nil {
+		return nil, err
+	}
+	return injector, nil
+}
+
+// AddConfigRules adds the rules of the config that select the node with the id and the replica id.
+func (i *Injector) AddConfigRules(rules []config.FaultRule, id int, replicaID int) error {
+	for _, configRule := range rules {
+		if (configRule.ID != nil && *configRule.ID != id) || (configRule.ReplicaID != nil && *configRule.ReplicaID != replicaID) {
+			continue
+		}
+		action, err := ParseAction(configRule.Action)
+		if err != nil {
+			return err
+		}
+		err = i.Add(Rule{
+			Point:  configRule.Point,
+			Action: action,
+			Delay:  time.Duration(configRule.Delay * float64(time.Millisecond)),
+			Skip:   configRule.Skip,
+			Count:  configRule.Count,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear removes all the rules. The counts of the faulted hits are kept.
+func (i *Injector) Clear() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.rules = nil
+}
+
+// Faulted returns the number of hits of the point that were faulted.
+func (i *Injector) Faulted(point string) int {
+	if i == nil {
+		return 0
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.faulted[point]
+}
+
+// It returns the first rule of the point that faults this hit, or nil.
+// Every rule of the point counts the hit.
+func (i *Injector) match(point string) *Rule {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var matched *Rule
+	for _, rule := range i.rules {
+		if rule.Point != point {
+			continue
+		}
+		rule.hits++
+		if matched != nil || rule.hits <= rule.Skip || (rule.Count != 0 && rule.faulted >= rule.Count) {
+			continue
+		}
+		rule.faulted++
+		matched = &rule.Rule
+	}
+	if matched != nil {
+		i.faulted[point]++
+	}
+	return matched
+}
+
+// Hit is called when a node reaches the point. It returns an error if the operation should not continue.
+func (i *Injector) Hit(point string) error {
+	if i == nil {
+		return nil
+	}
+	rule := i.match(point)
+	if rule == nil {
+		return nil
+	}
+	log.Debug().Msgf("Injecting fault %s at %s", rule.Action, point)
+	switch rule.Action {
+	case Drop:
+		return ErrInjected
+	case Delay:
+		time.Sleep(rule.Delay)
+	case Crash:
+		i.crash(point)
+		return ErrCrashed
+	}
+	return nil
+}
+
+// UnaryServerInterceptor faults the RPCs of the grpc server with the rules of their full method names.
+// A dropped RPC fails with the unavailable code, like an RPC that did not reach the node.
+func (i *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		err := i.Hit(info.FullMethod)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "%s; %s", info.FullMethod, err)
+		}
+		return handler(ctx, req)
+	}
+}
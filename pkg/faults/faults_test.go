@@ -0,0 +1,124 @@
+package faults
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHitFaultsOnlyTheSelectedHits(t *testing.T) {
+	i := NewInjector(nil)
+	i.Add(Rule{Point: ReadPath, Action: Drop, Skip: 1, Count: 2})
+	var results []error
+	for hit := 0; hit < 4; hit++ {
+		results = append(results, i.Hit(ReadPath))
+	}
+	if results[0] != nil || results[1] != ErrInjected || results[2] != ErrInjected || results[3] != nil {
+		t.Errorf("expected only the second and third hits to be dropped but got %v", results)
+	}
+	if i.Faulted(ReadPath) != 2 {
+		t.Errorf("expected 2 faulted hits but got %d", i.Faulted(ReadPath))
+	}
+	if i.Hit(SendBlocks) != nil {
+		t.Errorf("expected the other points not to be faulted")
+	}
+}
+
+func TestHitAppliesTheFirstMatchingRule(t *testing.T) {
+	i := NewInjector(nil)
+	i.Add(Rule{Point: ReadPath, Action: Drop, Count: 1})
+	i.Add(Rule{Point: ReadPath, Action: Delay, Delay: 20 * time.Millisecond, Skip: 1, Count: 1})
+	if i.Hit(ReadPath) != ErrInjected {
+		t.Errorf("expected the first hit to be dropped")
+	}
+	start := time.Now()
+	if err := i.Hit(ReadPath); err != nil || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("expected the second hit to be delayed but got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestHitCallsCrashFunction(t *testing.T) {
+	var crashedAt string
+	i := NewInjector(func(point string) { crashedAt = point })
+	i.Add(Rule{Point: AfterWriteBuckets, Action: Crash})
+	if err := i.Hit(AfterWriteBuckets); err != ErrCrashed || crashedAt != AfterWriteBuckets {
+		t.Errorf("expected a crash at %s but got %v at %q", AfterWriteBuckets, err, crashedAt)
+	}
+}
+
+func TestNilInjectorDoesNotFault(t *testing.T) {
+	var i *Injector
+	if i.Hit(StoragePipeline) != nil || i.Faulted(StoragePipeline) != 0 {
+		t.Errorf("expected a nil injector not to fault")
+	}
+}
+
+func TestAddRejectsInvalidRules(t *testing.T) {
+	i := NewInjector(nil)
+	if i.Add(Rule{Action: Drop}) == nil {
+		t.Errorf("expected an error for a rule without point")
+	}
+	if i.Add(Rule{Point: ReadPath, Action: Drop, Count: -1}) == nil {
+		t.Errorf("expected an error for a negative count")
+	}
+}
+
+func TestAddConfigRulesAddsOnlyTheRulesOfTheReplica(t *testing.T) {
+	one := 1
+	i := NewInjector(func(string) {})
+	err := i.AddConfigRules([]config.FaultRule{
+		{Point: AfterBeginEviction, Action: "crash", ReplicaID: &one},
+		{Point: ReadPath, Action: "delay", Delay: 1},
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("expected the rules to be added but got %s", err)
+	}
+	if i.Hit(AfterBeginEviction) != nil {
+		t.Errorf("expected the rule of replica 1 not to be added to replica 0")
+	}
+	if i.Hit(ReadPath) != nil || i.Faulted(ReadPath) != 1 {
+		t.Errorf("expected the read path to be delayed")
+	}
+	if i.AddConfigRules([]config.FaultRule{{Point: ReadPath, Action: "explode"}}, 0, 0) == nil {
+		t.Errorf("expected an error for an unknown action")
+	}
+}
+
+func TestNewConfigInjectorReturnsNoInjectorIfFaultInjectionIsDisabled(t *testing.T) {
+	i, err := NewConfigInjector(nil, false, 0, 0)
+	if err != nil || i != nil {
+		t.Errorf("expected no injector but got %v; %v", i, err)
+	}
+	if _, err := NewConfigInjector([]config.FaultRule{{Point: ReadPath, Action: "drop"}}, false, 0, 0); err == nil {
+		t.Errorf("expected an error for fault rules without fault injection")
+	}
+	i, err = NewConfigInjector([]config.FaultRule{{Point: ReadPath, Action: "drop"}}, true, 0, 0)
+	if err != nil || i == nil || i.Hit(ReadPath) != ErrInjected {
+		t.Errorf("expected an injector with the rules but got %v; %v", i, err)
+	}
+}
+
+func TestUnaryServerInterceptorDropsRPCWithUnavailable(t *testing.T) {
+	i := NewInjector(nil)
+	i.Add(Rule{Point: SendBlocks, Action: Drop, Count: 1})
+	handled := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled++
+		return "reply", nil
+	}
+	interceptor := i.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: SendBlocks}
+	_, err := interceptor(context.Background(), nil, info, handler)
+	if status.Code(err) != codes.Unavailable || handled != 0 {
+		t.Errorf("expected the rpc to be dropped before the handler but got %v", err)
+	}
+	reply, err := interceptor(context.Background(), nil, info, handler)
+	if err != nil || reply != "reply" || handled != 1 {
+		t.Errorf("expected the second rpc to be handled but got %v, %v", reply, err)
+	}
+}
@@ -0,0 +1,223 @@
+// Package linearizability checks that the history of concurrent reads and writes of the blocks is linearizable.
+// Every block is a register, and the history of every block is checked on its own
+// with the algorithm of Wing and Gong, with the memoization of Lowe, like Porcupine does.
+package linearizability
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+type OperationType int
+
+const (
+	Read OperationType = iota
+	Write
+)
+
+// Operation is a read or a write that a client sent, with the time of its call and of its return.
+type Operation struct {
+	Type  OperationType
+	Block string
+	Value string // the value that is written, or the value that the read returned
+	Call  time.Time
+	// It is zero if the operation did not return, for example if it failed.
+	// A write that did not return may be applied at any time after its call, a read that did not return is ignored.
+	Return time.Time
+}
+
+func (o Operation) returned() bool {
+	return !o.Return.IsZero()
+}
+
+// CheckRegisters returns true if the history of every block is linearizable.
+// Otherwise, it returns false and a block whose history is not linearizable.
+// The blocks start with the empty value.
+func CheckRegisters(history []Operation) (linearizable bool, failedBlock string) {
+	histories := make(map[string][]Operation)
+	for _, operation := range history {
+		if operation.Type == Read && !operation.returned() {
+			continue
+		}
+		histories[operation.Block] = append(histories[operation.Block], operation)
+	}
+	blocks := make([]string, 0, len(histories))
+	for block := range histories {
+		blocks = append(blocks, block)
+	}
+	sort.Strings(blocks)
+	for _, block := range blocks {
+		if !checkRegister(histories[block]) {
+			return false, block
+		}
+	}
+	return true, ""
+}
+
+// A call and a return of an operation in the list of events.
+// The call points to its return with match, the return has no match.
+type event struct {
+	operation int
+	time      int64
+	match     *event
+	prev      *event
+	next      *event
+}
+
+// It returns the events of the operations in the order of their time, with a call before a return at the same time.
+// The head of the list is a sentinel.
+func makeEventList(history []Operation) *event {
+	type timedEvent struct {
+		event  *event
+		isCall bool
+	}
+	var events []timedEvent
+	for i, operation := range history {
+		returnTime := int64(math.MaxInt64)
+		if operation.returned() {
+			returnTime = operation.Return.UnixNano()
+		}
+		returnEvent := &event{operation: i, time: returnTime}
+		events = append(events, timedEvent{event: &event{operation: i, time: operation.Call.UnixNano(), match: returnEvent}, isCall: true})
+		events = append(events, timedEvent{event: returnEvent})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].event.time != events[j].event.time {
+			return events[i].event.time < events[j].event.time
+		}
+		return events[i].isCall && !events[j].isCall
+	})
+	head := &event{operation: -1}
+	last := head
+	for _, e := range events {
+		e.event.prev = last
+		last.next = e.event
+		last = e.event
+	}
+	return head
+}
+
+// It removes the call and its return from the list.
+func lift(call *event) {
+	call.prev.next = call.next
+	call.next.prev = call.prev
+	ret := call.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+// It puts the call and its return back in the list, in the reverse order of lift.
+func unlift(call *event) {
+	ret := call.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	call.prev.next = call
+	call.next.prev = call
+}
+
+type bitset []uint64
+
+func newBitset(size int) bitset {
+	return make(bitset, (size+63)/64)
+}
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << (i % 64) }
+
+func (b bitset) clone() bitset {
+	c := make(bitset, len(b))
+	copy(c, b)
+	return c
+}
+
+func (b bitset) equals(c bitset) bool {
+	for i := range b {
+		if b[i] != c[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bitset) hash() uint64 {
+	hash := uint64(len(b))
+	for _, word := range b {
+		hash = hash*31 + word
+	}
+	return hash
+}
+
+// The operations that are linearized and the value of the register after them.
+type cacheEntry struct {
+	linearized bitset
+	value      string
+}
+
+// It applies the operation to the register with value, and returns false if the operation can not see value.
+func step(value string, operation Operation) (bool, string) {
+	if operation.Type == Write {
+		return true, operation.Value
+	}
+	return operation.Value == value, value
+}
+
+// It searches for an order of the operations that is consistent with their times and with a register.
+// An operation is linearized if it is the first call in the list, or if no return comes before it.
+// If no call can be linearized before the next return, the last linearized operation is undone and the search goes on.
+// A state that was seen before, the same linearized operations with the same value, is not searched again.
+func checkRegister(history []Operation) bool {
+	head := makeEventList(history)
+	linearized := newBitset(len(history))
+	cache := make(map[uint64][]cacheEntry)
+	type call struct {
+		event *event
+		value string
+	}
+	var calls []call
+	value := ""
+	entry := head.next
+	for head.next != nil {
+		if entry.match != nil {
+			ok, newValue := step(value, history[entry.operation])
+			if ok {
+				newLinearized := linearized.clone()
+				newLinearized.set(entry.operation)
+				seen := false
+				hash := newLinearized.hash()
+				for _, cached := range cache[hash] {
+					if cached.value == newValue && cached.linearized.equals(newLinearized) {
+						seen = true
+						break
+					}
+				}
+				if !seen {
+					cache[hash] = append(cache[hash], cacheEntry{linearized: newLinearized, value: newValue})
+					calls = append(calls, call{event: entry, value: value})
+					value = newValue
+					linearized.set(entry.operation)
+					lift(entry)
+					entry = head.next
+					continue
+				}
+			}
+			entry = entry.next
+			continue
+		}
+		// A return comes before any call that can be linearized
+		if len(calls) == 0 {
+			return false
+		}
+		last := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		value = last.value
+		linearized.clear(last.event.operation)
+		unlift(last.event)
+		entry = last.event.next
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package linearizability
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+var start = time.Unix(0, 0)
+
+func at(t int) time.Time {
+	return start.Add(time.Duration(t) * time.Millisecond)
+}
+
+func write(block string, value string, call int, ret int) Operation {
+	operation := Operation{Type: Write, Block: block, Value: value, Call: at(call)}
+	if ret >= 0 {
+		operation.Return = at(ret)
+	}
+	return operation
+}
+
+func read(block string, value string, call int, ret int) Operation {
+	return Operation{Type: Read, Block: block, Value: value, Call: at(call), Return: at(ret)}
+}
+
+func TestCheckRegistersAcceptsLinearizableHistories(t *testing.T) {
+	histories := map[string][]Operation{
+		"sequential": {
+			read("a", "", 0, 1), write("a", "1", 2, 3), read("a", "1", 4, 5),
+		},
+		"read concurrent with a write sees the old value": {
+			write("a", "1", 0, 10), read("a", "", 1, 2), read("a", "1", 3, 4),
+		},
+		"overlapping writes in either order": {
+			write("a", "1", 0, 10), write("a", "2", 0, 10), read("a", "1", 11, 12),
+		},
+		"write that did not return is applied later": {
+			write("a", "1", 0, -1), read("a", "", 1, 2), read("a", "1", 3, 4),
+		},
+		"write that did not return is never applied": {
+			write("a", "1", 0, -1), read("a", "", 5, 6),
+		},
+	}
+	for name, history := range histories {
+		if linearizable, _ := CheckRegisters(history); !linearizable {
+			t.Errorf("expected the history %q to be linearizable", name)
+		}
+	}
+}
+
+func TestCheckRegistersRejectsHistoriesThatAreNotLinearizable(t *testing.T) {
+	histories := map[string][]Operation{
+		"stale read": {
+			write("a", "1", 0, 1), read("a", "", 2, 3),
+		},
+		"read of a value that was never written": {
+			read("a", "1", 0, 1),
+		},
+		"reads see the writes in different orders": {
+			write("a", "1", 0, 10), write("a", "2", 0, 10),
+			read("a", "1", 11, 12), read("a", "2", 13, 14), read("a", "1", 15, 16),
+		},
+	}
+	for name, history := range histories {
+		if linearizable, _ := CheckRegisters(history); linearizable {
+			t.Errorf("expected the history %q not to be linearizable", name)
+		}
+	}
+}
+
+func TestCheckRegistersReturnsTheBlockThatIsNotLinearizable(t *testing.T) {
+	history := []Operation{
+		write("a", "1", 0, 1), read("a", "1", 2, 3),
+		write("b", "1", 0, 1), read("b", "", 2, 3),
+	}
+	linearizable, block := CheckRegisters(history)
+	if linearizable || block != "b" {
+		t.Errorf("expected block b not to be linearizable but got %t, %s", linearizable, block)
+	}
+}
+
+// The operations are applied one after the other at a random time between their call and their return,
+// so the history is linearizable.
+func TestCheckRegistersAcceptsLargeConcurrentHistory(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	value := ""
+	var history []Operation
+	for i := 0; i < 300; i++ {
+		applyTime := i * 10
+		call := applyTime - rng.Intn(40)
+		ret := applyTime + rng.Intn(40)
+		if rng.Intn(2) == 0 {
+			value = fmt.Sprint(i)
+			history = append(history, write("a", value, call, ret))
+		} else {
+			history = append(history, read("a", value, call, ret))
+		}
+	}
+	if linearizable, _ := CheckRegisters(history); !linearizable {
+		t.Errorf("expected the history to be linearizable")
+	}
+}
@@ -0,0 +1,60 @@
+package oramnode
+
+import (
+	"fmt"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+)
+
+func (o *oramNodeServer) getParameters() config.Parameters {
+	o.parametersMu.RLock()
+	defer o.parametersMu.RUnlock()
+	return o.parameters
+}
+
+// The evict path count is replicated, so that a new leader evicts the same paths as the previous one.
+func (o *oramNodeServer) getEvictPathCount() int {
+	if evictPathCount := o.oramNodeFSM.getEvictPathCount(); evictPathCount != 0 {
+		return evictPathCount
+	}
+	return o.getParameters().EvictPathCount
+}
+
+// Parameters returns the parameters of the oram node with the replicated evict path count.
+func (o *oramNodeServer) Parameters() config.Parameters {
+	parameters := o.getParameters()
+	parameters.EvictPathCount = o.getEvictPathCount()
+	return parameters
+}
+
+// UpdateParameters changes the eviction rate and the max blocks to send of this replica.
+// A new evict path count is only replicated by the leader, the followers skip it and get it from the leader's log.
+func (o *oramNodeServer) UpdateParameters(parameters config.Parameters) error {
+	log.Debug().Msgf("Aquiring lock for oram node parameters in UpdateParameters")
+	o.parametersMu.Lock()
+	log.Debug().Msgf("Aquired lock for oram node parameters in UpdateParameters")
+	o.parameters.EvictionRate = parameters.EvictionRate
+	o.parameters.MaxBlocksToSend = parameters.MaxBlocksToSend
+	log.Debug().Msgf("Releasing lock for oram node parameters in UpdateParameters")
+	o.parametersMu.Unlock()
+	log.Debug().Msgf("Released lock for oram node parameters in UpdateParameters")
+
+	if parameters.EvictPathCount == o.getEvictPathCount() {
+		return nil
+	}
+	if o.raftNode.State() != raft.Leader {
+		log.Debug().Msgf("Skipping the evict path count %d since only the leader replicates it", parameters.EvictPathCount)
+		return nil
+	}
+	command, err := newReplicateEvictPathCountCommand(parameters.EvictPathCount)
+	if err != nil {
+		return fmt.Errorf("unable to create evict path count replication command; %s", err)
+	}
+	err = o.raftNode.Apply(command, 0).Error()
+	if err != nil {
+		return fmt.Errorf("could not apply log to the FSM; %s", err)
+	}
+	return nil
+}
@@ -23,12 +23,11 @@ type ReplicaRPCClientMap map[int]ShardNodeRPCClient
 
 type ShardNodeRPCClients map[int]ReplicaRPCClientMap
 
-func (c ShardNodeRPCClients) getRandomShardNodeClient() ReplicaRPCClientMap {
-	randomIndex := rand.Intn(len(c))
-	return c[randomIndex]
+func (c ShardNodeRPCClients) getRandomShardNodeID() int {
+	return rand.Intn(len(c))
 }
 
-func (r *ReplicaRPCClientMap) sendAcksToShardNode(acks []*shardnodepb.Ack) error {
+func (r *ReplicaRPCClientMap) sendAcksToShardNode(evictionID string, acks []*shardnodepb.Ack) error {
 
 	var replicaFuncs []rpc.CallFunc
 	var clients []interface{}
@@ -40,23 +39,51 @@ func (r *ReplicaRPCClientMap) sendAcksToShardNode(acks []*shardnodepb.Ack) error
 		)
 		clients = append(clients, c)
 	}
-	log.Debug().Msgf("Sending acks to shard node %v", acks)
+	log.Debug().Msgf("Sending acks of eviction %s to shard node %v", evictionID, acks)
 	_, err := rpc.CallAllReplicas(
 		context.Background(),
 		clients,
 		replicaFuncs,
 		&shardnodepb.AckSentBlocksRequest{
-			Acks: acks,
+			Acks:       acks,
+			EvictionId: evictionID,
 		},
 	)
 	if err != nil {
-		return fmt.Errorf("could not read blocks from the shardnode; %s", err)
+		return fmt.Errorf("could not send acks to the shardnode; %s", err)
 	}
 	return nil
 }
 
-func (r *ReplicaRPCClientMap) getBlocksFromShardNode(storageID int, maxBlocksToSend int) ([]*shardnodepb.Block, error) {
+// The shard node returns the same blocks for the same eviction id.
+// It returns the blocks that the shard node sends for the eviction, or finished if the eviction already got its acks and nacks.
+func (r *ReplicaRPCClientMap) getBlocksFromShardNode(evictionID string, paths []int, storageID int, maxBlocksToSend int) (blocks []*shardnodepb.Block, finished bool, err error) {
+	var pathsToSend []int32
+	for _, path := range paths {
+		pathsToSend = append(pathsToSend, int32(path))
+	}
+	shardNodeReply, err := r.callSendBlocks(&shardnodepb.SendBlocksRequest{
+		MaxBlocks:  int32(maxBlocksToSend),
+		Paths:      pathsToSend,
+		StorageId:  int32(storageID),
+		EvictionId: evictionID,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return shardNodeReply.Blocks, shardNodeReply.Finished, nil
+}
 
+// It asks the shard node if the eviction that is replayed already got its acks and nacks, and if it timed out.
+func (r *ReplicaRPCClientMap) getReplayedEviction(evictionID string) (finished bool, timedOut bool, err error) {
+	shardNodeReply, err := r.callSendBlocks(&shardnodepb.SendBlocksRequest{EvictionId: evictionID, Replay: true})
+	if err != nil {
+		return false, false, err
+	}
+	return shardNodeReply.Finished, shardNodeReply.TimedOut, nil
+}
+
+func (r *ReplicaRPCClientMap) callSendBlocks(request *shardnodepb.SendBlocksRequest) (*shardnodepb.SendBlocksReply, error) {
 	var replicaFuncs []rpc.CallFunc
 	var clients []interface{}
 	for _, c := range *r {
@@ -67,38 +94,31 @@ func (r *ReplicaRPCClientMap) getBlocksFromShardNode(storageID int, maxBlocksToS
 		)
 		clients = append(clients, c)
 	}
-	reply, err := rpc.CallAllReplicas(
-		context.Background(),
-		clients,
-		replicaFuncs,
-		&shardnodepb.SendBlocksRequest{
-			MaxBlocks: int32(maxBlocksToSend),
-			StorageId: int32(storageID),
-		},
-	)
+	reply, err := rpc.CallAllReplicas(context.Background(), clients, replicaFuncs, request)
 	if err != nil {
 		return nil, fmt.Errorf("could not read blocks from the shardnode; %s", err)
 	}
 	log.Debug().Msgf("Got reply from shard node: %v", reply)
-	shardNodeReply := reply.(*shardnodepb.SendBlocksReply)
-	return shardNodeReply.Blocks, nil
+	return reply.(*shardnodepb.SendBlocksReply), nil
 }
 
-func (r *ReplicaRPCClientMap) sendBackAcksNacks(recievedBlocksStatus map[string]bool) {
+func (r *ReplicaRPCClientMap) sendBackAcksNacks(evictionID string, recievedBlocksStatus map[string]bool) error {
 	var acks []*shardnodepb.Ack
 	for block, status := range recievedBlocksStatus {
 		acks = append(acks, &shardnodepb.Ack{Block: block, IsAck: status})
 	}
-	r.sendAcksToShardNode(acks)
+	return r.sendAcksToShardNode(evictionID, acks)
 }
 
-func StartShardNodeRPCClients(endpoints []config.ShardNodeEndpoint) (map[int]ReplicaRPCClientMap, error) {
+// The dial options are added to the default ones, for example to dial the replicas over in-memory connections.
+func StartShardNodeRPCClients(endpoints []config.ShardNodeEndpoint, dialOptions ...grpc.DialOption) (map[int]ReplicaRPCClientMap, error) {
 	log.Debug().Msgf("Starting ShardNode RPC clients for endpoints: %v", endpoints)
 	clients := make(map[int]ReplicaRPCClientMap)
 	for _, endpoint := range endpoints {
 		serverAddr := fmt.Sprintf("%s:%d", endpoint.IP, endpoint.Port)
 		log.Debug().Msgf("Starting ShardNode RPC client for endpoint: %s", serverAddr)
-		conn, err := grpc.Dial(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt64), grpc.MaxCallSendMsgSize(math.MaxInt64)))
+		options := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt64), grpc.MaxCallSendMsgSize(math.MaxInt64))}, dialOptions...)
+		conn, err := grpc.Dial(serverAddr, options...)
 		if err != nil {
 			return nil, err
 		}
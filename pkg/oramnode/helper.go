@@ -1,6 +1,11 @@
 package oramnode
 
+// It splits the bucketIDs into batches of at most maxElementCount.
+// If maxElementCount is not positive, all the bucketIDs go in one batch.
 func distributeBucketIDs(bucketIDs []int, maxElementCount int) [][]int {
+	if maxElementCount <= 0 {
+		return [][]int{bucketIDs}
+	}
 	var bucketIDBatches [][]int
 	for i := 0; i < len(bucketIDs); i += maxElementCount {
 		end := i + maxElementCount
@@ -32,3 +32,10 @@ func TestDistributeBucketIDs(t *testing.T) {
 		}
 	}
 }
+
+func TestDistributeBucketIDsWithoutMaxElementCountReturnsOneBatch(t *testing.T) {
+	batches := distributeBucketIDs([]int{1, 2, 3}, 0)
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Errorf("expected one batch with all the bucket ids but got %v", batches)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	strg "github.com/dsg-uwaterloo/treebeard/pkg/storage"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 	"github.com/rs/zerolog/log"
@@ -17,9 +18,14 @@ import (
 	"go.opentelemetry.io/otel"
 )
 
+// It is everything that is needed to replay an interrupted eviction exactly.
 type beginEvictionData struct {
+	evictionID           string // the shard node sends the same blocks and ignores repeated acks for the same eviction id
 	currentEvictionCount int
 	storageID            int
+	evictPathCount       int
+	shardNodeID          int
+	blocks               map[string]strg.BlockInfo // nil until the blocks of the shard node are replicated
 }
 
 type beginReadPathData struct {
@@ -27,27 +33,60 @@ type beginReadPathData struct {
 	storageID int
 }
 
+type reshuffleJobData struct {
+	buckets   []int
+	storageID int
+}
+
+// The number of read blocks that the FSM keeps until they are written back.
+const readBlocksWindowSize = 10000
+
+type readBlockKey struct {
+	storageID int
+	block     string
+}
+
+type readBlock struct {
+	strg.BlockInfo
+	index uint64 // the read blocks are trimmed from the oldest index
+}
+
 type oramNodeFSM struct {
-	unfinishedEviction   *beginEvictionData // unfinished eviction
-	unfinishedEvictionMu sync.Mutex
-	unfinishedReadPath   *beginReadPathData // unfinished read path
-	unfinishedReadPathMu sync.Mutex
-	evictionCountMap     map[int]int // map of storage id to number of evictions
+	unfinishedEviction    *beginEvictionData // unfinished eviction
+	unfinishedEvictionMu  sync.Mutex
+	unfinishedReadPaths   map[string]beginReadPathData // map of read path id to unfinished read path
+	unfinishedReadPathsMu sync.Mutex
+	evictionCountMap      map[int]int                 // map of storage id to number of evictions
+	pendingReshuffles     map[string]reshuffleJobData // map of reshuffle job id to the job that is not finished yet
+	pendingReshufflesMu   sync.Mutex
+	evictPathCount        int // zero until the evict path count is changed at runtime
+	evictPathCountMu      sync.RWMutex
+	// The blocks that were read from the storages and were not written back by an eviction yet.
+	// A shard node leader that crashes before it replicates the response of a read loses the value of the block,
+	// so the new leader reads the block again from the same path and gets the value from here.
+	readBlocks      map[readBlockKey]readBlock
+	readBlocksOrder []readBlockKey // the keys of the read blocks from the oldest to the newest read
+	readBlocksIndex uint64
+	readBlocksMu    sync.Mutex
 }
 
 func (fsm *oramNodeFSM) String() string {
 	out := fmt.Sprintln("oramNodeFSM")
 	out = out + fmt.Sprintf("unfinishedEviction: %v\n", fsm.unfinishedEviction)
+	out = out + fmt.Sprintf("pendingReshuffles: %v\n", fsm.pendingReshuffles)
 	return out
 }
 
 func newOramNodeFSM() *oramNodeFSM {
 	return &oramNodeFSM{
-		evictionCountMap: make(map[int]int),
+		unfinishedReadPaths: make(map[string]beginReadPathData),
+		evictionCountMap:    make(map[int]int),
+		pendingReshuffles:   make(map[string]reshuffleJobData),
+		readBlocks:          make(map[readBlockKey]readBlock),
 	}
 }
 
-func (fsm *oramNodeFSM) handleBeginEvictionCommand(currentEvictionCount int, storageID int) {
+func (fsm *oramNodeFSM) handleBeginEvictionCommand(evictionID string, currentEvictionCount int, storageID int, evictPathCount int, shardNodeID int) {
 	log.Debug().Msgf("Aquiring lock for oramNodeFSM in handleBeginEvictionCommand")
 	fsm.unfinishedEvictionMu.Lock()
 	log.Debug().Msgf("Aquired lock for oramNodeFSM in handleBeginEvictionCommand")
@@ -57,10 +96,52 @@ func (fsm *oramNodeFSM) handleBeginEvictionCommand(currentEvictionCount int, sto
 		log.Debug().Msgf("Released lock for oramNodeFSM in handleBeginEvictionCommand")
 	}()
 
-	fsm.unfinishedEviction = &beginEvictionData{currentEvictionCount, storageID}
+	fsm.unfinishedEviction = &beginEvictionData{
+		evictionID:           evictionID,
+		currentEvictionCount: currentEvictionCount,
+		storageID:            storageID,
+		evictPathCount:       evictPathCount,
+		shardNodeID:          shardNodeID,
+	}
 }
 
-func (fsm *oramNodeFSM) handleEndEvictionCommand(updatedEvictionCount int, storageID int) {
+func (fsm *oramNodeFSM) handleEvictionBlocksCommand(evictionID string, blocks map[string]strg.BlockInfo) {
+	log.Debug().Msgf("Aquiring lock for oramNodeFSM in handleEvictionBlocksCommand")
+	fsm.unfinishedEvictionMu.Lock()
+	log.Debug().Msgf("Aquired lock for oramNodeFSM in handleEvictionBlocksCommand")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for oramNodeFSM in handleEvictionBlocksCommand")
+		fsm.unfinishedEvictionMu.Unlock()
+		log.Debug().Msgf("Released lock for oramNodeFSM in handleEvictionBlocksCommand")
+	}()
+	if fsm.unfinishedEviction == nil || fsm.unfinishedEviction.evictionID != evictionID {
+		return
+	}
+	if blocks == nil {
+		blocks = make(map[string]strg.BlockInfo)
+	}
+	fsm.unfinishedEviction.blocks = blocks
+}
+
+// It returns a copy of the unfinished eviction, or nil if there is none.
+func (fsm *oramNodeFSM) getUnfinishedEviction() *beginEvictionData {
+	fsm.unfinishedEvictionMu.Lock()
+	defer fsm.unfinishedEvictionMu.Unlock()
+	if fsm.unfinishedEviction == nil {
+		return nil
+	}
+	eviction := *fsm.unfinishedEviction
+	if eviction.blocks != nil {
+		eviction.blocks = make(map[string]strg.BlockInfo)
+		for block, blockInfo := range fsm.unfinishedEviction.blocks {
+			eviction.blocks[block] = blockInfo
+		}
+	}
+	return &eviction
+}
+
+// The end of an eviction that is not the unfinished one is ignored, so a repeated end does not change the eviction count again.
+func (fsm *oramNodeFSM) handleEndEvictionCommand(evictionID string, updatedEvictionCount int, storageID int) {
 	log.Debug().Msgf("Aquiring lock for oramNodeFSM in handleEndEvictionCommand")
 	fsm.unfinishedEvictionMu.Lock()
 	log.Debug().Msgf("Aquired lock for oramNodeFSM in handleEndEvictionCommand")
@@ -69,36 +150,111 @@ func (fsm *oramNodeFSM) handleEndEvictionCommand(updatedEvictionCount int, stora
 		fsm.unfinishedEvictionMu.Unlock()
 		log.Debug().Msgf("Released lock for oramNodeFSM in handleEndEvictionCommand")
 	}()
+	if fsm.unfinishedEviction == nil || fsm.unfinishedEviction.evictionID != evictionID {
+		log.Debug().Msgf("Ignoring the end of eviction %s that is not unfinished", evictionID)
+		return
+	}
+	fsm.forgetReadBlocks(storageID, fsm.unfinishedEviction.blocks)
 	fsm.unfinishedEviction = nil
 	fsm.evictionCountMap[storageID] = updatedEvictionCount
 }
 
-func (fsm *oramNodeFSM) handleBeginReadPathCommand(paths []int, storageID int) {
+func (fsm *oramNodeFSM) handleBeginReadPathCommand(readPathID string, paths []int, storageID int) {
 	tracer := otel.Tracer("")
 	_, span := tracer.Start(context.Background(), "begin read path replication inside")
 	log.Debug().Msgf("Aquiring lock for oramNodeFSM in handleBeginReadPathCommand")
-	fsm.unfinishedReadPathMu.Lock()
+	fsm.unfinishedReadPathsMu.Lock()
 	log.Debug().Msgf("Aquired lock for oramNodeFSM in handleBeginReadPathCommand")
 	defer func() {
 		log.Debug().Msgf("Releasing lock for oramNodeFSM in handleBeginReadPathCommand")
-		fsm.unfinishedReadPathMu.Unlock()
+		fsm.unfinishedReadPathsMu.Unlock()
 		log.Debug().Msgf("Released lock for oramNodeFSM in handleBeginReadPathCommand")
 	}()
 
-	fsm.unfinishedReadPath = &beginReadPathData{paths, storageID}
+	fsm.unfinishedReadPaths[readPathID] = beginReadPathData{paths, storageID}
 	span.End()
 }
 
-func (fsm *oramNodeFSM) handleEndReadPathCommand() {
+func (fsm *oramNodeFSM) handleEndReadPathCommand(readPathID string, reshuffleJobID string, buckets []int, storageID int, readBlocks map[string]strg.BlockInfo) {
 	log.Debug().Msgf("Aquiring lock for oramNodeFSM in handleEndReadPathCommand")
-	fsm.unfinishedReadPathMu.Lock()
+	fsm.unfinishedReadPathsMu.Lock()
+	fsm.pendingReshufflesMu.Lock()
 	log.Debug().Msgf("Aquired lock for oramNodeFSM in handleEndReadPathCommand")
 	defer func() {
 		log.Debug().Msgf("Releasing lock for oramNodeFSM in handleEndReadPathCommand")
-		fsm.unfinishedReadPathMu.Unlock()
+		fsm.unfinishedReadPathsMu.Unlock()
+		fsm.pendingReshufflesMu.Unlock()
 		log.Debug().Msgf("Released lock for oramNodeFSM in handleEndReadPathCommand")
 	}()
-	fsm.unfinishedReadPath = nil
+	delete(fsm.unfinishedReadPaths, readPathID)
+	if reshuffleJobID != "" {
+		fsm.pendingReshuffles[reshuffleJobID] = reshuffleJobData{buckets: buckets, storageID: storageID}
+	}
+	fsm.addReadBlocks(storageID, readBlocks)
+}
+
+// It keeps the read blocks and forgets the oldest ones if the window is full.
+func (fsm *oramNodeFSM) addReadBlocks(storageID int, blocks map[string]strg.BlockInfo) {
+	fsm.readBlocksMu.Lock()
+	defer fsm.readBlocksMu.Unlock()
+	for block, blockInfo := range blocks {
+		key := readBlockKey{storageID: storageID, block: block}
+		fsm.readBlocksIndex++
+		fsm.readBlocks[key] = readBlock{BlockInfo: blockInfo, index: fsm.readBlocksIndex}
+		fsm.readBlocksOrder = append(fsm.readBlocksOrder, key)
+	}
+	for len(fsm.readBlocksOrder) > readBlocksWindowSize {
+		oldest := fsm.readBlocksOrder[0]
+		fsm.readBlocksOrder = fsm.readBlocksOrder[1:]
+		// The block may have been read again after this entry of the order
+		if readBlock, exists := fsm.readBlocks[oldest]; exists && readBlock.index <= fsm.readBlocksIndex-uint64(readBlocksWindowSize) {
+			delete(fsm.readBlocks, oldest)
+		}
+	}
+}
+
+// It forgets the read blocks that an eviction wrote back to the storage.
+func (fsm *oramNodeFSM) forgetReadBlocks(storageID int, blocks map[string]strg.BlockInfo) {
+	fsm.readBlocksMu.Lock()
+	defer fsm.readBlocksMu.Unlock()
+	for block := range blocks {
+		delete(fsm.readBlocks, readBlockKey{storageID: storageID, block: block})
+	}
+}
+
+// It returns the value of a block that was read from the path of the storage and was not written back yet.
+func (fsm *oramNodeFSM) getReadBlock(storageID int, block string, path int) (value string, exists bool) {
+	fsm.readBlocksMu.Lock()
+	defer fsm.readBlocksMu.Unlock()
+	readBlock, exists := fsm.readBlocks[readBlockKey{storageID: storageID, block: block}]
+	if !exists || readBlock.Path != path {
+		return "", false
+	}
+	return readBlock.Value, true
+}
+
+func (fsm *oramNodeFSM) handleEndReshuffleCommand(jobID string) {
+	log.Debug().Msgf("Aquiring lock for oramNodeFSM in handleEndReshuffleCommand")
+	fsm.pendingReshufflesMu.Lock()
+	log.Debug().Msgf("Aquired lock for oramNodeFSM in handleEndReshuffleCommand")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for oramNodeFSM in handleEndReshuffleCommand")
+		fsm.pendingReshufflesMu.Unlock()
+		log.Debug().Msgf("Released lock for oramNodeFSM in handleEndReshuffleCommand")
+	}()
+	delete(fsm.pendingReshuffles, jobID)
+}
+
+func (fsm *oramNodeFSM) handleEvictPathCountCommand(evictPathCount int) {
+	fsm.evictPathCountMu.Lock()
+	defer fsm.evictPathCountMu.Unlock()
+	fsm.evictPathCount = evictPathCount
+}
+
+func (fsm *oramNodeFSM) getEvictPathCount() int {
+	fsm.evictPathCountMu.RLock()
+	defer fsm.evictPathCountMu.RUnlock()
+	return fsm.evictPathCount
 }
 
 func (fsm *oramNodeFSM) Apply(rLog *raft.Log) interface{} {
@@ -116,7 +272,7 @@ func (fsm *oramNodeFSM) Apply(rLog *raft.Log) interface{} {
 			if err != nil {
 				return fmt.Errorf("could not unmarshall the begin eviction replication command; %s", err)
 			}
-			fsm.handleBeginEvictionCommand(payload.CurrentEvictionCount, payload.StorageID)
+			fsm.handleBeginEvictionCommand(payload.EvictionID, payload.CurrentEvictionCount, payload.StorageID, payload.EvictPathCount, payload.ShardNodeID)
 		} else if command.Type == ReplicateEndEviction {
 			log.Debug().Msgf("got replication command for replicate end eviction")
 			var payload ReplicateEndEvictionPayload
@@ -124,7 +280,7 @@ func (fsm *oramNodeFSM) Apply(rLog *raft.Log) interface{} {
 			if err != nil {
 				return fmt.Errorf("could not unmarshall the end eviction replication command; %s", err)
 			}
-			fsm.handleEndEvictionCommand(payload.UpdatedEvictionCount, payload.StorageID)
+			fsm.handleEndEvictionCommand(payload.EvictionID, payload.UpdatedEvictionCount, payload.StorageID)
 		} else if command.Type == ReplicateBeginReadPath {
 			log.Debug().Msgf("got replication command for replicate begin read path")
 			var payload ReplicateBeginReadPathPayload
@@ -132,10 +288,39 @@ func (fsm *oramNodeFSM) Apply(rLog *raft.Log) interface{} {
 			if err != nil {
 				return fmt.Errorf("could not unmarshall the begin read path replication command; %s", err)
 			}
-			fsm.handleBeginReadPathCommand(payload.Paths, payload.StorageID)
+			fsm.handleBeginReadPathCommand(payload.ReadPathID, payload.Paths, payload.StorageID)
 		} else if command.Type == ReplicateEndReadPath {
 			log.Debug().Msgf("got replication command for replicate end read path")
-			fsm.handleEndReadPathCommand()
+			var payload ReplicateEndReadPathPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the end read path replication command; %s", err)
+			}
+			fsm.handleEndReadPathCommand(payload.ReadPathID, payload.ReshuffleJobID, payload.Buckets, payload.StorageID, payload.ReadBlocks)
+		} else if command.Type == ReplicateEndReshuffle {
+			log.Debug().Msgf("got replication command for replicate end reshuffle")
+			var payload ReplicateEndReshufflePayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the end reshuffle replication command; %s", err)
+			}
+			fsm.handleEndReshuffleCommand(payload.JobID)
+		} else if command.Type == ReplicateEvictPathCount {
+			log.Debug().Msgf("got replication command for replicate evict path count")
+			var payload ReplicateEvictPathCountPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the evict path count replication command; %s", err)
+			}
+			fsm.handleEvictPathCountCommand(payload.EvictPathCount)
+		} else if command.Type == ReplicateEvictionBlocks {
+			log.Debug().Msgf("got replication command for replicate eviction blocks")
+			var payload ReplicateEvictionBlocksPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the eviction blocks replication command; %s", err)
+			}
+			fsm.handleEvictionBlocksCommand(payload.EvictionID, payload.Blocks)
 		} else {
 			log.Error().Msgf("wrong command type")
 		}
@@ -3,6 +3,7 @@ package oramnode
 import (
 	"fmt"
 
+	strg "github.com/dsg-uwaterloo/treebeard/pkg/storage"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
@@ -13,6 +14,9 @@ const (
 	ReplicateEndEviction
 	ReplicateBeginReadPath
 	ReplicateEndReadPath
+	ReplicateEndReshuffle
+	ReplicateEvictPathCount
+	ReplicateEvictionBlocks
 )
 
 type Command struct {
@@ -21,25 +25,57 @@ type Command struct {
 }
 
 type ReplicateBeginEvictionPayload struct {
+	EvictionID           string // the id that the shard node knows the eviction by
 	CurrentEvictionCount int
 	StorageID            int
+	EvictPathCount       int
+	ShardNodeID          int // the shard node that sends the blocks of the eviction
 }
 
 type ReplicateEndEvictionPayload struct {
+	EvictionID           string
 	UpdatedEvictionCount int
 	StorageID            int
 }
 
 type ReplicateBeginReadPathPayload struct {
-	Paths     []int
-	StorageID int
+	ReadPathID string
+	Paths      []int
+	StorageID  int
 }
 
-func newReplicateBeginEvictionCommand(currentEvictionCount int, storageID int) ([]byte, error) {
+// If ReshuffleJobID is not empty, the buckets need an early reshuffle after the read path.
+// ReadBlocks are the real blocks that the read path took out of the storage with the paths that they were read from.
+type ReplicateEndReadPathPayload struct {
+	ReadPathID     string
+	ReshuffleJobID string
+	Buckets        []int
+	StorageID      int
+	ReadBlocks     map[string]strg.BlockInfo
+}
+
+type ReplicateEndReshufflePayload struct {
+	JobID string
+}
+
+type ReplicateEvictPathCountPayload struct {
+	EvictPathCount int
+}
+
+// The blocks that the shard node sent for the unfinished eviction.
+type ReplicateEvictionBlocksPayload struct {
+	EvictionID string
+	Blocks     map[string]strg.BlockInfo
+}
+
+func newReplicateBeginEvictionCommand(evictionID string, currentEvictionCount int, storageID int, evictPathCount int, shardNodeID int) ([]byte, error) {
 	payload, err := msgpack.Marshal(
 		&ReplicateBeginEvictionPayload{
+			EvictionID:           evictionID,
 			CurrentEvictionCount: currentEvictionCount,
 			StorageID:            storageID,
+			EvictPathCount:       evictPathCount,
+			ShardNodeID:          shardNodeID,
 		},
 	)
 	if err != nil {
@@ -58,9 +94,10 @@ func newReplicateBeginEvictionCommand(currentEvictionCount int, storageID int) (
 	return command, nil
 }
 
-func newReplicateEndEvictionCommand(updatedEvictionCount int, storageID int) ([]byte, error) {
+func newReplicateEndEvictionCommand(evictionID string, updatedEvictionCount int, storageID int) ([]byte, error) {
 	payload, err := msgpack.Marshal(
 		&ReplicateEndEvictionPayload{
+			EvictionID:           evictionID,
 			UpdatedEvictionCount: updatedEvictionCount,
 			StorageID:            storageID,
 		},
@@ -80,11 +117,12 @@ func newReplicateEndEvictionCommand(updatedEvictionCount int, storageID int) ([]
 	return command, nil
 }
 
-func newReplicateBeginReadPathCommand(paths []int, storageID int) ([]byte, error) {
+func newReplicateBeginReadPathCommand(readPathID string, paths []int, storageID int) ([]byte, error) {
 	payload, err := msgpack.Marshal(
 		&ReplicateBeginReadPathPayload{
-			Paths:     paths,
-			StorageID: storageID,
+			ReadPathID: readPathID,
+			Paths:      paths,
+			StorageID:  storageID,
 		},
 	)
 	if err != nil {
@@ -103,11 +141,23 @@ func newReplicateBeginReadPathCommand(paths []int, storageID int) ([]byte, error
 	return command, nil
 }
 
-func newReplicateEndReadPathCommand() ([]byte, error) {
+func newReplicateEndReadPathCommand(readPathID string, reshuffleJobID string, buckets []int, storageID int, readBlocks map[string]strg.BlockInfo) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateEndReadPathPayload{
+			ReadPathID:     readPathID,
+			ReshuffleJobID: reshuffleJobID,
+			Buckets:        buckets,
+			StorageID:      storageID,
+			ReadBlocks:     readBlocks,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshall payload for the end read path command; %s", err)
+	}
 	command, err := msgpack.Marshal(
 		&Command{
 			Type:    ReplicateEndReadPath,
-			Payload: []byte{},
+			Payload: payload,
 		},
 	)
 	if err != nil {
@@ -115,3 +165,67 @@ func newReplicateEndReadPathCommand() ([]byte, error) {
 	}
 	return command, nil
 }
+
+func newReplicateEndReshuffleCommand(jobID string) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateEndReshufflePayload{
+			JobID: jobID,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshall payload for the end reshuffle command; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateEndReshuffle,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the end reshuffle command; %s", err)
+	}
+	return command, nil
+}
+
+func newReplicateEvictPathCountCommand(evictPathCount int) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateEvictPathCountPayload{
+			EvictPathCount: evictPathCount,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshall payload for the evict path count command; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateEvictPathCount,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the evict path count command; %s", err)
+	}
+	return command, nil
+}
+
+func newReplicateEvictionBlocksCommand(evictionID string, blocks map[string]strg.BlockInfo) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateEvictionBlocksPayload{
+			EvictionID: evictionID,
+			Blocks:     blocks,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshall payload for the eviction blocks command; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateEvictionBlocks,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the eviction blocks command; %s", err)
+	}
+	return command, nil
+}
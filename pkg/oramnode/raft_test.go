@@ -1,11 +1,16 @@
 package oramnode
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+
+	strg "github.com/dsg-uwaterloo/treebeard/pkg/storage"
+)
 
 func TestHandleBeginEvictionCommandAddsUnfinishedEviction(t *testing.T) {
 	fsm := newOramNodeFSM()
-	fsm.unfinishedEviction = &beginEvictionData{currentEvictionCount: 31, storageID: 2}
-	fsm.handleBeginEvictionCommand(34, 54)
+	fsm.unfinishedEviction = &beginEvictionData{evictionID: "old", currentEvictionCount: 31, storageID: 2}
+	fsm.handleBeginEvictionCommand("eviction", 34, 54, 4, 1)
 	fsm.unfinishedEvictionMu.Lock()
 	defer fsm.unfinishedEvictionMu.Unlock()
 	if fsm.unfinishedEviction.currentEvictionCount != 34 {
@@ -18,8 +23,8 @@ func TestHandleBeginEvictionCommandAddsUnfinishedEviction(t *testing.T) {
 
 func TestHandleEndEvictionCommandRemovesUnfinishedEviction(t *testing.T) {
 	fsm := newOramNodeFSM()
-	fsm.unfinishedEviction = &beginEvictionData{currentEvictionCount: 31, storageID: 2}
-	fsm.handleEndEvictionCommand(35, 2)
+	fsm.unfinishedEviction = &beginEvictionData{evictionID: "eviction", currentEvictionCount: 31, storageID: 2}
+	fsm.handleEndEvictionCommand("eviction", 35, 2)
 	fsm.unfinishedEvictionMu.Lock()
 	defer fsm.unfinishedEvictionMu.Unlock()
 	if fsm.unfinishedEviction != nil {
@@ -29,3 +34,122 @@ func TestHandleEndEvictionCommandRemovesUnfinishedEviction(t *testing.T) {
 		t.Errorf("handleEndEvictionCommand should update the eviction count map")
 	}
 }
+
+func TestHandleEndEvictionCommandIgnoresTheEndOfAnotherEviction(t *testing.T) {
+	fsm := newOramNodeFSM()
+	fsm.unfinishedEviction = &beginEvictionData{evictionID: "eviction", currentEvictionCount: 35, storageID: 2}
+	fsm.evictionCountMap[2] = 35
+	fsm.handleEndEvictionCommand("finished", 35, 2)
+	if fsm.getUnfinishedEviction() == nil {
+		t.Errorf("expected the unfinished eviction to stay after the end of another eviction")
+	}
+	fsm.handleEndEvictionCommand("eviction", 39, 2)
+	fsm.handleEndEvictionCommand("eviction", 43, 2)
+	if fsm.evictionCountMap[2] != 39 {
+		t.Errorf("expected a repeated end eviction to be ignored but the eviction count is %d", fsm.evictionCountMap[2])
+	}
+}
+
+func TestHandleBeginReadPathCommandKeepsConcurrentReadPaths(t *testing.T) {
+	fsm := newOramNodeFSM()
+	fsm.handleBeginReadPathCommand("readpath1", []int{1}, 0)
+	fsm.handleBeginReadPathCommand("readpath2", []int{2}, 1)
+	if len(fsm.unfinishedReadPaths) != 2 {
+		t.Errorf("expected two unfinished read paths but got: %v", fsm.unfinishedReadPaths)
+	}
+	if fsm.unfinishedReadPaths["readpath2"].storageID != 1 {
+		t.Errorf("expected readpath2 to be for storageID 1")
+	}
+}
+
+func TestHandleEndReadPathCommandAddsPendingReshuffle(t *testing.T) {
+	fsm := newOramNodeFSM()
+	fsm.unfinishedReadPaths["readpath1"] = beginReadPathData{paths: []int{1, 2}, storageID: 3}
+	fsm.handleEndReadPathCommand("readpath1", "job1", []int{1, 2}, 3, nil)
+	if _, exists := fsm.unfinishedReadPaths["readpath1"]; exists {
+		t.Errorf("handleEndReadPathCommand should remove the unfinished read path")
+	}
+	job, exists := fsm.pendingReshuffles["job1"]
+	if !exists || job.storageID != 3 || len(job.buckets) != 2 {
+		t.Errorf("expected a pending reshuffle for storageID 3 with 2 buckets but got: %v", fsm.pendingReshuffles)
+	}
+}
+
+func TestHandleEndReadPathCommandWithoutReshuffleDoesNotAddPendingReshuffle(t *testing.T) {
+	fsm := newOramNodeFSM()
+	fsm.handleEndReadPathCommand("readpath1", "", nil, 3, nil)
+	if len(fsm.pendingReshuffles) != 0 {
+		t.Errorf("expected no pending reshuffles but got: %v", fsm.pendingReshuffles)
+	}
+}
+
+func TestHandleEndReadPathCommandKeepsTheReadBlocksUntilAnEvictionWritesThemBack(t *testing.T) {
+	fsm := newOramNodeFSM()
+	fsm.handleEndReadPathCommand("readpath1", "", nil, 3, map[string]strg.BlockInfo{"a": {Value: "valA", Path: 5}, "b": {Value: "valB", Path: 6}})
+	if value, exists := fsm.getReadBlock(3, "a", 5); !exists || value != "valA" {
+		t.Errorf("expected block a to be kept with valA but got %s, %t", value, exists)
+	}
+	if _, exists := fsm.getReadBlock(3, "a", 6); exists {
+		t.Errorf("expected block a not to be returned for another path")
+	}
+	if _, exists := fsm.getReadBlock(4, "a", 5); exists {
+		t.Errorf("expected block a not to be returned for another storage")
+	}
+	fsm.handleBeginEvictionCommand("eviction", 0, 3, 1, 0)
+	fsm.handleEvictionBlocksCommand("eviction", map[string]strg.BlockInfo{"a": {Value: "newA", Path: 5}})
+	fsm.handleEndEvictionCommand("eviction", 1, 3)
+	if _, exists := fsm.getReadBlock(3, "a", 5); exists {
+		t.Errorf("expected block a to be forgotten after the eviction wrote it back")
+	}
+	if _, exists := fsm.getReadBlock(3, "b", 6); !exists {
+		t.Errorf("expected block b to be kept")
+	}
+}
+
+func TestAddReadBlocksForgetsTheOldestBlocksIfTheWindowIsFull(t *testing.T) {
+	fsm := newOramNodeFSM()
+	fsm.addReadBlocks(0, map[string]strg.BlockInfo{"a": {Value: "old"}})
+	for i := 0; i < readBlocksWindowSize-1; i++ {
+		fsm.addReadBlocks(0, map[string]strg.BlockInfo{fmt.Sprintf("block%d", i): {}})
+	}
+	// Reading block a again keeps it, even though its first read leaves the window
+	fsm.addReadBlocks(0, map[string]strg.BlockInfo{"a": {Value: "new"}})
+	fsm.addReadBlocks(0, map[string]strg.BlockInfo{"c": {}})
+	if value, exists := fsm.getReadBlock(0, "a", 0); !exists || value != "new" {
+		t.Errorf("expected block a to be kept with the new value but got %s, %t", value, exists)
+	}
+	if _, exists := fsm.getReadBlock(0, "block0", 0); exists {
+		t.Errorf("expected the oldest block to be forgotten")
+	}
+	if len(fsm.readBlocks) > readBlocksWindowSize {
+		t.Errorf("expected at most %d read blocks but got %d", readBlocksWindowSize, len(fsm.readBlocks))
+	}
+}
+
+func TestHandleEndReshuffleCommandRemovesPendingReshuffle(t *testing.T) {
+	fsm := newOramNodeFSM()
+	fsm.pendingReshuffles["job1"] = reshuffleJobData{buckets: []int{1}, storageID: 0}
+	fsm.pendingReshuffles["job2"] = reshuffleJobData{buckets: []int{2}, storageID: 0}
+	fsm.handleEndReshuffleCommand("job1")
+	if _, exists := fsm.pendingReshuffles["job1"]; exists {
+		t.Errorf("handleEndReshuffleCommand should remove the finished job")
+	}
+	if _, exists := fsm.pendingReshuffles["job2"]; !exists {
+		t.Errorf("handleEndReshuffleCommand should keep the other jobs")
+	}
+}
+
+func TestHandleEvictionBlocksCommandAddsBlocksToTheUnfinishedEviction(t *testing.T) {
+	fsm := newOramNodeFSM()
+	fsm.handleEvictionBlocksCommand("eviction", map[string]strg.BlockInfo{"a": {Value: "valA"}})
+	if fsm.unfinishedEviction != nil {
+		t.Errorf("expected the blocks to be ignored without an unfinished eviction")
+	}
+	fsm.handleBeginEvictionCommand("eviction", 3, 1, 2, 0)
+	fsm.handleEvictionBlocksCommand("another", map[string]strg.BlockInfo{"b": {Value: "valB"}})
+	fsm.handleEvictionBlocksCommand("eviction", map[string]strg.BlockInfo{"a": {Value: "valA"}})
+	eviction := fsm.getUnfinishedEviction()
+	if eviction == nil || eviction.blocks["a"].Value != "valA" || eviction.evictPathCount != 2 {
+		t.Errorf("expected the unfinished eviction to have block a but got %v", eviction)
+	}
+}
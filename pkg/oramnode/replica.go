@@ -0,0 +1,131 @@
+package oramnode
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/oramnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/admin"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
+	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+// Replica is a running ORAM node replica.
+// Unlike StartServer, StartReplica does not block and the replica can be stopped,
+// so that the tests can run many replicas in one process and crash them.
+type Replica struct {
+	server     *oramNodeServer
+	grpcServer *grpc.Server
+}
+
+type ReplicaConfig struct {
+	OramNodeID          int
+	ReplicaID           int
+	Listener            net.Listener // the replica serves its grpc services on it
+	ShardNodeRPCClients map[int]ReplicaRPCClientMap
+	Storage             Storage // the replicas of an ORAM node share the storages, so it should be initialized by the caller
+	Parameters          config.Parameters
+	ParametersPath      string // the parameters file is not watched if it is empty
+
+	// The raft node uses the default config if it is nil. Its local id is the replica id.
+	// The snapshots should be disabled in it, since the ORAM node FSM can not restore a snapshot.
+	RaftConfig    *raft.Config
+	RaftTransport raft.Transport
+	// A replica that is restarted with the stores of a stopped replica applies its log again.
+	RaftLogs      raft.LogStore
+	RaftStable    raft.StableStore
+	RaftSnapshots raft.SnapshotStore
+	Bootstrap     *raft.Configuration // the replica bootstraps the raft cluster with it if it is not nil
+
+	// The faults of the replica and its storage. Fault injection is disabled if it is nil.
+	Faults *faults.Injector
+}
+
+// It creates the server of the replica and starts its eviction and recovery loops.
+// The injector faults the RPCs, the evictions and the pipelines of the storage, if the storage supports it.
+// Fault injection is disabled if it is nil.
+func newReplica(oramNodeServerID int, replicaID int, r *raft.Raft, fsm *oramNodeFSM, shardNodeRPCClients map[int]ReplicaRPCClientMap, storageHandler Storage, parameters config.Parameters, parametersPath string, injector *faults.Injector) *Replica {
+	oramNodeServer := newOramNodeServer(oramNodeServerID, replicaID, r, fsm, shardNodeRPCClients, storageHandler, parameters)
+	oramNodeServer.faults = injector
+	if faultyStorage, ok := storageHandler.(interface{ SetFaults(*faults.Injector) }); ok {
+		faultyStorage.SetFaults(injector)
+	}
+	go func() {
+		for {
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-oramNodeServer.stop:
+				return
+			}
+			if oramNodeServer.readPathCounter.Load() >= int32(oramNodeServer.getParameters().EvictionRate) {
+				storageID := oramNodeServer.storageHandler.GetRandomStorageID()
+				oramNodeServer.evict(storageID)
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-oramNodeServer.stop:
+				return
+			default:
+				oramNodeServer.performFailedOperations()
+			}
+		}
+	}()
+	interceptors := []grpc.UnaryServerInterceptor{rpc.ContextPropagationUnaryServerInterceptor()}
+	// The interceptor locks the injector on every RPC, so it is only installed if fault injection is enabled.
+	if injector != nil {
+		interceptors = append(interceptors, injector.UnaryServerInterceptor())
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	pb.RegisterOramNodeServer(grpcServer, oramNodeServer)
+	admin.RegisterAdminServer(grpcServer, oramNodeServer, parametersPath, injector)
+	return &Replica{server: oramNodeServer, grpcServer: grpcServer}
+}
+
+// StartReplica starts a replica with the raft transport and stores of the config, and serves it on the listener of the config.
+func StartReplica(c ReplicaConfig) (*Replica, error) {
+	raftConfig := raft.DefaultConfig()
+	if c.RaftConfig != nil {
+		configCopy := *c.RaftConfig
+		raftConfig = &configCopy
+	}
+	raftConfig.Logger = hclog.New(&hclog.LoggerOptions{Output: log.Logger})
+	raftConfig.LocalID = raft.ServerID(strconv.Itoa(c.ReplicaID))
+
+	fsm := newOramNodeFSM()
+	r, err := raft.NewRaft(raftConfig, fsm, c.RaftLogs, c.RaftStable, c.RaftSnapshots, c.RaftTransport)
+	if err != nil {
+		return nil, err
+	}
+	if c.Bootstrap != nil {
+		// A replica that restarts has its configuration in its log, so the bootstrap fails and is not needed
+		r.BootstrapCluster(*c.Bootstrap)
+	}
+	replica := newReplica(c.OramNodeID, c.ReplicaID, r, fsm, c.ShardNodeRPCClients, c.Storage, c.Parameters, c.ParametersPath, c.Faults)
+	go replica.grpcServer.Serve(c.Listener)
+	return replica, nil
+}
+
+// Stop stops the replica like a crash.
+// The grpc server stops without waiting for the running requests, the raft node shuts down and the background loops end.
+// The storages are not changed, like a redis that outlives the ORAM node.
+func (r *Replica) Stop() {
+	close(r.server.stop)
+	r.grpcServer.Stop()
+	err := r.server.raftNode.Shutdown().Error()
+	if err != nil {
+		log.Error().Msgf("Could not shut down the raft node of ORAM node replica %d; %s", r.server.replicaID, err)
+	}
+}
+
+func (r *Replica) IsLeader() bool {
+	return r.server.raftNode.State() == raft.Leader
+}
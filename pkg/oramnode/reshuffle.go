@@ -0,0 +1,198 @@
+package oramnode
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	reshuffleRetryBaseDelay = 50 * time.Millisecond
+	reshuffleRetryMaxDelay  = 5 * time.Second
+)
+
+// reshuffleJob is an early reshuffle of buckets that reached the maximum access count.
+type reshuffleJob struct {
+	id        string
+	storageID int
+	buckets   []int
+	attempts  int
+}
+
+func (j *reshuffleJob) hasAnyBucket(buckets map[int]bool) bool {
+	for _, bucket := range j.buckets {
+		if buckets[bucket] {
+			return true
+		}
+	}
+	return false
+}
+
+// reshuffleQueue keeps the pending early reshuffle jobs of a single storage in the order they were added.
+// A job is taken out of the queue while it runs and put back at the front if it fails.
+// Jobs are only taken while holding the storage lock,
+// so a job never runs concurrently with a read path or an eviction on the same storage.
+type reshuffleQueue struct {
+	mu     sync.Mutex
+	jobs   []*reshuffleJob
+	notify chan struct{}
+}
+
+func newReshuffleQueue() *reshuffleQueue {
+	return &reshuffleQueue{notify: make(chan struct{}, 1)}
+}
+
+func (q *reshuffleQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// It adds the job to the end of the queue if a job with the same id is not already in the queue.
+func (q *reshuffleQueue) add(job *reshuffleJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, existing := range q.jobs {
+		if existing.id == job.id {
+			return
+		}
+	}
+	q.jobs = append(q.jobs, job)
+	q.signal()
+}
+
+// It puts jobs that did not finish back at the front of the queue.
+func (q *reshuffleQueue) putBack(jobs ...*reshuffleJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs = append(append([]*reshuffleJob{}, jobs...), q.jobs...)
+}
+
+func (q *reshuffleQueue) takeFirst() *reshuffleJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.jobs) == 0 {
+		return nil
+	}
+	job := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	return job
+}
+
+// It removes and returns the jobs that touch any of the buckets, preserving their order.
+func (q *reshuffleQueue) takeOverlapping(buckets []int) (overlapping []*reshuffleJob) {
+	bucketSet := make(map[int]bool)
+	for _, bucket := range buckets {
+		bucketSet[bucket] = true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var remaining []*reshuffleJob
+	for _, job := range q.jobs {
+		if job.hasAnyBucket(bucketSet) {
+			overlapping = append(overlapping, job)
+		} else {
+			remaining = append(remaining, job)
+		}
+	}
+	q.jobs = remaining
+	return overlapping
+}
+
+func (q *reshuffleQueue) clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs = nil
+}
+
+func (q *reshuffleQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs)
+}
+
+// It returns the reshuffle queue of the storage and starts its worker on first use.
+func (o *oramNodeServer) getReshuffleQueue(storageID int) *reshuffleQueue {
+	o.reshuffleQueuesMu.Lock()
+	defer o.reshuffleQueuesMu.Unlock()
+	queue, exists := o.reshuffleQueues[storageID]
+	if !exists {
+		queue = newReshuffleQueue()
+		o.reshuffleQueues[storageID] = queue
+		go o.runReshuffleWorker(storageID, queue)
+	}
+	return queue
+}
+
+// It performs the job and marks it as finished in the raft log.
+// The caller should hold the storage lock.
+func (o *oramNodeServer) performReshuffleJob(job *reshuffleJob) error {
+	log.Debug().Msgf("Performing reshuffle job %s with buckets %v and storageID %d", job.id, job.buckets, job.storageID)
+	err := o.earlyReshuffle(job.buckets, job.storageID)
+	if err != nil {
+		return err
+	}
+	endReshuffleCommand, err := newReplicateEndReshuffleCommand(job.id)
+	if err != nil {
+		return err
+	}
+	return o.raftNode.Apply(endReshuffleCommand, 0).Error()
+}
+
+func (o *oramNodeServer) logFailedReshuffleJob(job *reshuffleJob, err error) {
+	job.attempts++
+	log.Error().Msgf("Reshuffle job %s for storageID %d failed after %d attempts; %s", job.id, job.storageID, job.attempts, err)
+}
+
+// It runs the pending jobs that touch any of the buckets.
+// The caller should hold the storage lock, so that the buckets are reshuffled before they are read again.
+func (o *oramNodeServer) runOverlappingReshuffleJobs(buckets []int, storageID int) error {
+	queue := o.getReshuffleQueue(storageID)
+	jobs := queue.takeOverlapping(buckets)
+	for i, job := range jobs {
+		err := o.performReshuffleJob(job)
+		if err != nil {
+			o.logFailedReshuffleJob(job, err)
+			queue.putBack(jobs[i:]...)
+			return err
+		}
+	}
+	return nil
+}
+
+// It runs the jobs of a storage in the background and retries the failed jobs with exponential backoff.
+// Only the leader runs the jobs, a new leader gets the unfinished jobs from the FSM.
+func (o *oramNodeServer) runReshuffleWorker(storageID int, queue *reshuffleQueue) {
+	retryDelay := reshuffleRetryBaseDelay
+	for {
+		<-queue.notify
+		for {
+			if o.raftNode.State() != raft.Leader {
+				queue.clear()
+				break
+			}
+			o.storageHandler.LockStorage(storageID)
+			job := queue.takeFirst()
+			if job == nil {
+				o.storageHandler.UnlockStorage(storageID)
+				break
+			}
+			err := o.performReshuffleJob(job)
+			o.storageHandler.UnlockStorage(storageID)
+			if err != nil {
+				o.logFailedReshuffleJob(job, err)
+				queue.putBack(job)
+				time.Sleep(retryDelay)
+				retryDelay = retryDelay * 2
+				if retryDelay > reshuffleRetryMaxDelay {
+					retryDelay = reshuffleRetryMaxDelay
+				}
+				continue
+			}
+			retryDelay = reshuffleRetryBaseDelay
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package oramnode
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dsg-uwaterloo/treebeard/api/oramnode"
+	strg "github.com/dsg-uwaterloo/treebeard/pkg/storage"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestReshuffleQueueTakeOverlappingKeepsOtherJobs(t *testing.T) {
+	q := newReshuffleQueue()
+	q.add(&reshuffleJob{id: "job1", buckets: []int{1, 2}})
+	q.add(&reshuffleJob{id: "job2", buckets: []int{5}})
+	q.add(&reshuffleJob{id: "job3", buckets: []int{2, 4}})
+	q.add(&reshuffleJob{id: "job1", buckets: []int{1, 2}})
+
+	jobs := q.takeOverlapping([]int{2, 3})
+	if len(jobs) != 2 || jobs[0].id != "job1" || jobs[1].id != "job3" {
+		t.Errorf("expected jobs job1 and job3 in order but got %v", jobs)
+	}
+	if q.len() != 1 || q.takeFirst().id != "job2" {
+		t.Errorf("expected job2 to remain in the queue")
+	}
+}
+
+func accessCountsAtMax(maxAccessCount int) func(bucketIDs []int, storageID int) (counts map[int]int, err error) {
+	return func(bucketIDs []int, storageID int) (counts map[int]int, err error) {
+		counts = make(map[int]int)
+		for _, bucketID := range bucketIDs {
+			counts[bucketID] = maxAccessCount
+		}
+		return counts, nil
+	}
+}
+
+func TestReadPathQueuesEarlyReshuffleAndWorkerFinishesIt(t *testing.T) {
+	var writeCount atomic.Int32
+	m := strg.NewMockStorageHandler(3, 4).WithCustomBatchGetAccessCountFunc(accessCountsAtMax(4)).WithCustomBatchWriteBucketFunc(
+		func(storageID int, readBucketBlocksList map[int]map[string]string, shardNodeBlocks map[string]strg.BlockInfo) (writtenBlocks map[string]string, err error) {
+			writeCount.Add(1)
+			return nil, nil
+		},
+	).WithCustomBatchReadBucketFunc(
+		func(bucketIDs []int, storageID int) (blocks map[int]map[string]string, err error) {
+			blocks = make(map[int]map[string]string)
+			for _, bucketID := range bucketIDs {
+				blocks[bucketID] = map[string]string{}
+			}
+			return blocks, nil
+		},
+	)
+	o := startLeaderRaftNodeServer(t, m)
+	o.parameters.RedisPipelineSize = 10
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("requestid", "request1"))
+	_, err := o.ReadPath(ctx, &oramnode.ReadPathRequest{StorageId: 0, Requests: []*oramnode.BlockRequest{{Block: "a", Path: 1}}})
+	if err != nil {
+		t.Errorf("expected ReadPath to succeed but got %s", err)
+	}
+	for i := 0; i < 50; i++ {
+		o.oramNodeFSM.pendingReshufflesMu.Lock()
+		pendingCount := len(o.oramNodeFSM.pendingReshuffles)
+		o.oramNodeFSM.pendingReshufflesMu.Unlock()
+		if pendingCount == 0 && writeCount.Load() != 0 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("expected the background worker to write the buckets and finish the reshuffle job")
+}
+
+func TestRunOverlappingReshuffleJobsPutsBackFailedJobs(t *testing.T) {
+	m := strg.NewMockStorageHandler(3, 4).WithCustomBatchGetAccessCountFunc(
+		func(bucketIDs []int, storageID int) (counts map[int]int, err error) {
+			return nil, fmt.Errorf("storage is not reachable")
+		},
+	)
+	o := startLeaderRaftNodeServer(t, m)
+	o.parameters.RedisPipelineSize = 10
+	q := newReshuffleQueue() // no worker is started for this queue
+	o.reshuffleQueues[0] = q
+	q.add(&reshuffleJob{id: "job1", storageID: 0, buckets: []int{1}})
+	q.add(&reshuffleJob{id: "job2", storageID: 0, buckets: []int{1}})
+
+	err := o.runOverlappingReshuffleJobs([]int{1, 2}, 0)
+	if err == nil {
+		t.Errorf("expected runOverlappingReshuffleJobs to return the reshuffle error")
+	}
+	if q.len() != 2 {
+		t.Errorf("expected both jobs to be put back in the queue but got %d jobs", q.len())
+	}
+	if job := q.takeFirst(); job.id != "job1" || job.attempts != 1 {
+		t.Errorf("expected job1 to be first with one failed attempt")
+	}
+}
+
+func TestReshuffleWorkerRetriesFailedJobs(t *testing.T) {
+	var writeCount atomic.Int32
+	m := strg.NewMockStorageHandler(3, 4).WithCustomBatchGetAccessCountFunc(accessCountsAtMax(4)).WithCustomBatchWriteBucketFunc(
+		func(storageID int, readBucketBlocksList map[int]map[string]string, shardNodeBlocks map[string]strg.BlockInfo) (writtenBlocks map[string]string, err error) {
+			if writeCount.Add(1) == 1 {
+				return nil, fmt.Errorf("pipeline failed")
+			}
+			return nil, nil
+		},
+	).WithCustomBatchReadBucketFunc(
+		func(bucketIDs []int, storageID int) (blocks map[int]map[string]string, err error) {
+			return map[int]map[string]string{1: {}}, nil
+		},
+	)
+	o := startLeaderRaftNodeServer(t, m)
+	o.parameters.RedisPipelineSize = 10
+	o.oramNodeFSM.pendingReshuffles["job1"] = reshuffleJobData{buckets: []int{1}, storageID: 0}
+	o.getReshuffleQueue(0).add(&reshuffleJob{id: "job1", storageID: 0, buckets: []int{1}})
+	for i := 0; i < 50; i++ {
+		o.oramNodeFSM.pendingReshufflesMu.Lock()
+		_, pending := o.oramNodeFSM.pendingReshuffles["job1"]
+		o.oramNodeFSM.pendingReshufflesMu.Unlock()
+		if !pending {
+			if writeCount.Load() != 2 {
+				t.Errorf("expected the job to be retried once but got %d writes", writeCount.Load())
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("expected the failed job to be retried and finished")
+}
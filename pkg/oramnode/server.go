@@ -4,15 +4,17 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
+	"sync"
 	"sync/atomic"
-	"time"
 
 	pb "github.com/dsg-uwaterloo/treebeard/api/oramnode"
 	"github.com/dsg-uwaterloo/treebeard/pkg/commonerrs"
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
-	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
 	strg "github.com/dsg-uwaterloo/treebeard/pkg/storage"
+	"github.com/google/uuid"
 	"github.com/hashicorp/raft"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel"
@@ -20,7 +22,8 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-type storage interface {
+// Storage keeps the ORAM trees of the storages of an ORAM node, in redis or in memory for the tests.
+type Storage interface {
 	GetMaxAccessCount() int
 	LockStorage(storageID int)
 	UnlockStorage(storageID int)
@@ -29,9 +32,11 @@ type storage interface {
 	BatchReadBucket(bucketIDs []int, storageID int) (blocks map[int]map[string]string, err error)
 	BatchWriteBucket(storageID int, readBucketBlocksList map[int]map[string]string, shardNodeBlocks map[string]strg.BlockInfo) (writtenBlocks map[string]string, err error)
 	BatchReadBlock(offsets map[int]int, storageID int) (values map[int]string, err error)
+	BatchReadBlockXOR(groups []strg.XORGroup, storageID int) (values []string, err error)
 	GetBucketsInPaths(paths []int) (bucketIDs []int, err error)
 	GetRandomStorageID() int
 	GetMultipleReverseLexicographicPaths(evictionCount int, count int) (paths []int)
+	AddStorage(endpoint config.RedisEndpoint, initialize bool) error
 }
 
 type oramNodeServer struct {
@@ -42,11 +47,17 @@ type oramNodeServer struct {
 	oramNodeFSM         *oramNodeFSM
 	shardNodeRPCClients ShardNodeRPCClients
 	readPathCounter     atomic.Int32
-	storageHandler      storage
+	storageHandler      Storage
 	parameters          config.Parameters
+	parametersMu        sync.RWMutex            // the runtime parameters can change while the server is running
+	reshuffleQueues     map[int]*reshuffleQueue // map of storage id to its pending early reshuffles
+	reshuffleQueuesMu   sync.Mutex
+	bucketVersions      *bucketVersions
+	stop                chan struct{} // it is closed when the replica stops to end the background loops
+	faults              *faults.Injector
 }
 
-func newOramNodeServer(oramNodeServerID int, replicaID int, raftNode *raft.Raft, oramNodeFSM *oramNodeFSM, shardNodeRPCClients map[int]ReplicaRPCClientMap, storageHandler storage, parameters config.Parameters) *oramNodeServer {
+func newOramNodeServer(oramNodeServerID int, replicaID int, raftNode *raft.Raft, oramNodeFSM *oramNodeFSM, shardNodeRPCClients map[int]ReplicaRPCClientMap, storageHandler Storage, parameters config.Parameters) *oramNodeServer {
 	return &oramNodeServer{
 		oramNodeServerID:    oramNodeServerID,
 		replicaID:           replicaID,
@@ -56,34 +67,61 @@ func newOramNodeServer(oramNodeServerID int, replicaID int, raftNode *raft.Raft,
 		readPathCounter:     atomic.Int32{},
 		storageHandler:      storageHandler,
 		parameters:          parameters,
+		reshuffleQueues:     make(map[int]*reshuffleQueue),
+		bucketVersions:      newBucketVersions(),
+		stop:                make(chan struct{}),
 	}
 }
 
-// It runs the failed eviction and read path as the new leader.
+// It runs the failed eviction and read paths as the new leader.
+// It also queues the early reshuffles that the previous leader did not finish.
 func (o *oramNodeServer) performFailedOperations() error {
-	<-o.raftNode.LeaderCh()
-	o.oramNodeFSM.unfinishedEvictionMu.Lock()
-	o.oramNodeFSM.unfinishedReadPathMu.Lock()
-	needsEviction := o.oramNodeFSM.unfinishedEviction
-	needsReadPath := o.oramNodeFSM.unfinishedReadPath
-	o.oramNodeFSM.unfinishedEvictionMu.Unlock()
-	o.oramNodeFSM.unfinishedReadPathMu.Unlock()
-	if needsEviction != nil {
-		o.oramNodeFSM.unfinishedEvictionMu.Lock()
-		storageID := o.oramNodeFSM.unfinishedEviction.storageID
-		o.oramNodeFSM.unfinishedEvictionMu.Unlock()
-		log.Debug().Msgf("Performing failed eviction for storageID %d", storageID)
-		o.evict(storageID)
-	}
-	if needsReadPath != nil {
-		log.Debug().Msgf("Performing failed read path")
-		o.oramNodeFSM.unfinishedReadPathMu.Lock()
-		paths := o.oramNodeFSM.unfinishedReadPath.paths
-		storageID := o.oramNodeFSM.unfinishedReadPath.storageID
-		o.oramNodeFSM.unfinishedReadPathMu.Unlock()
-		buckets, _ := o.storageHandler.GetBucketsInPaths(paths)
-		log.Debug().Msgf("Performing failed read path with paths %v and storageID %d", paths, storageID)
-		o.earlyReshuffle(buckets, storageID)
+	select {
+	case isLeader := <-o.raftNode.LeaderCh():
+		if !isLeader {
+			return nil
+		}
+	case <-o.stop:
+		return nil
+	}
+	// The new leader may not have applied all the committed logs yet
+	err := o.raftNode.Barrier(0).Error()
+	if err != nil {
+		return fmt.Errorf("could not apply the committed logs; %s", err)
+	}
+	o.oramNodeFSM.unfinishedReadPathsMu.Lock()
+	unfinishedReadPaths := make(map[string]beginReadPathData)
+	for readPathID, readPath := range o.oramNodeFSM.unfinishedReadPaths {
+		unfinishedReadPaths[readPathID] = readPath
+	}
+	o.oramNodeFSM.unfinishedReadPathsMu.Unlock()
+	log.Debug().Msgf("Replaying the failed eviction if there is one")
+	err = o.replayEviction()
+	if err != nil {
+		// The eviction loop replays it again before the next eviction
+		log.Error().Msgf("Could not replay the failed eviction; %s", err)
+	}
+	for readPathID, readPath := range unfinishedReadPaths {
+		buckets, _ := o.storageHandler.GetBucketsInPaths(readPath.paths)
+		log.Debug().Msgf("Performing failed read path with paths %v and storageID %d", readPath.paths, readPath.storageID)
+		endReadPathCommand, err := newReplicateEndReadPathCommand(readPathID, uuid.New().String(), buckets, readPath.storageID, nil)
+		if err != nil {
+			return fmt.Errorf("unable to create end read path replication command; %s", err)
+		}
+		err = o.raftNode.Apply(endReadPathCommand, 0).Error()
+		if err != nil {
+			return fmt.Errorf("could not apply log to the FSM; %s", err)
+		}
+	}
+	o.oramNodeFSM.pendingReshufflesMu.Lock()
+	var jobs []*reshuffleJob
+	for jobID, jobData := range o.oramNodeFSM.pendingReshuffles {
+		jobs = append(jobs, &reshuffleJob{id: jobID, storageID: jobData.storageID, buckets: jobData.buckets})
+	}
+	o.oramNodeFSM.pendingReshufflesMu.Unlock()
+	for _, job := range jobs {
+		log.Debug().Msgf("Queueing unfinished reshuffle job %s for storageID %d", job.id, job.storageID)
+		o.getReshuffleQueue(job.storageID).add(job)
 	}
 	return nil
 }
@@ -98,19 +136,18 @@ func (o *oramNodeServer) asyncGetAccessCount(bucketIDs []int, storageID int, res
 	responseChan <- getAccessCountResponse{counts: counts, err: err}
 }
 
-func (o *oramNodeServer) earlyReshuffle(buckets []int, storageID int) error {
-	log.Debug().Msgf("Performing early reshuffle with buckets %v and storageID %d", buckets, storageID)
-	// TODO: can we make this a background thread?
-	batches := distributeBucketIDs(buckets, o.parameters.RedisPipelineSize)
+// It returns the buckets that reached the maximum access count.
+func (o *oramNodeServer) getBucketsToReshuffle(buckets []int, storageID int) (bucketsToWrite []int, err error) {
+	batches := distributeBucketIDs(buckets, o.getParameters().RedisPipelineSize)
 	accessCountChan := make(chan getAccessCountResponse)
 	for _, bucketIDs := range batches {
 		go o.asyncGetAccessCount(bucketIDs, storageID, accessCountChan)
 	}
-	var bucketsToWrite []int
 	for i := 0; i < len(batches); i++ {
 		response := <-accessCountChan
 		if response.err != nil {
-			return fmt.Errorf("unable to get access count from the server; %s", response.err)
+			err = fmt.Errorf("unable to get access count from the server; %s", response.err)
+			continue
 		}
 		for bucket, accessCount := range response.counts {
 			if accessCount >= o.storageHandler.GetMaxAccessCount() {
@@ -118,23 +155,58 @@ func (o *oramNodeServer) earlyReshuffle(buckets []int, storageID int) error {
 			}
 		}
 	}
+	if err != nil {
+		return nil, err
+	}
+	return bucketsToWrite, nil
+}
+
+type writeBucketResponse struct {
+	err error
+}
+
+func (o *oramNodeServer) asyncWriteBucket(storageID int, blocks map[int]map[string]string, responseChan chan writeBucketResponse) {
+	_, err := o.storageHandler.BatchWriteBucket(storageID, blocks, nil)
+	responseChan <- writeBucketResponse{err: err}
+}
+
+// It rewrites the buckets that reached the maximum access count.
+// The caller should hold the storage lock.
+func (o *oramNodeServer) earlyReshuffle(buckets []int, storageID int) error {
+	log.Debug().Msgf("Performing early reshuffle with buckets %v and storageID %d", buckets, storageID)
+	bucketsToWrite, err := o.getBucketsToReshuffle(buckets, storageID)
+	if err != nil {
+		return err
+	}
 	readBucketChan := make(chan readBucketResponse)
-	batches = distributeBucketIDs(bucketsToWrite, o.parameters.RedisPipelineSize)
+	batches := distributeBucketIDs(bucketsToWrite, o.getParameters().RedisPipelineSize)
 	for _, bucketIDs := range batches {
 		go o.asyncReadBucket(bucketIDs, storageID, readBucketChan)
 	}
-	blocksFromReadBucketBatches := make([]map[int]map[string]string, len(batches))
+	var blocksFromReadBucketBatches []map[int]map[string]string
 	for i := 0; i < len(batches); i++ {
 		response := <-readBucketChan
 		if response.err != nil {
-			return fmt.Errorf("unable to read bucket from the server; %s", response.err)
+			err = fmt.Errorf("unable to read bucket from the server; %s", response.err)
+			continue
 		}
-		blocksFromReadBucketBatches[i] = response.bucketValues
+		blocksFromReadBucketBatches = append(blocksFromReadBucketBatches, response.bucketValues)
 	}
+	if err != nil {
+		return err
+	}
+	defer o.bucketVersions.bump(storageID, bucketsToWrite)
+	writeBucketChan := make(chan writeBucketResponse)
 	for _, blocks := range blocksFromReadBucketBatches {
-		go o.storageHandler.BatchWriteBucket(storageID, blocks, nil)
+		go o.asyncWriteBucket(storageID, blocks, writeBucketChan)
 	}
-	return nil
+	for i := 0; i < len(blocksFromReadBucketBatches); i++ {
+		response := <-writeBucketChan
+		if response.err != nil {
+			err = fmt.Errorf("unable to write bucket to the server; %s", response.err)
+		}
+	}
+	return err
 }
 
 type readBucketResponse struct {
@@ -160,7 +232,7 @@ func (o *oramNodeServer) readAllBuckets(buckets []int, storageID int) (blocksFro
 		blocksFromReadBucket[bucket] = make(map[string]string)
 	}
 	readBucketResponseChan := make(chan readBucketResponse)
-	batches := distributeBucketIDs(buckets, o.parameters.RedisPipelineSize)
+	batches := distributeBucketIDs(buckets, o.getParameters().RedisPipelineSize)
 	for _, bucketIDs := range batches {
 		go o.asyncReadBucket(bucketIDs, storageID, readBucketResponseChan)
 	}
@@ -180,18 +252,18 @@ func (o *oramNodeServer) readAllBuckets(buckets []int, storageID int) (blocksFro
 	return blocksFromReadBucket, nil
 }
 
-func (o *oramNodeServer) readBlocksFromShardNode(paths []int, storageID int, randomShardNode ReplicaRPCClientMap) (receivedBlocks map[string]strg.BlockInfo, err error) {
+func (o *oramNodeServer) readBlocksFromShardNode(evictionID string, paths []int, storageID int, randomShardNode ReplicaRPCClientMap) (receivedBlocks map[string]strg.BlockInfo, finished bool, err error) {
 	log.Debug().Msgf("Reading blocks from shard node with paths %v and storageID %d", paths, storageID)
 	receivedBlocks = make(map[string]strg.BlockInfo) // map of received block to value and path
 
-	shardNodeBlocks, err := randomShardNode.getBlocksFromShardNode(storageID, o.parameters.MaxBlocksToSend)
+	shardNodeBlocks, finished, err := randomShardNode.getBlocksFromShardNode(evictionID, paths, storageID, o.getParameters().MaxBlocksToSend)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get blocks from shard node; %s", err)
+		return nil, false, fmt.Errorf("unable to get blocks from shard node; %s", err)
 	}
 	for _, block := range shardNodeBlocks {
 		receivedBlocks[block.Block] = strg.BlockInfo{Value: block.Value, Path: int(block.Path)}
 	}
-	return receivedBlocks, nil
+	return receivedBlocks, finished, nil
 }
 
 func (o *oramNodeServer) writeBackBlocksToAllBuckets(buckets []int, storageID int, blocksFromReadBucket map[int]map[string]string, receivedBlocks map[string]strg.BlockInfo) (receivedBlocksIsWritten map[string]bool, err error) {
@@ -202,7 +274,7 @@ func (o *oramNodeServer) writeBackBlocksToAllBuckets(buckets []int, storageID in
 		receivedBlocksIsWritten[block] = false
 	}
 	// TODO: is there any way to parallelize this?
-	batches := distributeBucketIDs(buckets, o.parameters.RedisPipelineSize)
+	batches := distributeBucketIDs(buckets, o.getParameters().RedisPipelineSize)
 	bucketIDToBatchIndex := make(map[int]int)
 	for i, bucketIDs := range batches {
 		for _, bucketID := range bucketIDs {
@@ -233,12 +305,20 @@ func (o *oramNodeServer) writeBackBlocksToAllBuckets(buckets []int, storageID in
 }
 
 func (o *oramNodeServer) evict(storageID int) error {
+	// The FSM keeps one unfinished eviction, so an interrupted eviction is replayed before a new one begins
+	if o.oramNodeFSM.getUnfinishedEviction() != nil {
+		return o.replayEviction()
+	}
 	o.storageHandler.LockStorage(storageID)
 	defer o.storageHandler.UnlockStorage(storageID)
-	currentEvictionCount := o.oramNodeFSM.evictionCountMap[storageID]
-	paths := o.storageHandler.GetMultipleReverseLexicographicPaths(currentEvictionCount, o.parameters.EvictPathCount)
-	log.Debug().Msgf("Evicting with paths %v and storageID %d", paths, storageID)
-	beginEvictionCommand, err := newReplicateBeginEvictionCommand(currentEvictionCount, storageID)
+	eviction := beginEvictionData{
+		evictionID:           uuid.New().String(),
+		currentEvictionCount: o.oramNodeFSM.evictionCountMap[storageID],
+		storageID:            storageID,
+		evictPathCount:       o.getEvictPathCount(),
+		shardNodeID:          o.shardNodeRPCClients.getRandomShardNodeID(),
+	}
+	beginEvictionCommand, err := newReplicateBeginEvictionCommand(eviction.evictionID, eviction.currentEvictionCount, storageID, eviction.evictPathCount, eviction.shardNodeID)
 	if err != nil {
 		return fmt.Errorf("unable to marshal begin eviction command; %s", err)
 	}
@@ -246,18 +326,115 @@ func (o *oramNodeServer) evict(storageID int) error {
 	if err != nil {
 		return fmt.Errorf("could not apply log to the FSM; %s", err)
 	}
+	err = o.faults.Hit(faults.AfterBeginEviction)
+	if err != nil {
+		return fmt.Errorf("eviction stopped after it began; %s", err)
+	}
+	return o.runEviction(eviction)
+}
+
+// It replays the eviction that was interrupted by a crash of the leader or a failure, if there is one.
+// The eviction evicts the same paths with the blocks of the same shard node and keeps its eviction id,
+// so the blocks that the shard node already sent get their acks and nacks exactly once.
+func (o *oramNodeServer) replayEviction() error {
+	unfinishedEviction := o.oramNodeFSM.getUnfinishedEviction()
+	if unfinishedEviction == nil {
+		return nil
+	}
+	o.storageHandler.LockStorage(unfinishedEviction.storageID)
+	defer o.storageHandler.UnlockStorage(unfinishedEviction.storageID)
+	// It could have been replayed while waiting for the lock
+	eviction := o.oramNodeFSM.getUnfinishedEviction()
+	if eviction == nil || eviction.storageID != unfinishedEviction.storageID || eviction.currentEvictionCount != unfinishedEviction.currentEvictionCount {
+		return nil
+	}
+	if eviction.evictPathCount == 0 {
+		// The eviction began before the evict path count was replicated with it
+		eviction.evictPathCount = o.getEvictPathCount()
+	}
+	return o.runEviction(*eviction)
+}
+
+// It gets the blocks of the eviction from its shard node and replicates them,
+// or returns the replicated blocks if the eviction is replayed.
+// The shard node sends the blocks that it recorded for the eviction id again if they were sent before a crash.
+// The replayed blocks that are already in the buckets were written before the crash, and they are acked without writing them again.
+// The eviction is abandoned if the shard node does not wait for its acks anymore,
+// then the eviction only writes back the buckets without the blocks of the shard node.
+func (o *oramNodeServer) getEvictionBlocks(eviction beginEvictionData, paths []int, blocksFromReadBucket map[int]map[string]string) (receivedBlocks map[string]strg.BlockInfo, writtenBlocks []string, abandoned bool, err error) {
+	shardNode := o.shardNodeRPCClients[eviction.shardNodeID]
+	if eviction.blocks == nil {
+		var finished bool
+		receivedBlocks, finished, err = o.readBlocksFromShardNode(eviction.evictionID, paths, eviction.storageID, shardNode)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		// The eviction crashed before its blocks were replicated, and the shard node nacked them after the timeout
+		if finished {
+			log.Debug().Msgf("Abandoning eviction %s since the shard node finished it", eviction.evictionID)
+			return receivedBlocks, nil, true, nil
+		}
+		evictionBlocksCommand, err := newReplicateEvictionBlocksCommand(eviction.evictionID, receivedBlocks)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("unable to marshal eviction blocks command; %s", err)
+		}
+		err = o.raftNode.Apply(evictionBlocksCommand, 0).Error()
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("could not apply log to the FSM; %s", err)
+		}
+		return receivedBlocks, nil, false, nil
+	}
+	finished, timedOut, err := shardNode.getReplayedEviction(eviction.evictionID)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("could not check if eviction %s is outstanding; %s", eviction.evictionID, err)
+	}
+	if finished {
+		log.Debug().Msgf("Abandoning the replay of eviction %s since the shard node finished it", eviction.evictionID)
+		if timedOut {
+			// The shard node kept every block of the eviction, so the copies written before the crash are stale
+			for _, blockValues := range blocksFromReadBucket {
+				for block := range blockValues {
+					if _, exists := eviction.blocks[block]; exists {
+						delete(blockValues, block)
+					}
+				}
+			}
+		}
+		return make(map[string]strg.BlockInfo), nil, true, nil
+	}
+	receivedBlocks = eviction.blocks
+	for _, blockValues := range blocksFromReadBucket {
+		for block := range blockValues {
+			if _, exists := receivedBlocks[block]; exists {
+				delete(receivedBlocks, block)
+				writtenBlocks = append(writtenBlocks, block)
+			}
+		}
+	}
+	return receivedBlocks, writtenBlocks, false, nil
+}
+
+func (o *oramNodeServer) runEviction(eviction beginEvictionData) error {
+	storageID := eviction.storageID
+	paths := o.storageHandler.GetMultipleReverseLexicographicPaths(eviction.currentEvictionCount, eviction.evictPathCount)
+	log.Debug().Msgf("Evicting with paths %v and storageID %d", paths, storageID)
 
 	buckets, err := o.storageHandler.GetBucketsInPaths(paths)
 	if err != nil {
 		return fmt.Errorf("unable to get buckets for paths; %v", err)
 	}
+	err = o.runOverlappingReshuffleJobs(buckets, storageID)
+	if err != nil {
+		return fmt.Errorf("unable to perform pending early reshuffles; %s", err)
+	}
 	blocksFromReadBucket, err := o.readAllBuckets(buckets, storageID)
 	if err != nil {
 		return fmt.Errorf("unable to perform ReadBucket on all levels")
 	}
+	defer o.bucketVersions.bump(storageID, buckets)
 
-	randomShardNode := o.shardNodeRPCClients.getRandomShardNodeClient()
-	receivedBlocks, err := o.readBlocksFromShardNode(paths, storageID, randomShardNode)
+	shardNode := o.shardNodeRPCClients[eviction.shardNodeID]
+	receivedBlocks, writtenBlocks, abandoned, err := o.getEvictionBlocks(eviction, paths, blocksFromReadBucket)
 	log.Debug().Msgf("Received blocks from shardnode %v", receivedBlocks)
 	if err != nil {
 		return err
@@ -268,10 +445,27 @@ func (o *oramNodeServer) evict(storageID int) error {
 	if err != nil {
 		return fmt.Errorf("unable to perform WriteBucket on all levels; %s", err)
 	}
+	for _, block := range writtenBlocks {
+		receivedBlocksIsWritten[block] = true
+	}
+	err = o.faults.Hit(faults.AfterWriteBuckets)
+	if err != nil {
+		return fmt.Errorf("eviction stopped after writing the buckets; %s", err)
+	}
 
-	randomShardNode.sendBackAcksNacks(receivedBlocksIsWritten)
+	// The eviction stays unfinished if the acks are not delivered, so its replay sends them again
+	if !abandoned {
+		err = shardNode.sendBackAcksNacks(eviction.evictionID, receivedBlocksIsWritten)
+		if err != nil {
+			return fmt.Errorf("unable to send acks and nacks to the shard node; %s", err)
+		}
+	}
+	err = o.faults.Hit(faults.AfterSendAcks)
+	if err != nil {
+		return fmt.Errorf("eviction stopped after sending the acks; %s", err)
+	}
 
-	endEvictionCommand, err := newReplicateEndEvictionCommand(currentEvictionCount+o.parameters.EvictPathCount, storageID)
+	endEvictionCommand, err := newReplicateEndEvictionCommand(eviction.evictionID, eviction.currentEvictionCount+eviction.evictPathCount, storageID)
 	if err != nil {
 		return fmt.Errorf("unable to marshal end eviction command; %s", err)
 	}
@@ -307,6 +501,34 @@ func (o *oramNodeServer) asyncGetBlockOffset(bucketIDs []int, storageID int, blo
 	responseChan <- blockOffsetResponse{offsets: offsets, err: err}
 }
 
+// It returns the offset to read in each bucket and the real blocks that are found in the buckets.
+func (o *oramNodeServer) getBlockOffsets(buckets []int, storageID int, blocks []string) (offsets map[int]int, realBlockBucketMapping map[int]string, err error) {
+	offsets = make(map[int]int)                   // map of bucket id to offset
+	realBlockBucketMapping = make(map[int]string) // map of bucket id to block
+	offsetResponseChan := make(chan blockOffsetResponse)
+	batches := distributeBucketIDs(buckets, o.getParameters().RedisPipelineSize)
+	for _, bucketIDs := range batches {
+		go o.asyncGetBlockOffset(bucketIDs, storageID, blocks, offsetResponseChan)
+	}
+	for i := 0; i < len(batches); i++ {
+		response := <-offsetResponseChan
+		if response.err != nil {
+			err = fmt.Errorf("could not get offset from storage; %s", response.err)
+			continue
+		}
+		for bucketID, offsetStatus := range response.offsets {
+			if offsetStatus.IsReal {
+				realBlockBucketMapping[bucketID] = offsetStatus.BlockFound
+			}
+			offsets[bucketID] = offsetStatus.Offset
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return offsets, realBlockBucketMapping, nil
+}
+
 type readBlockResponse struct {
 	values map[int]string
 	err    error
@@ -317,6 +539,116 @@ func (o *oramNodeServer) asyncReadBlock(offsets map[int]int, storageID int, resp
 	responseChan <- readBlockResponse{values: values, err: err}
 }
 
+// It reads the blocks at the offsets and returns the values of the real blocks.
+func (o *oramNodeServer) readBlocks(offsets map[int]int, storageID int, realBlockBucketMapping map[int]string) (values map[string]string, err error) {
+	var buckets []int
+	for bucketID := range offsets {
+		buckets = append(buckets, bucketID)
+	}
+	readBlockResponseChan := make(chan readBlockResponse)
+	batches := distributeBucketIDs(buckets, o.getParameters().RedisPipelineSize)
+	for _, bucketIDs := range batches {
+		batchOffsets := make(map[int]int)
+		for _, bucketID := range bucketIDs {
+			batchOffsets[bucketID] = offsets[bucketID]
+		}
+		go o.asyncReadBlock(batchOffsets, storageID, readBlockResponseChan)
+	}
+	values = make(map[string]string) // map of block to value
+	for i := 0; i < len(batches); i++ {
+		response := <-readBlockResponseChan
+		if response.err != nil {
+			log.Error().Msgf("Could not read block %v; %s", response.values, response.err)
+			err = fmt.Errorf("could not read blocks from storage; %s", response.err)
+			continue
+		}
+		for bucketID, value := range response.values {
+			if block, exists := realBlockBucketMapping[bucketID]; exists {
+				values[block] = value
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// It groups the buckets so that the shape of the groups only depends on the paths of the requests.
+// The buckets that are only on the path of a single request have at most one real block, so they are read in one group.
+// The buckets that are on the paths of multiple requests may have multiple real blocks, so each of them is read in its own group.
+func (o *oramNodeServer) getXORGroups(requestPaths []int, offsets map[int]int, realBlockBucketMapping map[int]string) (groups []strg.XORGroup, err error) {
+	sortedPaths := append([]int{}, requestPaths...)
+	sort.Ints(sortedPaths)
+	pathBuckets := make(map[int][]int) // map of path to its buckets
+	requestCount := make(map[int]int)  // map of bucket to the number of requests that have it on their path
+	for _, path := range sortedPaths {
+		if _, exists := pathBuckets[path]; !exists {
+			pathBuckets[path], err = o.storageHandler.GetBucketsInPaths([]int{path})
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, bucketID := range pathBuckets[path] {
+			requestCount[bucketID]++
+		}
+	}
+	var sharedBuckets []int
+	for _, path := range sortedPaths {
+		if _, exists := pathBuckets[path]; !exists {
+			continue // the buckets of the path are already grouped
+		}
+		group := strg.XORGroup{Offsets: make(map[int]int)}
+		for _, bucketID := range pathBuckets[path] {
+			offset, exists := offsets[bucketID]
+			if exists && requestCount[bucketID] == 1 {
+				group.Offsets[bucketID] = offset
+				if _, isReal := realBlockBucketMapping[bucketID]; isReal {
+					group.RealBucket = bucketID
+				}
+			} else if exists && requestCount[bucketID] > 1 {
+				sharedBuckets = append(sharedBuckets, bucketID)
+			}
+			requestCount[bucketID] = 0
+		}
+		delete(pathBuckets, path)
+		if len(group.Offsets) != 0 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Ints(sharedBuckets)
+	for _, bucketID := range sharedBuckets {
+		group := strg.XORGroup{Offsets: map[int]int{bucketID: offsets[bucketID]}}
+		if _, isReal := realBlockBucketMapping[bucketID]; isReal {
+			group.RealBucket = bucketID
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// It reads a single XORed block for each group of buckets instead of one block for each bucket.
+func (o *oramNodeServer) readBlocksXOR(requestPaths []int, offsets map[int]int, storageID int, realBlockBucketMapping map[int]string) (values map[string]string, err error) {
+	groups, err := o.getXORGroups(requestPaths, offsets, realBlockBucketMapping)
+	if err != nil {
+		return nil, fmt.Errorf("could not group the buckets of the paths; %s", err)
+	}
+	groupValues, err := o.storageHandler.BatchReadBlockXOR(groups, storageID)
+	if err != nil {
+		return nil, fmt.Errorf("could not read xored blocks from storage; %s", err)
+	}
+	values = make(map[string]string) // map of block to value
+	for i, group := range groups {
+		if block, exists := realBlockBucketMapping[group.RealBucket]; exists {
+			values[block] = groupValues[i]
+		}
+	}
+	return values, nil
+}
+
+// ReadPath gets the block offsets before taking the storage lock,
+// so that it overlaps with the read path or the early reshuffle that currently holds the lock.
+// After taking the lock, it gets the offsets again only for the buckets that became stale in the meantime.
 func (o *oramNodeServer) ReadPath(ctx context.Context, request *pb.ReadPathRequest) (*pb.ReadPathReply, error) {
 	if o.raftNode.State() != raft.Leader {
 		return nil, fmt.Errorf(commonerrs.NotTheLeaderError)
@@ -324,18 +656,20 @@ func (o *oramNodeServer) ReadPath(ctx context.Context, request *pb.ReadPathReque
 	log.Debug().Msgf("Received read path request %v", request)
 	tracer := otel.Tracer("")
 	ctx, span := tracer.Start(ctx, "oramnode read path request")
-	o.storageHandler.LockStorage(int(request.StorageId))
-	defer o.storageHandler.UnlockStorage(int(request.StorageId))
+	storageID := int(request.StorageId)
 
 	var blocks []string
+	var requestPaths []int
+	blockPaths := make(map[string]int) // map of block to the path that it is requested from
 	for _, request := range request.Requests {
 		blocks = append(blocks, request.Block)
+		requestPaths = append(requestPaths, int(request.Path))
+		blockPaths[request.Block] = int(request.Path)
 	}
 
-	realBlockBucketMapping := make(map[int]string) // map of bucket id to block
-
+	readPathID := uuid.New().String()
 	paths := o.getDistinctPathsInBatch(request.Requests)
-	beginReadPathCommand, err := newReplicateBeginReadPathCommand(paths, int(request.StorageId))
+	beginReadPathCommand, err := newReplicateBeginReadPathCommand(readPathID, paths, storageID)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create begin read path replication command; %v", err)
 	}
@@ -351,64 +685,85 @@ func (o *oramNodeServer) ReadPath(ctx context.Context, request *pb.ReadPathReque
 	if err != nil {
 		return nil, fmt.Errorf("could not get bucket ids in the paths; %v", err)
 	}
+
 	_, getBlockOffsetsSpan := tracer.Start(ctx, "get block offsets")
-	offsetListResponseChan := make(chan blockOffsetResponse)
-	batches := distributeBucketIDs(buckets, o.parameters.RedisPipelineSize)
-	for _, bucketIDs := range batches {
-		go o.asyncGetBlockOffset(bucketIDs, int(request.StorageId), blocks, offsetListResponseChan)
+	versions := o.bucketVersions.get(storageID, buckets)
+	offsets, realBlockBucketMapping, err := o.getBlockOffsets(buckets, storageID, blocks)
+	if err != nil {
+		return nil, err
 	}
 	getBlockOffsetsSpan.End()
-	var offsetList []map[int]int // list of map of bucket id to offset
-	for i := 0; i < len(batches); i++ {
-		response := <-offsetListResponseChan
-		if response.err != nil {
-			return nil, fmt.Errorf("could not get offset from storage")
-		}
-		offsetList = append(offsetList, make(map[int]int))
-		for bucketID, offsetStatus := range response.offsets {
-			if offsetStatus.IsReal {
-				realBlockBucketMapping[bucketID] = offsetStatus.BlockFound
-			}
-			offsetList[i][bucketID] = offsetStatus.Offset
-		}
-	}
-	log.Debug().Msgf("Got offsets %v", offsetList)
+	log.Debug().Msgf("Got offsets %v", offsets)
 
-	returnValues := make(map[string]string) // map of block to value
-	for _, block := range blocks {
-		returnValues[block] = ""
-	}
-	_, readBlocksSpan := tracer.Start(ctx, "read blocks")
-	readBlockResponseChan := make(chan readBlockResponse)
+	o.storageHandler.LockStorage(storageID)
+	defer o.storageHandler.UnlockStorage(storageID)
 
-	for _, offsets := range offsetList {
-		go o.asyncReadBlock(offsets, int(request.StorageId), readBlockResponseChan)
+	_, pendingReshuffleSpan := tracer.Start(ctx, "pending early reshuffles")
+	err = o.runOverlappingReshuffleJobs(buckets, storageID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to perform pending early reshuffles; %s", err)
 	}
-	for i := 0; i < len(offsetList); i++ {
-		response := <-readBlockResponseChan
-		if response.err != nil {
-			log.Error().Msgf("Could not read block %v; %s", response.values, response.err)
+	pendingReshuffleSpan.End()
+
+	staleBuckets := o.bucketVersions.getStale(storageID, versions, offsets)
+	if len(staleBuckets) != 0 {
+		log.Debug().Msgf("Getting offsets again for stale buckets %v", staleBuckets)
+		_, getStaleBlockOffsetsSpan := tracer.Start(ctx, "get stale block offsets")
+		staleOffsets, staleRealBlockBucketMapping, err := o.getBlockOffsets(staleBuckets, storageID, blocks)
+		if err != nil {
 			return nil, err
 		}
-		for bucketID, value := range response.values {
-			if _, exists := realBlockBucketMapping[bucketID]; exists {
-				returnValues[realBlockBucketMapping[bucketID]] = value
+		for _, bucketID := range staleBuckets {
+			offsets[bucketID] = staleOffsets[bucketID]
+			delete(realBlockBucketMapping, bucketID)
+			if block, exists := staleRealBlockBucketMapping[bucketID]; exists {
+				realBlockBucketMapping[bucketID] = block
 			}
 		}
+		getStaleBlockOffsetsSpan.End()
+	}
+
+	_, readBlocksSpan := tracer.Start(ctx, "read blocks")
+	var values map[string]string
+	if o.getParameters().XORRead {
+		values, err = o.readBlocksXOR(requestPaths, offsets, storageID, realBlockBucketMapping)
+	} else {
+		values, err = o.readBlocks(offsets, storageID, realBlockBucketMapping)
+	}
+	if err != nil {
+		return nil, err
 	}
+	o.bucketVersions.markRead(storageID, offsets)
 	readBlocksSpan.End()
+	returnValues := make(map[string]string) // map of block to value
+	readBlocks := make(map[string]strg.BlockInfo)
+	for _, block := range blocks {
+		if value, isReal := values[block]; isReal {
+			returnValues[block] = value
+			readBlocks[block] = strg.BlockInfo{Value: value, Path: blockPaths[block]}
+		} else if value, wasRead := o.oramNodeFSM.getReadBlock(storageID, block, blockPaths[block]); wasRead {
+			// The shard node did not get the block the last time it was read
+			log.Debug().Msgf("Returning the value of block %s from its last read", block)
+			returnValues[block] = value
+		} else {
+			returnValues[block] = ""
+		}
+	}
 	log.Debug().Msgf("Going to return values %v", returnValues)
 
-	_, earlyReshuffleSpan := tracer.Start(ctx, "early reshuffle")
-	err = o.earlyReshuffle(buckets, int(request.StorageId))
+	// The early reshuffle runs in the background. Read paths and evictions that touch its buckets run it first.
+	bucketsToReshuffle, err := o.getBucketsToReshuffle(buckets, storageID)
 	if err != nil {
-		return nil, fmt.Errorf("early reshuffle failed;%s", err)
+		return nil, fmt.Errorf("could not get buckets for early reshuffle; %s", err)
+	}
+	reshuffleJobID := ""
+	if len(bucketsToReshuffle) != 0 {
+		reshuffleJobID = uuid.New().String()
 	}
-	earlyReshuffleSpan.End()
 
 	o.readPathCounter.Add(1)
 
-	endReadPathCommand, err := newReplicateEndReadPathCommand()
+	endReadPathCommand, err := newReplicateEndReadPathCommand(readPathID, reshuffleJobID, bucketsToReshuffle, storageID, readBlocks)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create end read path replication command; %s", err)
 	}
@@ -418,6 +773,9 @@ func (o *oramNodeServer) ReadPath(ctx context.Context, request *pb.ReadPathReque
 		return nil, fmt.Errorf("could not apply log to the FSM; %s", err)
 	}
 	endReadPathReplicationSpan.End()
+	if reshuffleJobID != "" {
+		o.getReshuffleQueue(storageID).add(&reshuffleJob{id: reshuffleJobID, storageID: storageID, buckets: bucketsToReshuffle})
+	}
 
 	var response []*pb.BlockResponse
 	for block, value := range returnValues {
@@ -445,7 +803,22 @@ func (o *oramNodeServer) JoinRaftVoter(ctx context.Context, joinRaftVoterRequest
 	return &pb.JoinRaftVoterReply{Success: true}, nil
 }
 
-func StartServer(oramNodeServerID int, bindIP string, advIP string, rpcPort int, replicaID int, raftPort int, joinAddr string, shardNodeRPCClients map[int]ReplicaRPCClientMap, redisEndpoints []config.RedisEndpoint, parameters config.Parameters) {
+// AddStorage connects the replica to a new storage of this oram node.
+// The leader also builds the tree of the storage, so it should be called on the leader before the shard nodes use the storage.
+// It should be called on every replica, so that a new leader can use the storage.
+func (o *oramNodeServer) AddStorage(ctx context.Context, request *pb.AddStorageRequest) (*pb.AddStorageReply, error) {
+	log.Debug().Msgf("Received add storage request %v", request)
+	isLeader := o.raftNode.State() == raft.Leader
+	endpoint := config.RedisEndpoint{ID: int(request.StorageId), ORAMNodeID: o.oramNodeServerID, IP: request.Ip, Port: int(request.Port)}
+	err := o.storageHandler.AddStorage(endpoint, isLeader)
+	if err != nil {
+		return &pb.AddStorageReply{Success: false}, fmt.Errorf("could not add storage %d; %s", request.StorageId, err)
+	}
+	return &pb.AddStorageReply{Success: true}, nil
+}
+
+// The fault rules are only used if faultInjection is true, and the faults can not be injected otherwise.
+func StartServer(oramNodeServerID int, bindIP string, advIP string, rpcPort int, replicaID int, raftPort int, joinAddr string, shardNodeRPCClients map[int]ReplicaRPCClientMap, redisEndpoints []config.RedisEndpoint, parameters config.Parameters, parametersPath string, faultRules []config.FaultRule, faultInjection bool) {
 	isFirst := joinAddr == ""
 	oramNodeFSM := newOramNodeFSM()
 	r, err := startRaftServer(isFirst, bindIP, advIP, replicaID, raftPort, oramNodeFSM)
@@ -488,22 +861,10 @@ func StartServer(oramNodeServerID int, bindIP string, advIP string, rpcPort int,
 			log.Fatal().Msgf("failed to initialize the database: %v", err)
 		}
 	}
-	oramNodeServer := newOramNodeServer(oramNodeServerID, replicaID, r, oramNodeFSM, shardNodeRPCClients, storageHandler, parameters)
-	go func() {
-		for {
-			time.Sleep(100 * time.Millisecond)
-			if oramNodeServer.readPathCounter.Load() >= int32(oramNodeServer.parameters.EvictionRate) {
-				storageID := oramNodeServer.storageHandler.GetRandomStorageID()
-				oramNodeServer.evict(storageID)
-			}
-		}
-	}()
-	go func() {
-		for {
-			oramNodeServer.performFailedOperations()
-		}
-	}()
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(rpc.ContextPropagationUnaryServerInterceptor()))
-	pb.RegisterOramNodeServer(grpcServer, oramNodeServer)
-	grpcServer.Serve(lis)
+	injector, err := faults.NewConfigInjector(faultRules, faultInjection, oramNodeServerID, replicaID)
+	if err != nil {
+		log.Fatal().Msgf("invalid fault rules; %v", err)
+	}
+	replica := newReplica(oramNodeServerID, replicaID, r, oramNodeFSM, shardNodeRPCClients, storageHandler, parameters, parametersPath, injector)
+	replica.grpcServer.Serve(lis)
 }
@@ -3,8 +3,13 @@ package oramnode
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/dsg-uwaterloo/treebeard/api/oramnode"
 	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
@@ -18,7 +23,26 @@ import (
 
 type mockShardNodeClient struct {
 	sendBlocksReply    func() (*shardnodepb.SendBlocksReply, error)
+	replayReply        *shardnodepb.SendBlocksReply // the reply before a replay, the eviction is outstanding if it is nil
 	ackSentBlocksReply func() (*shardnodepb.AckSentBlocksReply, error)
+	evictionIDs        []string // the eviction ids of the received requests
+	mu                 sync.Mutex
+}
+
+// getEvictionIDs returns the eviction ids of the requests that were received so far.
+// The replicas are called concurrently, so the eviction ids are guarded by mu.
+func (m *mockShardNodeClient) getEvictionIDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	evictionIDs := make([]string, len(m.evictionIDs))
+	copy(evictionIDs, m.evictionIDs)
+	return evictionIDs
+}
+
+func (m *mockShardNodeClient) addEvictionID(evictionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evictionIDs = append(m.evictionIDs, evictionID)
 }
 
 func (m *mockShardNodeClient) BatchQuery(ctx context.Context, in *shardnodepb.RequestBatch, opts ...grpc.CallOption) (*shardnodepb.ReplyBatch, error) {
@@ -32,14 +56,47 @@ func (m *mockShardNodeClient) Write(ctx context.Context, in *shardnodepb.WriteRe
 	return nil, nil
 }
 func (m *mockShardNodeClient) SendBlocks(ctx context.Context, in *shardnodepb.SendBlocksRequest, opts ...grpc.CallOption) (*shardnodepb.SendBlocksReply, error) {
+	m.addEvictionID(in.EvictionId)
+	if in.Replay {
+		if m.replayReply == nil {
+			return &shardnodepb.SendBlocksReply{}, nil
+		}
+		return m.replayReply, nil
+	}
 	return m.sendBlocksReply()
 }
 func (m *mockShardNodeClient) AckSentBlocks(ctx context.Context, in *shardnodepb.AckSentBlocksRequest, opts ...grpc.CallOption) (*shardnodepb.AckSentBlocksReply, error) {
+	m.addEvictionID(in.EvictionId)
 	return m.ackSentBlocksReply()
 }
 func (m *mockShardNodeClient) JoinRaftVoter(ctx context.Context, in *shardnodepb.JoinRaftVoterRequest, opts ...grpc.CallOption) (*shardnodepb.JoinRaftVoterReply, error) {
 	return nil, nil
 }
+func (m *mockShardNodeClient) MigrateBlocks(ctx context.Context, in *shardnodepb.MigrateBlocksRequest, opts ...grpc.CallOption) (shardnodepb.ShardNode_MigrateBlocksClient, error) {
+	return nil, nil
+}
+func (m *mockShardNodeClient) ReceiveMigratedBlocks(ctx context.Context, opts ...grpc.CallOption) (shardnodepb.ShardNode_ReceiveMigratedBlocksClient, error) {
+	return nil, nil
+}
+func (m *mockShardNodeClient) FinishMigration(ctx context.Context, in *shardnodepb.FinishMigrationRequest, opts ...grpc.CallOption) (*shardnodepb.FinishMigrationReply, error) {
+	return nil, nil
+}
+func (m *mockShardNodeClient) DecideMigration(ctx context.Context, in *shardnodepb.DecideMigrationRequest, opts ...grpc.CallOption) (*shardnodepb.DecideMigrationReply, error) {
+	return nil, nil
+}
+func (m *mockShardNodeClient) AddStorage(ctx context.Context, in *shardnodepb.AddStorageRequest, opts ...grpc.CallOption) (*shardnodepb.AddStorageReply, error) {
+	return nil, nil
+}
+func (m *mockShardNodeClient) DecideTransactions(ctx context.Context, in *shardnodepb.TransactionDecisions, opts ...grpc.CallOption) (*shardnodepb.DecideTransactionsReply, error) {
+	return nil, nil
+}
+func (m *mockShardNodeClient) DecideTransaction(ctx context.Context, in *shardnodepb.DecideTransactionRequest, opts ...grpc.CallOption) (*shardnodepb.DecideTransactionReply, error) {
+	return nil, nil
+}
+
+func (m *mockShardNodeClient) Scan(ctx context.Context, in *shardnodepb.ScanRequest, opts ...grpc.CallOption) (*shardnodepb.ScanReply, error) {
+	return nil, nil
+}
 
 func getMockShardNodeClients() map[int]ReplicaRPCClientMap {
 	return map[int]ReplicaRPCClientMap{
@@ -102,7 +159,7 @@ func getFailedMockShardNodeClients() map[int]ReplicaRPCClientMap {
 	}
 }
 
-func startLeaderRaftNodeServer(t *testing.T, storageHandler storage) *oramNodeServer {
+func startLeaderRaftNodeServer(t testing.TB, storageHandler Storage) *oramNodeServer {
 	fsm := newOramNodeFSM()
 	raftPort, err := freeport.GetFreePort()
 	if err != nil {
@@ -162,7 +219,7 @@ func TestReadAllBucketsReturnsAllTreeBlocks(t *testing.T) {
 
 func TestReadBlocksFromShardNodeReturnsAllShardNodeBlocks(t *testing.T) {
 	o := startLeaderRaftNodeServer(t, strg.NewMockStorageHandler(3, 4))
-	blocks, err := o.readBlocksFromShardNode([]int{4, 7, 11}, 1, o.shardNodeRPCClients[0])
+	blocks, _, err := o.readBlocksFromShardNode("eviction", []int{4, 7, 11}, 1, o.shardNodeRPCClients[0])
 	if err != nil {
 		t.Errorf("expected Successful execution of readBlocksFromShardNode")
 	}
@@ -331,6 +388,219 @@ func TestEvictResetsReadPathCounter(t *testing.T) {
 	}
 }
 
+func TestReplayEvictionWritesTheReplicatedBlocksAndAcksThemToTheSameShardNode(t *testing.T) {
+	var writtenShardNodeBlocks []string
+	m := strg.NewMockStorageHandler(3, 4).WithCustomBatchReadBucketFunc(
+		func(bucketIDs []int, storageID int) (blocks map[int]map[string]string, err error) {
+			// The interrupted eviction already wrote block a
+			return map[int]map[string]string{bucketIDs[0]: {"a": "valA"}}, nil
+		},
+	).WithCustomBatchWriteBucketFunc(
+		func(storageID int, readBucketBlocksList map[int]map[string]string, shardNodeBlocks map[string]strg.BlockInfo) (writtenBlocks map[string]string, err error) {
+			writtenBlocks = make(map[string]string)
+			for block, blockInfo := range shardNodeBlocks {
+				writtenShardNodeBlocks = append(writtenShardNodeBlocks, block)
+				writtenBlocks[block] = blockInfo.Value
+			}
+			return writtenBlocks, nil
+		},
+	)
+	o := startLeaderRaftNodeServer(t, m)
+	ackedShardNode := -1
+	mockShardNode := func(shardNodeID int) ReplicaRPCClientMap {
+		return ReplicaRPCClientMap{0: {ClientAPI: &mockShardNodeClient{
+			sendBlocksReply: func() (*shardnodepb.SendBlocksReply, error) {
+				t.Errorf("the replayed eviction should not get new blocks from the shard node")
+				return &shardnodepb.SendBlocksReply{}, nil
+			},
+			ackSentBlocksReply: func() (*shardnodepb.AckSentBlocksReply, error) {
+				ackedShardNode = shardNodeID
+				return &shardnodepb.AckSentBlocksReply{Success: true}, nil
+			},
+		}}}
+	}
+	o.shardNodeRPCClients = map[int]ReplicaRPCClientMap{0: mockShardNode(0), 1: mockShardNode(1)}
+	o.oramNodeFSM.handleBeginEvictionCommand("eviction", 6, 0, 2, 1)
+	o.oramNodeFSM.handleEvictionBlocksCommand("eviction", map[string]strg.BlockInfo{"a": {Value: "valA"}, "b": {Value: "valB"}})
+
+	err := o.replayEviction()
+	if err != nil {
+		t.Fatalf("expected the eviction to be replayed; %s", err)
+	}
+	if len(writtenShardNodeBlocks) != 1 || writtenShardNodeBlocks[0] != "b" {
+		t.Errorf("expected only block b to be written again but got %v", writtenShardNodeBlocks)
+	}
+	if ackedShardNode != 1 {
+		t.Errorf("expected the acks to be sent to shard node 1 of the eviction but got %d", ackedShardNode)
+	}
+	if o.oramNodeFSM.getUnfinishedEviction() != nil || o.oramNodeFSM.evictionCountMap[0] != 8 {
+		t.Errorf("expected the replayed eviction to end with eviction count 8 but got %d", o.oramNodeFSM.evictionCountMap[0])
+	}
+}
+
+func TestReplayEvictionIsAbandonedIfTheShardNodeFinishedTheEviction(t *testing.T) {
+	for _, timedOut := range []bool{false, true} {
+		var writtenBlocks map[string]string
+		m := strg.NewMockStorageHandler(3, 4).WithCustomBatchReadBucketFunc(
+			func(bucketIDs []int, storageID int) (blocks map[int]map[string]string, err error) {
+				// The interrupted eviction already wrote block a
+				return map[int]map[string]string{bucketIDs[0]: {"a": "valA", "c": "valC"}}, nil
+			},
+		).WithCustomBatchWriteBucketFunc(
+			func(storageID int, readBucketBlocksList map[int]map[string]string, shardNodeBlocks map[string]strg.BlockInfo) (map[string]string, error) {
+				writtenBlocks = make(map[string]string)
+				for _, blocks := range readBucketBlocksList {
+					for block, value := range blocks {
+						writtenBlocks[block] = value
+					}
+				}
+				for block, blockInfo := range shardNodeBlocks {
+					writtenBlocks[block] = blockInfo.Value
+				}
+				return writtenBlocks, nil
+			},
+		)
+		o := startLeaderRaftNodeServer(t, m)
+		shardNode := &mockShardNodeClient{
+			replayReply: &shardnodepb.SendBlocksReply{Finished: true, TimedOut: timedOut},
+			ackSentBlocksReply: func() (*shardnodepb.AckSentBlocksReply, error) {
+				t.Errorf("expected no acks for an abandoned replay")
+				return &shardnodepb.AckSentBlocksReply{Success: true}, nil
+			},
+		}
+		o.shardNodeRPCClients = map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: shardNode}}}
+		o.oramNodeFSM.handleBeginEvictionCommand("eviction", 6, 0, 2, 0)
+		o.oramNodeFSM.handleEvictionBlocksCommand("eviction", map[string]strg.BlockInfo{"a": {Value: "valA"}, "b": {Value: "valB"}})
+
+		err := o.replayEviction()
+		if err != nil {
+			t.Fatalf("expected the eviction to be replayed; %s", err)
+		}
+		if _, exists := writtenBlocks["b"]; exists {
+			t.Errorf("expected the stale block b not to be written")
+		}
+		// The shard node keeps the blocks of a timed out eviction, and it removed the acked blocks from its stash
+		if _, exists := writtenBlocks["a"]; exists == timedOut {
+			t.Errorf("expected block a to be written back only if the eviction did not time out, timed out: %t", timedOut)
+		}
+		if writtenBlocks["c"] != "valC" {
+			t.Errorf("expected the other blocks of the buckets to be written back")
+		}
+		if o.oramNodeFSM.getUnfinishedEviction() != nil || o.oramNodeFSM.evictionCountMap[0] != 8 {
+			t.Errorf("expected the abandoned eviction to end with eviction count 8 but got %d", o.oramNodeFSM.evictionCountMap[0])
+		}
+	}
+}
+
+func TestReplayEvictionIsAbandonedIfTheShardNodeFinishedTheEvictionBeforeItsBlocksWereReplicated(t *testing.T) {
+	var writtenShardNodeBlocks map[string]strg.BlockInfo
+	m := strg.NewMockStorageHandler(3, 4).WithCustomBatchWriteBucketFunc(
+		func(storageID int, readBucketBlocksList map[int]map[string]string, shardNodeBlocks map[string]strg.BlockInfo) (map[string]string, error) {
+			writtenShardNodeBlocks = shardNodeBlocks
+			return nil, nil
+		},
+	)
+	o := startLeaderRaftNodeServer(t, m)
+	shardNode := &mockShardNodeClient{
+		sendBlocksReply: func() (*shardnodepb.SendBlocksReply, error) {
+			return &shardnodepb.SendBlocksReply{Finished: true, TimedOut: true}, nil
+		},
+		ackSentBlocksReply: func() (*shardnodepb.AckSentBlocksReply, error) {
+			t.Errorf("expected no acks for an abandoned eviction")
+			return &shardnodepb.AckSentBlocksReply{Success: true}, nil
+		},
+	}
+	o.shardNodeRPCClients = map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: shardNode}}}
+	o.oramNodeFSM.handleBeginEvictionCommand("eviction", 6, 0, 2, 0)
+
+	err := o.replayEviction()
+	if err != nil {
+		t.Fatalf("expected the eviction to be replayed; %s", err)
+	}
+	if len(writtenShardNodeBlocks) != 0 {
+		t.Errorf("expected no shard node blocks to be written but got %v", writtenShardNodeBlocks)
+	}
+	if o.oramNodeFSM.getUnfinishedEviction() != nil {
+		t.Errorf("expected the abandoned eviction to end")
+	}
+}
+
+func TestEvictReplaysTheUnfinishedEvictionFirst(t *testing.T) {
+	o := startLeaderRaftNodeServer(t, strg.NewMockStorageHandler(3, 4))
+	o.oramNodeFSM.handleBeginEvictionCommand("eviction", 3, 1, 1, 0)
+	o.oramNodeFSM.handleEvictionBlocksCommand("eviction", map[string]strg.BlockInfo{})
+	o.evict(0)
+	if o.oramNodeFSM.getUnfinishedEviction() != nil || o.oramNodeFSM.evictionCountMap[1] != 4 {
+		t.Errorf("expected the unfinished eviction of storage 1 to be replayed")
+	}
+	if _, exists := o.oramNodeFSM.evictionCountMap[0]; exists {
+		t.Errorf("expected no new eviction before the unfinished one is replayed")
+	}
+}
+
+func TestReplayEvictionGetsTheBlocksOfTheSameEvictionFromTheShardNode(t *testing.T) {
+	o := startLeaderRaftNodeServer(t, strg.NewMockStorageHandler(3, 4))
+	shardNode := &mockShardNodeClient{
+		sendBlocksReply: func() (*shardnodepb.SendBlocksReply, error) {
+			return &shardnodepb.SendBlocksReply{Blocks: []*shardnodepb.Block{{Block: "a", Value: "valA"}}}, nil
+		},
+		ackSentBlocksReply: func() (*shardnodepb.AckSentBlocksReply, error) {
+			return &shardnodepb.AckSentBlocksReply{Success: true}, nil
+		},
+	}
+	o.shardNodeRPCClients = map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: shardNode}}}
+	// The leader crashed before the blocks of the eviction were replicated
+	o.oramNodeFSM.handleBeginEvictionCommand("eviction", 0, 0, 1, 0)
+
+	err := o.replayEviction()
+	if err != nil {
+		t.Fatalf("expected the eviction to be replayed; %s", err)
+	}
+	evictionIDs := shardNode.getEvictionIDs()
+	if len(evictionIDs) != 2 || evictionIDs[0] != "eviction" || evictionIDs[1] != "eviction" {
+		t.Errorf("expected the blocks and acks of the replayed eviction to use its eviction id but got %v", evictionIDs)
+	}
+}
+
+func TestEvictKeepsTheEvictionUnfinishedIfTheAcksAreNotDelivered(t *testing.T) {
+	o := startLeaderRaftNodeServer(t, strg.NewMockStorageHandler(3, 4))
+	acksDelivered := false
+	shardNode := &mockShardNodeClient{
+		sendBlocksReply: func() (*shardnodepb.SendBlocksReply, error) {
+			return &shardnodepb.SendBlocksReply{Blocks: []*shardnodepb.Block{{Block: "a", Value: "valA"}}}, nil
+		},
+		ackSentBlocksReply: func() (*shardnodepb.AckSentBlocksReply, error) {
+			if !acksDelivered {
+				return nil, fmt.Errorf("the shard node crashed")
+			}
+			return &shardnodepb.AckSentBlocksReply{Success: true}, nil
+		},
+	}
+	o.shardNodeRPCClients = map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: shardNode}}}
+
+	err := o.evict(0)
+	if err == nil {
+		t.Fatalf("expected the eviction to fail without delivering the acks")
+	}
+	eviction := o.oramNodeFSM.getUnfinishedEviction()
+	if eviction == nil {
+		t.Fatalf("expected the eviction to stay unfinished")
+	}
+	acksDelivered = true
+	err = o.evict(0)
+	if err != nil {
+		t.Fatalf("expected the eviction to be replayed; %s", err)
+	}
+	if o.oramNodeFSM.getUnfinishedEviction() != nil {
+		t.Errorf("expected the replayed eviction to finish")
+	}
+	evictionIDs := shardNode.getEvictionIDs()
+	lastEvictionID := evictionIDs[len(evictionIDs)-1]
+	if lastEvictionID != eviction.evictionID {
+		t.Errorf("expected the acks to be sent again for eviction %s but got %s", eviction.evictionID, lastEvictionID)
+	}
+}
+
 func TestGetDistinctPathsInBatchReturnsDistinctPathsInRequests(t *testing.T) {
 	o := newOramNodeServer(0, 0, &raft.Raft{}, &oramNodeFSM{}, make(map[int]ReplicaRPCClientMap), &strg.StorageHandler{}, config.Parameters{})
 	paths := o.getDistinctPathsInBatch(
@@ -378,3 +648,342 @@ func TestReadPathIncrementsReadPathCounter(t *testing.T) {
 		t.Errorf("ReadPath should increment readPathCounter")
 	}
 }
+
+func TestReadPathDoesNotMarkOffsetsReadIfTheReadFails(t *testing.T) {
+	m := strg.NewMockStorageHandler(3, 4).WithCusomBatchGetBlockOffsetFunc(
+		func(bucketIDs []int, storageID int, blocks []string) (offsets map[int]strg.BlockOffsetStatus, err error) {
+			offsets = make(map[int]strg.BlockOffsetStatus)
+			for _, bucketID := range bucketIDs {
+				offsets[bucketID] = strg.BlockOffsetStatus{Offset: 0}
+			}
+			return offsets, nil
+		},
+	).WithCustomBatchReadBlockFunc(
+		func(offsets map[int]int, storageID int) (values map[int]string, err error) {
+			return nil, fmt.Errorf("redis is down")
+		},
+	)
+	o := startLeaderRaftNodeServer(t, m)
+	o.parameters.RedisPipelineSize = 2
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("requestid", "request1"))
+	_, err := o.ReadPath(ctx, &oramnode.ReadPathRequest{StorageId: 0, Requests: []*oramnode.BlockRequest{{Block: "a", Path: 1}}})
+	if err == nil {
+		t.Fatalf("expected the read path to fail")
+	}
+	if stale := o.bucketVersions.getStale(0, map[int]uint64{1: 0}, map[int]int{1: 0}); len(stale) != 0 {
+		t.Errorf("expected the offsets of the failed read not to be marked as read but got stale buckets %v", stale)
+	}
+}
+
+func TestReadPathReturnsTheLastReadValueOfABlockThatIsNotInThePathAnymore(t *testing.T) {
+	read := false
+	m := strg.NewMockStorageHandler(3, 4).WithCusomBatchGetBlockOffsetFunc(
+		func(bucketIDs []int, storageID int, blocks []string) (offsets map[int]strg.BlockOffsetStatus, err error) {
+			offsets = make(map[int]strg.BlockOffsetStatus)
+			for _, bucketID := range bucketIDs {
+				offsets[bucketID] = strg.BlockOffsetStatus{Offset: 0}
+			}
+			if !read {
+				offsets[1] = strg.BlockOffsetStatus{Offset: 0, IsReal: true, BlockFound: "a"}
+			}
+			return offsets, nil
+		},
+	).WithCustomBatchReadBlockFunc(
+		func(offsets map[int]int, storageID int) (values map[int]string, err error) {
+			values = make(map[int]string)
+			for bucketID := range offsets {
+				values[bucketID] = "valA"
+			}
+			return values, nil
+		},
+	)
+	o := startLeaderRaftNodeServer(t, m)
+	o.parameters.RedisPipelineSize = 10
+	_, err := o.ReadPath(context.Background(), &oramnode.ReadPathRequest{StorageId: 0, Requests: []*oramnode.BlockRequest{{Block: "a", Path: 1}}})
+	if err != nil {
+		t.Fatalf("expected ReadPath to succeed but got %s", err)
+	}
+	read = true
+	// The shard node reads the block again from the same path if it did not replicate the first response
+	reply, err := o.ReadPath(context.Background(), &oramnode.ReadPathRequest{StorageId: 0, Requests: []*oramnode.BlockRequest{{Block: "a", Path: 1}}})
+	if err != nil {
+		t.Fatalf("expected ReadPath to succeed but got %s", err)
+	}
+	if len(reply.Responses) != 1 || reply.Responses[0].Value != "valA" {
+		t.Errorf("expected the last read value valA but got %v", reply.Responses)
+	}
+	reply, err = o.ReadPath(context.Background(), &oramnode.ReadPathRequest{StorageId: 0, Requests: []*oramnode.BlockRequest{{Block: "a", Path: 2}}})
+	if err != nil {
+		t.Fatalf("expected ReadPath to succeed but got %s", err)
+	}
+	if len(reply.Responses) != 1 || reply.Responses[0].Value != "" {
+		t.Errorf("expected no value for another path but got %v", reply.Responses)
+	}
+}
+
+func TestReadPathGetsOffsetsAgainForBucketsWrittenByPendingReshuffle(t *testing.T) {
+	var getBlockOffsetCalls [][]int
+	m := strg.NewMockStorageHandler(3, 4).WithCusomBatchGetBlockOffsetFunc(
+		func(bucketIDs []int, storageID int, blocks []string) (offsets map[int]strg.BlockOffsetStatus, err error) {
+			getBlockOffsetCalls = append(getBlockOffsetCalls, bucketIDs)
+			offsets = make(map[int]strg.BlockOffsetStatus)
+			for _, bucketID := range bucketIDs {
+				offsets[bucketID] = strg.BlockOffsetStatus{Offset: len(getBlockOffsetCalls)}
+			}
+			return offsets, nil
+		},
+	).WithCustomBatchGetAccessCountFunc(
+		func(bucketIDs []int, storageID int) (counts map[int]int, err error) {
+			return map[int]int{3: 4}, nil
+		},
+	).WithCustomBatchReadBucketFunc(
+		func(bucketIDs []int, storageID int) (blocks map[int]map[string]string, err error) {
+			return map[int]map[string]string{3: {}}, nil
+		},
+	)
+	var readOffsets map[int]int
+	m.WithCustomBatchReadBlockFunc(
+		func(offsets map[int]int, storageID int) (values map[int]string, err error) {
+			readOffsets = offsets
+			return nil, nil
+		},
+	)
+	o := startLeaderRaftNodeServer(t, m)
+	o.parameters.RedisPipelineSize = 10
+	q := newReshuffleQueue() // no worker is started for this queue
+	o.reshuffleQueues[0] = q
+	q.add(&reshuffleJob{id: "job1", storageID: 0, buckets: []int{3}})
+
+	_, err := o.ReadPath(context.Background(), &oramnode.ReadPathRequest{StorageId: 0, Requests: []*oramnode.BlockRequest{{Block: "a", Path: 1}}})
+	if err != nil {
+		t.Errorf("expected ReadPath to succeed but got %s", err)
+	}
+	if len(getBlockOffsetCalls) != 2 || len(getBlockOffsetCalls[1]) != 1 || getBlockOffsetCalls[1][0] != 3 {
+		t.Errorf("expected offsets to be fetched again only for bucket 3 but got calls %v", getBlockOffsetCalls)
+	}
+	if readOffsets[3] != 2 || readOffsets[1] != 1 {
+		t.Errorf("expected the new offset for bucket 3 and the old offsets for other buckets but got %v", readOffsets)
+	}
+}
+
+// benchmarkStorage returns the real buckets of the paths, so that read paths on different paths only share the top buckets.
+type benchmarkStorage struct {
+	*strg.MockStorageHandler
+	treeHeight int
+}
+
+func (b benchmarkStorage) GetBucketsInPaths(paths []int) (bucketIDs []int, err error) {
+	buckets := make(map[int]bool)
+	for _, path := range paths {
+		for bucketID := (1 << (b.treeHeight - 1)) + path - 1; bucketID > 0; bucketID = bucketID >> 1 {
+			buckets[bucketID] = true
+		}
+	}
+	for bucketID := range buckets {
+		bucketIDs = append(bucketIDs, bucketID)
+	}
+	return bucketIDs, nil
+}
+
+// It simulates the storage latency to compare read paths that run one at a time with concurrent read paths.
+// Concurrent read paths get the block offsets while another read path holds the storage lock.
+func BenchmarkReadPath(b *testing.B) {
+	m := strg.NewMockStorageHandler(10, 4).WithCusomBatchGetBlockOffsetFunc(
+		func(bucketIDs []int, storageID int, blocks []string) (offsets map[int]strg.BlockOffsetStatus, err error) {
+			time.Sleep(2 * time.Millisecond)
+			offsets = make(map[int]strg.BlockOffsetStatus)
+			for _, bucketID := range bucketIDs {
+				offsets[bucketID] = strg.BlockOffsetStatus{Offset: rand.Intn(10)}
+			}
+			return offsets, nil
+		},
+	).WithCustomBatchGetAccessCountFunc(
+		func(bucketIDs []int, storageID int) (counts map[int]int, err error) {
+			time.Sleep(time.Millisecond)
+			return nil, nil
+		},
+	).WithCustomBatchReadBlockFunc(
+		func(offsets map[int]int, storageID int) (values map[int]string, err error) {
+			time.Sleep(time.Millisecond)
+			return nil, nil
+		},
+	)
+	o := startLeaderRaftNodeServer(b, benchmarkStorage{MockStorageHandler: m, treeHeight: 10})
+	o.parameters.RedisPipelineSize = 20
+	newRequest := func(i int) *oramnode.ReadPathRequest {
+		return &oramnode.ReadPathRequest{StorageId: 0, Requests: []*oramnode.BlockRequest{{Block: "a", Path: int32(i%512 + 1)}}}
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			o.ReadPath(context.Background(), newRequest(i))
+		}
+	})
+	b.Run("concurrent", func(b *testing.B) {
+		var counter atomic.Int32
+		b.SetParallelism(4)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				o.ReadPath(context.Background(), newRequest(int(counter.Add(1))*37))
+			}
+		})
+	})
+}
+
+func TestReadPathWithXORReadReadsOneBlockForEachPath(t *testing.T) {
+	realBlocks := map[int]string{4: "a", 2: "b", 5: "c"}
+	m := strg.NewMockStorageHandler(3, 4).WithCusomBatchGetBlockOffsetFunc(
+		func(bucketIDs []int, storageID int, blocks []string) (offsets map[int]strg.BlockOffsetStatus, err error) {
+			offsets = make(map[int]strg.BlockOffsetStatus)
+			for _, bucketID := range bucketIDs {
+				block, isReal := realBlocks[bucketID]
+				offsets[bucketID] = strg.BlockOffsetStatus{Offset: bucketID, IsReal: isReal, BlockFound: block}
+			}
+			return offsets, nil
+		},
+	)
+	var readGroups []strg.XORGroup
+	m.WithCustomBatchReadBlockXORFunc(
+		func(groups []strg.XORGroup, storageID int) (values []string, err error) {
+			readGroups = groups
+			for _, group := range groups {
+				values = append(values, "value"+realBlocks[group.RealBucket])
+			}
+			return values, nil
+		},
+	)
+	o := startLeaderRaftNodeServer(t, benchmarkStorage{MockStorageHandler: m, treeHeight: 3})
+	o.parameters.RedisPipelineSize = 10
+	o.parameters.XORRead = true
+
+	reply, err := o.ReadPath(context.Background(), &oramnode.ReadPathRequest{StorageId: 0, Requests: []*oramnode.BlockRequest{{Block: "a", Path: 1}, {Block: "b", Path: 1}, {Block: "c", Path: 2}}})
+	if err != nil {
+		t.Errorf("expected ReadPath to succeed but got %s", err)
+	}
+	// path 1 has buckets 4, 2 and 1, and path 2 has buckets 5, 2 and 1. Only bucket 5 is on the path of a single request.
+	if len(readGroups) != 4 {
+		t.Errorf("expected 4 groups but got %v", readGroups)
+	}
+	groupedBuckets := make(map[int]bool)
+	for _, group := range readGroups {
+		for bucketID := range group.Offsets {
+			if _, isReal := realBlocks[bucketID]; isReal && bucketID != group.RealBucket {
+				t.Errorf("expected group %v to have a single real block", group)
+			}
+			groupedBuckets[bucketID] = true
+		}
+	}
+	if len(groupedBuckets) != 4 {
+		t.Errorf("expected every bucket to be read once but got groups %v", readGroups)
+	}
+	for _, blockResponse := range reply.Responses {
+		if blockResponse.Value != "value"+blockResponse.Block {
+			t.Errorf("expected value%s for block %s but got %s", blockResponse.Block, blockResponse.Block, blockResponse.Value)
+		}
+	}
+}
+
+func TestGetXORGroupsDependOnlyOnTheRequestPaths(t *testing.T) {
+	o := startLeaderRaftNodeServer(t, benchmarkStorage{MockStorageHandler: strg.NewMockStorageHandler(3, 4), treeHeight: 4})
+	offsets := make(map[int]int)
+	for bucketID := 1; bucketID < 16; bucketID++ {
+		offsets[bucketID] = bucketID
+	}
+	groupShape := func(groups []strg.XORGroup) (shape []string) {
+		for _, group := range groups {
+			var buckets []int
+			for bucketID := range group.Offsets {
+				buckets = append(buckets, bucketID)
+			}
+			sort.Ints(buckets)
+			shape = append(shape, fmt.Sprint(buckets))
+		}
+		return shape
+	}
+	// path 1 has buckets 8, 4, 2 and 1, path 2 has buckets 9, 4, 2 and 1, and path 5 has buckets 12, 6, 3 and 1.
+	requestPaths := []int{2, 1, 5}
+	var expectedShape []string
+	for _, realBlockBucketMapping := range []map[int]string{
+		{8: "a", 9: "b", 12: "c"},
+		{4: "a", 2: "b", 1: "c"},
+		{1: "a", 12: "c"},
+	} {
+		groups, err := o.getXORGroups(requestPaths, offsets, realBlockBucketMapping)
+		if err != nil {
+			t.Fatalf("expected getXORGroups to succeed but got %s", err)
+		}
+		for _, group := range groups {
+			for bucketID := range group.Offsets {
+				if _, isReal := realBlockBucketMapping[bucketID]; isReal && bucketID != group.RealBucket {
+					t.Errorf("expected group %v to have a single real block", group)
+				}
+			}
+		}
+		shape := groupShape(groups)
+		if expectedShape == nil {
+			expectedShape = shape
+		} else if !reflect.DeepEqual(shape, expectedShape) {
+			t.Errorf("expected the groups %v to have the same shape as %v", shape, expectedShape)
+		}
+	}
+	if !reflect.DeepEqual(expectedShape, []string{"[8]", "[9]", "[3 6 12]", "[1]", "[2]", "[4]"}) {
+		t.Errorf("expected the shared buckets to be read in their own groups but got %v", expectedShape)
+	}
+}
+
+func TestAddStorageInitializesStorageOnlyOnLeader(t *testing.T) {
+	var initialized []bool
+	storageHandler := strg.NewMockStorageHandler(4, 4).WithCustomAddStorageFunc(func(endpoint config.RedisEndpoint, initialize bool) error {
+		if endpoint.ID != 3 || endpoint.ORAMNodeID != 0 {
+			t.Errorf("expected storage 3 of oram node 0 but got %v", endpoint)
+		}
+		initialized = append(initialized, initialize)
+		return nil
+	})
+	leader := startLeaderRaftNodeServer(t, storageHandler)
+	follower := newOramNodeServer(0, 1, &raft.Raft{}, newOramNodeFSM(), getMockShardNodeClients(), storageHandler, config.Parameters{})
+
+	for _, o := range []*oramNodeServer{leader, follower} {
+		_, err := o.AddStorage(context.Background(), &oramnode.AddStorageRequest{StorageId: 3, Ip: "localhost", Port: 6379})
+		if err != nil {
+			t.Errorf("expected successful add storage but got %s", err)
+		}
+	}
+	if len(initialized) != 2 || !initialized[0] || initialized[1] {
+		t.Errorf("expected only the leader to initialize the storage but got %v", initialized)
+	}
+}
+
+func TestUpdateParametersReplicatesEvictPathCountOnLeader(t *testing.T) {
+	o := startLeaderRaftNodeServer(t, strg.NewMockStorageHandler(4, 4))
+	parameters := o.Parameters()
+	parameters.EvictPathCount = 7
+	parameters.EvictionRate = 9
+	err := o.UpdateParameters(parameters)
+	if err != nil {
+		t.Errorf("expected successful update but got %s", err)
+	}
+	if o.oramNodeFSM.getEvictPathCount() != 7 {
+		t.Errorf("expected the evict path count 7 in the FSM but got %d", o.oramNodeFSM.getEvictPathCount())
+	}
+	if o.Parameters().EvictionRate != 9 || o.Parameters().EvictPathCount != 7 {
+		t.Errorf("expected the updated parameters but got %v", o.Parameters())
+	}
+}
+
+func TestUpdateParametersAppliesLocalParametersAndSkipsEvictPathCountOnFollower(t *testing.T) {
+	o := newOramNodeServer(0, 1, &raft.Raft{}, newOramNodeFSM(), getMockShardNodeClients(), strg.NewMockStorageHandler(4, 4), config.Parameters{EvictPathCount: 2, EvictionRate: 3, MaxBlocksToSend: 4})
+	parameters := o.Parameters()
+	parameters.EvictPathCount = 7
+	parameters.EvictionRate = 9
+	parameters.MaxBlocksToSend = 10
+	if err := o.UpdateParameters(parameters); err != nil {
+		t.Errorf("expected a follower to apply its local parameters but got %s", err)
+	}
+	if o.Parameters().EvictPathCount != 2 {
+		t.Errorf("the evict path count should not change on a follower")
+	}
+	if o.Parameters().EvictionRate != 9 || o.Parameters().MaxBlocksToSend != 10 {
+		t.Errorf("expected the eviction rate and max blocks to send to change on a follower but got %v", o.Parameters())
+	}
+}
@@ -0,0 +1,75 @@
+package oramnode
+
+import "sync"
+
+type bucketVersion struct {
+	version     uint64
+	readOffsets map[int]bool // offsets that were read since the bucket was written
+}
+
+// bucketVersions keeps a version for each bucket that changes whenever the bucket is written,
+// and the offsets that were read since then.
+// Read paths get the block offsets before taking the storage lock,
+// and use the versions to find the offsets that became stale in the meantime.
+type bucketVersions struct {
+	mu       sync.Mutex
+	versions map[int]map[int]*bucketVersion // map of storage id to map of bucket id to version
+}
+
+func newBucketVersions() *bucketVersions {
+	return &bucketVersions{versions: make(map[int]map[int]*bucketVersion)}
+}
+
+func (b *bucketVersions) getBucketVersion(storageID int, bucket int) *bucketVersion {
+	if _, exists := b.versions[storageID]; !exists {
+		b.versions[storageID] = make(map[int]*bucketVersion)
+	}
+	if _, exists := b.versions[storageID][bucket]; !exists {
+		b.versions[storageID][bucket] = &bucketVersion{readOffsets: make(map[int]bool)}
+	}
+	return b.versions[storageID][bucket]
+}
+
+func (b *bucketVersions) get(storageID int, buckets []int) (versions map[int]uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	versions = make(map[int]uint64)
+	for _, bucket := range buckets {
+		versions[bucket] = b.getBucketVersion(storageID, bucket).version
+	}
+	return versions
+}
+
+// It should be called after the buckets are written.
+func (b *bucketVersions) bump(storageID int, buckets []int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, bucket := range buckets {
+		bucketVersion := b.getBucketVersion(storageID, bucket)
+		bucketVersion.version++
+		bucketVersion.readOffsets = make(map[int]bool)
+	}
+}
+
+// It should be called after the offsets are read.
+func (b *bucketVersions) markRead(storageID int, offsets map[int]int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for bucket, offset := range offsets {
+		b.getBucketVersion(storageID, bucket).readOffsets[offset] = true
+	}
+}
+
+// It returns the buckets that were written after the given versions,
+// or whose offset was read by another read path.
+func (b *bucketVersions) getStale(storageID int, versions map[int]uint64, offsets map[int]int) (stale []int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for bucket, version := range versions {
+		bucketVersion := b.getBucketVersion(storageID, bucket)
+		if bucketVersion.version != version || bucketVersion.readOffsets[offsets[bucket]] {
+			stale = append(stale, bucket)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,38 @@
+package oramnode
+
+import "testing"
+
+func TestBucketVersionsGetStaleReturnsWrittenBuckets(t *testing.T) {
+	b := newBucketVersions()
+	b.bump(0, []int{1, 2})
+	versions := b.get(0, []int{1, 2, 3})
+	b.bump(0, []int{2, 3})
+	b.bump(1, []int{1})
+
+	stale := b.getStale(0, versions, map[int]int{1: 0, 2: 0, 3: 0})
+	if len(stale) != 2 {
+		t.Errorf("expected buckets 2 and 3 to be stale but got %v", stale)
+	}
+	for _, bucket := range stale {
+		if bucket == 1 {
+			t.Errorf("bucket 1 of storage 0 should not be stale")
+		}
+	}
+}
+
+func TestBucketVersionsGetStaleReturnsBucketsWithReadOffsets(t *testing.T) {
+	b := newBucketVersions()
+	versions := b.get(0, []int{1, 2})
+	b.markRead(0, map[int]int{1: 3, 2: 4})
+
+	stale := b.getStale(0, versions, map[int]int{1: 3, 2: 5})
+	if len(stale) != 1 || stale[0] != 1 {
+		t.Errorf("expected only bucket 1 to be stale but got %v", stale)
+	}
+	b.bump(0, []int{1})
+	versions = b.get(0, []int{1})
+	stale = b.getStale(0, versions, map[int]int{1: 3})
+	if len(stale) != 0 {
+		t.Errorf("writing a bucket should reset its read offsets but got stale buckets %v", stale)
+	}
+}
@@ -0,0 +1,21 @@
+package router
+
+import (
+	"time"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+)
+
+// Parameters returns the parameters of the router with the current epoch time.
+func (r *routerServer) Parameters() config.Parameters {
+	parameters := r.parameters
+	parameters.EpochTime = float64(r.epochManager.getEpochDuration()) / float64(time.Millisecond)
+	return parameters
+}
+
+// UpdateParameters changes the epoch time of the router.
+// The new epoch time is used from the next epoch.
+func (r *routerServer) UpdateParameters(parameters config.Parameters) error {
+	r.epochManager.setEpochDuration(time.Duration(parameters.EpochTime * float64(time.Millisecond)))
+	return nil
+}
@@ -0,0 +1,33 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+)
+
+func TestUpdateParametersChangesEpochDuration(t *testing.T) {
+	e := createTestEpochManager(1)
+	r := newRouterServer(0, e)
+	parameters := r.Parameters()
+	parameters.EpochTime = 25
+	err := r.UpdateParameters(parameters)
+	if err != nil {
+		t.Errorf("expected successful update but got %s", err)
+	}
+	if e.getEpochDuration() != 25*time.Millisecond {
+		t.Errorf("expected an epoch duration of 25ms but got %s", e.getEpochDuration())
+	}
+	if r.Parameters().EpochTime != 25 {
+		t.Errorf("expected epoch time 25 but got %f", r.Parameters().EpochTime)
+	}
+}
+
+func TestParametersKeepsNonRuntimeParameters(t *testing.T) {
+	r := newRouterServer(0, createTestEpochManager(1))
+	r.parameters = config.Parameters{VirtualNodes: 10}
+	if r.Parameters().VirtualNodes != 10 {
+		t.Errorf("expected the parameters of the router but got %v", r.Parameters())
+	}
+}
@@ -0,0 +1,189 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/router"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// It waits for the responses of the requests in order.
+// If one of them fails, the ones that are still pending are removed from the epoch.
+func (r *routerServer) waitForResponses(ctx context.Context, requests []*request, responseChans []chan any) ([]any, error) {
+	var responses []any
+	for i, responseChannel := range responseChans {
+		response, err := r.waitForResponse(ctx, requests[i].requestId, responseChannel)
+		if err != nil {
+			for _, pending := range requests[i+1:] {
+				r.epochManager.removeRequestFromCurrentEpoch(pending.requestId)
+			}
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// BatchRead reads all the blocks in the same epoch.
+// It fails if any of the reads fails.
+func (r *routerServer) BatchRead(ctx context.Context, batchReadRequest *pb.BatchReadRequest) (*pb.BatchReadReply, error) {
+	log.Debug().Msgf("Received batch read request for %d blocks", len(batchReadRequest.Blocks))
+	tracer := otel.Tracer("")
+	ctx, span := tracer.Start(ctx, "router batch read request")
+	var requests []*request
+	for _, block := range batchReadRequest.Blocks {
+		requests = append(requests, &request{ctx: ctx, requestId: uuid.New().String(), operationType: Read, block: block})
+	}
+	responseChans := r.epochManager.addRequestsToCurrentEpoch(requests)
+	responses, err := r.waitForResponses(ctx, requests, responseChans)
+	if err != nil {
+		return nil, err
+	}
+	reply := &pb.BatchReadReply{}
+	for _, response := range responses {
+		readReply, err := readReplyFromResponse(response.(readResponse))
+		if err != nil {
+			return nil, err
+		}
+		reply.Replies = append(reply.Replies, readReply)
+	}
+	log.Debug().Msgf("Returning batch read response for %d blocks", len(batchReadRequest.Blocks))
+	span.End()
+	return reply, nil
+}
+
+// BatchWrite writes all the blocks in the same epoch.
+// It fails if any of the writes fails, but the other writes may still be applied.
+func (r *routerServer) BatchWrite(ctx context.Context, batchWriteRequest *pb.BatchWriteRequest) (*pb.BatchWriteReply, error) {
+	log.Debug().Msgf("Received batch write request for %d blocks", len(batchWriteRequest.Writes))
+	tracer := otel.Tracer("")
+	ctx, span := tracer.Start(ctx, "router batch write request")
+	var requests []*request
+	for _, write := range batchWriteRequest.Writes {
+		if err := checkWritableBlock(write.Block); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid request; %s", err)
+		}
+		requests = append(requests, &request{ctx: ctx, requestId: uuid.New().String(), operationType: Write, block: write.Block, value: write.Value, idempotencyKey: write.IdempotencyKey})
+	}
+	responseChans := r.epochManager.addRequestsToCurrentEpoch(requests)
+	responses, err := r.waitForResponses(ctx, requests, responseChans)
+	if err != nil {
+		return nil, err
+	}
+	reply := &pb.BatchWriteReply{}
+	for _, response := range responses {
+		writeReply, err := writeReplyFromResponse(response.(writeResponse))
+		if err != nil {
+			return nil, err
+		}
+		reply.Replies = append(reply.Replies, writeReply)
+	}
+	log.Debug().Msgf("Returning batch write response for %d blocks", len(batchWriteRequest.Writes))
+	span.End()
+	return reply, nil
+}
+
+// It runs one request of a stream and returns its reply.
+func (r *routerServer) runStreamRequest(ctx context.Context, streamRequest *pb.StreamRequest) *pb.StreamReply {
+	reply := &pb.StreamReply{RequestId: streamRequest.RequestId}
+	var req *request
+	switch operation := streamRequest.Operation.(type) {
+	case *pb.StreamRequest_Read:
+		req = &request{ctx: ctx, requestId: uuid.New().String(), operationType: Read, block: operation.Read.Block}
+	case *pb.StreamRequest_Write:
+		req = &request{ctx: ctx, requestId: uuid.New().String(), operationType: Write, block: operation.Write.Block, value: operation.Write.Value, idempotencyKey: operation.Write.IdempotencyKey}
+	case *pb.StreamRequest_Delete:
+		req = &request{ctx: ctx, requestId: uuid.New().String(), operationType: Delete, block: operation.Delete.Block, idempotencyKey: operation.Delete.IdempotencyKey}
+	case *pb.StreamRequest_Exists:
+		req = &request{ctx: ctx, requestId: uuid.New().String(), operationType: Read, block: operation.Exists.Block}
+	case *pb.StreamRequest_CompareAndSwap:
+		cas := operation.CompareAndSwap
+		req = &request{ctx: ctx, requestId: uuid.New().String(), operationType: CompareAndSwap, block: cas.Block, value: cas.NewValue, expected: cas.Expected, expectedVersion: cas.ExpectedVersion, idempotencyKey: cas.IdempotencyKey}
+	default:
+		reply.Error = "the request has no operation"
+		return reply
+	}
+	if req.operationType != Read {
+		if err := checkWritableBlock(req.block); err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+	}
+	responseChannel := r.epochManager.addRequestToCurrentEpoch(req)
+	response, err := r.waitForResponse(ctx, req.requestId, responseChannel)
+	if err != nil {
+		reply.Error = err.Error()
+		return reply
+	}
+	if req.operationType == Read {
+		readReply, err := readReplyFromResponse(response.(readResponse))
+		if err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+		if _, isExists := streamRequest.Operation.(*pb.StreamRequest_Exists); isExists {
+			reply.Operation = &pb.StreamReply_Exists{Exists: &pb.ExistsReply{Exists: readReply.Exists}}
+		} else {
+			reply.Operation = &pb.StreamReply_Read{Read: readReply}
+		}
+		return reply
+	}
+	if req.operationType == CompareAndSwap {
+		casReply, err := compareAndSwapReplyFromResponse(response.(writeResponse))
+		if err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+		reply.Operation = &pb.StreamReply_CompareAndSwap{CompareAndSwap: casReply}
+		return reply
+	}
+	writeReply, err := writeReplyFromResponse(response.(writeResponse))
+	if err != nil {
+		reply.Error = err.Error()
+		return reply
+	}
+	if req.operationType == Delete {
+		reply.Operation = &pb.StreamReply_Delete{Delete: &pb.DeleteReply{Success: writeReply.Success}}
+	} else {
+		reply.Operation = &pb.StreamReply_Write{Write: writeReply}
+	}
+	return reply
+}
+
+// Stream runs the requests it receives and sends their replies as soon as they are answered.
+// The requests are added to the epoch when they are received, so many requests can be in flight on one stream.
+// After the client closes its side of the stream, the pending requests are answered before the stream ends.
+func (r *routerServer) Stream(stream pb.Router_StreamServer) error {
+	ctx := stream.Context()
+	var sendMu sync.Mutex // grpc does not allow concurrent sends on a stream
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		streamRequest, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not receive a request from the stream; %s", err)
+		}
+		log.Debug().Msgf("Received stream request %s", streamRequest.RequestId)
+		wg.Add(1)
+		go func(streamRequest *pb.StreamRequest) {
+			defer wg.Done()
+			reply := r.runStreamRequest(ctx, streamRequest)
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			err := stream.Send(reply)
+			if err != nil {
+				log.Error().Msgf("Could not send the reply of stream request %s; %s", streamRequest.RequestId, err)
+			}
+		}(streamRequest)
+	}
+}
@@ -0,0 +1,128 @@
+package router
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/router"
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func startTestRouterServer(failedWrites map[string]bool) routerServer {
+	client := &transactionShardNodeClient{failedWrites: failedWrites, decisions: make(chan *shardnodepb.TransactionDecisions, 100)}
+	e := newEpochManager(map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: client}}}, 10*time.Millisecond, 0, time.Second, 100)
+	go e.run()
+	return newRouterServer(0, e)
+}
+
+func TestAddRequestsToCurrentEpochAddsAllRequestsToTheSameEpoch(t *testing.T) {
+	e := createTestEpochManager(1)
+	e.currentEpoch = 3
+	responseChans := e.addRequestsToCurrentEpoch([]*request{
+		{ctx: context.Background(), requestId: "a", operationType: Read, block: "a"},
+		{ctx: context.Background(), requestId: "b", operationType: Write, block: "b", value: "value"},
+	})
+	if len(responseChans) != 2 || len(e.requests[3]) != 2 || len(e.reponseChans[3]) != 2 {
+		t.Errorf("expected both requests to be added to epoch 3")
+	}
+	if e.reponseChans[3]["a"] != responseChans[0] || e.reponseChans[3]["b"] != responseChans[1] {
+		t.Errorf("expected the response channels to be in the order of the requests")
+	}
+}
+
+func TestBatchReadReturnsRepliesInOrder(t *testing.T) {
+	r := startTestRouterServer(nil)
+	reply, err := r.BatchRead(context.Background(), &pb.BatchReadRequest{Blocks: []string{"c", "a", "b"}})
+	if err != nil {
+		t.Errorf("expected BatchRead to succeed but got %s", err)
+		return
+	}
+	if len(reply.Replies) != 3 || reply.Replies[0].Value != "c" || reply.Replies[1].Value != "a" || reply.Replies[2].Value != "b" {
+		t.Errorf("expected the values of c, a and b but got %v", reply.Replies)
+	}
+}
+
+func TestBatchWriteReturnsRepliesInOrder(t *testing.T) {
+	r := startTestRouterServer(map[string]bool{"b": true})
+	reply, err := r.BatchWrite(context.Background(), &pb.BatchWriteRequest{Writes: []*pb.WriteRequest{{Block: "a", Value: "1"}, {Block: "b", Value: "2"}}})
+	if err != nil {
+		t.Errorf("expected BatchWrite to succeed but got %s", err)
+		return
+	}
+	if len(reply.Replies) != 2 || !reply.Replies[0].Success || reply.Replies[1].Success {
+		t.Errorf("expected the write to a to succeed and the write to b to fail but got %v", reply.Replies)
+	}
+}
+
+func TestBatchReadRemovesRequestsFromEpochWhenContextIsCancelled(t *testing.T) {
+	e := createTestEpochManager(1)
+	r := newRouterServer(0, e)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := r.BatchRead(ctx, &pb.BatchReadRequest{Blocks: []string{"a", "b"}})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected a deadline exceeded error but got %v", err)
+	}
+	if len(e.requests[0]) != 0 || len(e.reponseChans[0]) != 0 {
+		t.Errorf("expected the requests to be removed from the epoch")
+	}
+}
+
+type fakeRouterStreamServer struct {
+	grpc.ServerStream
+	requests chan *pb.StreamRequest
+	replies  chan *pb.StreamReply
+}
+
+func (f *fakeRouterStreamServer) Context() context.Context {
+	return context.Background()
+}
+
+func (f *fakeRouterStreamServer) Recv() (*pb.StreamRequest, error) {
+	request, ok := <-f.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return request, nil
+}
+
+func (f *fakeRouterStreamServer) Send(reply *pb.StreamReply) error {
+	f.replies <- reply
+	return nil
+}
+
+func TestStreamAnswersAllRequestsBeforeEnding(t *testing.T) {
+	r := startTestRouterServer(nil)
+	stream := &fakeRouterStreamServer{requests: make(chan *pb.StreamRequest, 3), replies: make(chan *pb.StreamReply, 3)}
+	stream.requests <- &pb.StreamRequest{RequestId: "1", Operation: &pb.StreamRequest_Read{Read: &pb.ReadRequest{Block: "a"}}}
+	stream.requests <- &pb.StreamRequest{RequestId: "2", Operation: &pb.StreamRequest_Write{Write: &pb.WriteRequest{Block: "b", Value: "value"}}}
+	stream.requests <- &pb.StreamRequest{RequestId: "3"}
+	close(stream.requests)
+	err := r.Stream(stream)
+	if err != nil {
+		t.Errorf("expected the stream to end without an error but got %s", err)
+	}
+	close(stream.replies)
+	replies := make(map[string]*pb.StreamReply)
+	for reply := range stream.replies {
+		replies[reply.RequestId] = reply
+	}
+	if len(replies) != 3 {
+		t.Errorf("expected a reply for every request but got %v", replies)
+		return
+	}
+	if replies["1"].GetRead().GetValue() != "a" {
+		t.Errorf("expected the read of a to return a but got %v", replies["1"])
+	}
+	if !replies["2"].GetWrite().GetSuccess() {
+		t.Errorf("expected the write of b to succeed but got %v", replies["2"])
+	}
+	if replies["3"].Error == "" {
+		t.Errorf("expected an error for the request without an operation")
+	}
+}
@@ -15,21 +15,24 @@ import (
 type ShardNodeRPCClient struct {
 	ClientAPI shardnodepb.ShardNodeClient
 	Conn      *grpc.ClientConn
+	Endpoint  config.ShardNodeEndpoint // the shard nodes dial it to ask the coordinator of a transaction for its decision
 }
 
 type ReplicaRPCClientMap map[int]ShardNodeRPCClient
 
-func StartShardNodeRPCClients(endpoints []config.ShardNodeEndpoint) (map[int]ReplicaRPCClientMap, error) {
+// The dial options are added to the default ones, for example to dial the replicas over in-memory connections.
+func StartShardNodeRPCClients(endpoints []config.ShardNodeEndpoint, dialOptions ...grpc.DialOption) (map[int]ReplicaRPCClientMap, error) {
 	log.Debug().Msgf("Starting ShardNode RPC clients for endpoints: %v", endpoints)
 	clients := make(map[int]ReplicaRPCClientMap)
 	for _, endpoint := range endpoints {
 		serverAddr := fmt.Sprintf("%s:%d", endpoint.IP, endpoint.Port)
 		log.Debug().Msgf("Starting ShardNode RPC client for endpoint: %s", serverAddr)
-		conn, err := grpc.Dial(serverAddr,
+		options := append([]grpc.DialOption{
 			grpc.WithTransportCredentials(insecure.NewCredentials()),
 			grpc.WithUnaryInterceptor(rpc.ContextPropagationUnaryClientInterceptor()),
 			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt64), grpc.MaxCallSendMsgSize(math.MaxInt64)),
-		)
+		}, dialOptions...)
+		conn, err := grpc.Dial(serverAddr, options...)
 		if err != nil {
 			return nil, err
 		}
@@ -37,7 +40,7 @@ func StartShardNodeRPCClients(endpoints []config.ShardNodeEndpoint) (map[int]Rep
 		if len(clients[endpoint.ID]) == 0 {
 			clients[endpoint.ID] = make(ReplicaRPCClientMap)
 		}
-		clients[endpoint.ID][endpoint.ReplicaID] = ShardNodeRPCClient{ClientAPI: clientAPI, Conn: conn}
+		clients[endpoint.ID][endpoint.ReplicaID] = ShardNodeRPCClient{ClientAPI: clientAPI, Conn: conn, Endpoint: endpoint}
 	}
 	return clients, nil
 }
@@ -2,41 +2,73 @@ package router
 
 import (
 	"context"
-	"math"
+	"errors"
 	"sync"
 	"time"
 
 	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
 	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
 	utils "github.com/dsg-uwaterloo/treebeard/pkg/utils"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 )
 
 type epochManager struct {
 	shardNodeRPCClients map[int]ReplicaRPCClientMap
+	clientsMu           sync.RWMutex
+	virtualNodes        int
+	scaleOutMu          sync.Mutex                  // only one shard node is added at a time
 	requests            map[int][]*request          // map of epoch round to requests
 	reponseChans        map[int]map[string]chan any // map of epoch round to map of request id to response channel
 	currentEpoch        int
-	epochDuration       time.Duration
-	hasher              utils.Hasher
+	epochDuration       time.Duration // guarded by mu, since it can change at runtime
+	batchSize           int           // the number of requests sent to each shard node in every epoch, zero if the epochs are not padded
+	epochTimeout        time.Duration
+	ring                *utils.HashRing
 	mu                  sync.Mutex
+	stop                chan struct{} // it is closed when the router stops to end the epochs
 }
 
-func newEpochManager(shardNodeRPCClients map[int]ReplicaRPCClientMap, epochDuration time.Duration) *epochManager {
+const (
+	defaultEpochTimeout = 10 * time.Second
+	defaultVirtualNodes = 100
+	hashCacheSize       = 1 << 16
+)
+
+var errEpochTimedOut = errors.New("the epoch timed out before the shard nodes answered")
+
+// All the routers should use the same number of virtual nodes, so that they forward each block to the same shard node.
+func newEpochManager(shardNodeRPCClients map[int]ReplicaRPCClientMap, epochDuration time.Duration, batchSize int, epochTimeout time.Duration, virtualNodes int) *epochManager {
+	if epochTimeout <= 0 {
+		epochTimeout = defaultEpochTimeout
+	}
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	ring := utils.NewHashRing(virtualNodes, utils.NewHasher(hashCacheSize))
+	for shardNodeID := range shardNodeRPCClients {
+		ring.AddNode(shardNodeID)
+	}
 	return &epochManager{
 		shardNodeRPCClients: shardNodeRPCClients,
 		requests:            make(map[int][]*request),
 		reponseChans:        make(map[int]map[string]chan any),
 		currentEpoch:        0,
 		epochDuration:       epochDuration,
-		hasher:              utils.Hasher{KnownHashes: make(map[string]uint32)},
+		batchSize:           batchSize,
+		epochTimeout:        epochTimeout,
+		virtualNodes:        virtualNodes,
+		ring:                ring,
+		stop:                make(chan struct{}),
 	}
 }
 
 const (
 	Read int = iota
 	Write
+	Delete         // it is sent to the shard nodes as a write
+	CompareAndSwap // it is sent to the shard nodes as a write with the expected value
 )
 
 type request struct {
@@ -45,44 +77,108 @@ type request struct {
 	operationType int
 	block         string
 	value         string
+	redirects     int    // the number of times a shard node redirected the request
+	transactionID string // set for the requests of a transaction, which share the response channel of the transaction
+	// The expected value and version of a compare and swap request
+	expected        string
+	expectedVersion uint64
+	idempotencyKey  string // the key of the client for the write, empty if the client did not send one
+}
+
+// It returns the key of the response channel of the request.
+func (r *request) responseKey() string {
+	if r.transactionID != "" {
+		return r.transactionID
+	}
+	return r.requestId
 }
 
 func (e *epochManager) addRequestToCurrentEpoch(r *request) chan any {
-	log.Debug().Msgf("Aquiring lock for epoch manager in addRequestToCurrentEpoch")
+	return e.addRequestsToCurrentEpoch([]*request{r})[0]
+}
+
+// It adds the requests to the current epoch together, so that they are all sent in the same epoch.
+// It returns the response channels in the order of the requests.
+func (e *epochManager) addRequestsToCurrentEpoch(requests []*request) []chan any {
+	log.Debug().Msgf("Aquiring lock for epoch manager in addRequestsToCurrentEpoch")
 	e.mu.Lock()
-	log.Debug().Msgf("Aquired lock for epoch manager in addRequestToCurrentEpoch")
-	log.Debug().Msgf("Adding request %v to epoch %d", r, e.currentEpoch)
+	log.Debug().Msgf("Aquired lock for epoch manager in addRequestsToCurrentEpoch")
 	defer func() {
-		log.Debug().Msgf("Releasing lock for epoch manager in addRequestToCurrentEpoch")
+		log.Debug().Msgf("Releasing lock for epoch manager in addRequestsToCurrentEpoch")
 		e.mu.Unlock()
-		log.Debug().Msgf("Released lock for epoch manager in addRequestToCurrentEpoch")
+		log.Debug().Msgf("Released lock for epoch manager in addRequestsToCurrentEpoch")
 	}()
-	e.requests[e.currentEpoch] = append(e.requests[e.currentEpoch], r)
 	if _, exists := e.reponseChans[e.currentEpoch]; !exists {
 		e.reponseChans[e.currentEpoch] = make(map[string]chan any)
 	}
-	e.reponseChans[e.currentEpoch][r.requestId] = make(chan any)
-	return e.reponseChans[e.currentEpoch][r.requestId]
+	var responseChans []chan any
+	for _, r := range requests {
+		log.Debug().Msgf("Adding request %v to epoch %d", r, e.currentEpoch)
+		e.requests[e.currentEpoch] = append(e.requests[e.currentEpoch], r)
+		// The channel is buffered, so answering a request never blocks even if its caller has stopped waiting.
+		e.reponseChans[e.currentEpoch][r.requestId] = make(chan any, 1)
+		responseChans = append(responseChans, e.reponseChans[e.currentEpoch][r.requestId])
+	}
+	return responseChans
+}
+
+// It removes a request or all the requests of a transaction that have not been sent yet from the current epoch.
+// It returns false if the request has already been sent to the shard nodes.
+func (e *epochManager) removeRequestFromCurrentEpoch(requestID string) bool {
+	log.Debug().Msgf("Aquiring lock for epoch manager in removeRequestFromCurrentEpoch")
+	e.mu.Lock()
+	log.Debug().Msgf("Aquired lock for epoch manager in removeRequestFromCurrentEpoch")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for epoch manager in removeRequestFromCurrentEpoch")
+		e.mu.Unlock()
+		log.Debug().Msgf("Released lock for epoch manager in removeRequestFromCurrentEpoch")
+	}()
+	if _, exists := e.reponseChans[e.currentEpoch][requestID]; !exists {
+		return false
+	}
+	delete(e.reponseChans[e.currentEpoch], requestID)
+	var kept []*request
+	for _, r := range e.requests[e.currentEpoch] {
+		if r.responseKey() != requestID {
+			kept = append(kept, r)
+		}
+	}
+	e.requests[e.currentEpoch] = kept
+	log.Debug().Msgf("Removed request %s from epoch %d", requestID, e.currentEpoch)
+	return true
+}
+
+// It deletes the requests and the response channels of an epoch that has been answered.
+func (e *epochManager) deleteEpoch(epochNumber int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.requests, epochNumber)
+	delete(e.reponseChans, epochNumber)
 }
 
 func (e *epochManager) whereToForward(block string) (shardNodeID int) {
-	h := e.hasher.Hash(block)
-	return int(math.Mod(float64(h), float64(len(e.shardNodeRPCClients))))
+	return e.ring.GetNode(block)
 }
 
 type readResponse struct {
-	value string
-	err   error
+	value   string
+	exists  bool
+	version uint64
+	err     error
 }
 
+// The value and version are the state of the block after the write.
 type writeResponse struct {
 	success bool
+	value   string
+	version uint64
 	err     error
 }
 
 type batchResponse struct {
 	readResponses  []*shardnodepb.ReadReply
 	writeResponses []*shardnodepb.WriteReply
+	ring           *shardnodepb.Ring // the ring of the migration of the shard node if it redirected a request
 	err            error
 }
 
@@ -112,10 +208,11 @@ func (e *epochManager) sendBatch(ctx context.Context, shardnodeClient ReplicaRPC
 		return
 	}
 	log.Debug().Msgf("Received batch of requests from shardnode; reply: %v", reply)
-	batchResponseChan <- batchResponse{readResponses: reply.(*shardnodepb.ReplyBatch).ReadReplies, writeResponses: reply.(*shardnodepb.ReplyBatch).WriteReplies, err: nil}
+	batchResponseChan <- batchResponse{readResponses: reply.(*shardnodepb.ReplyBatch).ReadReplies, writeResponses: reply.(*shardnodepb.ReplyBatch).WriteReplies, ring: reply.(*shardnodepb.ReplyBatch).Ring, err: nil}
 }
 
-func (e *epochManager) getShardnodeBatches(requests []*request) map[int]*shardnodepb.RequestBatch {
+// The writes of a transaction carry the replicas of its coordinator.
+func (e *epochManager) getShardnodeBatches(requests []*request, transactions map[string]*transactionState) map[int]*shardnodepb.RequestBatch {
 	requestBatches := make(map[int]*shardnodepb.RequestBatch)
 	for _, r := range requests {
 		shardNodeID := e.whereToForward(r.block)
@@ -125,67 +222,219 @@ func (e *epochManager) getShardnodeBatches(requests []*request) map[int]*shardno
 		if r.operationType == Read {
 			requestBatches[shardNodeID].ReadRequests = append(requestBatches[shardNodeID].ReadRequests, &shardnodepb.ReadRequest{RequestId: r.requestId, Block: r.block})
 		} else {
-			requestBatches[shardNodeID].WriteRequests = append(requestBatches[shardNodeID].WriteRequests, &shardnodepb.WriteRequest{RequestId: r.requestId, Block: r.block, Value: r.value})
+			writeRequest := &shardnodepb.WriteRequest{RequestId: r.requestId, Block: r.block, Value: r.value, TransactionId: r.transactionID, Delete: r.operationType == Delete, Compare: r.operationType == CompareAndSwap, Expected: r.expected, ExpectedVersion: r.expectedVersion, IdempotencyKey: r.idempotencyKey}
+			if transaction := getTransaction(transactions, r); transaction != nil {
+				writeRequest.CoordinatorReplicas = transaction.coordinatorReplicas
+			}
+			requestBatches[shardNodeID].WriteRequests = append(requestBatches[shardNodeID].WriteRequests, writeRequest)
 		}
 	}
 	return requestBatches
 }
 
+const paddingRequestIDPrefix = "padding-"
+
+// It adds fake read requests to the batches, so that every shard node gets exactly batchSize requests.
+// The batches should not have more than batchSize requests.
+func (e *epochManager) padShardnodeBatches(requestBatches map[int]*shardnodepb.RequestBatch) {
+	for _, shardNodeID := range e.ring.Nodes() {
+		if _, exists := requestBatches[shardNodeID]; !exists {
+			requestBatches[shardNodeID] = &shardnodepb.RequestBatch{}
+		}
+		batch := requestBatches[shardNodeID]
+		for len(batch.ReadRequests)+len(batch.WriteRequests) < e.batchSize {
+			batch.ReadRequests = append(batch.ReadRequests, &shardnodepb.ReadRequest{RequestId: paddingRequestIDPrefix + uuid.New().String(), Block: utils.PaddingBlock})
+		}
+	}
+}
+
+// It keeps at most batchSize requests of each shard node in the epoch and moves the rest to the current epoch.
+// The requests of a transaction are kept or moved together.
+// The caller should hold the lock and call it right after the current epoch is incremented.
+func (e *epochManager) deferOverflowRequests(epochNumber int) {
+	shardNodeRequestCount := make(map[int]int)
+	// map of response key to the requests that are kept or moved together
+	requestGroups := make(map[string][]*request)
+	var groupOrder []string
+	for _, r := range e.requests[epochNumber] {
+		if _, exists := requestGroups[r.responseKey()]; !exists {
+			groupOrder = append(groupOrder, r.responseKey())
+		}
+		requestGroups[r.responseKey()] = append(requestGroups[r.responseKey()], r)
+	}
+	var kept []*request
+	for _, responseKey := range groupOrder {
+		group := requestGroups[responseKey]
+		groupRequestCount := make(map[int]int)
+		fits := true
+		for _, r := range group {
+			shardNodeID := e.whereToForward(r.block)
+			groupRequestCount[shardNodeID]++
+			if shardNodeRequestCount[shardNodeID]+groupRequestCount[shardNodeID] > e.batchSize {
+				fits = false
+			}
+		}
+		// A transaction that can never fit is sent in an epoch of its own, since deferring it would not help.
+		if fits || len(kept) == 0 {
+			for shardNodeID, count := range groupRequestCount {
+				shardNodeRequestCount[shardNodeID] += count
+			}
+			kept = append(kept, group...)
+			continue
+		}
+		log.Debug().Msgf("Deferring request %s from epoch %d to epoch %d", responseKey, epochNumber, e.currentEpoch)
+		// The new epoch has no requests yet, so the deferred requests stay ahead of its requests.
+		e.requests[e.currentEpoch] = append(e.requests[e.currentEpoch], group...)
+		if _, exists := e.reponseChans[e.currentEpoch]; !exists {
+			e.reponseChans[e.currentEpoch] = make(map[string]chan any)
+		}
+		e.reponseChans[e.currentEpoch][responseKey] = e.reponseChans[epochNumber][responseKey]
+		delete(e.reponseChans[epochNumber], responseKey)
+	}
+	e.requests[epochNumber] = kept
+}
+
+// It sends the response to the request if the request is waiting for one and has not been answered yet.
+// The fake requests have no response channel.
+func answerRequest(responseChans map[string]chan any, answered map[string]bool, requestID string, response any) {
+	responseChan, exists := responseChans[requestID]
+	if !exists || answered[requestID] {
+		return
+	}
+	answered[requestID] = true
+	responseChan <- response
+}
+
 // This function waits for all the responses then answers all of the requests.
-// It can time out since a request may have failed.
+// If the shard nodes do not answer before the epoch timeout, the unanswered requests get errEpochTimedOut.
+// The transactions of the epoch are committed or aborted after all the batches are answered.
 func (e *epochManager) sendEpochRequestsAndAnswerThem(epochNumber int, requests []*request, responseChans map[string]chan any) {
+	defer e.deleteEpoch(epochNumber)
 	requestsCount := len(requests)
-	if requestsCount == 0 {
+	if requestsCount == 0 && e.batchSize == 0 {
 		return
 	}
 	log.Debug().Msgf("Sending epoch requests and answering them for epoch %d with %d requests", epochNumber, requestsCount)
-	batchRequests := e.getShardnodeBatches(requests)
-	batchResponseChan := make(chan batchResponse)
+	transactions := e.newTransactionStates(requests)
+	batchRequests := e.getShardnodeBatches(requests, transactions)
+	if e.batchSize != 0 {
+		e.padShardnodeBatches(batchRequests)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.epochTimeout)
+	defer cancel()
+	// The channel is buffered, so the batches that finish after the timeout do not block.
+	batchResponseChan := make(chan batchResponse, len(batchRequests))
 	waitingCount := 0
 	for shardNodeID, shardNodeRequests := range batchRequests {
 		if len(shardNodeRequests.ReadRequests) == 0 && len(shardNodeRequests.WriteRequests) == 0 {
 			continue
 		}
 		waitingCount++
-		go e.sendBatch(context.Background(), e.shardNodeRPCClients[shardNodeID], shardNodeRequests, batchResponseChan)
+		go e.sendBatch(ctx, e.getShardNodeRPCClients(shardNodeID), shardNodeRequests, batchResponseChan)
+	}
+	answered := make(map[string]bool)
+	requestsByID := make(map[string]*request)
+	for _, r := range requests {
+		requestsByID[r.requestId] = r
 	}
-	timeout := time.After(10 * time.Second)
 	for i := 0; i < waitingCount; i++ {
 		select {
-		case <-timeout:
-			log.Error().Msgf("Timed out while waiting for batch response")
+		case <-ctx.Done():
+			log.Error().Msgf("Timed out while waiting for batch response of epoch %d", epochNumber)
+			e.decideTransactions(transactions, responseChans, answered, errEpochTimedOut)
+			for _, r := range requests {
+				if r.operationType == Read {
+					answerRequest(responseChans, answered, r.requestId, readResponse{err: errEpochTimedOut})
+				} else {
+					answerRequest(responseChans, answered, r.requestId, writeResponse{err: errEpochTimedOut})
+				}
+			}
 			return
 		case reply := <-batchResponseChan:
 			if reply.err != nil {
 				log.Error().Msgf("Error while sending batch of requests; %s", reply.err)
 				for _, r := range reply.readResponses {
-					responseChans[r.RequestId] <- readResponse{err: reply.err}
+					if transaction := getTransaction(transactions, requestsByID[r.RequestId]); transaction != nil {
+						transaction.fail()
+						continue
+					}
+					answerRequest(responseChans, answered, r.RequestId, readResponse{err: reply.err})
 				}
 				for _, r := range reply.writeResponses {
-					responseChans[r.RequestId] <- writeResponse{err: reply.err}
+					if transaction := getTransaction(transactions, requestsByID[r.RequestId]); transaction != nil {
+						transaction.fail()
+						continue
+					}
+					answerRequest(responseChans, answered, r.RequestId, writeResponse{err: reply.err})
 				}
 				continue
 			}
 			log.Debug().Msgf("Received batch reply %v", reply)
 			log.Debug().Msgf("Answering epoch requests for epoch %d", epochNumber)
+			countRedirects := !e.followRing(reply.ring)
 			for _, r := range reply.readResponses {
-				responseChans[r.RequestId] <- readResponse{value: r.Value}
+				// The requests of a transaction can not move to another epoch, so a redirected request aborts the transaction.
+				if transaction := getTransaction(transactions, requestsByID[r.RequestId]); transaction != nil {
+					if r.Redirected {
+						transaction.fail()
+					} else {
+						transaction.recordRead(requestsByID[r.RequestId].block, r.Value)
+					}
+					continue
+				}
+				if r.Redirected {
+					e.redirectRequest(requestsByID[r.RequestId], responseChans, answered, countRedirects)
+					continue
+				}
+				answerRequest(responseChans, answered, r.RequestId, readResponse{value: r.Value, exists: r.Exists, version: r.Version})
 			}
 			for _, r := range reply.writeResponses {
-				responseChans[r.RequestId] <- writeResponse{success: r.Success}
+				if transaction := getTransaction(transactions, requestsByID[r.RequestId]); transaction != nil {
+					if r.Redirected {
+						transaction.fail()
+					} else {
+						transaction.recordWrite(r.Success)
+					}
+					continue
+				}
+				if r.Redirected {
+					e.redirectRequest(requestsByID[r.RequestId], responseChans, answered, countRedirects)
+					continue
+				}
+				answerRequest(responseChans, answered, r.RequestId, writeResponse{success: r.Success, value: r.Value, version: r.Version})
 			}
 		}
 	}
+	e.decideTransactions(transactions, responseChans, answered, nil)
 }
 
-// This function runs the epochManger forever.
+func (e *epochManager) getEpochDuration() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.epochDuration
+}
+
+func (e *epochManager) setEpochDuration(epochDuration time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.epochDuration = epochDuration
+}
+
+// This function runs the epochManger until the router stops.
 func (e *epochManager) run() {
 	for {
-		epochTimeOut := time.After(e.epochDuration)
-		<-epochTimeOut
+		epochTimeOut := time.After(e.getEpochDuration())
+		select {
+		case <-epochTimeOut:
+		case <-e.stop:
+			return
+		}
 		e.mu.Lock()
 		e.currentEpoch++
 		epochNumber := e.currentEpoch - 1
+		if e.batchSize != 0 {
+			e.deferOverflowRequests(epochNumber)
+		}
 		go e.sendEpochRequestsAndAnswerThem(epochNumber, e.requests[epochNumber], e.reponseChans[epochNumber])
 		e.mu.Unlock()
 	}
@@ -3,15 +3,18 @@ package router
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
 	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	utils "github.com/dsg-uwaterloo/treebeard/pkg/utils"
 	"google.golang.org/grpc"
 )
 
 func TestAddRequestToCurrentEpochAddsRequestAndChannel(t *testing.T) {
-	e := newEpochManager(make(map[int]ReplicaRPCClientMap), time.Second)
+	e := newEpochManager(make(map[int]ReplicaRPCClientMap), time.Second, 0, time.Second, 100)
 	e.currentEpoch = 12
 	req := &request{ctx: context.Background(), requestId: "test_request_id", operationType: Read, block: "a", value: "value"}
 	e.addRequestToCurrentEpoch(req)
@@ -24,44 +27,48 @@ func TestAddRequestToCurrentEpochAddsRequestAndChannel(t *testing.T) {
 	}
 }
 
-type whereToForwardTest struct {
-	e                     *epochManager
-	block                 string
-	expectedShardNodeDest int
-}
-
 func createTestEpochManager(shardNodeRPCClientsCount int) (e *epochManager) {
-	e = newEpochManager(make(map[int]ReplicaRPCClientMap), time.Second)
+	shardNodeRPCClients := make(map[int]ReplicaRPCClientMap)
 	for i := 0; i < shardNodeRPCClientsCount; i++ {
-		e.shardNodeRPCClients[i] = make(ReplicaRPCClientMap)
+		shardNodeRPCClients[i] = make(ReplicaRPCClientMap)
 	}
-	return e
+	return newEpochManager(shardNodeRPCClients, time.Second, 0, time.Second, 100)
 }
 
-var testCases = []whereToForwardTest{
-	{createTestEpochManager(1), "a", 0},
-	{createTestEpochManager(1), "b", 0},
-	{createTestEpochManager(2), "a", 0},
-	{createTestEpochManager(2), "b", 1},
-	{createTestEpochManager(2), "c", 0},
-	{createTestEpochManager(2), "d", 1},
-	{createTestEpochManager(2), "e", 0},
-	{createTestEpochManager(3), "a", 1},
-	{createTestEpochManager(3), "b", 1},
-	{createTestEpochManager(3), "c", 2},
-	{createTestEpochManager(3), "d", 1},
-	{createTestEpochManager(3), "e", 2},
+func TestWhereToForwardIsTheSameForAllRouters(t *testing.T) {
+	e1 := createTestEpochManager(3)
+	e2 := createTestEpochManager(3)
+	for i := 0; i < 1000; i++ {
+		block := "block" + strconv.Itoa(i)
+		shardNodeID := e1.whereToForward(block)
+		if shardNodeID < 0 || shardNodeID > 2 {
+			t.Errorf("block %s is forwarded to shard node %d that does not exist", block, shardNodeID)
+		}
+		if e2.whereToForward(block) != shardNodeID {
+			t.Errorf("block %s is forwarded to different shard nodes by different routers", block)
+		}
+	}
 }
 
-func TestWhereToForward(t *testing.T) {
-	for _, test := range testCases {
-		e := test.e
-		output := e.whereToForward(test.block)
-		if output != test.expectedShardNodeDest {
-			t.Errorf("Block \"%s\" is getting forwarded to shard node number %d while it should go to shard node number %d",
-				test.block, output, test.expectedShardNodeDest)
+func TestWhereToForwardMovesFewBlocksWhenShardNodeIsAdded(t *testing.T) {
+	e3 := createTestEpochManager(3)
+	e4 := createTestEpochManager(4)
+	moved := 0
+	blockCount := 10000
+	for i := 0; i < blockCount; i++ {
+		block := "block" + strconv.Itoa(i)
+		before, after := e3.whereToForward(block), e4.whereToForward(block)
+		if before != after {
+			if after != 3 {
+				t.Errorf("block %s moved from shard node %d to shard node %d instead of the new shard node", block, before, after)
+			}
+			moved++
 		}
 	}
+	// a quarter of the blocks should move to the new shard node
+	if moved < blockCount/8 || moved > blockCount*3/8 {
+		t.Errorf("expected about %d blocks to move but %d blocks moved", blockCount/4, moved)
+	}
 }
 
 func TestGetShardnodeBatchesAddsEachRequestToCorrectBatch(t *testing.T) {
@@ -73,27 +80,22 @@ func TestGetShardnodeBatchesAddsEachRequestToCorrectBatch(t *testing.T) {
 		{ctx: context.Background(), requestId: "4", operationType: Write, block: "d", value: "value"},
 		{ctx: context.Background(), requestId: "5", operationType: Write, block: "e", value: "value"},
 	}
-	expectedBatchs := map[int]*shardnodepb.RequestBatch{
-		0: {},
-		1: {
-			ReadRequests: []*shardnodepb.ReadRequest{
-				{RequestId: "1", Block: "a"},
-			},
-			WriteRequests: []*shardnodepb.WriteRequest{
-				{RequestId: "2", Block: "b", Value: "value"},
-				{RequestId: "4", Block: "d", Value: "value"},
-			},
-		},
-		2: {
-			ReadRequests: []*shardnodepb.ReadRequest{
-				{RequestId: "3", Block: "c"},
-			},
-			WriteRequests: []*shardnodepb.WriteRequest{
-				{RequestId: "5", Block: "e", Value: "value"},
-			},
-		},
+	expectedBatchs := make(map[int]*shardnodepb.RequestBatch)
+	for _, r := range requests {
+		shardNodeID := e.whereToForward(r.block)
+		if _, exists := expectedBatchs[shardNodeID]; !exists {
+			expectedBatchs[shardNodeID] = &shardnodepb.RequestBatch{}
+		}
+		if r.operationType == Read {
+			expectedBatchs[shardNodeID].ReadRequests = append(expectedBatchs[shardNodeID].ReadRequests, &shardnodepb.ReadRequest{RequestId: r.requestId, Block: r.block})
+		} else {
+			expectedBatchs[shardNodeID].WriteRequests = append(expectedBatchs[shardNodeID].WriteRequests, &shardnodepb.WriteRequest{RequestId: r.requestId, Block: r.block, Value: r.value})
+		}
+	}
+	batches := e.getShardnodeBatches(requests, nil)
+	if len(batches) != len(expectedBatchs) {
+		t.Errorf("Expected %d batches but got %d", len(expectedBatchs), len(batches))
 	}
-	batches := e.getShardnodeBatches(requests)
 	for shardNodeID, batch := range batches {
 		if len(batch.ReadRequests) != len(expectedBatchs[shardNodeID].ReadRequests) || len(batch.WriteRequests) != len(expectedBatchs[shardNodeID].WriteRequests) {
 			t.Errorf("Expected to see %d read requests and %d write requests for shard node %d", len(expectedBatchs[shardNodeID].ReadRequests), len(expectedBatchs[shardNodeID].WriteRequests), shardNodeID)
@@ -111,8 +113,26 @@ func TestGetShardnodeBatchesAddsEachRequestToCorrectBatch(t *testing.T) {
 	}
 }
 
+func TestGetShardnodeBatchesPassesTheIdempotencyKeyToTheShardNode(t *testing.T) {
+	e := createTestEpochManager(1)
+	batches := e.getShardnodeBatches([]*request{{ctx: context.Background(), requestId: "1", operationType: Delete, block: "a", idempotencyKey: "key"}}, nil)
+	for _, batch := range batches {
+		if len(batch.WriteRequests) != 1 || batch.WriteRequests[0].IdempotencyKey != "key" || !batch.WriteRequests[0].Delete {
+			t.Errorf("expected a delete with the idempotency key but got %v", batch.WriteRequests)
+		}
+	}
+}
+
 type mockShardNodeClient struct {
-	batchReply func() (*shardnodepb.ReplyBatch, error)
+	batchReply             func() (*shardnodepb.ReplyBatch, error)
+	migrateBlocksReply     func() (shardnodepb.ShardNode_MigrateBlocksClient, error)
+	receiveMigratedReply   func() (shardnodepb.ShardNode_ReceiveMigratedBlocksClient, error)
+	finishMigrationReply   func() (*shardnodepb.FinishMigrationReply, error)
+	decideMigrationReply   func(*shardnodepb.DecideMigrationRequest) (*shardnodepb.DecideMigrationReply, error)
+	addStorageReply        func() (*shardnodepb.AddStorageReply, error)
+	decideReply            func(*shardnodepb.TransactionDecisions) (*shardnodepb.DecideTransactionsReply, error)
+	decideTransactionReply func(*shardnodepb.DecideTransactionRequest) (*shardnodepb.DecideTransactionReply, error)
+	scanReply              func(*shardnodepb.ScanRequest) (*shardnodepb.ScanReply, error)
 }
 
 func (m *mockShardNodeClient) BatchQuery(ctx context.Context, in *shardnodepb.RequestBatch, opts ...grpc.CallOption) (*shardnodepb.ReplyBatch, error) {
@@ -127,6 +147,43 @@ func (m *mockShardNodeClient) AckSentBlocks(ctx context.Context, in *shardnodepb
 func (m *mockShardNodeClient) JoinRaftVoter(ctx context.Context, in *shardnodepb.JoinRaftVoterRequest, opts ...grpc.CallOption) (*shardnodepb.JoinRaftVoterReply, error) {
 	return nil, nil
 }
+func (m *mockShardNodeClient) MigrateBlocks(ctx context.Context, in *shardnodepb.MigrateBlocksRequest, opts ...grpc.CallOption) (shardnodepb.ShardNode_MigrateBlocksClient, error) {
+	return m.migrateBlocksReply()
+}
+func (m *mockShardNodeClient) ReceiveMigratedBlocks(ctx context.Context, opts ...grpc.CallOption) (shardnodepb.ShardNode_ReceiveMigratedBlocksClient, error) {
+	return m.receiveMigratedReply()
+}
+func (m *mockShardNodeClient) FinishMigration(ctx context.Context, in *shardnodepb.FinishMigrationRequest, opts ...grpc.CallOption) (*shardnodepb.FinishMigrationReply, error) {
+	return m.finishMigrationReply()
+}
+func (m *mockShardNodeClient) DecideMigration(ctx context.Context, in *shardnodepb.DecideMigrationRequest, opts ...grpc.CallOption) (*shardnodepb.DecideMigrationReply, error) {
+	if m.decideMigrationReply == nil {
+		return &shardnodepb.DecideMigrationReply{Committed: in.Commit}, nil
+	}
+	return m.decideMigrationReply(in)
+}
+func (m *mockShardNodeClient) AddStorage(ctx context.Context, in *shardnodepb.AddStorageRequest, opts ...grpc.CallOption) (*shardnodepb.AddStorageReply, error) {
+	return m.addStorageReply()
+}
+func (m *mockShardNodeClient) DecideTransactions(ctx context.Context, in *shardnodepb.TransactionDecisions, opts ...grpc.CallOption) (*shardnodepb.DecideTransactionsReply, error) {
+	if m.decideReply == nil {
+		return &shardnodepb.DecideTransactionsReply{Success: true}, nil
+	}
+	return m.decideReply(in)
+}
+func (m *mockShardNodeClient) DecideTransaction(ctx context.Context, in *shardnodepb.DecideTransactionRequest, opts ...grpc.CallOption) (*shardnodepb.DecideTransactionReply, error) {
+	if m.decideTransactionReply == nil {
+		return &shardnodepb.DecideTransactionReply{Committed: in.Commit}, nil
+	}
+	return m.decideTransactionReply(in)
+}
+
+func (m *mockShardNodeClient) Scan(ctx context.Context, in *shardnodepb.ScanRequest, opts ...grpc.CallOption) (*shardnodepb.ScanReply, error) {
+	if m.scanReply == nil {
+		return &shardnodepb.ScanReply{}, nil
+	}
+	return m.scanReply(in)
+}
 
 func getMockShardNodeClients() map[int]ReplicaRPCClientMap {
 	return map[int]ReplicaRPCClientMap{
@@ -173,7 +230,7 @@ func getMockShardNodeClients() map[int]ReplicaRPCClientMap {
 }
 
 func TestSendEpochRequestsAndAnswerThemReturnsAllResponses(t *testing.T) {
-	e := newEpochManager(getMockShardNodeClients(), time.Second)
+	e := newEpochManager(getMockShardNodeClients(), time.Second, 0, time.Second, 100)
 	e.currentEpoch = 2
 	request1 := &request{ctx: context.Background(), requestId: "a", operationType: Read, block: "a"}
 	request2 := &request{ctx: context.Background(), requestId: "c", operationType: Write, block: "b", value: "123"}
@@ -214,3 +271,327 @@ func TestSendEpochRequestsAndAnswerThemReturnsAllResponses(t *testing.T) {
 		}
 	}
 }
+
+func TestPadShardnodeBatchesSendsBatchSizeRequestsToEveryShardNode(t *testing.T) {
+	e := createTestEpochManager(3)
+	e.batchSize = 4
+	requests := []*request{
+		{ctx: context.Background(), requestId: "1", operationType: Read, block: "a"},
+		{ctx: context.Background(), requestId: "2", operationType: Write, block: "b", value: "value"},
+	}
+	batches := e.getShardnodeBatches(requests, nil)
+	e.padShardnodeBatches(batches)
+	if len(batches) != 3 {
+		t.Errorf("expected a batch for every shard node but got %d batches", len(batches))
+	}
+	for shardNodeID, batch := range batches {
+		if len(batch.ReadRequests)+len(batch.WriteRequests) != 4 {
+			t.Errorf("expected 4 requests for shard node %d but got %v", shardNodeID, batch)
+		}
+		for _, r := range batch.ReadRequests {
+			if r.RequestId != "1" && r.Block != utils.PaddingBlock {
+				t.Errorf("expected the fake requests to read the padding block but got %v", r)
+			}
+		}
+	}
+}
+
+func TestDeferOverflowRequestsMovesExtraRequestsToCurrentEpoch(t *testing.T) {
+	e := createTestEpochManager(2)
+	e.batchSize = 2
+	e.currentEpoch = 1
+	// a, b and e go to shard node 1, c goes to shard node 0
+	for _, block := range []string{"a", "b", "c", "e"} {
+		e.addRequestToCurrentEpoch(&request{ctx: context.Background(), requestId: block, operationType: Read, block: block})
+	}
+	e.currentEpoch++
+	e.deferOverflowRequests(1)
+	if len(e.requests[1]) != 3 || e.requests[1][0].requestId != "a" || e.requests[1][1].requestId != "b" || e.requests[1][2].requestId != "c" {
+		t.Errorf("expected requests a, b and c to stay in epoch 1 but got %v", e.requests[1])
+	}
+	if len(e.requests[2]) != 1 || e.requests[2][0].requestId != "e" {
+		t.Errorf("expected request e to be deferred to epoch 2 but got %v", e.requests[2])
+	}
+	if _, exists := e.reponseChans[2]["e"]; !exists || len(e.reponseChans[1]) != 3 {
+		t.Errorf("expected the response channel of request e to move to epoch 2")
+	}
+}
+
+func TestSendEpochRequestsAndAnswerThemSendsPaddedBatchesWithoutRequests(t *testing.T) {
+	batchSizes := make(chan int, 2)
+	clients := make(map[int]ReplicaRPCClientMap)
+	for shardNodeID := 0; shardNodeID < 2; shardNodeID++ {
+		clients[shardNodeID] = ReplicaRPCClientMap{0: {ClientAPI: &recordingShardNodeClient{batchSizes: batchSizes}}}
+	}
+	e := newEpochManager(clients, time.Second, 3, time.Second, 100)
+	e.sendEpochRequestsAndAnswerThem(1, nil, map[string]chan any{})
+	for i := 0; i < 2; i++ {
+		select {
+		case size := <-batchSizes:
+			if size != 3 {
+				t.Errorf("expected batches of 3 requests but got %d", size)
+			}
+		default:
+			t.Errorf("expected a batch for every shard node")
+		}
+	}
+}
+
+type recordingShardNodeClient struct {
+	mockShardNodeClient
+	batchSizes chan int
+}
+
+func (r *recordingShardNodeClient) BatchQuery(ctx context.Context, in *shardnodepb.RequestBatch, opts ...grpc.CallOption) (*shardnodepb.ReplyBatch, error) {
+	r.batchSizes <- len(in.ReadRequests) + len(in.WriteRequests)
+	reply := &shardnodepb.ReplyBatch{}
+	for _, readRequest := range in.ReadRequests {
+		reply.ReadReplies = append(reply.ReadReplies, &shardnodepb.ReadReply{RequestId: readRequest.RequestId})
+	}
+	return reply, nil
+}
+
+type blockingShardNodeClient struct {
+	mockShardNodeClient
+}
+
+func (b *blockingShardNodeClient) BatchQuery(ctx context.Context, in *shardnodepb.RequestBatch, opts ...grpc.CallOption) (*shardnodepb.ReplyBatch, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestSendEpochRequestsAndAnswerThemAnswersAllRequestsOnTimeout(t *testing.T) {
+	clients := map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: &blockingShardNodeClient{}}}}
+	e := newEpochManager(clients, time.Second, 0, 50*time.Millisecond, 100)
+	readChan := e.addRequestToCurrentEpoch(&request{ctx: context.Background(), requestId: "a", operationType: Read, block: "a"})
+	writeChan := e.addRequestToCurrentEpoch(&request{ctx: context.Background(), requestId: "b", operationType: Write, block: "b", value: "value"})
+	e.sendEpochRequestsAndAnswerThem(0, e.requests[0], e.reponseChans[0])
+	select {
+	case response := <-readChan:
+		if response.(readResponse).err == nil {
+			t.Errorf("expected the read request to get an error")
+		}
+	default:
+		t.Errorf("expected the read request to be answered")
+	}
+	select {
+	case response := <-writeChan:
+		if response.(writeResponse).err == nil {
+			t.Errorf("expected the write request to get an error")
+		}
+	default:
+		t.Errorf("expected the write request to be answered")
+	}
+}
+
+func TestSendEpochRequestsAndAnswerThemDeletesEpochState(t *testing.T) {
+	e := newEpochManager(getMockShardNodeClients(), time.Second, 0, time.Second, 100)
+	e.currentEpoch = 1
+	e.addRequestToCurrentEpoch(&request{ctx: context.Background(), requestId: "b", operationType: Read, block: "c"})
+	e.addRequestToCurrentEpoch(&request{ctx: context.Background(), requestId: "d", operationType: Write, block: "d", value: "123"})
+	e.sendEpochRequestsAndAnswerThem(1, e.requests[1], e.reponseChans[1])
+	if _, exists := e.requests[1]; exists {
+		t.Errorf("expected the requests of epoch 1 to be deleted")
+	}
+	if _, exists := e.reponseChans[1]; exists {
+		t.Errorf("expected the response channels of epoch 1 to be deleted")
+	}
+}
+
+func TestRemoveRequestFromCurrentEpochRemovesOnlyPendingRequests(t *testing.T) {
+	e := createTestEpochManager(1)
+	e.addRequestToCurrentEpoch(&request{ctx: context.Background(), requestId: "a", operationType: Read, block: "a"})
+	e.addRequestToCurrentEpoch(&request{ctx: context.Background(), requestId: "b", operationType: Read, block: "b"})
+	if !e.removeRequestFromCurrentEpoch("a") {
+		t.Errorf("expected request a to be removed")
+	}
+	if len(e.requests[0]) != 1 || e.requests[0][0].requestId != "b" || len(e.reponseChans[0]) != 1 {
+		t.Errorf("expected only request b to stay in the epoch but got %v", e.requests[0])
+	}
+	e.currentEpoch++
+	if e.removeRequestFromCurrentEpoch("b") {
+		t.Errorf("expected request b not to be removed after its epoch ended")
+	}
+}
+
+func TestDeferOverflowRequestsKeepsTransactionsTogether(t *testing.T) {
+	e := createTestEpochManager(2)
+	e.batchSize = 2
+	e.currentEpoch = 1
+	// a, b and e go to shard node 1, c goes to shard node 0
+	e.addRequestToCurrentEpoch(&request{ctx: context.Background(), requestId: "a", operationType: Read, block: "a"})
+	e.addTransactionToCurrentEpoch("t1", []*request{
+		{ctx: context.Background(), requestId: "b", operationType: Read, block: "b", transactionID: "t1"},
+		{ctx: context.Background(), requestId: "e", operationType: Write, block: "e", value: "value", transactionID: "t1"},
+		{ctx: context.Background(), requestId: "c", operationType: Read, block: "c", transactionID: "t1"},
+	})
+	e.currentEpoch++
+	e.deferOverflowRequests(1)
+	if len(e.requests[1]) != 1 || e.requests[1][0].requestId != "a" {
+		t.Errorf("expected only request a to stay in epoch 1 but got %v", e.requests[1])
+	}
+	if len(e.requests[2]) != 3 {
+		t.Errorf("expected all the requests of the transaction to be deferred to epoch 2 but got %v", e.requests[2])
+	}
+	if _, exists := e.reponseChans[2]["t1"]; !exists {
+		t.Errorf("expected the response channel of the transaction to move to epoch 2")
+	}
+}
+
+func TestRemoveRequestFromCurrentEpochRemovesAllRequestsOfTransaction(t *testing.T) {
+	e := createTestEpochManager(1)
+	e.addRequestToCurrentEpoch(&request{ctx: context.Background(), requestId: "a", operationType: Read, block: "a"})
+	e.addTransactionToCurrentEpoch("t1", []*request{
+		{ctx: context.Background(), requestId: "b", operationType: Read, block: "b", transactionID: "t1"},
+		{ctx: context.Background(), requestId: "c", operationType: Write, block: "c", value: "value", transactionID: "t1"},
+	})
+	if !e.removeRequestFromCurrentEpoch("t1") {
+		t.Errorf("expected transaction t1 to be removed")
+	}
+	if len(e.requests[0]) != 1 || e.requests[0][0].requestId != "a" || len(e.reponseChans[0]) != 1 {
+		t.Errorf("expected only request a to stay in the epoch but got %v", e.requests[0])
+	}
+}
+
+func TestCheckTransactionFitsInEpochRejectsTooManyBlocksOnOneShardNode(t *testing.T) {
+	e := createTestEpochManager(2)
+	e.batchSize = 2
+	// a, b and e go to shard node 1
+	requests := []*request{{block: "a"}, {block: "b"}, {block: "e"}}
+	if err := e.checkTransactionFitsInEpoch(requests); err == nil {
+		t.Errorf("expected an error for a transaction with three blocks on one shard node")
+	}
+	if err := e.checkTransactionFitsInEpoch(requests[:2]); err != nil {
+		t.Errorf("expected no error for a transaction that fits in the epoch but got %s", err)
+	}
+}
+
+// It answers every read with the block name and prepares the writes to the blocks that are not in failedWrites.
+type transactionShardNodeClient struct {
+	mockShardNodeClient
+	failedWrites map[string]bool
+	decisions    chan *shardnodepb.TransactionDecisions
+}
+
+func (c *transactionShardNodeClient) BatchQuery(ctx context.Context, in *shardnodepb.RequestBatch, opts ...grpc.CallOption) (*shardnodepb.ReplyBatch, error) {
+	reply := &shardnodepb.ReplyBatch{}
+	for _, readRequest := range in.ReadRequests {
+		reply.ReadReplies = append(reply.ReadReplies, &shardnodepb.ReadReply{RequestId: readRequest.RequestId, Value: readRequest.Block})
+	}
+	for _, writeRequest := range in.WriteRequests {
+		reply.WriteReplies = append(reply.WriteReplies, &shardnodepb.WriteReply{RequestId: writeRequest.RequestId, Success: !c.failedWrites[writeRequest.Block]})
+	}
+	return reply, nil
+}
+
+func (c *transactionShardNodeClient) DecideTransactions(ctx context.Context, in *shardnodepb.TransactionDecisions, opts ...grpc.CallOption) (*shardnodepb.DecideTransactionsReply, error) {
+	c.decisions <- in
+	return &shardnodepb.DecideTransactionsReply{Success: true}, nil
+}
+
+func TestSendEpochRequestsAndAnswerThemCommitsAndAbortsTransactions(t *testing.T) {
+	client := &transactionShardNodeClient{failedWrites: map[string]bool{"d": true}, decisions: make(chan *shardnodepb.TransactionDecisions, 1)}
+	e := newEpochManager(map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: client}}}, time.Second, 0, time.Second, 100)
+	committedChan := e.addTransactionToCurrentEpoch("t1", []*request{
+		{ctx: context.Background(), requestId: "a", operationType: Read, block: "a", transactionID: "t1"},
+		{ctx: context.Background(), requestId: "b", operationType: Write, block: "b", value: "value", transactionID: "t1"},
+	})
+	abortedChan := e.addTransactionToCurrentEpoch("t2", []*request{
+		{ctx: context.Background(), requestId: "c", operationType: Read, block: "c", transactionID: "t2"},
+		{ctx: context.Background(), requestId: "d", operationType: Write, block: "d", value: "value", transactionID: "t2"},
+	})
+	e.sendEpochRequestsAndAnswerThem(0, e.requests[0], e.reponseChans[0])
+	select {
+	case decisions := <-client.decisions:
+		if len(decisions.Committed) != 1 || decisions.Committed[0] != "t1" || len(decisions.Aborted) != 1 || decisions.Aborted[0] != "t2" {
+			t.Errorf("expected t1 to be committed and t2 to be aborted but got %v", decisions)
+		}
+	default:
+		t.Errorf("expected the decisions to be sent to the shard node")
+	}
+	select {
+	case response := <-committedChan:
+		r := response.(transactionResponse)
+		if !r.committed || r.err != nil || r.reads["a"] != "a" {
+			t.Errorf("expected t1 to commit with the read of a but got %v", r)
+		}
+	default:
+		t.Errorf("expected t1 to be answered")
+	}
+	select {
+	case response := <-abortedChan:
+		if response.(transactionResponse).committed {
+			t.Errorf("expected t2 to abort")
+		}
+	default:
+		t.Errorf("expected t2 to be answered")
+	}
+}
+
+func TestSendEpochRequestsAndAnswerThemAbortsATransactionThatTheCoordinatorAborted(t *testing.T) {
+	client := &transactionShardNodeClient{decisions: make(chan *shardnodepb.TransactionDecisions, 1)}
+	client.decideTransactionReply = func(in *shardnodepb.DecideTransactionRequest) (*shardnodepb.DecideTransactionReply, error) {
+		return &shardnodepb.DecideTransactionReply{Committed: false}, nil
+	}
+	e := newEpochManager(map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: client}}}, time.Second, 0, time.Second, 100)
+	responseChan := e.addTransactionToCurrentEpoch("t1", []*request{
+		{ctx: context.Background(), requestId: "a", operationType: Write, block: "a", value: "value", transactionID: "t1"},
+	})
+	e.sendEpochRequestsAndAnswerThem(0, e.requests[0], e.reponseChans[0])
+	decisions := <-client.decisions
+	if len(decisions.Committed) != 0 || len(decisions.Aborted) != 1 || decisions.Aborted[0] != "t1" {
+		t.Errorf("expected t1 to be aborted but got %v", decisions)
+	}
+	if response := (<-responseChan).(transactionResponse); response.committed || response.err != nil {
+		t.Errorf("expected t1 to abort but got %v", response)
+	}
+}
+
+func TestDecideTransactionsCommitsARecordedTransactionEvenIfTheDecisionsCouldNotBeSent(t *testing.T) {
+	client := &mockShardNodeClient{decideReply: func(*shardnodepb.TransactionDecisions) (*shardnodepb.DecideTransactionsReply, error) {
+		return nil, fmt.Errorf("unavailable")
+	}}
+	e := newEpochManager(map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: client}}}, time.Second, 0, 200*time.Millisecond, 100)
+	responseChans := map[string]chan any{"t1": make(chan any, 1)}
+	transactions := e.newTransactionStates([]*request{{requestId: "a", operationType: Write, block: "a", transactionID: "t1"}})
+	transactions["t1"].recordWrite(true)
+	e.decideTransactions(transactions, responseChans, make(map[string]bool), nil)
+	if response := (<-responseChans["t1"]).(transactionResponse); !response.committed || response.err != nil {
+		t.Errorf("expected t1 to commit, since its shard nodes get the commit from the coordinator, but got %v", response)
+	}
+}
+
+func TestDecideTransactionsReturnsAnErrorIfTheCommitCouldNotBeRecorded(t *testing.T) {
+	client := &mockShardNodeClient{decideTransactionReply: func(*shardnodepb.DecideTransactionRequest) (*shardnodepb.DecideTransactionReply, error) {
+		return nil, fmt.Errorf("unavailable")
+	}}
+	e := newEpochManager(map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: client}}}, time.Second, 0, 200*time.Millisecond, 100)
+	responseChans := map[string]chan any{"t1": make(chan any, 1)}
+	transactions := e.newTransactionStates([]*request{{requestId: "a", operationType: Write, block: "a", transactionID: "t1"}})
+	transactions["t1"].recordWrite(true)
+	e.decideTransactions(transactions, responseChans, make(map[string]bool), nil)
+	if response := (<-responseChans["t1"]).(transactionResponse); response.committed || response.err == nil {
+		t.Errorf("expected the outcome of t1 to be unknown but got %v", response)
+	}
+}
+
+func TestGetShardnodeBatchesSendsTheCoordinatorWithTheWritesOfATransaction(t *testing.T) {
+	client := ShardNodeRPCClient{ClientAPI: &mockShardNodeClient{}, Endpoint: config.ShardNodeEndpoint{ID: 0, ReplicaID: 1, IP: "shardnode", Port: 1234}}
+	e := newEpochManager(map[int]ReplicaRPCClientMap{0: {1: client}}, time.Second, 0, time.Second, 100)
+	requests := []*request{
+		{requestId: "a", operationType: Write, block: "a", value: "value", transactionID: "t1"},
+		{requestId: "b", operationType: Write, block: "b", value: "value"},
+	}
+	batches := e.getShardnodeBatches(requests, e.newTransactionStates(requests))
+	for _, writeRequest := range batches[0].WriteRequests {
+		if writeRequest.TransactionId == "" {
+			if len(writeRequest.CoordinatorReplicas) != 0 {
+				t.Errorf("expected no coordinator for a write outside a transaction but got %v", writeRequest.CoordinatorReplicas)
+			}
+			continue
+		}
+		if len(writeRequest.CoordinatorReplicas) != 1 || writeRequest.CoordinatorReplicas[0].ReplicaId != 1 || writeRequest.CoordinatorReplicas[0].Ip != "shardnode" || writeRequest.CoordinatorReplicas[0].Port != 1234 {
+			t.Errorf("expected the replica of shard node 0 as the coordinator but got %v", writeRequest.CoordinatorReplicas)
+		}
+	}
+}
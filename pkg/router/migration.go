@@ -0,0 +1,314 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+// A shard node redirects the requests for blocks that moved to another shard node.
+// The router sends them again in the next epoch, and gives up if the block keeps moving.
+// The redirects during a migration are not counted, since the shard node finishes or aborts the migration in time.
+const maxRedirects = 100
+
+// The number of migrated blocks that the router forwards to the destination in one stream,
+// so that the router does not keep the blocks of a whole shard node in memory.
+const migratedBlocksBatchSize = 1000
+
+func (e *epochManager) getShardNodeRPCClients(shardNodeID int) ReplicaRPCClientMap {
+	e.clientsMu.RLock()
+	defer e.clientsMu.RUnlock()
+	return e.shardNodeRPCClients[shardNodeID]
+}
+
+func (e *epochManager) setShardNodeRPCClients(shardNodeID int, clients ReplicaRPCClientMap) {
+	e.clientsMu.Lock()
+	defer e.clientsMu.Unlock()
+	e.shardNodeRPCClients[shardNodeID] = clients
+}
+
+// It adds the new shard node of a finished migration to the ring, so that a router which did not add the shard node
+// sends the redirected requests to it.
+// It returns true if the redirected requests should not count as redirects,
+// because the migration is in progress or the router uses its ring now.
+func (e *epochManager) followRing(ring *shardnodepb.Ring) bool {
+	if ring == nil {
+		return false
+	}
+	if !ring.Finished {
+		return true
+	}
+	newShardNodeID := int(ring.NewShardNodeId)
+	if e.ring.HasNode(newShardNodeID) {
+		return true
+	}
+	// A router that adds a shard node holds the lock, and the requests are sent again until it is done.
+	if !e.scaleOutMu.TryLock() {
+		return true
+	}
+	defer e.scaleOutMu.Unlock()
+	if e.ring.HasNode(newShardNodeID) {
+		return true
+	}
+	// The router can only add the new shard node, so it should have the other shard nodes of the ring.
+	if int(ring.VirtualNodes) != e.virtualNodes || len(ring.ShardNodeIds) != len(e.ring.Nodes())+1 {
+		return false
+	}
+	for _, shardNodeID := range ring.ShardNodeIds {
+		if int(shardNodeID) != newShardNodeID && !e.ring.HasNode(int(shardNodeID)) {
+			return false
+		}
+	}
+	var endpoints []config.ShardNodeEndpoint
+	for _, replica := range ring.NewShardNodeReplicas {
+		endpoints = append(endpoints, config.ShardNodeEndpoint{ID: newShardNodeID, ReplicaID: int(replica.ReplicaId), IP: replica.Ip, Port: int(replica.Port)})
+	}
+	clients, err := StartShardNodeRPCClients(endpoints)
+	if err != nil || len(clients[newShardNodeID]) == 0 {
+		log.Error().Msgf("Could not connect to shard node %d of the new ring; %v", newShardNodeID, err)
+		return false
+	}
+	e.setShardNodeRPCClients(newShardNodeID, clients[newShardNodeID])
+	e.ring.AddNode(newShardNodeID)
+	log.Debug().Msgf("Added shard node %d to the ring after a shard node redirected a request to it", newShardNodeID)
+	return true
+}
+
+// It adds a redirected request to the current epoch with the same response channel.
+// The request only counts toward maxRedirects if counted is true.
+func (e *epochManager) redirectRequest(r *request, responseChans map[string]chan any, answered map[string]bool, counted bool) {
+	if r == nil || answered[r.requestId] {
+		return
+	}
+	if counted && r.redirects >= maxRedirects {
+		err := fmt.Errorf("block %s was redirected %d times", r.block, r.redirects)
+		if r.operationType == Read {
+			answerRequest(responseChans, answered, r.requestId, readResponse{err: err})
+		} else {
+			answerRequest(responseChans, answered, r.requestId, writeResponse{err: err})
+		}
+		return
+	}
+	answered[r.requestId] = true
+	if counted {
+		r.redirects++
+	}
+	log.Debug().Msgf("Aquiring lock for epoch manager in redirectRequest")
+	e.mu.Lock()
+	log.Debug().Msgf("Aquired lock for epoch manager in redirectRequest")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for epoch manager in redirectRequest")
+		e.mu.Unlock()
+		log.Debug().Msgf("Released lock for epoch manager in redirectRequest")
+	}()
+	e.requests[e.currentEpoch] = append(e.requests[e.currentEpoch], r)
+	if _, exists := e.reponseChans[e.currentEpoch]; !exists {
+		e.reponseChans[e.currentEpoch] = make(map[string]chan any)
+	}
+	e.reponseChans[e.currentEpoch][r.requestId] = responseChans[r.requestId]
+}
+
+// It streams the blocks that move to the destination from the leader of the source shard node,
+// and forwards every migratedBlocksBatchSize blocks to the destination while it receives the next ones.
+// If the source stream fails, the blocks are streamed again from the start, and the destination applies them again.
+// It returns the number of blocks that the destination received.
+func (e *epochManager) forwardMigratingBlocks(ctx context.Context, sourceClients ReplicaRPCClientMap, destinationClients ReplicaRPCClientMap, request *shardnodepb.MigrateBlocksRequest) (int, error) {
+	var replicaFuncs []rpc.CallFunc
+	var clients []any
+	for _, client := range sourceClients {
+		replicaFuncs = append(replicaFuncs,
+			func(ctx context.Context, client any, request any, opts ...grpc.CallOption) (any, error) {
+				stream, err := client.(ShardNodeRPCClient).ClientAPI.MigrateBlocks(ctx, request.(*shardnodepb.MigrateBlocksRequest), opts...)
+				if err != nil {
+					return nil, err
+				}
+				migratedBlocks := 0
+				var blocks []*shardnodepb.MigratedBlock
+				for {
+					block, err := stream.Recv()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						return nil, err
+					}
+					blocks = append(blocks, block)
+					if len(blocks) == migratedBlocksBatchSize {
+						receivedBlocks, err := e.pushMigratedBlocks(ctx, destinationClients, blocks)
+						if err != nil {
+							return nil, fmt.Errorf("could not send the migrated blocks to the destination; %s", err)
+						}
+						migratedBlocks += receivedBlocks
+						blocks = nil
+					}
+				}
+				if len(blocks) != 0 {
+					receivedBlocks, err := e.pushMigratedBlocks(ctx, destinationClients, blocks)
+					if err != nil {
+						return nil, fmt.Errorf("could not send the migrated blocks to the destination; %s", err)
+					}
+					migratedBlocks += receivedBlocks
+				}
+				return migratedBlocks, nil
+			},
+		)
+		clients = append(clients, client)
+	}
+	reply, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, request)
+	if err != nil {
+		return 0, err
+	}
+	return reply.(int), nil
+}
+
+// It streams the blocks to the leader of the destination shard node.
+func (e *epochManager) pushMigratedBlocks(ctx context.Context, destinationClients ReplicaRPCClientMap, blocks []*shardnodepb.MigratedBlock) (int, error) {
+	var replicaFuncs []rpc.CallFunc
+	var clients []any
+	for _, client := range destinationClients {
+		replicaFuncs = append(replicaFuncs,
+			func(ctx context.Context, client any, request any, opts ...grpc.CallOption) (any, error) {
+				stream, err := client.(ShardNodeRPCClient).ClientAPI.ReceiveMigratedBlocks(ctx, opts...)
+				if err != nil {
+					return nil, err
+				}
+				for _, block := range request.([]*shardnodepb.MigratedBlock) {
+					// A follower closes the stream, the error is returned by CloseAndRecv.
+					if err := stream.Send(block); err != nil {
+						break
+					}
+				}
+				return stream.CloseAndRecv()
+			},
+		)
+		clients = append(clients, client)
+	}
+	reply, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, blocks)
+	if err != nil {
+		return 0, err
+	}
+	return int(reply.(*shardnodepb.ReceiveMigratedBlocksReply).ReceivedBlocks), nil
+}
+
+func (e *epochManager) finishMigration(ctx context.Context, sourceClients ReplicaRPCClientMap, request *shardnodepb.FinishMigrationRequest) error {
+	var replicaFuncs []rpc.CallFunc
+	var clients []any
+	for _, client := range sourceClients {
+		replicaFuncs = append(replicaFuncs,
+			func(ctx context.Context, client any, request any, opts ...grpc.CallOption) (any, error) {
+				return client.(ShardNodeRPCClient).ClientAPI.FinishMigration(ctx, request.(*shardnodepb.FinishMigrationRequest), opts...)
+			},
+		)
+		clients = append(clients, client)
+	}
+	_, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, request)
+	return err
+}
+
+// It commits the migration on the destination and returns false if a source shard node has already aborted it.
+func (e *epochManager) commitMigration(ctx context.Context, destinationClients ReplicaRPCClientMap, migrationID string) (bool, error) {
+	var replicaFuncs []rpc.CallFunc
+	var clients []any
+	for _, client := range destinationClients {
+		replicaFuncs = append(replicaFuncs,
+			func(ctx context.Context, client any, request any, opts ...grpc.CallOption) (any, error) {
+				return client.(ShardNodeRPCClient).ClientAPI.DecideMigration(ctx, request.(*shardnodepb.DecideMigrationRequest), opts...)
+			},
+		)
+		clients = append(clients, client)
+	}
+	reply, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, &shardnodepb.DecideMigrationRequest{MigrationId: migrationID, Commit: true})
+	if err != nil {
+		return false, err
+	}
+	return reply.(*shardnodepb.DecideMigrationReply).Committed, nil
+}
+
+// addShardNode moves the blocks that belong to the new shard node on the new ring and then starts using the new ring.
+//  1. Each source shard node starts redirecting the moving blocks and streams them to the router.
+//  2. The router forwards the blocks to the new shard node in batches, which it replicates.
+//  3. The router commits the migration on the new shard node, unless a source shard node has aborted it.
+//  4. The router adds the new shard node to its ring, so the redirected requests go to the new shard node.
+//  5. The source shard nodes remove the moved blocks and send the new ring with their redirects,
+//     so the other routers start using it too.
+//
+// If the router crashes, the source shard nodes finish the migration if it was committed and abort it otherwise.
+// Every router should add the shard node. Only the first one moves blocks, the others get empty streams.
+func (e *epochManager) addShardNode(ctx context.Context, shardNodeID int, endpoints []config.ShardNodeEndpoint, clients ReplicaRPCClientMap) (migratedBlocks int, err error) {
+	e.scaleOutMu.Lock()
+	defer e.scaleOutMu.Unlock()
+	if e.ring.HasNode(shardNodeID) {
+		return 0, nil
+	}
+	sourceIDs := e.ring.Nodes()
+	log.Debug().Msgf("Adding shard node %d to the ring with shard nodes %v", shardNodeID, sourceIDs)
+	e.setShardNodeRPCClients(shardNodeID, clients)
+	var newShardNodeIDs []int32
+	for _, sourceID := range append(append([]int{}, sourceIDs...), shardNodeID) {
+		newShardNodeIDs = append(newShardNodeIDs, int32(sourceID))
+	}
+	sort.Slice(newShardNodeIDs, func(i, j int) bool { return newShardNodeIDs[i] < newShardNodeIDs[j] })
+	var destinationReplicas []*shardnodepb.ShardNodeReplicaEndpoint
+	for _, endpoint := range endpoints {
+		destinationReplicas = append(destinationReplicas, &shardnodepb.ShardNodeReplicaEndpoint{ReplicaId: int32(endpoint.ReplicaID), Ip: endpoint.IP, Port: int32(endpoint.Port)})
+	}
+	migrationID := uuid.New().String()
+
+	for _, sourceID := range sourceIDs {
+		sourceBlocks, err := e.forwardMigratingBlocks(ctx, e.getShardNodeRPCClients(sourceID), clients,
+			&shardnodepb.MigrateBlocksRequest{ShardNodeIds: newShardNodeIDs, VirtualNodes: int32(e.virtualNodes), DestinationShardNodeId: int32(shardNodeID), MigrationId: migrationID, DestinationReplicas: destinationReplicas})
+		if err != nil {
+			return 0, fmt.Errorf("could not move the migrating blocks of shard node %d to shard node %d; %s", sourceID, shardNodeID, err)
+		}
+		migratedBlocks += sourceBlocks
+	}
+	committed, err := e.commitMigration(ctx, clients, migrationID)
+	if err != nil {
+		return 0, fmt.Errorf("could not commit migration %s on shard node %d; %s", migrationID, shardNodeID, err)
+	}
+	if !committed {
+		return 0, fmt.Errorf("migration %s was aborted by a source shard node", migrationID)
+	}
+
+	e.ring.AddNode(shardNodeID)
+	log.Debug().Msgf("Added shard node %d to the ring after migrating %d blocks", shardNodeID, migratedBlocks)
+
+	for _, sourceID := range sourceIDs {
+		err := e.finishMigration(ctx, e.getShardNodeRPCClients(sourceID),
+			&shardnodepb.FinishMigrationRequest{ShardNodeIds: newShardNodeIDs, VirtualNodes: int32(e.virtualNodes), MigrationId: migrationID})
+		if err != nil {
+			return migratedBlocks, fmt.Errorf("could not finish the migration of shard node %d; %s", sourceID, err)
+		}
+	}
+	return migratedBlocks, nil
+}
+
+// addStorage registers a new storage with every shard node.
+// Each shard node makes sure that the oram node of the storage is ready before it places blocks in the storage.
+func (e *epochManager) addStorage(ctx context.Context, request *shardnodepb.AddStorageRequest) error {
+	for _, shardNodeID := range e.ring.Nodes() {
+		var replicaFuncs []rpc.CallFunc
+		var clients []any
+		for _, client := range e.getShardNodeRPCClients(shardNodeID) {
+			replicaFuncs = append(replicaFuncs,
+				func(ctx context.Context, client any, request any, opts ...grpc.CallOption) (any, error) {
+					return client.(ShardNodeRPCClient).ClientAPI.AddStorage(ctx, request.(*shardnodepb.AddStorageRequest), opts...)
+				},
+			)
+			clients = append(clients, client)
+		}
+		_, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, request)
+		if err != nil {
+			return fmt.Errorf("could not add storage %d to shard node %d; %s", request.StorageId, shardNodeID, err)
+		}
+	}
+	return nil
+}
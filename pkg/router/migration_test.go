@@ -0,0 +1,307 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"google.golang.org/grpc"
+)
+
+type mockMigrateBlocksClient struct {
+	grpc.ClientStream
+	blocks []*shardnodepb.MigratedBlock
+}
+
+func (m *mockMigrateBlocksClient) Recv() (*shardnodepb.MigratedBlock, error) {
+	if len(m.blocks) == 0 {
+		return nil, io.EOF
+	}
+	block := m.blocks[0]
+	m.blocks = m.blocks[1:]
+	return block, nil
+}
+
+type mockReceiveMigratedBlocksClient struct {
+	grpc.ClientStream
+	received []*shardnodepb.MigratedBlock
+}
+
+func (m *mockReceiveMigratedBlocksClient) Send(block *shardnodepb.MigratedBlock) error {
+	m.received = append(m.received, block)
+	return nil
+}
+
+func (m *mockReceiveMigratedBlocksClient) CloseAndRecv() (*shardnodepb.ReceiveMigratedBlocksReply, error) {
+	return &shardnodepb.ReceiveMigratedBlocksReply{ReceivedBlocks: int32(len(m.received))}, nil
+}
+
+func TestRedirectRequestAddsRequestToCurrentEpochWithSameChannel(t *testing.T) {
+	e := createTestEpochManager(1)
+	e.currentEpoch = 3
+	r := &request{ctx: context.Background(), requestId: "a", operationType: Read, block: "a"}
+	responseChan := make(chan any, 1)
+	answered := make(map[string]bool)
+	e.redirectRequest(r, map[string]chan any{"a": responseChan}, answered, true)
+	if len(e.requests[3]) != 1 || e.requests[3][0] != r {
+		t.Errorf("expected the redirected request in the current epoch but got %v", e.requests[3])
+	}
+	if e.reponseChans[3]["a"] != responseChan {
+		t.Errorf("expected the redirected request to keep its response channel")
+	}
+	if !answered["a"] || r.redirects != 1 {
+		t.Errorf("expected the request to be marked as handled with one redirect")
+	}
+}
+
+func TestRedirectRequestAnswersWithErrorAfterMaxRedirects(t *testing.T) {
+	e := createTestEpochManager(1)
+	r := &request{ctx: context.Background(), requestId: "a", operationType: Write, block: "a", redirects: maxRedirects}
+	responseChan := make(chan any, 1)
+	e.redirectRequest(r, map[string]chan any{"a": responseChan}, make(map[string]bool), true)
+	select {
+	case response := <-responseChan:
+		if response.(writeResponse).err == nil {
+			t.Errorf("expected an error after %d redirects", maxRedirects)
+		}
+	default:
+		t.Errorf("expected the request to be answered")
+	}
+	if len(e.requests[e.currentEpoch]) != 0 {
+		t.Errorf("the request should not be sent again")
+	}
+}
+
+func TestSendEpochRequestsAndAnswerThemRequeuesRedirectedRequests(t *testing.T) {
+	clients := map[int]ReplicaRPCClientMap{
+		0: {0: {ClientAPI: &mockShardNodeClient{
+			batchReply: func() (*shardnodepb.ReplyBatch, error) {
+				return &shardnodepb.ReplyBatch{ReadReplies: []*shardnodepb.ReadReply{{RequestId: "a", Redirected: true}}}, nil
+			},
+		}}},
+	}
+	e := newEpochManager(clients, time.Second, 0, time.Second, 100)
+	e.currentEpoch = 2
+	r := &request{ctx: context.Background(), requestId: "a", operationType: Read, block: "a"}
+	responseChan := make(chan any, 1)
+	e.requests[1] = []*request{r}
+	e.reponseChans[1] = map[string]chan any{"a": responseChan}
+
+	e.sendEpochRequestsAndAnswerThem(1, e.requests[1], e.reponseChans[1])
+	select {
+	case <-responseChan:
+		t.Errorf("redirected requests should not be answered")
+	default:
+	}
+	if len(e.requests[2]) != 1 || e.requests[2][0].requestId != "a" {
+		t.Errorf("expected the redirected request in the current epoch but got %v", e.requests[2])
+	}
+}
+
+// It sends a request that the shard node redirects with the ring and returns the epoch manager and the request.
+func sendRedirectedRequestWithRing(ring *shardnodepb.Ring, redirects int) (*epochManager, *request) {
+	clients := map[int]ReplicaRPCClientMap{
+		0: {0: {ClientAPI: &mockShardNodeClient{
+			batchReply: func() (*shardnodepb.ReplyBatch, error) {
+				return &shardnodepb.ReplyBatch{ReadReplies: []*shardnodepb.ReadReply{{RequestId: "a", Redirected: true}}, Ring: ring}, nil
+			},
+		}}},
+	}
+	e := newEpochManager(clients, time.Second, 0, time.Second, 100)
+	e.currentEpoch = 2
+	r := &request{ctx: context.Background(), requestId: "a", operationType: Read, block: "a", redirects: redirects}
+	e.requests[1] = []*request{r}
+	e.reponseChans[1] = map[string]chan any{"a": make(chan any, 1)}
+	e.sendEpochRequestsAndAnswerThem(1, e.requests[1], e.reponseChans[1])
+	return e, r
+}
+
+func TestRedirectsDuringAMigrationAreNotCounted(t *testing.T) {
+	e, r := sendRedirectedRequestWithRing(&shardnodepb.Ring{ShardNodeIds: []int32{0, 1}, VirtualNodes: 100, NewShardNodeId: 1}, maxRedirects)
+	if len(e.requests[2]) != 1 || r.redirects != maxRedirects {
+		t.Errorf("expected the request to be sent again without counting the redirect but got %d redirects", r.redirects)
+	}
+	if e.ring.HasNode(1) {
+		t.Errorf("the router should not use the ring of a migration in progress")
+	}
+}
+
+func TestRedirectWithTheRingOfAFinishedMigrationAddsTheNewShardNode(t *testing.T) {
+	ring := &shardnodepb.Ring{
+		ShardNodeIds:         []int32{0, 1},
+		VirtualNodes:         100,
+		NewShardNodeId:       1,
+		NewShardNodeReplicas: []*shardnodepb.ShardNodeReplicaEndpoint{{ReplicaId: 0, Ip: "localhost", Port: 1234}},
+		Finished:             true,
+	}
+	e, r := sendRedirectedRequestWithRing(ring, 0)
+	if !e.ring.HasNode(1) || len(e.getShardNodeRPCClients(1)) != 1 {
+		t.Errorf("expected the router to add shard node 1 with its replica but got the ring %v", e.ring.Nodes())
+	}
+	if len(e.requests[2]) != 1 || r.redirects != 0 {
+		t.Errorf("expected the request to be sent again without counting the redirect")
+	}
+}
+
+func TestRedirectWithARingThatMissesOtherShardNodesIsCounted(t *testing.T) {
+	ring := &shardnodepb.Ring{ShardNodeIds: []int32{0, 1, 2}, VirtualNodes: 100, NewShardNodeId: 2, Finished: true}
+	e, r := sendRedirectedRequestWithRing(ring, 0)
+	if e.ring.HasNode(2) || r.redirects != 1 {
+		t.Errorf("expected the router to not use a ring that it can not follow")
+	}
+}
+
+func TestAddShardNodeMovesBlocksAndAddsShardNodeToRing(t *testing.T) {
+	finished := make(chan *shardnodepb.FinishMigrationReply, 1)
+	sourceClients := map[int]ReplicaRPCClientMap{
+		0: {0: {ClientAPI: &mockShardNodeClient{
+			migrateBlocksReply: func() (shardnodepb.ShardNode_MigrateBlocksClient, error) {
+				return &mockMigrateBlocksClient{blocks: []*shardnodepb.MigratedBlock{{Block: "a"}, {Block: "b"}}}, nil
+			},
+			finishMigrationReply: func() (*shardnodepb.FinishMigrationReply, error) {
+				reply := &shardnodepb.FinishMigrationReply{Success: true}
+				finished <- reply
+				return reply, nil
+			},
+		}}},
+	}
+	receiver := &mockReceiveMigratedBlocksClient{}
+	destinationClients := ReplicaRPCClientMap{0: {ClientAPI: &mockShardNodeClient{
+		receiveMigratedReply: func() (shardnodepb.ShardNode_ReceiveMigratedBlocksClient, error) {
+			return receiver, nil
+		},
+	}}}
+	e := newEpochManager(sourceClients, time.Second, 0, time.Second, 100)
+
+	migratedBlocks, err := e.addShardNode(context.Background(), 1, []config.ShardNodeEndpoint{{ID: 1, ReplicaID: 0, IP: "localhost", Port: 1234}}, destinationClients)
+	if err != nil {
+		t.Errorf("expected successful scale out but got %s", err)
+	}
+	if migratedBlocks != 2 || len(receiver.received) != 2 {
+		t.Errorf("expected 2 migrated blocks but got %d", migratedBlocks)
+	}
+	nodes := e.ring.Nodes()
+	if len(nodes) != 2 || nodes[1] != 1 {
+		t.Errorf("expected shard node 1 on the ring but got %v", nodes)
+	}
+	if e.getShardNodeRPCClients(1) == nil {
+		t.Errorf("expected clients for shard node 1")
+	}
+	select {
+	case <-finished:
+	default:
+		t.Errorf("expected the source shard node to finish the migration")
+	}
+}
+
+func TestAddShardNodeForwardsTheMigratedBlocksInBatches(t *testing.T) {
+	var sourceBlocks []*shardnodepb.MigratedBlock
+	for i := 0; i < 2*migratedBlocksBatchSize+1; i++ {
+		sourceBlocks = append(sourceBlocks, &shardnodepb.MigratedBlock{Block: fmt.Sprintf("block%d", i)})
+	}
+	source := &mockMigrateBlocksClient{blocks: sourceBlocks}
+	sourceClients := map[int]ReplicaRPCClientMap{
+		0: {0: {ClientAPI: &mockShardNodeClient{
+			migrateBlocksReply: func() (shardnodepb.ShardNode_MigrateBlocksClient, error) {
+				return source, nil
+			},
+			finishMigrationReply: func() (*shardnodepb.FinishMigrationReply, error) {
+				return &shardnodepb.FinishMigrationReply{Success: true}, nil
+			},
+		}}},
+	}
+	var receivers []*mockReceiveMigratedBlocksClient
+	destinationClients := ReplicaRPCClientMap{0: {ClientAPI: &mockShardNodeClient{
+		receiveMigratedReply: func() (shardnodepb.ShardNode_ReceiveMigratedBlocksClient, error) {
+			// The router forwards a batch before it receives the rest of the blocks from the source
+			expectedReceivedBlocks := (len(receivers) + 1) * migratedBlocksBatchSize
+			if expectedReceivedBlocks > len(sourceBlocks) {
+				expectedReceivedBlocks = len(sourceBlocks)
+			}
+			if receivedBlocks := len(sourceBlocks) - len(source.blocks); receivedBlocks != expectedReceivedBlocks {
+				t.Errorf("expected the batch to be forwarded after %d received blocks but got %d", expectedReceivedBlocks, receivedBlocks)
+			}
+			receiver := &mockReceiveMigratedBlocksClient{}
+			receivers = append(receivers, receiver)
+			return receiver, nil
+		},
+	}}}
+	e := newEpochManager(sourceClients, time.Second, 0, time.Second, 100)
+
+	migratedBlocks, err := e.addShardNode(context.Background(), 1, nil, destinationClients)
+	if err != nil {
+		t.Fatalf("expected successful scale out but got %s", err)
+	}
+	if migratedBlocks != len(sourceBlocks) {
+		t.Errorf("expected %d migrated blocks but got %d", len(sourceBlocks), migratedBlocks)
+	}
+	if len(receivers) != 3 || len(receivers[0].received) != migratedBlocksBatchSize || len(receivers[2].received) != 1 {
+		t.Errorf("expected the blocks to be forwarded in 3 batches but got %d streams", len(receivers))
+	}
+}
+
+func TestAddShardNodeDoesNothingForExistingShardNode(t *testing.T) {
+	e := createTestEpochManager(2)
+	migratedBlocks, err := e.addShardNode(context.Background(), 1, nil, ReplicaRPCClientMap{})
+	if err != nil || migratedBlocks != 0 {
+		t.Errorf("adding an existing shard node should be a no-op but got %d, %v", migratedBlocks, err)
+	}
+}
+
+func TestAddShardNodeFailsIfASourceShardNodeAbortedTheMigration(t *testing.T) {
+	var migrationIDs []string
+	sourceClients := map[int]ReplicaRPCClientMap{
+		0: {0: {ClientAPI: &mockShardNodeClient{
+			migrateBlocksReply: func() (shardnodepb.ShardNode_MigrateBlocksClient, error) {
+				return &mockMigrateBlocksClient{}, nil
+			},
+			finishMigrationReply: func() (*shardnodepb.FinishMigrationReply, error) {
+				t.Errorf("an aborted migration should not be finished")
+				return &shardnodepb.FinishMigrationReply{Success: true}, nil
+			},
+		}}},
+	}
+	destinationClients := ReplicaRPCClientMap{0: {ClientAPI: &mockShardNodeClient{
+		decideMigrationReply: func(in *shardnodepb.DecideMigrationRequest) (*shardnodepb.DecideMigrationReply, error) {
+			migrationIDs = append(migrationIDs, in.MigrationId)
+			return &shardnodepb.DecideMigrationReply{Committed: false}, nil
+		},
+	}}}
+	e := newEpochManager(sourceClients, time.Second, 0, time.Second, 100)
+	_, err := e.addShardNode(context.Background(), 1, nil, destinationClients)
+	if err == nil {
+		t.Errorf("expected an error for an aborted migration")
+	}
+	if len(migrationIDs) != 1 || migrationIDs[0] == "" {
+		t.Errorf("expected the router to commit the migration with its id but got %v", migrationIDs)
+	}
+	if e.ring.HasNode(1) {
+		t.Errorf("the router should not add the shard node of an aborted migration")
+	}
+}
+
+func TestAddStorageRegistersStorageWithEveryShardNode(t *testing.T) {
+	registered := make(chan int, 2)
+	clients := make(map[int]ReplicaRPCClientMap)
+	for shardNodeID := 0; shardNodeID < 2; shardNodeID++ {
+		shardNodeID := shardNodeID
+		clients[shardNodeID] = ReplicaRPCClientMap{0: {ClientAPI: &mockShardNodeClient{
+			addStorageReply: func() (*shardnodepb.AddStorageReply, error) {
+				registered <- shardNodeID
+				return &shardnodepb.AddStorageReply{Success: true}, nil
+			},
+		}}}
+	}
+	e := newEpochManager(clients, time.Second, 0, time.Second, 100)
+	err := e.addStorage(context.Background(), &shardnodepb.AddStorageRequest{StorageId: 3, OramNodeId: 1})
+	if err != nil {
+		t.Errorf("expected successful add storage but got %s", err)
+	}
+	if len(registered) != 2 {
+		t.Errorf("expected the storage to be registered with both shard nodes")
+	}
+}
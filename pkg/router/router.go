@@ -0,0 +1,51 @@
+package router
+
+import (
+	"net"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/router"
+	"github.com/dsg-uwaterloo/treebeard/pkg/admin"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Router is a running router.
+// Unlike StartRPCServer, StartRouter does not block and the router can be stopped,
+// so that the tests can run many routers in one process.
+type Router struct {
+	grpcServer   *grpc.Server
+	epochManager *epochManager
+}
+
+func newRouter(shardNodeRPCClients map[int]ReplicaRPCClientMap, routerID int, parameters config.Parameters, parametersPath string) *Router {
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(rpc.ContextPropagationUnaryServerInterceptor()))
+
+	epochManager := newEpochManager(shardNodeRPCClients, time.Duration(parameters.EpochTime)*time.Millisecond, parameters.EpochBatchSize, time.Duration(parameters.EpochTimeout)*time.Millisecond, parameters.VirtualNodes)
+	go epochManager.run()
+	routerServer := newRouterServer(routerID, epochManager)
+	routerServer.parameters = parameters
+	pb.RegisterRouterServer(grpcServer, &routerServer)
+	admin.RegisterAdminServer(grpcServer, &routerServer, parametersPath, nil)
+	// The clients check the health of the routers to stop sending requests to the routers that are down
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pb.Router_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	return &Router{grpcServer: grpcServer, epochManager: epochManager}
+}
+
+// StartRouter starts a router that serves on the listener.
+func StartRouter(listener net.Listener, shardNodeRPCClients map[int]ReplicaRPCClientMap, routerID int, parameters config.Parameters, parametersPath string) *Router {
+	router := newRouter(shardNodeRPCClients, routerID, parameters, parametersPath)
+	go router.grpcServer.Serve(listener)
+	return router
+}
+
+// Stop stops the router without waiting for the running requests.
+func (r *Router) Stop() {
+	close(r.epochManager.stop)
+	r.grpcServer.Stop()
+}
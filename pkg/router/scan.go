@@ -0,0 +1,88 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/router"
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A scan returns at most maxScanLimit blocks, since every shard node sends up to limit blocks.
+const maxScanLimit = 10000
+
+// It sends the scan to the leader of every shard node and merges the blocks in order.
+// Every shard node gets the same request, so the scan does not show which shard nodes have the blocks.
+// A block that is being migrated can be returned by two shard nodes, so the duplicates are removed.
+func (e *epochManager) scan(ctx context.Context, request *shardnodepb.ScanRequest) ([]string, error) {
+	shardNodeIDs := e.ring.Nodes()
+	type scanResult struct {
+		blocks []string
+		err    error
+	}
+	resultChan := make(chan scanResult, len(shardNodeIDs))
+	for _, shardNodeID := range shardNodeIDs {
+		go func(shardNodeID int) {
+			var replicaFuncs []rpc.CallFunc
+			var clients []any
+			for _, client := range e.getShardNodeRPCClients(shardNodeID) {
+				replicaFuncs = append(replicaFuncs,
+					func(ctx context.Context, client any, request any, opts ...grpc.CallOption) (any, error) {
+						return client.(ShardNodeRPCClient).ClientAPI.Scan(ctx, request.(*shardnodepb.ScanRequest), opts...)
+					},
+				)
+				clients = append(clients, client)
+			}
+			reply, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, request)
+			if err != nil {
+				resultChan <- scanResult{err: fmt.Errorf("could not scan shard node %d; %s", shardNodeID, err)}
+				return
+			}
+			resultChan <- scanResult{blocks: reply.(*shardnodepb.ScanReply).Blocks}
+		}(shardNodeID)
+	}
+	var blocks []string
+	for range shardNodeIDs {
+		result := <-resultChan
+		if result.err != nil {
+			return nil, result.err
+		}
+		blocks = append(blocks, result.blocks...)
+	}
+	sort.Strings(blocks)
+	var merged []string
+	for i, block := range blocks {
+		if len(merged) == int(request.Limit) {
+			break
+		}
+		if i > 0 && blocks[i-1] == block {
+			continue
+		}
+		merged = append(merged, block)
+	}
+	return merged, nil
+}
+
+// Scan returns the blocks that exist and start with the prefix, in order.
+func (r *routerServer) Scan(ctx context.Context, scanRequest *pb.ScanRequest) (*pb.ScanReply, error) {
+	log.Debug().Msgf("Received scan request for prefix %s with limit %d", scanRequest.Prefix, scanRequest.Limit)
+	if scanRequest.Limit <= 0 || scanRequest.Limit > maxScanLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "the limit should be between 1 and %d", maxScanLimit)
+	}
+	tracer := otel.Tracer("")
+	ctx, span := tracer.Start(ctx, "router scan request")
+	defer span.End()
+	blocks, err := r.epochManager.scan(ctx, &shardnodepb.ScanRequest{Prefix: scanRequest.Prefix, Limit: scanRequest.Limit})
+	if err != nil {
+		return nil, fmt.Errorf("could not scan the shard nodes; %s", err)
+	}
+	log.Debug().Msgf("Returning %d blocks for the scan of prefix %s", len(blocks), scanRequest.Prefix)
+	return &pb.ScanReply{Blocks: blocks}, nil
+}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/router"
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func scanShardNodeClient(blocks ...string) ShardNodeRPCClient {
+	return ShardNodeRPCClient{ClientAPI: &mockShardNodeClient{
+		scanReply: func(in *shardnodepb.ScanRequest) (*shardnodepb.ScanReply, error) {
+			return &shardnodepb.ScanReply{Blocks: blocks}, nil
+		},
+	}}
+}
+
+func TestScanMergesTheBlocksOfAllShardNodesInOrder(t *testing.T) {
+	clients := map[int]ReplicaRPCClientMap{
+		0: {0: scanShardNodeClient("a1", "a4")},
+		1: {0: scanShardNodeClient("a2", "a3", "a4")}, // a4 is being migrated
+	}
+	r := newRouterServer(0, newEpochManager(clients, time.Second, 0, time.Second, 100))
+	reply, err := r.Scan(context.Background(), &pb.ScanRequest{Prefix: "a", Limit: 10})
+	if err != nil || !reflect.DeepEqual(reply.Blocks, []string{"a1", "a2", "a3", "a4"}) {
+		t.Errorf("expected [a1 a2 a3 a4] but got %v, %v", reply, err)
+	}
+	reply, err = r.Scan(context.Background(), &pb.ScanRequest{Prefix: "a", Limit: 2})
+	if err != nil || !reflect.DeepEqual(reply.Blocks, []string{"a1", "a2"}) {
+		t.Errorf("expected [a1 a2] but got %v, %v", reply, err)
+	}
+}
+
+func TestScanReturnsInvalidArgumentForInvalidLimit(t *testing.T) {
+	r := newRouterServer(0, createTestEpochManager(1))
+	for _, limit := range []int32{0, -1, maxScanLimit + 1} {
+		_, err := r.Scan(context.Background(), &pb.ScanRequest{Prefix: "a", Limit: limit})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected an invalid argument error for limit %d but got %v", limit, err)
+		}
+	}
+}
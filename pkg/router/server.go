@@ -2,23 +2,26 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
-	"time"
 
 	pb "github.com/dsg-uwaterloo/treebeard/api/router"
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
-	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	utils "github.com/dsg-uwaterloo/treebeard/pkg/utils"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel"
-	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type routerServer struct {
 	pb.UnimplementedRouterServer
 	routerID     int
 	epochManager *epochManager
+	parameters   config.Parameters // the epoch time in it is not updated at runtime, the epoch manager has the current one
 }
 
 func newRouterServer(routerID int, epochManager *epochManager) routerServer {
@@ -29,46 +32,286 @@ func newRouterServer(routerID int, epochManager *epochManager) routerServer {
 	}
 }
 
+// It waits for the response of the request or for the context to be done.
+// If the context is done before the request is sent, the request is removed from the epoch.
+// A request that has already been sent still runs, but its response is dropped.
+func (r *routerServer) waitForResponse(ctx context.Context, requestID string, responseChannel chan any) (any, error) {
+	select {
+	case response := <-responseChannel:
+		return response, nil
+	case <-ctx.Done():
+		removed := r.epochManager.removeRequestFromCurrentEpoch(requestID)
+		log.Debug().Msgf("Request %s was cancelled; removed from the epoch: %t", requestID, removed)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// It turns the response of a read request into the reply of the Read RPC.
+func readReplyFromResponse(response readResponse) (*pb.ReadReply, error) {
+	if errors.Is(response.err, errEpochTimedOut) {
+		return nil, status.Errorf(codes.DeadlineExceeded, "could not read value from the shardnode; %s", response.err)
+	}
+	if response.err != nil {
+		return nil, fmt.Errorf("could not read value from the shardnode; %s", response.err)
+	}
+	return &pb.ReadReply{Value: response.value, Exists: response.exists, Version: response.version}, nil
+}
+
+// It turns the response of a write request into the reply of the Write RPC.
+func writeReplyFromResponse(response writeResponse) (*pb.WriteReply, error) {
+	if errors.Is(response.err, errEpochTimedOut) {
+		return nil, status.Errorf(codes.DeadlineExceeded, "could not write value to the shardnode; %s", response.err)
+	}
+	if response.err != nil {
+		return nil, fmt.Errorf("could not write value to the shardnode; %s", response.err)
+	}
+	return &pb.WriteReply{Success: response.success}, nil
+}
+
+// The clients can not write the padding block, because the fake requests of every epoch read it.
+func checkWritableBlock(block string) error {
+	if block == utils.PaddingBlock {
+		return fmt.Errorf("block %s is reserved", block)
+	}
+	return nil
+}
+
 func (r *routerServer) Read(ctx context.Context, readRequest *pb.ReadRequest) (*pb.ReadReply, error) {
 	log.Debug().Msgf("Received read request for block %s", readRequest.Block)
 	tracer := otel.Tracer("")
 	ctx, span := tracer.Start(ctx, "router read request")
-	responseChannel := r.epochManager.addRequestToCurrentEpoch(&request{ctx: ctx, requestId: uuid.New().String(), operationType: Read, block: readRequest.Block})
-	response := <-responseChannel
-	readResponse := response.(readResponse)
-	if readResponse.err != nil {
-		return nil, fmt.Errorf("could not read value from the shardnode; %s", readResponse.err)
+	requestID := uuid.New().String()
+	responseChannel := r.epochManager.addRequestToCurrentEpoch(&request{ctx: ctx, requestId: requestID, operationType: Read, block: readRequest.Block})
+	response, err := r.waitForResponse(ctx, requestID, responseChannel)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := readReplyFromResponse(response.(readResponse))
+	if err != nil {
+		return nil, err
 	}
-	log.Debug().Msgf("Returning read response (value: %s) for block %s", readResponse.value, readRequest.Block)
+	log.Debug().Msgf("Returning read response (value: %s) for block %s", reply.Value, readRequest.Block)
 	span.End()
-	return &pb.ReadReply{Value: readResponse.value}, nil
+	return reply, nil
 }
 
 func (r *routerServer) Write(ctx context.Context, writeRequest *pb.WriteRequest) (*pb.WriteReply, error) {
 	log.Debug().Msgf("Received write request for block %s", writeRequest.Block)
+	if err := checkWritableBlock(writeRequest.Block); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid request; %s", err)
+	}
 	tracer := otel.Tracer("")
 	ctx, span := tracer.Start(ctx, "router write request")
-	responseChannel := r.epochManager.addRequestToCurrentEpoch(&request{ctx: ctx, requestId: uuid.New().String(), operationType: Write, block: writeRequest.Block, value: writeRequest.Value})
-	response := <-responseChannel
-	writeResponse := response.(writeResponse)
-	if writeResponse.err != nil {
-		return nil, fmt.Errorf("could not write value to the shardnode; %s", writeResponse.err)
+	requestID := uuid.New().String()
+	responseChannel := r.epochManager.addRequestToCurrentEpoch(&request{ctx: ctx, requestId: requestID, operationType: Write, block: writeRequest.Block, value: writeRequest.Value, idempotencyKey: writeRequest.IdempotencyKey})
+	response, err := r.waitForResponse(ctx, requestID, responseChannel)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := writeReplyFromResponse(response.(writeResponse))
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Returning write response (success: %t) for block %s", reply.Success, writeRequest.Block)
+	span.End()
+	return reply, nil
+}
+
+// Delete removes the block, so that it does not exist until it is written again.
+func (r *routerServer) Delete(ctx context.Context, deleteRequest *pb.DeleteRequest) (*pb.DeleteReply, error) {
+	log.Debug().Msgf("Received delete request for block %s", deleteRequest.Block)
+	if err := checkWritableBlock(deleteRequest.Block); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid request; %s", err)
+	}
+	tracer := otel.Tracer("")
+	ctx, span := tracer.Start(ctx, "router delete request")
+	requestID := uuid.New().String()
+	responseChannel := r.epochManager.addRequestToCurrentEpoch(&request{ctx: ctx, requestId: requestID, operationType: Delete, block: deleteRequest.Block, idempotencyKey: deleteRequest.IdempotencyKey})
+	response, err := r.waitForResponse(ctx, requestID, responseChannel)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := writeReplyFromResponse(response.(writeResponse))
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Returning delete response (success: %t) for block %s", reply.Success, deleteRequest.Block)
+	span.End()
+	return &pb.DeleteReply{Success: reply.Success}, nil
+}
+
+// It turns the response of a compare and swap request into the reply of the CompareAndSwap RPC.
+func compareAndSwapReplyFromResponse(response writeResponse) (*pb.CompareAndSwapReply, error) {
+	if _, err := writeReplyFromResponse(response); err != nil {
+		return nil, err
+	}
+	return &pb.CompareAndSwapReply{Swapped: response.success, Value: response.value, Version: response.version}, nil
+}
+
+// CompareAndSwap writes the new value if the block has the expected value.
+// If the expected version is not zero, the version of the block should match it too.
+// A block that does not exist has an empty value and version zero.
+// The reply has the value and version of the block after the request, so a failed swap can be retried with them.
+func (r *routerServer) CompareAndSwap(ctx context.Context, casRequest *pb.CompareAndSwapRequest) (*pb.CompareAndSwapReply, error) {
+	log.Debug().Msgf("Received compare and swap request for block %s", casRequest.Block)
+	if err := checkWritableBlock(casRequest.Block); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid request; %s", err)
+	}
+	tracer := otel.Tracer("")
+	ctx, span := tracer.Start(ctx, "router compare and swap request")
+	requestID := uuid.New().String()
+	responseChannel := r.epochManager.addRequestToCurrentEpoch(&request{ctx: ctx, requestId: requestID, operationType: CompareAndSwap, block: casRequest.Block, value: casRequest.NewValue, expected: casRequest.Expected, expectedVersion: casRequest.ExpectedVersion, idempotencyKey: casRequest.IdempotencyKey})
+	response, err := r.waitForResponse(ctx, requestID, responseChannel)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := compareAndSwapReplyFromResponse(response.(writeResponse))
+	if err != nil {
+		return nil, err
 	}
-	log.Debug().Msgf("Returning write response (success: %t) for block %s", writeResponse.success, writeRequest.Block)
+	log.Debug().Msgf("Returning compare and swap response (swapped: %t, version: %d) for block %s", reply.Swapped, reply.Version, casRequest.Block)
 	span.End()
-	return &pb.WriteReply{Success: writeResponse.success}, nil
+	return reply, nil
 }
 
-func StartRPCServer(ip string, shardNodeRPCClients map[int]ReplicaRPCClientMap, routerID int, port int, parameters config.Parameters) {
+// Exists reads the block and only returns whether it exists.
+func (r *routerServer) Exists(ctx context.Context, existsRequest *pb.ExistsRequest) (*pb.ExistsReply, error) {
+	log.Debug().Msgf("Received exists request for block %s", existsRequest.Block)
+	tracer := otel.Tracer("")
+	ctx, span := tracer.Start(ctx, "router exists request")
+	requestID := uuid.New().String()
+	responseChannel := r.epochManager.addRequestToCurrentEpoch(&request{ctx: ctx, requestId: requestID, operationType: Read, block: existsRequest.Block})
+	response, err := r.waitForResponse(ctx, requestID, responseChannel)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := readReplyFromResponse(response.(readResponse))
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("Returning exists response (exists: %t) for block %s", reply.Exists, existsRequest.Block)
+	span.End()
+	return &pb.ExistsReply{Exists: reply.Exists}, nil
+}
+
+// It creates a request for every block of the transaction.
+// The reads go before the writes, so a block that is read and written returns the value before the transaction.
+func newTransactionRequests(ctx context.Context, transactionID string, transactionRequest *pb.TransactionRequest) ([]*request, error) {
+	if len(transactionRequest.ReadSet) == 0 && len(transactionRequest.WriteSet) == 0 {
+		return nil, fmt.Errorf("the transaction has no reads or writes")
+	}
+	var requests []*request
+	readBlocks := make(map[string]bool)
+	for _, block := range transactionRequest.ReadSet {
+		if readBlocks[block] {
+			continue
+		}
+		readBlocks[block] = true
+		requests = append(requests, &request{ctx: ctx, requestId: uuid.New().String(), operationType: Read, block: block, transactionID: transactionID})
+	}
+	writtenBlocks := make(map[string]bool)
+	for _, write := range transactionRequest.WriteSet {
+		if writtenBlocks[write.Block] {
+			return nil, fmt.Errorf("block %s is written more than once", write.Block)
+		}
+		if err := checkWritableBlock(write.Block); err != nil {
+			return nil, err
+		}
+		writtenBlocks[write.Block] = true
+		requests = append(requests, &request{ctx: ctx, requestId: uuid.New().String(), operationType: Write, block: write.Block, value: write.Value, transactionID: transactionID})
+	}
+	return requests, nil
+}
+
+// Transaction runs the reads and writes of the transaction in one epoch and applies the writes only if all of them are prepared.
+// Its requests look like single reads and writes to the shard nodes and the oram nodes.
+func (r *routerServer) Transaction(ctx context.Context, transactionRequest *pb.TransactionRequest) (*pb.TransactionReply, error) {
+	log.Debug().Msgf("Received transaction request with %d reads and %d writes", len(transactionRequest.ReadSet), len(transactionRequest.WriteSet))
+	tracer := otel.Tracer("")
+	ctx, span := tracer.Start(ctx, "router transaction request")
+	transactionID := uuid.New().String()
+	requests, err := newTransactionRequests(ctx, transactionID, transactionRequest)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid transaction; %s", err)
+	}
+	err = r.epochManager.checkTransactionFitsInEpoch(requests)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid transaction; %s", err)
+	}
+	responseChannel := r.epochManager.addTransactionToCurrentEpoch(transactionID, requests)
+	response, err := r.waitForResponse(ctx, transactionID, responseChannel)
+	if err != nil {
+		return nil, err
+	}
+	transactionResponse := response.(transactionResponse)
+	if errors.Is(transactionResponse.err, errEpochTimedOut) {
+		return nil, status.Errorf(codes.DeadlineExceeded, "could not run the transaction; %s", transactionResponse.err)
+	}
+	if transactionResponse.err != nil {
+		return nil, fmt.Errorf("could not run the transaction; %s", transactionResponse.err)
+	}
+	log.Debug().Msgf("Returning transaction response (committed: %t) for transaction %s", transactionResponse.committed, transactionID)
+	span.End()
+	if !transactionResponse.committed {
+		return &pb.TransactionReply{Committed: false}, nil
+	}
+	var reads []*pb.ReadResult
+	for _, r := range requests {
+		if r.operationType == Read {
+			reads = append(reads, &pb.ReadResult{Block: r.block, Value: transactionResponse.reads[r.block]})
+		}
+	}
+	return &pb.TransactionReply{Committed: true, Reads: reads}, nil
+}
+
+// AddShardNode connects to the replicas of a new shard node and moves the blocks that it owns to it.
+// It should be called on every router.
+func (r *routerServer) AddShardNode(ctx context.Context, addShardNodeRequest *pb.AddShardNodeRequest) (*pb.AddShardNodeReply, error) {
+	log.Debug().Msgf("Received add shard node request for shard node %d", addShardNodeRequest.ShardNodeId)
+	var endpoints []config.ShardNodeEndpoint
+	for _, replica := range addShardNodeRequest.Replicas {
+		endpoints = append(endpoints, config.ShardNodeEndpoint{
+			ID:        int(addShardNodeRequest.ShardNodeId),
+			ReplicaID: int(replica.ReplicaId),
+			IP:        replica.Ip,
+			Port:      int(replica.Port),
+		})
+	}
+	if len(endpoints) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "shard node %d has no replicas", addShardNodeRequest.ShardNodeId)
+	}
+	clients, err := StartShardNodeRPCClients(endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to shard node %d; %s", addShardNodeRequest.ShardNodeId, err)
+	}
+	migratedBlocks, err := r.epochManager.addShardNode(ctx, int(addShardNodeRequest.ShardNodeId), endpoints, clients[int(addShardNodeRequest.ShardNodeId)])
+	if err != nil {
+		return nil, fmt.Errorf("could not add shard node %d; %s", addShardNodeRequest.ShardNodeId, err)
+	}
+	return &pb.AddShardNodeReply{MigratedBlocks: int32(migratedBlocks)}, nil
+}
+
+// AddStorage adds a new storage to an oram node and registers it with every shard node.
+// It only needs to be called on one router.
+func (r *routerServer) AddStorage(ctx context.Context, addStorageRequest *pb.AddStorageRequest) (*pb.AddStorageReply, error) {
+	log.Debug().Msgf("Received add storage request for storage %d", addStorageRequest.StorageId)
+	err := r.epochManager.addStorage(ctx, &shardnodepb.AddStorageRequest{
+		StorageId:  addStorageRequest.StorageId,
+		OramNodeId: addStorageRequest.OramNodeId,
+		Ip:         addStorageRequest.Ip,
+		Port:       addStorageRequest.Port,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not add storage %d; %s", addStorageRequest.StorageId, err)
+	}
+	return &pb.AddStorageReply{Success: true}, nil
+}
+
+func StartRPCServer(ip string, shardNodeRPCClients map[int]ReplicaRPCClientMap, routerID int, port int, parameters config.Parameters, parametersPath string) {
 	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", ip, port))
 	if err != nil {
 		log.Fatal().Msgf("failed to listen: %v", err)
 	}
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(rpc.ContextPropagationUnaryServerInterceptor()))
-
-	epochManager := newEpochManager(shardNodeRPCClients, time.Duration(parameters.EpochTime)*time.Millisecond)
-	go epochManager.run()
-	routerServer := newRouterServer(routerID, epochManager)
-	pb.RegisterRouterServer(grpcServer, &routerServer)
-	grpcServer.Serve(lis)
+	router := newRouter(shardNodeRPCClients, routerID, parameters, parametersPath)
+	router.grpcServer.Serve(lis)
 }
@@ -0,0 +1,140 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/router"
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	utils "github.com/dsg-uwaterloo/treebeard/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReadRemovesRequestFromEpochWhenContextIsCancelled(t *testing.T) {
+	e := createTestEpochManager(1)
+	r := newRouterServer(0, e)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := r.Read(ctx, &pb.ReadRequest{Block: "a"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected a deadline exceeded error but got %v", err)
+	}
+	if len(e.requests[0]) != 0 || len(e.reponseChans[0]) != 0 {
+		t.Errorf("expected the request to be removed from the epoch")
+	}
+}
+
+func TestWriteReturnsDeadlineExceededWhenEpochTimesOut(t *testing.T) {
+	clients := map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: &blockingShardNodeClient{}}}}
+	e := newEpochManager(clients, 10*time.Millisecond, 0, 50*time.Millisecond, 100)
+	go e.run()
+	r := newRouterServer(0, e)
+	_, err := r.Write(context.Background(), &pb.WriteRequest{Block: "a", Value: "value"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected a deadline exceeded error but got %v", err)
+	}
+}
+
+func TestWritesToThePaddingBlockAreRejected(t *testing.T) {
+	r := newRouterServer(0, createTestEpochManager(1))
+	_, err := r.Write(context.Background(), &pb.WriteRequest{Block: utils.PaddingBlock, Value: "value"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an invalid argument error for a write but got %v", err)
+	}
+	_, err = r.Delete(context.Background(), &pb.DeleteRequest{Block: utils.PaddingBlock})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an invalid argument error for a delete but got %v", err)
+	}
+	_, err = r.Transaction(context.Background(), &pb.TransactionRequest{WriteSet: []*pb.WriteRequest{{Block: utils.PaddingBlock, Value: "value"}}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an invalid argument error for a transaction but got %v", err)
+	}
+	reply := r.runStreamRequest(context.Background(), &pb.StreamRequest{RequestId: "1", Operation: &pb.StreamRequest_Write{Write: &pb.WriteRequest{Block: utils.PaddingBlock, Value: "value"}}})
+	if reply.Error == "" {
+		t.Errorf("expected an error for a stream write")
+	}
+}
+
+// It answers that only block "a" exists and acks every write.
+type existsShardNodeClient struct {
+	mockShardNodeClient
+	deletes chan string
+}
+
+func (c *existsShardNodeClient) BatchQuery(ctx context.Context, in *shardnodepb.RequestBatch, opts ...grpc.CallOption) (*shardnodepb.ReplyBatch, error) {
+	reply := &shardnodepb.ReplyBatch{}
+	for _, readRequest := range in.ReadRequests {
+		reply.ReadReplies = append(reply.ReadReplies, &shardnodepb.ReadReply{RequestId: readRequest.RequestId, Exists: readRequest.Block == "a"})
+	}
+	for _, writeRequest := range in.WriteRequests {
+		if writeRequest.Delete {
+			c.deletes <- writeRequest.Block
+		}
+		reply.WriteReplies = append(reply.WriteReplies, &shardnodepb.WriteReply{RequestId: writeRequest.RequestId, Success: true})
+	}
+	return reply, nil
+}
+
+func TestDeleteAndExistsAreSentToTheShardNodes(t *testing.T) {
+	client := &existsShardNodeClient{deletes: make(chan string, 1)}
+	e := newEpochManager(map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: client}}}, 10*time.Millisecond, 0, time.Second, 100)
+	go e.run()
+	r := newRouterServer(0, e)
+	existsReply, err := r.Exists(context.Background(), &pb.ExistsRequest{Block: "a"})
+	if err != nil || !existsReply.Exists {
+		t.Errorf("expected block a to exist but got %v, %v", existsReply, err)
+	}
+	existsReply, err = r.Exists(context.Background(), &pb.ExistsRequest{Block: "b"})
+	if err != nil || existsReply.Exists {
+		t.Errorf("expected block b not to exist but got %v, %v", existsReply, err)
+	}
+	deleteReply, err := r.Delete(context.Background(), &pb.DeleteRequest{Block: "a"})
+	if err != nil || !deleteReply.Success {
+		t.Errorf("expected the delete to succeed but got %v, %v", deleteReply, err)
+	}
+	select {
+	case block := <-client.deletes:
+		if block != "a" {
+			t.Errorf("expected the delete of block a but got %s", block)
+		}
+	default:
+		t.Errorf("expected the delete to be sent to the shard node as a write with delete set")
+	}
+}
+
+// It swaps a block when the expected value is "old" and returns the version 7 of the block.
+type casShardNodeClient struct {
+	mockShardNodeClient
+}
+
+func (c *casShardNodeClient) BatchQuery(ctx context.Context, in *shardnodepb.RequestBatch, opts ...grpc.CallOption) (*shardnodepb.ReplyBatch, error) {
+	reply := &shardnodepb.ReplyBatch{}
+	for _, writeRequest := range in.WriteRequests {
+		if !writeRequest.Compare {
+			return nil, status.Errorf(codes.InvalidArgument, "expected a compare and swap request")
+		}
+		if writeRequest.Expected == "old" {
+			reply.WriteReplies = append(reply.WriteReplies, &shardnodepb.WriteReply{RequestId: writeRequest.RequestId, Success: true, Value: writeRequest.Value, Version: 7})
+		} else {
+			reply.WriteReplies = append(reply.WriteReplies, &shardnodepb.WriteReply{RequestId: writeRequest.RequestId, Success: false, Value: "old", Version: 7})
+		}
+	}
+	return reply, nil
+}
+
+func TestCompareAndSwapIsSentToTheShardNodes(t *testing.T) {
+	e := newEpochManager(map[int]ReplicaRPCClientMap{0: {0: {ClientAPI: &casShardNodeClient{}}}}, 10*time.Millisecond, 0, time.Second, 100)
+	go e.run()
+	r := newRouterServer(0, e)
+	reply, err := r.CompareAndSwap(context.Background(), &pb.CompareAndSwapRequest{Block: "a", Expected: "old", NewValue: "new"})
+	if err != nil || !reply.Swapped || reply.Value != "new" || reply.Version != 7 {
+		t.Errorf("expected the swap to succeed but got %v, %v", reply, err)
+	}
+	reply, err = r.CompareAndSwap(context.Background(), &pb.CompareAndSwapRequest{Block: "a", Expected: "other", NewValue: "new"})
+	if err != nil || reply.Swapped || reply.Value != "old" {
+		t.Errorf("expected the swap to fail with the current value but got %v, %v", reply, err)
+	}
+}
@@ -0,0 +1,234 @@
+package router
+
+import (
+	"context"
+	"fmt"
+
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+type transactionResponse struct {
+	committed bool
+	reads     map[string]string // map of block to value
+	err       error
+}
+
+// It tracks the replies to the requests of a transaction in an epoch.
+type transactionState struct {
+	pendingRequests int
+	failed          bool // a request failed or a write could not be prepared
+	reads           map[string]string
+	// The shard node that records the decision of the transaction, and its replicas that are sent with the writes.
+	coordinatorID       int
+	coordinatorReplicas []*shardnodepb.ShardNodeReplicaEndpoint
+}
+
+// The coordinator of a transaction is the shard node of its id on the ring,
+// so it does not depend on which blocks the transaction uses.
+func (e *epochManager) newTransactionStates(requests []*request) map[string]*transactionState {
+	transactions := make(map[string]*transactionState)
+	for _, r := range requests {
+		if r.transactionID == "" {
+			continue
+		}
+		if _, exists := transactions[r.transactionID]; !exists {
+			coordinatorID := e.whereToForward(r.transactionID)
+			var coordinatorReplicas []*shardnodepb.ShardNodeReplicaEndpoint
+			for replicaID, client := range e.getShardNodeRPCClients(coordinatorID) {
+				coordinatorReplicas = append(coordinatorReplicas, &shardnodepb.ShardNodeReplicaEndpoint{ReplicaId: int32(replicaID), Ip: client.Endpoint.IP, Port: int32(client.Endpoint.Port)})
+			}
+			transactions[r.transactionID] = &transactionState{reads: make(map[string]string), coordinatorID: coordinatorID, coordinatorReplicas: coordinatorReplicas}
+		}
+		transactions[r.transactionID].pendingRequests++
+	}
+	return transactions
+}
+
+// It returns the transaction of the request, or nil if the request is not part of a transaction.
+func getTransaction(transactions map[string]*transactionState, r *request) *transactionState {
+	if r == nil || r.transactionID == "" {
+		return nil
+	}
+	return transactions[r.transactionID]
+}
+
+func (t *transactionState) recordRead(block string, value string) {
+	t.pendingRequests--
+	t.reads[block] = value
+}
+
+func (t *transactionState) recordWrite(prepared bool) {
+	t.pendingRequests--
+	if !prepared {
+		t.failed = true
+	}
+}
+
+func (t *transactionState) fail() {
+	t.pendingRequests--
+	t.failed = true
+}
+
+func (t *transactionState) canCommit() bool {
+	return !t.failed && t.pendingRequests == 0
+}
+
+// It adds all the requests of a transaction to the current epoch, so that they are sent together.
+// The transaction has one response channel for all of its requests.
+func (e *epochManager) addTransactionToCurrentEpoch(transactionID string, requests []*request) chan any {
+	log.Debug().Msgf("Aquiring lock for epoch manager in addTransactionToCurrentEpoch")
+	e.mu.Lock()
+	log.Debug().Msgf("Aquired lock for epoch manager in addTransactionToCurrentEpoch")
+	log.Debug().Msgf("Adding transaction %s with %d requests to epoch %d", transactionID, len(requests), e.currentEpoch)
+	defer func() {
+		log.Debug().Msgf("Releasing lock for epoch manager in addTransactionToCurrentEpoch")
+		e.mu.Unlock()
+		log.Debug().Msgf("Released lock for epoch manager in addTransactionToCurrentEpoch")
+	}()
+	e.requests[e.currentEpoch] = append(e.requests[e.currentEpoch], requests...)
+	if _, exists := e.reponseChans[e.currentEpoch]; !exists {
+		e.reponseChans[e.currentEpoch] = make(map[string]chan any)
+	}
+	e.reponseChans[e.currentEpoch][transactionID] = make(chan any, 1)
+	return e.reponseChans[e.currentEpoch][transactionID]
+}
+
+// It returns an error if the transaction has more requests for a shard node than a padded epoch can send to it.
+func (e *epochManager) checkTransactionFitsInEpoch(requests []*request) error {
+	if e.batchSize == 0 {
+		return nil
+	}
+	shardNodeRequestCount := make(map[int]int)
+	for _, r := range requests {
+		shardNodeID := e.whereToForward(r.block)
+		shardNodeRequestCount[shardNodeID]++
+		if shardNodeRequestCount[shardNodeID] > e.batchSize {
+			return fmt.Errorf("the transaction has more than %d blocks on shard node %d", e.batchSize, shardNodeID)
+		}
+	}
+	return nil
+}
+
+// It sends the decisions to the leader of every shard node.
+// Every shard node gets the same decisions, so the decisions do not show which shard nodes a transaction used.
+func (e *epochManager) sendTransactionDecisions(ctx context.Context, decisions *shardnodepb.TransactionDecisions) error {
+	shardNodeIDs := e.ring.Nodes()
+	errorChan := make(chan error, len(shardNodeIDs))
+	for _, shardNodeID := range shardNodeIDs {
+		go func(shardNodeID int) {
+			var replicaFuncs []rpc.CallFunc
+			var clients []any
+			for _, client := range e.getShardNodeRPCClients(shardNodeID) {
+				replicaFuncs = append(replicaFuncs,
+					func(ctx context.Context, client any, request any, opts ...grpc.CallOption) (any, error) {
+						return client.(ShardNodeRPCClient).ClientAPI.DecideTransactions(ctx, request.(*shardnodepb.TransactionDecisions), opts...)
+					},
+				)
+				clients = append(clients, client)
+			}
+			_, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, decisions)
+			if err != nil {
+				errorChan <- fmt.Errorf("could not send the transaction decisions to shard node %d; %s", shardNodeID, err)
+				return
+			}
+			errorChan <- nil
+		}(shardNodeID)
+	}
+	var err error
+	for range shardNodeIDs {
+		if sendErr := <-errorChan; sendErr != nil {
+			err = sendErr
+		}
+	}
+	return err
+}
+
+// It records the commits of the transactions at their coordinators.
+// A coordinator aborts a transaction instead if a participant asked for the decision first, after its prepare timed out.
+// It returns the committed and the aborted transactions, and the errors of the transactions whose decision is unknown.
+func (e *epochManager) recordTransactionCommits(ctx context.Context, transactions map[string]*transactionState, transactionIDs []string) (committed []string, aborted []string, failed map[string]error) {
+	type recordResult struct {
+		transactionID string
+		committed     bool
+		err           error
+	}
+	resultChan := make(chan recordResult, len(transactionIDs))
+	for _, transactionID := range transactionIDs {
+		go func(transactionID string, coordinatorID int) {
+			var replicaFuncs []rpc.CallFunc
+			var clients []any
+			for _, client := range e.getShardNodeRPCClients(coordinatorID) {
+				replicaFuncs = append(replicaFuncs,
+					func(ctx context.Context, client any, request any, opts ...grpc.CallOption) (any, error) {
+						return client.(ShardNodeRPCClient).ClientAPI.DecideTransaction(ctx, request.(*shardnodepb.DecideTransactionRequest), opts...)
+					},
+				)
+				clients = append(clients, client)
+			}
+			reply, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, &shardnodepb.DecideTransactionRequest{TransactionId: transactionID, Commit: true})
+			if err != nil {
+				resultChan <- recordResult{transactionID: transactionID, err: fmt.Errorf("could not record the commit of transaction %s at shard node %d; %s", transactionID, coordinatorID, err)}
+				return
+			}
+			resultChan <- recordResult{transactionID: transactionID, committed: reply.(*shardnodepb.DecideTransactionReply).Committed}
+		}(transactionID, transactions[transactionID].coordinatorID)
+	}
+	failed = make(map[string]error)
+	for range transactionIDs {
+		result := <-resultChan
+		if result.err != nil {
+			failed[result.transactionID] = result.err
+		} else if result.committed {
+			committed = append(committed, result.transactionID)
+		} else {
+			aborted = append(aborted, result.transactionID)
+		}
+	}
+	return committed, aborted, failed
+}
+
+// It commits the transactions whose requests all succeeded, aborts the others, and answers them.
+// If err is not nil, every transaction is aborted and answered with err.
+// A transaction is committed once its coordinator recorded the commit,
+// and a shard node that misses the decisions asks the coordinator after its prepare timeout, so it gets the same outcome.
+// The transactions whose commit could not be recorded are not sent, their shard nodes ask the coordinator too.
+// In padded epochs the decisions are sent even if there are no transactions, so that the epochs with transactions look like the others.
+func (e *epochManager) decideTransactions(transactions map[string]*transactionState, responseChans map[string]chan any, answered map[string]bool, err error) {
+	if len(transactions) == 0 && e.batchSize == 0 {
+		return
+	}
+	decisions := &shardnodepb.TransactionDecisions{}
+	var canCommit []string
+	for transactionID, transaction := range transactions {
+		if err == nil && transaction.canCommit() {
+			canCommit = append(canCommit, transactionID)
+		} else {
+			decisions.Aborted = append(decisions.Aborted, transactionID)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.epochTimeout)
+	defer cancel()
+	committed, aborted, failed := e.recordTransactionCommits(ctx, transactions, canCommit)
+	decisions.Committed = committed
+	for _, transactionID := range aborted {
+		log.Debug().Msgf("Transaction %s was aborted by its coordinator", transactionID)
+		decisions.Aborted = append(decisions.Aborted, transactionID)
+	}
+	sendErr := e.sendTransactionDecisions(ctx, decisions)
+	if sendErr != nil {
+		log.Error().Msgf("Could not send all the transaction decisions; %s", sendErr)
+	}
+	for _, transactionID := range decisions.Aborted {
+		answerRequest(responseChans, answered, transactionID, transactionResponse{committed: false, err: err})
+	}
+	for transactionID, recordErr := range failed {
+		// The coordinator may have recorded the commit, so the outcome is unknown.
+		answerRequest(responseChans, answered, transactionID, transactionResponse{err: recordErr})
+	}
+	for _, transactionID := range decisions.Committed {
+		answerRequest(responseChans, answered, transactionID, transactionResponse{committed: true, reads: transactions[transactionID].reads})
+	}
+}
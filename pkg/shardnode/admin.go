@@ -0,0 +1,21 @@
+package shardnode
+
+import (
+	"time"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+)
+
+// Parameters returns the parameters of the shard node with the current batch timeout.
+func (s *shardNodeServer) Parameters() config.Parameters {
+	parameters := s.parameters
+	parameters.BatchTimout = float64(s.batchManager.getBatchTimeout()) / float64(time.Millisecond)
+	return parameters
+}
+
+// UpdateParameters changes the batch timeout of this replica.
+// The batch timeout is not part of the FSM, so each replica can have its own.
+func (s *shardNodeServer) UpdateParameters(parameters config.Parameters) error {
+	s.batchManager.setBatchTimeout(time.Duration(parameters.BatchTimout * float64(time.Millisecond)))
+	return nil
+}
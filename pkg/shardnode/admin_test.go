@@ -0,0 +1,24 @@
+package shardnode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestUpdateParametersChangesBatchTimeout(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), nil, map[int]int{0: 0}, 5, newBatchManager(time.Millisecond))
+	parameters := s.Parameters()
+	parameters.BatchTimout = 3
+	err := s.UpdateParameters(parameters)
+	if err != nil {
+		t.Errorf("expected successful update but got %s", err)
+	}
+	if s.batchManager.getBatchTimeout() != 3*time.Millisecond {
+		t.Errorf("expected a batch timeout of 3ms but got %s", s.batchManager.getBatchTimeout())
+	}
+	if s.Parameters().BatchTimout != 3 {
+		t.Errorf("expected batch timeout 3 but got %f", s.Parameters().BatchTimout)
+	}
+}
@@ -2,6 +2,7 @@ package shardnode
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/dsg-uwaterloo/treebeard/api/oramnode"
@@ -17,6 +18,7 @@ type blockRequest struct {
 
 type batchManager struct {
 	batchTimeout    time.Duration
+	batchTimeoutMu  sync.RWMutex           // the batch timeout can change at runtime
 	storageQueues   map[int][]blockRequest // map of storage id to its requests
 	responseChannel map[string]chan string // map of block to its response channel
 	mu              utils.PriorityLock
@@ -32,6 +34,18 @@ func newBatchManager(batchTimeout time.Duration) *batchManager {
 	return &batchManager
 }
 
+func (b *batchManager) getBatchTimeout() time.Duration {
+	b.batchTimeoutMu.RLock()
+	defer b.batchTimeoutMu.RUnlock()
+	return b.batchTimeout
+}
+
+func (b *batchManager) setBatchTimeout(batchTimeout time.Duration) {
+	b.batchTimeoutMu.Lock()
+	defer b.batchTimeoutMu.Unlock()
+	b.batchTimeout = batchTimeout
+}
+
 // It add the request to the correct queue and return a response channel.
 // The client uses the response channel to get the result of this request.
 func (b *batchManager) addRequestToStorageQueueAndWait(req blockRequest, storageID int) chan string {
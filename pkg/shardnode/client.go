@@ -6,6 +6,7 @@ import (
 	"math"
 
 	oramnodepb "github.com/dsg-uwaterloo/treebeard/api/oramnode"
+	pb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
 	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
 	"github.com/rs/zerolog/log"
@@ -57,17 +58,31 @@ func (r *ReplicaRPCClientMap) readPathFromAllOramNodeReplicas(ctx context.Contex
 	return oramNodeReply, nil
 }
 
-func StartOramNodeRPCClients(endpoints []config.OramNodeEndpoint) (map[int]ReplicaRPCClientMap, error) {
+// Every replica should connect to the storage, so it returns an error if any replica fails.
+func (r *ReplicaRPCClientMap) addStorageToAllOramNodeReplicas(ctx context.Context, request *oramnodepb.AddStorageRequest) error {
+	for replicaID, c := range *r {
+		log.Debug().Msgf("Adding storage %d to oram node replica %d", request.StorageId, replicaID)
+		_, err := c.ClientAPI.AddStorage(ctx, request)
+		if err != nil {
+			return fmt.Errorf("could not add storage %d to oram node replica %d; %s", request.StorageId, replicaID, err)
+		}
+	}
+	return nil
+}
+
+// The dial options are added to the default ones, for example to dial the replicas over in-memory connections.
+func StartOramNodeRPCClients(endpoints []config.OramNodeEndpoint, dialOptions ...grpc.DialOption) (map[int]ReplicaRPCClientMap, error) {
 	log.Debug().Msgf("Starting OramNode RPC clients for endpoints: %v", endpoints)
 	clients := make(map[int]ReplicaRPCClientMap)
 	for _, endpoint := range endpoints {
 		serverAddr := fmt.Sprintf("%s:%d", endpoint.IP, endpoint.Port)
 		log.Debug().Msgf("Starting OramNode RPC client for endpoint: %s", serverAddr)
-		conn, err := grpc.Dial(serverAddr,
+		options := append([]grpc.DialOption{
 			grpc.WithTransportCredentials(insecure.NewCredentials()),
 			grpc.WithUnaryInterceptor(rpc.ContextPropagationUnaryClientInterceptor()),
 			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt64), grpc.MaxCallSendMsgSize(math.MaxInt64)),
-		)
+		}, dialOptions...)
+		conn, err := grpc.Dial(serverAddr, options...)
 		if err != nil {
 			return nil, err
 		}
@@ -79,3 +94,73 @@ func StartOramNodeRPCClients(endpoints []config.OramNodeEndpoint) (map[int]Repli
 	}
 	return clients, nil
 }
+
+type shardNodeRPCClient struct {
+	ClientAPI pb.ShardNodeClient
+	Conn      *grpc.ClientConn
+}
+
+// shardNodeReplicaClients are the clients of the replicas of another shard node, map of replicaID to client.
+type shardNodeReplicaClients map[int]shardNodeRPCClient
+
+// It connects to the replicas of one shard node.
+func startShardNodeRPCClients(endpoints []config.ShardNodeEndpoint, dialOptions ...grpc.DialOption) (shardNodeReplicaClients, error) {
+	log.Debug().Msgf("Starting ShardNode RPC clients for endpoints: %v", endpoints)
+	clients := make(shardNodeReplicaClients)
+	for _, endpoint := range endpoints {
+		serverAddr := fmt.Sprintf("%s:%d", endpoint.IP, endpoint.Port)
+		options := append([]grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithUnaryInterceptor(rpc.ContextPropagationUnaryClientInterceptor()),
+		}, dialOptions...)
+		conn, err := grpc.Dial(serverAddr, options...)
+		if err != nil {
+			clients.close()
+			return nil, err
+		}
+		clients[endpoint.ReplicaID] = shardNodeRPCClient{ClientAPI: pb.NewShardNodeClient(conn), Conn: conn}
+	}
+	return clients, nil
+}
+
+func (c shardNodeReplicaClients) close() {
+	for _, client := range c {
+		client.Conn.Close()
+	}
+}
+
+func (c shardNodeReplicaClients) decideMigrationOnAllReplicas(ctx context.Context, request *pb.DecideMigrationRequest) (*pb.DecideMigrationReply, error) {
+	var replicaFuncs []rpc.CallFunc
+	var clients []interface{}
+	for _, client := range c {
+		replicaFuncs = append(replicaFuncs,
+			func(ctx context.Context, client interface{}, request interface{}, opts ...grpc.CallOption) (interface{}, error) {
+				return client.(shardNodeRPCClient).ClientAPI.DecideMigration(ctx, request.(*pb.DecideMigrationRequest), opts...)
+			},
+		)
+		clients = append(clients, client)
+	}
+	reply, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, request)
+	if err != nil {
+		return nil, err
+	}
+	return reply.(*pb.DecideMigrationReply), nil
+}
+
+func (c shardNodeReplicaClients) decideTransactionOnAllReplicas(ctx context.Context, request *pb.DecideTransactionRequest) (*pb.DecideTransactionReply, error) {
+	var replicaFuncs []rpc.CallFunc
+	var clients []interface{}
+	for _, client := range c {
+		replicaFuncs = append(replicaFuncs,
+			func(ctx context.Context, client interface{}, request interface{}, opts ...grpc.CallOption) (interface{}, error) {
+				return client.(shardNodeRPCClient).ClientAPI.DecideTransaction(ctx, request.(*pb.DecideTransactionRequest), opts...)
+			},
+		)
+		clients = append(clients, client)
+	}
+	reply, err := rpc.CallAllReplicas(ctx, clients, replicaFuncs, request)
+	if err != nil {
+		return nil, err
+	}
+	return reply.(*pb.DecideTransactionReply), nil
+}
@@ -11,7 +11,8 @@ import (
 )
 
 type mockOramNodeClient struct {
-	replyFunc func([]*oramnode.BlockRequest) (*oramnodepb.ReadPathReply, error)
+	replyFunc      func([]*oramnode.BlockRequest) (*oramnodepb.ReadPathReply, error)
+	addStorageFunc func(*oramnodepb.AddStorageRequest) (*oramnodepb.AddStorageReply, error)
 }
 
 func (c *mockOramNodeClient) ReadPath(ctx context.Context, in *oramnodepb.ReadPathRequest, opts ...grpc.CallOption) (*oramnodepb.ReadPathReply, error) {
@@ -22,6 +23,13 @@ func (c *mockOramNodeClient) JoinRaftVoter(ctx context.Context, in *oramnodepb.J
 	return nil, nil
 }
 
+func (c *mockOramNodeClient) AddStorage(ctx context.Context, in *oramnodepb.AddStorageRequest, opts ...grpc.CallOption) (*oramnodepb.AddStorageReply, error) {
+	if c.addStorageFunc == nil {
+		return &oramnodepb.AddStorageReply{Success: true}, nil
+	}
+	return c.addStorageFunc(in)
+}
+
 func TestReadPathFromAllOramNodeReplicasReturnsResponseFromLeader(t *testing.T) {
 	oramNodeClients := map[int]ReplicaRPCClientMap{
 		0: map[int]oramNodeRPCClient{
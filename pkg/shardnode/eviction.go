@@ -0,0 +1,98 @@
+package shardnode
+
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultEvictionTimeout = time.Minute
+
+// evictionTimer finds the evictions that waited too long for their acks and nacks.
+// It measures the wait from when the leader first saw the eviction waiting,
+// so a new leader waits the whole timeout again.
+type evictionTimer struct {
+	timeout      time.Duration
+	waitingSince map[string]time.Time // map of eviction id to when it was first seen waiting
+}
+
+func newEvictionTimer(timeout time.Duration) *evictionTimer {
+	if timeout <= 0 {
+		timeout = defaultEvictionTimeout
+	}
+	return &evictionTimer{timeout: timeout, waitingSince: make(map[string]time.Time)}
+}
+
+// It returns the waiting evictions that timed out and forgets the evictions that are not waiting anymore.
+func (e *evictionTimer) expired(waitingEvictions []string, now time.Time) (expiredEvictions []string) {
+	waiting := make(map[string]bool)
+	for _, evictionID := range waitingEvictions {
+		waiting[evictionID] = true
+		since, exists := e.waitingSince[evictionID]
+		if !exists {
+			e.waitingSince[evictionID] = now
+			continue
+		}
+		if now.Sub(since) >= e.timeout {
+			expiredEvictions = append(expiredEvictions, evictionID)
+		}
+	}
+	for evictionID := range e.waitingSince {
+		if !waiting[evictionID] {
+			delete(e.waitingSince, evictionID)
+		}
+	}
+	return expiredEvictions
+}
+
+func (e *evictionTimer) reset() {
+	e.waitingSince = make(map[string]time.Time)
+}
+
+// It returns the ids of the evictions that wait for their acks and nacks.
+func (s *shardNodeServer) getOutstandingEvictions() (evictionIDs []string) {
+	log.Debug().Msgf("Aquiring lock for shard node FSM in getOutstandingEvictions")
+	s.shardNodeFSM.stashMu.Lock()
+	log.Debug().Msgf("Aquired lock for shard node FSM in getOutstandingEvictions")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for shard node FSM in getOutstandingEvictions")
+		s.shardNodeFSM.stashMu.Unlock()
+		log.Debug().Msgf("Released lock for shard node FSM in getOutstandingEvictions")
+	}()
+	for evictionID := range s.shardNodeFSM.evictions {
+		evictionIDs = append(evictionIDs, evictionID)
+	}
+	return evictionIDs
+}
+
+// It nacks the evictions that did not finish before the timeout until the server stops,
+// so the blocks of an eviction that the oram node could not replay are evicted again.
+// The oram node replays an interrupted eviction with the same eviction id and resends its acks,
+// so the timeout should be longer than an eviction and its replay.
+// The acks of a nacked eviction are ignored if they arrive later.
+func (s *shardNodeServer) nackTimedOutEvictionsForever(timer *evictionTimer) {
+	for {
+		select {
+		case <-time.After(timer.timeout / 4):
+		case <-s.stop:
+			return
+		}
+		if s.raftNode.State() != raft.Leader {
+			timer.reset()
+			continue
+		}
+		for _, evictionID := range timer.expired(s.getOutstandingEvictions(), time.Now()) {
+			log.Debug().Msgf("Nacking the blocks of eviction %s since it timed out", evictionID)
+			acksNacksReplicationCommand, err := newAcksNacksReplicationCommand(evictionID, nil, nil, true)
+			if err != nil {
+				log.Error().Msgf("Could not create acks/nacks replication command; %s", err)
+				continue
+			}
+			err = s.raftNode.Apply(acksNacksReplicationCommand, 0).Error()
+			if err != nil {
+				log.Error().Msgf("Could not apply log to the FSM; %s", err)
+			}
+		}
+	}
+}
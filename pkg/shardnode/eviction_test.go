@@ -0,0 +1,46 @@
+package shardnode
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestEvictionTimerExpiresEvictionsThatWaitLongerThanTheTimeout(t *testing.T) {
+	timer := newEvictionTimer(time.Second)
+	start := time.Now()
+	if expired := timer.expired([]string{"a"}, start); len(expired) != 0 {
+		t.Errorf("expected no expired evictions when they are first seen but got %v", expired)
+	}
+	if expired := timer.expired([]string{"a", "b"}, start.Add(500*time.Millisecond)); len(expired) != 0 {
+		t.Errorf("expected no expired evictions before the timeout but got %v", expired)
+	}
+	expired := timer.expired([]string{"a", "b"}, start.Add(time.Second))
+	if len(expired) != 1 || expired[0] != "a" {
+		t.Errorf("expected only eviction a to expire but got %v", expired)
+	}
+}
+
+func TestEvictionTimerForgetsEvictionsThatStopWaiting(t *testing.T) {
+	timer := newEvictionTimer(time.Second)
+	start := time.Now()
+	timer.expired([]string{"a", "b"}, start)
+	timer.expired([]string{"b"}, start.Add(500*time.Millisecond))
+	expired := timer.expired([]string{"a", "b"}, start.Add(time.Second))
+	if len(expired) != 1 || expired[0] != "b" {
+		t.Errorf("expected eviction a to wait again from the start but got %v", expired)
+	}
+}
+
+func TestGetOutstandingEvictionsReturnsTheEvictionsWaitingForTheirAcks(t *testing.T) {
+	s := &shardNodeServer{shardNodeFSM: newShardNodeFSM(0)}
+	s.shardNodeFSM.evictions = map[string][]SentBlock{
+		"eviction1": {{Block: "a", Value: "a"}},
+		"eviction2": nil,
+	}
+	evictionIDs := s.getOutstandingEvictions()
+	sort.Strings(evictionIDs)
+	if len(evictionIDs) != 2 || evictionIDs[0] != "eviction1" || evictionIDs[1] != "eviction2" {
+		t.Errorf("expected the outstanding evictions eviction1 and eviction2 but got %v", evictionIDs)
+	}
+}
@@ -0,0 +1,107 @@
+package shardnode
+
+import (
+	"math/rand"
+)
+
+const (
+	keyIndexMaxLevel = 32
+	// keyIndexLevelProbability is the probability that a key that is in a level is also in the next level.
+	keyIndexLevelProbability = 0.25
+)
+
+type keyIndexNode struct {
+	key  string
+	next []*keyIndexNode // the next node in each level of the node
+}
+
+// keyIndex keeps the blocks in order with a skiplist.
+// Inserting, deleting and finding a block take O(log n) time on average.
+// It is not safe for concurrent use.
+type keyIndex struct {
+	head   *keyIndexNode
+	level  int // the number of levels that have at least one key
+	length int
+	random *rand.Rand
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		head:   &keyIndexNode{next: make([]*keyIndexNode, keyIndexMaxLevel)},
+		level:  1,
+		random: rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+func (k *keyIndex) randomLevel() int {
+	level := 1
+	for level < keyIndexMaxLevel && k.random.Float64() < keyIndexLevelProbability {
+		level++
+	}
+	return level
+}
+
+// It returns the last node before the key in each level.
+func (k *keyIndex) findPredecessors(key string) (predecessors [keyIndexMaxLevel]*keyIndexNode) {
+	node := k.head
+	for level := k.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+		predecessors[level] = node
+	}
+	return predecessors
+}
+
+// It adds the key to the index if it is new.
+func (k *keyIndex) insert(key string) {
+	predecessors := k.findPredecessors(key)
+	if next := predecessors[0].next[0]; next != nil && next.key == key {
+		return
+	}
+	level := k.randomLevel()
+	for ; k.level < level; k.level++ {
+		predecessors[k.level] = k.head
+	}
+	node := &keyIndexNode{key: key, next: make([]*keyIndexNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = predecessors[i].next[i]
+		predecessors[i].next[i] = node
+	}
+	k.length++
+}
+
+// It removes the key from the index if it exists.
+func (k *keyIndex) delete(key string) {
+	predecessors := k.findPredecessors(key)
+	node := predecessors[0].next[0]
+	if node == nil || node.key != key {
+		return
+	}
+	for i := 0; i < len(node.next); i++ {
+		predecessors[i].next[i] = node.next[i]
+	}
+	for k.level > 1 && k.head.next[k.level-1] == nil {
+		k.level--
+	}
+	k.length--
+}
+
+// It calls f for the keys that are not less than from, in order, until f returns false.
+func (k *keyIndex) ascend(from string, f func(key string) bool) {
+	for node := k.findPredecessors(from)[0].next[0]; node != nil; node = node.next[0] {
+		if !f(node.key) {
+			return
+		}
+	}
+}
+
+// It returns every key of the index in order.
+func (k *keyIndex) keys() []string {
+	keys := make([]string, 0, k.length)
+	k.ascend("", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
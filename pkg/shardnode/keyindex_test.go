@@ -0,0 +1,54 @@
+package shardnode
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestKeyIndexKeepsTheKeysInOrderAfterInsertsAndDeletes(t *testing.T) {
+	k := newKeyIndex()
+	expected := make(map[string]bool)
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("block%d", rand.Intn(1000))
+		if rand.Intn(3) == 0 {
+			k.delete(key)
+			delete(expected, key)
+		} else {
+			k.insert(key)
+			expected[key] = true
+		}
+	}
+	var expectedKeys []string
+	for key := range expected {
+		expectedKeys = append(expectedKeys, key)
+	}
+	sort.Strings(expectedKeys)
+	if keys := k.keys(); !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("expected the keys %v but got %v", expectedKeys, keys)
+	}
+}
+
+func TestKeyIndexAscendStartsFromTheFirstKeyThatIsNotLess(t *testing.T) {
+	k := newKeyIndex()
+	for _, key := range []string{"d", "b", "a", "c"} {
+		k.insert(key)
+	}
+	var keys []string
+	k.ascend("bb", func(key string) bool {
+		keys = append(keys, key)
+		return key != "c"
+	})
+	if !reflect.DeepEqual(keys, []string{"c"}) {
+		t.Errorf("expected [c] but got %v", keys)
+	}
+}
+
+func BenchmarkSetPositionWithIncreasingBlocks(b *testing.B) {
+	fsm := newShardNodeFSM(0)
+	for i := 0; i < b.N; i++ {
+		fsm.setPosition(fmt.Sprintf("block%012d", i), positionState{})
+	}
+}
@@ -0,0 +1,373 @@
+package shardnode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/commonerrs"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/utils"
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	migrationPollInterval      = 10 * time.Millisecond
+	migratedBlocksBatchSize    = 1000
+	ownershipRingHashCacheSize = 1 << 16
+	defaultMigrationTimeout    = 10 * time.Minute
+)
+
+// migrationState is a migration of blocks from this shard node to a new shard node.
+type migrationState struct {
+	id                  string
+	shardNodeIDs        []int // the ring after the new shard node is added
+	virtualNodes        int
+	destinationID       int
+	destinationReplicas []ShardNodeReplicaPayload
+	finished            bool
+	// The migration and the ownership before this migration, they are restored if this migration is aborted.
+	previous          *migrationState
+	previousOwnership *ownershipRing
+}
+
+func (m migrationState) hasRing(shardNodeIDs []int, virtualNodes int) bool {
+	if m.virtualNodes != virtualNodes || len(m.shardNodeIDs) != len(shardNodeIDs) {
+		return false
+	}
+	for i := range shardNodeIDs {
+		if m.shardNodeIDs[i] != shardNodeIDs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// It describes the ring of the migration to the routers, so that they can start using it after the migration finishes.
+func (m migrationState) toProto() *pb.Ring {
+	ring := &pb.Ring{
+		ShardNodeIds:   utils.ConvertIntSliceToInt32Slice(m.shardNodeIDs),
+		VirtualNodes:   int32(m.virtualNodes),
+		NewShardNodeId: int32(m.destinationID),
+		Finished:       m.finished,
+	}
+	for _, replica := range m.destinationReplicas {
+		ring.NewShardNodeReplicas = append(ring.NewShardNodeReplicas, &pb.ShardNodeReplicaEndpoint{ReplicaId: int32(replica.ReplicaID), Ip: replica.IP, Port: int32(replica.Port)})
+	}
+	return ring
+}
+
+// ownershipRing is the hashing ring of the routers after a scale out.
+// It decides which blocks belong to this shard node.
+type ownershipRing struct {
+	ownerID int
+	ring    *utils.HashRing
+}
+
+func newOwnershipRing(shardNodeIDs []int, virtualNodes int, ownerID int) *ownershipRing {
+	ring := utils.NewHashRing(virtualNodes, utils.NewHasher(ownershipRingHashCacheSize))
+	for _, shardNodeID := range shardNodeIDs {
+		ring.AddNode(shardNodeID)
+	}
+	return &ownershipRing{ownerID: ownerID, ring: ring}
+}
+
+// Every shard node owns the padding block, so the fake requests of the routers always access the ORAM.
+func (o *ownershipRing) owns(block string) bool {
+	return block == utils.PaddingBlock || o.ring.GetNode(block) == o.ownerID
+}
+
+// It splits the requests of the batch into the requests that this shard node answers and the redirected ones.
+func (s *shardNodeServer) separateRedirectedRequests(request *pb.RequestBatch) (owned *pb.RequestBatch, redirected *pb.ReplyBatch) {
+	owned = &pb.RequestBatch{}
+	redirected = &pb.ReplyBatch{}
+	for _, readRequest := range request.ReadRequests {
+		if s.shardNodeFSM.isRedirected(readRequest.Block) {
+			redirected.ReadReplies = append(redirected.ReadReplies, &pb.ReadReply{RequestId: readRequest.RequestId, Redirected: true})
+		} else {
+			owned.ReadRequests = append(owned.ReadRequests, readRequest)
+		}
+	}
+	for _, writeRequest := range request.WriteRequests {
+		if s.shardNodeFSM.isRedirected(writeRequest.Block) {
+			redirected.WriteReplies = append(redirected.WriteReplies, &pb.WriteReply{RequestId: writeRequest.RequestId, Redirected: true})
+		} else {
+			owned.WriteRequests = append(owned.WriteRequests, writeRequest)
+		}
+	}
+	if len(redirected.ReadReplies) != 0 || len(redirected.WriteReplies) != 0 {
+		if migration, exists := s.shardNodeFSM.getMigration(); exists {
+			redirected.Ring = migration.toProto()
+		}
+	}
+	return owned, redirected
+}
+
+func (s *shardNodeServer) getMigratingBlocks(destinationID int) (blocks []string) {
+	s.shardNodeFSM.ownershipMu.RLock()
+	ownership := s.shardNodeFSM.ownership
+	s.shardNodeFSM.ownershipMu.RUnlock()
+	s.shardNodeFSM.positionMapMu.RLock()
+	defer s.shardNodeFSM.positionMapMu.RUnlock()
+	for block := range s.shardNodeFSM.positionMap {
+		if block != utils.PaddingBlock && ownership.ring.GetNode(block) == destinationID {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+func (s *shardNodeServer) getMigratedBlock(block string) *pb.MigratedBlock {
+	s.shardNodeFSM.stashMu.Lock()
+	stashState, inStash := s.shardNodeFSM.stash[block]
+	s.shardNodeFSM.stashMu.Unlock()
+	s.shardNodeFSM.positionMapMu.RLock()
+	position := s.shardNodeFSM.positionMap[block]
+	s.shardNodeFSM.positionMapMu.RUnlock()
+	return &pb.MigratedBlock{Block: block, Path: int32(position.path), StorageId: int32(position.storageID), InStash: inStash, Value: stashState.value, Tombstone: stashState.tombstone, Version: position.version}
+}
+
+// MigrateBlocks starts redirecting the blocks that belong to the destination on the new ring,
+// and then streams their position map entries and stash values.
+// A block is only sent after its requests in progress and its eviction finish, so it does not change after it is sent.
+// Calling it again for the same migration only sends the blocks that are not handed off yet,
+// and calling it for a ring whose migration has finished sends nothing.
+func (s *shardNodeServer) MigrateBlocks(request *pb.MigrateBlocksRequest, stream pb.ShardNode_MigrateBlocksServer) error {
+	if s.raftNode.State() != raft.Leader {
+		return fmt.Errorf(commonerrs.NotTheLeaderError)
+	}
+	log.Debug().Msgf("Received migrate blocks request %v", request)
+	if request.MigrationId == "" {
+		return fmt.Errorf("the migration id should not be empty")
+	}
+	shardNodeIDs := utils.ConvertInt32SliceToIntSlice(request.ShardNodeIds)
+	var destinationReplicas []ShardNodeReplicaPayload
+	for _, replica := range request.DestinationReplicas {
+		destinationReplicas = append(destinationReplicas, ShardNodeReplicaPayload{ReplicaID: int(replica.ReplicaId), IP: replica.Ip, Port: int(replica.Port)})
+	}
+	// The lock makes sure that the requests that were not redirected are in the request log before the blocks are sent.
+	s.migrationMu.Lock()
+	migration, exists := s.shardNodeFSM.getMigration()
+	if exists && migration.finished && migration.hasRing(shardNodeIDs, int(request.VirtualNodes)) {
+		s.migrationMu.Unlock()
+		log.Debug().Msgf("The migration to the ring %v has already finished", shardNodeIDs)
+		return nil
+	}
+	if exists && !migration.finished && migration.id != request.MigrationId {
+		s.migrationMu.Unlock()
+		return fmt.Errorf("migration %s is in progress", migration.id)
+	}
+	if !exists || migration.id != request.MigrationId {
+		command, err := newBeginMigrationReplicationCommand(request.MigrationId, shardNodeIDs, int(request.VirtualNodes), s.shardNodeServerID, int(request.DestinationShardNodeId), destinationReplicas)
+		if err != nil {
+			s.migrationMu.Unlock()
+			return fmt.Errorf("could not create begin migration replication command; %s", err)
+		}
+		err = s.raftNode.Apply(command, 0).Error()
+		if err != nil {
+			s.migrationMu.Unlock()
+			return fmt.Errorf("could not apply log to the FSM; %s", err)
+		}
+	}
+	s.migrationMu.Unlock()
+
+	for _, block := range s.getMigratingBlocks(int(request.DestinationShardNodeId)) {
+		for s.shardNodeFSM.isBlockBusy(block) {
+			select {
+			case <-stream.Context().Done():
+				return stream.Context().Err()
+			case <-time.After(migrationPollInterval):
+			}
+		}
+		err := stream.Send(s.getMigratedBlock(block))
+		if err != nil {
+			return fmt.Errorf("could not send migrated block %s; %s", block, err)
+		}
+	}
+	return nil
+}
+
+func (s *shardNodeServer) replicateMigratedBlocks(blocks []MigratedBlockPayload) error {
+	command, err := newMigratedBlocksReplicationCommand(blocks)
+	if err != nil {
+		return fmt.Errorf("could not create migrated blocks replication command; %s", err)
+	}
+	err = s.raftNode.Apply(command, 0).Error()
+	if err != nil {
+		return fmt.Errorf("could not apply log to the FSM; %s", err)
+	}
+	return nil
+}
+
+// ReceiveMigratedBlocks adds the streamed blocks to the position map and the stash of the destination shard node.
+func (s *shardNodeServer) ReceiveMigratedBlocks(stream pb.ShardNode_ReceiveMigratedBlocksServer) error {
+	if s.raftNode.State() != raft.Leader {
+		return fmt.Errorf(commonerrs.NotTheLeaderError)
+	}
+	receivedBlocks := 0
+	var blocks []MigratedBlockPayload
+	for {
+		block, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not receive migrated block; %s", err)
+		}
+		blocks = append(blocks, MigratedBlockPayload{Block: block.Block, Path: int(block.Path), StorageID: int(block.StorageId), InStash: block.InStash, Value: block.Value, Tombstone: block.Tombstone, Version: block.Version})
+		if len(blocks) == migratedBlocksBatchSize {
+			err := s.replicateMigratedBlocks(blocks)
+			if err != nil {
+				return err
+			}
+			receivedBlocks += len(blocks)
+			blocks = nil
+		}
+	}
+	if len(blocks) != 0 {
+		err := s.replicateMigratedBlocks(blocks)
+		if err != nil {
+			return err
+		}
+		receivedBlocks += len(blocks)
+	}
+	log.Debug().Msgf("Received %d migrated blocks", receivedBlocks)
+	return stream.SendAndClose(&pb.ReceiveMigratedBlocksReply{ReceivedBlocks: int32(receivedBlocks)})
+}
+
+// It removes the blocks that do not belong to this shard node on the ring of the migration.
+func (s *shardNodeServer) finishMigration(migration migrationState) error {
+	ownership := newOwnershipRing(migration.shardNodeIDs, migration.virtualNodes, s.shardNodeServerID)
+	var removedBlocks []string
+	s.shardNodeFSM.positionMapMu.RLock()
+	for block := range s.shardNodeFSM.positionMap {
+		if !ownership.owns(block) {
+			removedBlocks = append(removedBlocks, block)
+		}
+	}
+	s.shardNodeFSM.positionMapMu.RUnlock()
+	command, err := newFinishMigrationReplicationCommand(migration.id, removedBlocks)
+	if err != nil {
+		return fmt.Errorf("could not create finish migration replication command; %s", err)
+	}
+	err = s.raftNode.Apply(command, 0).Error()
+	if err != nil {
+		return fmt.Errorf("could not apply log to the FSM; %s", err)
+	}
+	log.Debug().Msgf("Removed %d migrated blocks", len(removedBlocks))
+	return nil
+}
+
+// FinishMigration removes the blocks that do not belong to this shard node on the new ring.
+// It should be called after the destination has committed the migration.
+func (s *shardNodeServer) FinishMigration(ctx context.Context, request *pb.FinishMigrationRequest) (*pb.FinishMigrationReply, error) {
+	if s.raftNode.State() != raft.Leader {
+		return nil, fmt.Errorf(commonerrs.NotTheLeaderError)
+	}
+	migration, exists := s.shardNodeFSM.getMigration()
+	if exists && migration.finished && migration.hasRing(utils.ConvertInt32SliceToIntSlice(request.ShardNodeIds), int(request.VirtualNodes)) {
+		return &pb.FinishMigrationReply{Success: true}, nil
+	}
+	if !exists || migration.finished || migration.id != request.MigrationId {
+		return nil, fmt.Errorf("migration %s is not in progress", request.MigrationId)
+	}
+	err := s.finishMigration(migration)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.FinishMigrationReply{Success: true}, nil
+}
+
+// DecideMigration keeps the first decision for a migration to this shard node and returns it.
+func (s *shardNodeServer) DecideMigration(ctx context.Context, request *pb.DecideMigrationRequest) (*pb.DecideMigrationReply, error) {
+	if s.raftNode.State() != raft.Leader {
+		return nil, fmt.Errorf(commonerrs.NotTheLeaderError)
+	}
+	if request.MigrationId == "" {
+		return nil, fmt.Errorf("the migration id should not be empty")
+	}
+	command, err := newMigrationDecisionReplicationCommand(request.MigrationId, request.Commit)
+	if err != nil {
+		return nil, fmt.Errorf("could not create migration decision replication command; %s", err)
+	}
+	future := s.raftNode.Apply(command, 0)
+	err = future.Error()
+	if err != nil {
+		return nil, fmt.Errorf("could not apply log to the FSM; %s", err)
+	}
+	committed := future.Response().(bool)
+	log.Debug().Msgf("Migration %s is committed: %t", request.MigrationId, committed)
+	return &pb.DecideMigrationReply{Committed: committed}, nil
+}
+
+// It asks the destination of the migration to abort it, and finishes the migration instead if the destination has committed it.
+func (s *shardNodeServer) resolveMigration(ctx context.Context, migration migrationState) error {
+	var endpoints []config.ShardNodeEndpoint
+	for _, replica := range migration.destinationReplicas {
+		endpoints = append(endpoints, config.ShardNodeEndpoint{ID: migration.destinationID, ReplicaID: replica.ReplicaID, IP: replica.IP, Port: replica.Port})
+	}
+	clients, err := startShardNodeRPCClients(endpoints, s.dialOptions...)
+	if err != nil {
+		return fmt.Errorf("could not connect to shard node %d; %s", migration.destinationID, err)
+	}
+	defer clients.close()
+	reply, err := clients.decideMigrationOnAllReplicas(ctx, &pb.DecideMigrationRequest{MigrationId: migration.id, Commit: false})
+	if err != nil {
+		return fmt.Errorf("could not get the decision of migration %s; %s", migration.id, err)
+	}
+	if reply.Committed {
+		log.Debug().Msgf("Finishing migration %s since it was committed", migration.id)
+		return s.finishMigration(migration)
+	}
+	log.Debug().Msgf("Aborting migration %s since it did not finish in time", migration.id)
+	command, err := newAbortMigrationReplicationCommand(migration.id)
+	if err != nil {
+		return fmt.Errorf("could not create abort migration replication command; %s", err)
+	}
+	err = s.raftNode.Apply(command, 0).Error()
+	if err != nil {
+		return fmt.Errorf("could not apply log to the FSM; %s", err)
+	}
+	return nil
+}
+
+// It resolves the migration from this shard node if it does not finish before the timeout, until the server stops.
+// The router that moves the blocks may crash before it finishes the migration,
+// so the shard node finishes the migration if the destination committed it and aborts it otherwise.
+// The timeout is measured from when the leader first saw the migration in progress.
+func (s *shardNodeServer) resolveInterruptedMigrationsForever(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultMigrationTimeout
+	}
+	waitingMigrationID := ""
+	var waitingSince time.Time
+	for {
+		select {
+		case <-time.After(timeout / 4):
+		case <-s.stop:
+			return
+		}
+		migration, exists := s.shardNodeFSM.getMigration()
+		if s.raftNode.State() != raft.Leader || !exists || migration.finished {
+			waitingMigrationID = ""
+			continue
+		}
+		if migration.id != waitingMigrationID {
+			waitingMigrationID = migration.id
+			waitingSince = time.Now()
+			continue
+		}
+		if time.Since(waitingSince) < timeout {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout/4)
+		err := s.resolveMigration(ctx, migration)
+		cancel()
+		if err != nil {
+			log.Error().Msgf("Could not resolve migration %s; %s", migration.id, err)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package shardnode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/utils"
+	"github.com/hashicorp/raft"
+	"google.golang.org/grpc"
+)
+
+type mockMigrateBlocksStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	blocks []*shardnodepb.MigratedBlock
+}
+
+func (m *mockMigrateBlocksStream) Send(block *shardnodepb.MigratedBlock) error {
+	m.blocks = append(m.blocks, block)
+	return nil
+}
+
+func (m *mockMigrateBlocksStream) Context() context.Context {
+	return m.ctx
+}
+
+type mockReceiveMigratedBlocksStream struct {
+	grpc.ServerStream
+	blocks []*shardnodepb.MigratedBlock
+	reply  *shardnodepb.ReceiveMigratedBlocksReply
+}
+
+func (m *mockReceiveMigratedBlocksStream) Recv() (*shardnodepb.MigratedBlock, error) {
+	if len(m.blocks) == 0 {
+		return nil, io.EOF
+	}
+	block := m.blocks[0]
+	m.blocks = m.blocks[1:]
+	return block, nil
+}
+
+func (m *mockReceiveMigratedBlocksStream) SendAndClose(reply *shardnodepb.ReceiveMigratedBlocksReply) error {
+	m.reply = reply
+	return nil
+}
+
+// It returns count blocks that belong to the owner on a ring with shard nodes 0 and 1.
+func getBlocksOwnedBy(owner int, count int) (blocks []string) {
+	ownership := newOwnershipRing([]int{0, 1}, 10, owner)
+	for i := 0; len(blocks) < count; i++ {
+		block := fmt.Sprintf("block%d", i)
+		if ownership.owns(block) {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+func TestHandleReplicateBeginMigrationRedirectsBlocksOfOtherShardNodes(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	kept := getBlocksOwnedBy(0, 1)[0]
+	moved := getBlocksOwnedBy(1, 1)[0]
+	if fsm.isRedirected(moved) {
+		t.Errorf("blocks should not be redirected before a migration")
+	}
+	fsm.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: 0})
+	if !fsm.isRedirected(moved) {
+		t.Errorf("expected block %s to be redirected", moved)
+	}
+	if fsm.isRedirected(kept) {
+		t.Errorf("expected block %s to not be redirected", kept)
+	}
+}
+
+func TestHandleReplicateMigratedBlocksAddsBlocksToPositionMapAndStash(t *testing.T) {
+	fsm := newShardNodeFSM(1)
+	fsm.handleReplicateMigratedBlocks(ReplicateMigratedBlocksPayload{
+		Blocks: []MigratedBlockPayload{
+			{Block: "a", Path: 3, StorageID: 2, InStash: true, Value: "valA"},
+			{Block: "b", Path: 5, StorageID: 1},
+		},
+	})
+	if fsm.positionMap["a"] != (positionState{path: 3, storageID: 2}) || fsm.positionMap["b"] != (positionState{path: 5, storageID: 1}) {
+		t.Errorf("expected migrated blocks in the position map but got %v", fsm.positionMap)
+	}
+	if fsm.stash["a"].value != "valA" {
+		t.Errorf("expected block a in the stash with value valA but got %v", fsm.stash["a"])
+	}
+	if _, exists := fsm.stash["b"]; exists {
+		t.Errorf("block b was not in the stash of the source and should not be added to the stash")
+	}
+}
+
+func TestHandleReplicateFinishMigrationRemovesBlocks(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	fsm.positionMap["a"] = positionState{path: 1, storageID: 1}
+	fsm.positionMap["b"] = positionState{path: 2, storageID: 1}
+	fsm.stash["a"] = stashState{value: "valA"}
+	fsm.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{MigrationID: "m", ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: 0})
+	fsm.handleReplicateFinishMigration(ReplicateFinishMigrationPayload{MigrationID: "m", RemovedBlocks: []string{"a"}})
+	if _, exists := fsm.positionMap["a"]; exists {
+		t.Errorf("expected block a to be removed from the position map")
+	}
+	if _, exists := fsm.stash["a"]; exists {
+		t.Errorf("expected block a to be removed from the stash")
+	}
+	if _, exists := fsm.positionMap["b"]; !exists {
+		t.Errorf("expected block b to stay in the position map")
+	}
+}
+
+func TestHandleReplicateAbortMigrationRestoresTheOwnership(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	moved := getBlocksOwnedBy(1, 1)[0]
+	fsm.positionMap[moved] = positionState{path: 1, storageID: 1}
+	fsm.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{MigrationID: "m", ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: 0})
+	fsm.handleReplicateAbortMigration(ReplicateAbortMigrationPayload{MigrationID: "m"})
+	if fsm.isRedirected(moved) {
+		t.Errorf("expected block %s to belong to the shard node again after the abort", moved)
+	}
+	if _, exists := fsm.getMigration(); exists {
+		t.Errorf("expected no migration after the abort")
+	}
+	fsm.handleReplicateFinishMigration(ReplicateFinishMigrationPayload{MigrationID: "m", RemovedBlocks: []string{moved}})
+	if _, exists := fsm.positionMap[moved]; !exists {
+		t.Errorf("the finish of an aborted migration should not remove blocks")
+	}
+}
+
+func TestHandleReplicateAbortMigrationIsIgnoredAfterTheFinish(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	moved := getBlocksOwnedBy(1, 1)[0]
+	fsm.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{MigrationID: "m", ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: 0})
+	fsm.handleReplicateFinishMigration(ReplicateFinishMigrationPayload{MigrationID: "m"})
+	fsm.handleReplicateAbortMigration(ReplicateAbortMigrationPayload{MigrationID: "m"})
+	if !fsm.isRedirected(moved) {
+		t.Errorf("expected block %s to stay redirected after the migration finished", moved)
+	}
+}
+
+func TestHandleReplicateMigrationDecisionKeepsTheFirstDecision(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	if committed := fsm.handleReplicateMigrationDecision(ReplicateMigrationDecisionPayload{MigrationID: "m", Commit: false}); committed {
+		t.Errorf("expected the migration to be aborted")
+	}
+	if committed := fsm.handleReplicateMigrationDecision(ReplicateMigrationDecisionPayload{MigrationID: "m", Commit: true}); committed {
+		t.Errorf("a later commit should not change the decision")
+	}
+	if committed := fsm.handleReplicateMigrationDecision(ReplicateMigrationDecisionPayload{MigrationID: "other", Commit: true}); !committed {
+		t.Errorf("expected the other migration to be committed")
+	}
+}
+
+func TestIsBlockBusyForRequestsAndWaitingBlocks(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	fsm.requestLog["a"] = []string{"request1"}
+	fsm.stash["b"] = stashState{value: "valB", waitingStatus: true}
+	fsm.stash["c"] = stashState{value: "valC"}
+	if !fsm.isBlockBusy("a") || !fsm.isBlockBusy("b") {
+		t.Errorf("blocks with requests in progress or waiting for an eviction should be busy")
+	}
+	if fsm.isBlockBusy("c") {
+		t.Errorf("block c should not be busy")
+	}
+}
+
+func TestSeparateRedirectedRequestsRepliesWithRedirectForMovedBlocks(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), nil, map[int]int{0: 0}, 5, newBatchManager(1))
+	s.shardNodeFSM.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: 0})
+	kept := getBlocksOwnedBy(0, 1)[0]
+	moved := getBlocksOwnedBy(1, 1)[0]
+	owned, redirected := s.separateRedirectedRequests(&shardnodepb.RequestBatch{
+		ReadRequests:  []*shardnodepb.ReadRequest{{Block: kept, RequestId: "request1"}, {Block: moved, RequestId: "request2"}},
+		WriteRequests: []*shardnodepb.WriteRequest{{Block: moved, RequestId: "request3", Value: "val"}},
+	})
+	if len(owned.ReadRequests) != 1 || owned.ReadRequests[0].RequestId != "request1" || len(owned.WriteRequests) != 0 {
+		t.Errorf("expected only request1 to be answered by the shard node but got %v", owned)
+	}
+	if len(redirected.ReadReplies) != 1 || !redirected.ReadReplies[0].Redirected || redirected.ReadReplies[0].RequestId != "request2" {
+		t.Errorf("expected request2 to be redirected but got %v", redirected.ReadReplies)
+	}
+	if len(redirected.WriteReplies) != 1 || !redirected.WriteReplies[0].Redirected || redirected.WriteReplies[0].RequestId != "request3" {
+		t.Errorf("expected request3 to be redirected but got %v", redirected.WriteReplies)
+	}
+}
+
+func TestSeparateRedirectedRequestsRepliesWithTheRingOfTheMigration(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), nil, map[int]int{0: 0}, 5, newBatchManager(1))
+	s.shardNodeFSM.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{
+		MigrationID:         "m",
+		ShardNodeIDs:        []int{0, 1},
+		VirtualNodes:        10,
+		OwnerID:             0,
+		DestinationID:       1,
+		DestinationReplicas: []ShardNodeReplicaPayload{{ReplicaID: 2, IP: "localhost", Port: 1234}},
+	})
+	moved := getBlocksOwnedBy(1, 1)[0]
+	_, redirected := s.separateRedirectedRequests(&shardnodepb.RequestBatch{ReadRequests: []*shardnodepb.ReadRequest{{Block: moved, RequestId: "request1"}}})
+	ring := redirected.Ring
+	if ring == nil || ring.Finished || ring.NewShardNodeId != 1 || len(ring.ShardNodeIds) != 2 || len(ring.NewShardNodeReplicas) != 1 || ring.NewShardNodeReplicas[0].Port != 1234 {
+		t.Errorf("expected the ring of the migration in progress but got %v", ring)
+	}
+	s.shardNodeFSM.handleReplicateFinishMigration(ReplicateFinishMigrationPayload{MigrationID: "m"})
+	_, redirected = s.separateRedirectedRequests(&shardnodepb.RequestBatch{ReadRequests: []*shardnodepb.ReadRequest{{Block: moved, RequestId: "request1"}}})
+	if redirected.Ring == nil || !redirected.Ring.Finished {
+		t.Errorf("expected the ring of the finished migration but got %v", redirected.Ring)
+	}
+}
+
+func TestSeparateRedirectedRequestsAnswersThePaddingBlockOnEveryShardNode(t *testing.T) {
+	for _, ownerID := range []int{0, 1} {
+		s := newShardNodeServer(ownerID, 0, &raft.Raft{}, newShardNodeFSM(0), nil, map[int]int{0: 0}, 5, newBatchManager(1))
+		s.shardNodeFSM.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: ownerID})
+		owned, redirected := s.separateRedirectedRequests(&shardnodepb.RequestBatch{
+			ReadRequests: []*shardnodepb.ReadRequest{{Block: utils.PaddingBlock, RequestId: "padding"}},
+		})
+		if len(owned.ReadRequests) != 1 || len(redirected.ReadReplies) != 0 {
+			t.Errorf("expected shard node %d to answer the padding request but got redirected replies %v", ownerID, redirected.ReadReplies)
+		}
+	}
+}
+
+func TestMigrateBlocksSendsOnlyBlocksOfDestination(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	kept := getBlocksOwnedBy(0, 2)
+	moved := getBlocksOwnedBy(1, 2)
+	for i, block := range append(kept, moved...) {
+		s.shardNodeFSM.positionMap[block] = positionState{path: i, storageID: 1}
+	}
+	s.shardNodeFSM.stash[moved[0]] = stashState{value: "movedValue"}
+
+	stream := &mockMigrateBlocksStream{ctx: context.Background()}
+	err := s.MigrateBlocks(&shardnodepb.MigrateBlocksRequest{ShardNodeIds: []int32{0, 1}, VirtualNodes: 10, DestinationShardNodeId: 1, MigrationId: "m"}, stream)
+	if err != nil {
+		t.Errorf("expected successful migration but got %s", err)
+	}
+	if len(stream.blocks) != 2 {
+		t.Errorf("expected blocks %v to be sent but got %v", moved, stream.blocks)
+	}
+	for _, block := range stream.blocks {
+		if block.Block != moved[0] && block.Block != moved[1] {
+			t.Errorf("block %s does not belong to the destination", block.Block)
+		}
+		if block.Block == moved[0] && (!block.InStash || block.Value != "movedValue") {
+			t.Errorf("expected the stash value of %s to be sent but got %v", moved[0], block)
+		}
+	}
+	if !s.shardNodeFSM.isRedirected(moved[0]) {
+		t.Errorf("migrating blocks should be redirected")
+	}
+}
+
+func TestMigrateBlocksReturnsErrorIfBusyBlockIsNotReleased(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	moved := getBlocksOwnedBy(1, 1)[0]
+	s.shardNodeFSM.positionMap[moved] = positionState{path: 1, storageID: 1}
+	s.shardNodeFSM.stash[moved] = stashState{value: "val", waitingStatus: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream := &mockMigrateBlocksStream{ctx: ctx}
+	err := s.MigrateBlocks(&shardnodepb.MigrateBlocksRequest{ShardNodeIds: []int32{0, 1}, VirtualNodes: 10, DestinationShardNodeId: 1, MigrationId: "m"}, stream)
+	if err == nil {
+		t.Errorf("expected an error when the stream is done before the block is released")
+	}
+	if len(stream.blocks) != 0 {
+		t.Errorf("busy blocks should not be sent")
+	}
+}
+
+func TestReceiveMigratedBlocksAddsBlocksAndRepliesWithCount(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	stream := &mockReceiveMigratedBlocksStream{
+		blocks: []*shardnodepb.MigratedBlock{
+			{Block: "a", Path: 1, StorageId: 2, InStash: true, Value: "valA"},
+			{Block: "b", Path: 3, StorageId: 4},
+		},
+	}
+	err := s.ReceiveMigratedBlocks(stream)
+	if err != nil {
+		t.Errorf("expected successful receive but got %s", err)
+	}
+	if stream.reply.ReceivedBlocks != 2 {
+		t.Errorf("expected 2 received blocks but got %d", stream.reply.ReceivedBlocks)
+	}
+	s.shardNodeFSM.positionMapMu.RLock()
+	defer s.shardNodeFSM.positionMapMu.RUnlock()
+	if s.shardNodeFSM.positionMap["b"] != (positionState{path: 3, storageID: 4}) {
+		t.Errorf("expected block b in the position map but got %v", s.shardNodeFSM.positionMap["b"])
+	}
+}
+
+func TestFinishMigrationRemovesBlocksOfOtherShardNodes(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	kept := getBlocksOwnedBy(0, 1)[0]
+	moved := getBlocksOwnedBy(1, 1)[0]
+	s.shardNodeFSM.positionMap[kept] = positionState{path: 1, storageID: 1}
+	s.shardNodeFSM.positionMap[moved] = positionState{path: 2, storageID: 1}
+
+	_, err := s.FinishMigration(context.Background(), &shardnodepb.FinishMigrationRequest{ShardNodeIds: []int32{0, 1}, VirtualNodes: 10, MigrationId: "m"})
+	if err == nil {
+		t.Errorf("expected an error for a migration that did not begin")
+	}
+	s.shardNodeFSM.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{MigrationID: "m", ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: 0})
+	_, err = s.FinishMigration(context.Background(), &shardnodepb.FinishMigrationRequest{ShardNodeIds: []int32{0, 1}, VirtualNodes: 10, MigrationId: "m"})
+	if err != nil {
+		t.Errorf("expected successful finish but got %s", err)
+	}
+	s.shardNodeFSM.positionMapMu.RLock()
+	defer s.shardNodeFSM.positionMapMu.RUnlock()
+	if _, exists := s.shardNodeFSM.positionMap[moved]; exists {
+		t.Errorf("expected block %s to be removed", moved)
+	}
+	if _, exists := s.shardNodeFSM.positionMap[kept]; !exists {
+		t.Errorf("expected block %s to stay", kept)
+	}
+}
+
+func TestMigrateBlocksRejectsAnotherMigrationInProgress(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	request := &shardnodepb.MigrateBlocksRequest{ShardNodeIds: []int32{0, 1}, VirtualNodes: 10, DestinationShardNodeId: 1, MigrationId: "m"}
+	err := s.MigrateBlocks(request, &mockMigrateBlocksStream{ctx: context.Background()})
+	if err != nil {
+		t.Errorf("expected successful migration but got %s", err)
+	}
+	request.MigrationId = "other"
+	err = s.MigrateBlocks(request, &mockMigrateBlocksStream{ctx: context.Background()})
+	if err == nil {
+		t.Errorf("expected an error while migration m is in progress")
+	}
+}
+
+func TestMigrateBlocksSendsNothingForAFinishedRing(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	moved := getBlocksOwnedBy(1, 1)[0]
+	s.shardNodeFSM.positionMap[moved] = positionState{path: 1, storageID: 1}
+	s.shardNodeFSM.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{MigrationID: "m", ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: 0})
+	s.shardNodeFSM.handleReplicateFinishMigration(ReplicateFinishMigrationPayload{MigrationID: "m"})
+	stream := &mockMigrateBlocksStream{ctx: context.Background()}
+	err := s.MigrateBlocks(&shardnodepb.MigrateBlocksRequest{ShardNodeIds: []int32{0, 1}, VirtualNodes: 10, DestinationShardNodeId: 1, MigrationId: "other"}, stream)
+	if err != nil || len(stream.blocks) != 0 {
+		t.Errorf("expected no blocks for a finished ring but got %v, %v", stream.blocks, err)
+	}
+	if migration, _ := s.shardNodeFSM.getMigration(); migration.id != "m" {
+		t.Errorf("expected the finished migration to stay but got %s", migration.id)
+	}
+}
+
+func TestDecideMigrationRepliesWithTheFirstDecision(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	reply, err := s.DecideMigration(context.Background(), &shardnodepb.DecideMigrationRequest{MigrationId: "m", Commit: true})
+	if err != nil || !reply.Committed {
+		t.Errorf("expected the migration to be committed but got %v, %v", reply, err)
+	}
+	reply, err = s.DecideMigration(context.Background(), &shardnodepb.DecideMigrationRequest{MigrationId: "m", Commit: false})
+	if err != nil || !reply.Committed {
+		t.Errorf("expected the migration to stay committed but got %v, %v", reply, err)
+	}
+}
+
+// It answers DecideMigration and DecideTransaction with the decisions of the map and keeps the first decision of the others.
+type decidingShardNodeServer struct {
+	shardnodepb.UnimplementedShardNodeServer
+	mu        sync.Mutex
+	decisions map[string]bool
+}
+
+func (d *decidingShardNodeServer) DecideMigration(ctx context.Context, request *shardnodepb.DecideMigrationRequest) (*shardnodepb.DecideMigrationReply, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, decided := d.decisions[request.MigrationId]; !decided {
+		d.decisions[request.MigrationId] = request.Commit
+	}
+	return &shardnodepb.DecideMigrationReply{Committed: d.decisions[request.MigrationId]}, nil
+}
+
+func (d *decidingShardNodeServer) DecideTransaction(ctx context.Context, request *shardnodepb.DecideTransactionRequest) (*shardnodepb.DecideTransactionReply, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, decided := d.decisions[request.TransactionId]; !decided {
+		d.decisions[request.TransactionId] = request.Commit
+	}
+	return &shardnodepb.DecideTransactionReply{Committed: d.decisions[request.TransactionId]}, nil
+}
+
+// It serves the destination on a local port and returns its replicas for the migration payload.
+func startDecidingDestination(t *testing.T, decisions map[string]bool) (*decidingShardNodeServer, []ShardNodeReplicaPayload) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen; %s", err)
+	}
+	grpcServer := grpc.NewServer()
+	destination := &decidingShardNodeServer{decisions: decisions}
+	shardnodepb.RegisterShardNodeServer(grpcServer, destination)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return destination, []ShardNodeReplicaPayload{{ReplicaID: 0, IP: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port}}
+}
+
+func TestResolveMigrationFinishesACommittedMigration(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	moved := getBlocksOwnedBy(1, 1)[0]
+	s.shardNodeFSM.positionMap[moved] = positionState{path: 1, storageID: 1}
+	_, replicas := startDecidingDestination(t, map[string]bool{"m": true})
+	s.shardNodeFSM.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{MigrationID: "m", ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: 0, DestinationID: 1, DestinationReplicas: replicas})
+	migration, _ := s.shardNodeFSM.getMigration()
+	err := s.resolveMigration(context.Background(), migration)
+	if err != nil {
+		t.Errorf("expected the migration to be resolved but got %s", err)
+	}
+	if migration, _ := s.shardNodeFSM.getMigration(); !migration.finished {
+		t.Errorf("expected the committed migration to be finished")
+	}
+	s.shardNodeFSM.positionMapMu.RLock()
+	defer s.shardNodeFSM.positionMapMu.RUnlock()
+	if _, exists := s.shardNodeFSM.positionMap[moved]; exists {
+		t.Errorf("expected block %s to be removed", moved)
+	}
+}
+
+func TestResolveMigrationAbortsAMigrationThatWasNotCommitted(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	moved := getBlocksOwnedBy(1, 1)[0]
+	s.shardNodeFSM.positionMap[moved] = positionState{path: 1, storageID: 1}
+	destination, replicas := startDecidingDestination(t, make(map[string]bool))
+	s.shardNodeFSM.handleReplicateBeginMigration(ReplicateBeginMigrationPayload{MigrationID: "m", ShardNodeIDs: []int{0, 1}, VirtualNodes: 10, OwnerID: 0, DestinationID: 1, DestinationReplicas: replicas})
+	migration, _ := s.shardNodeFSM.getMigration()
+	err := s.resolveMigration(context.Background(), migration)
+	if err != nil {
+		t.Errorf("expected the migration to be resolved but got %s", err)
+	}
+	if _, exists := s.shardNodeFSM.getMigration(); exists || s.shardNodeFSM.isRedirected(moved) {
+		t.Errorf("expected the migration to be aborted")
+	}
+	destination.mu.Lock()
+	committed, decided := destination.decisions["m"]
+	destination.mu.Unlock()
+	if !decided || committed {
+		t.Errorf("expected the destination to record the abort")
+	}
+	s.shardNodeFSM.positionMapMu.RLock()
+	defer s.shardNodeFSM.positionMapMu.RUnlock()
+	if _, exists := s.shardNodeFSM.positionMap[moved]; !exists {
+		t.Errorf("expected block %s to stay", moved)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 	"github.com/rs/zerolog/log"
@@ -20,11 +19,22 @@ type stashState struct {
 	value         string
 	logicalTime   int
 	waitingStatus bool
+	tombstone     bool // the block is deleted or was never written, it is dropped from the stash instead of being evicted
+}
+
+// It is the state of a block right after a request is applied.
+type blockResponse struct {
+	value    string
+	exists   bool
+	version  uint64
+	swapped  bool // only set for the compare and swap request that was applied
+	rejected bool // the write was not applied, since a transaction prepared the block
 }
 
 type positionState struct {
 	path      int
 	storageID int
+	version   uint64 // the number of changes of the block
 }
 
 func (p positionState) isPathInPaths(paths []int) bool {
@@ -36,32 +46,74 @@ func (p positionState) isPathInPaths(paths []int) bool {
 	return false
 }
 
+// The number of finished evictions that the FSM remembers.
+const finishedEvictionsWindowSize = 10000
+
 type shardNodeFSM struct {
-	requestLog      map[string][]string   // map of block to requesting requestIDs
-	pathMap         map[string]int        // map of requestID to new path
-	storageIDMap    map[string]int        // map of requestID to new storageID
-	stash           map[string]stashState // map of block to stashState
-	stashMu         sync.Mutex
-	responseChannel sync.Map                 // map of requestId to their channel for receiving response map[string] chan string
-	acks            map[string][]string      // map of requestID to array of blocks
-	nacks           map[string][]string      // map of requestID to array of blocks
-	positionMap     map[string]positionState // map of block to positionState
-	positionMapMu   sync.RWMutex
+	requestLog             map[string][]string   // map of block to requesting requestIDs
+	requestLogMu           sync.Mutex            // only needed for reading the requestLog outside of the FSM
+	requestLogTerm         uint64                // the raft term of the requests in the requestLog
+	pathMap                map[string]int        // map of requestID to new path
+	storageIDMap           map[string]int        // map of requestID to new storageID
+	stash                  map[string]stashState // map of block to stashState
+	stashMu                sync.Mutex
+	responseChannel        sync.Map                 // map of requestId to their channel for receiving response map[string] chan blockResponse
+	evictions              map[string][]SentBlock   // map of eviction id to the blocks that wait for its acks, it is guarded by stashMu
+	finishedEvictions      map[string]bool          // map of eviction id to whether it timed out for the last finished evictions, it is guarded by stashMu
+	finishedEvictionsOrder []string                 // the finished evictions from the oldest to the newest, it is guarded by stashMu
+	positionMap            map[string]positionState // map of block to positionState
+	positionMapMu          sync.RWMutex
+	keyIndex               *keyIndex      // the blocks of the position map in order, it is guarded by positionMapMu
+	ownership              *ownershipRing // nil if the shard node owns every block
+	ownershipMu            sync.RWMutex
+	migration              *migrationState // the last migration from this shard node, it is guarded by ownershipMu
+	// map of migration id to whether it is committed, for the migrations to this shard node.
+	// It is guarded by ownershipMu.
+	migrationDecisions map[string]bool
+	addedStorages      map[int]addedStorage // map of storageID to the storages added after the start
+	addedStoragesMu    sync.RWMutex
+	preparedWrites     map[string]map[string]string // map of transactionID to the prepared writes of the transaction (map of block to value)
+	preparedBlocks     map[string]string            // map of block to the transactionID that prepared a write for it
+	// map of transactionID to the replicas of the shard node that records the decision, for the prepared transactions.
+	// It is guarded by preparedMu.
+	preparedCoordinators map[string][]ShardNodeReplicaPayload
+	// map of transactionID to whether it is committed, for the transactions that this shard node coordinates.
+	// They are guarded by preparedMu.
+	transactionDecisions      map[string]bool
+	transactionDecisionsOrder []transactionDecision // the decisions from the oldest to the newest
+	preparedMu                sync.Mutex            // it is acquired after stashMu if both are needed
+	// The responses of the writes with an idempotency key that were applied in the idempotency window,
+	// map of block and key to the response. They are guarded by stashMu.
+	appliedWrites      map[string]blockResponse
+	appliedWritesOrder []appliedWrite // the writes from the oldest to the newest
+	// map of block to the requests that wait for the response of the block, in the order that they were replicated.
+	// Unlike the requestLog, every replica tracks them, since the concurrent requests are applied with the first one.
+	// It is guarded by stashMu.
+	pendingRequests map[string][]ReplicateRequestAndPathAndStoragePayload
 
 	replicaID int
 }
 
 func newShardNodeFSM(replicaID int) *shardNodeFSM {
 	return &shardNodeFSM{
-		requestLog:      make(map[string][]string),
-		pathMap:         make(map[string]int),
-		storageIDMap:    make(map[string]int),
-		stash:           make(map[string]stashState),
-		responseChannel: sync.Map{},
-		acks:            make(map[string][]string),
-		nacks:           make(map[string][]string),
-		positionMap:     make(map[string]positionState),
-		replicaID:       replicaID,
+		requestLog:           make(map[string][]string),
+		pathMap:              make(map[string]int),
+		storageIDMap:         make(map[string]int),
+		stash:                make(map[string]stashState),
+		responseChannel:      sync.Map{},
+		evictions:            make(map[string][]SentBlock),
+		finishedEvictions:    make(map[string]bool),
+		positionMap:          make(map[string]positionState),
+		keyIndex:             newKeyIndex(),
+		addedStorages:        make(map[int]addedStorage),
+		migrationDecisions:   make(map[string]bool),
+		preparedWrites:       make(map[string]map[string]string),
+		preparedBlocks:       make(map[string]string),
+		preparedCoordinators: make(map[string][]ShardNodeReplicaPayload),
+		transactionDecisions: make(map[string]bool),
+		appliedWrites:        make(map[string]blockResponse),
+		pendingRequests:      make(map[string][]ReplicateRequestAndPathAndStoragePayload),
+		replicaID:            replicaID,
 	}
 }
 
@@ -71,9 +123,8 @@ func (fsm *shardNodeFSM) String() string {
 	out = out + fmt.Sprintf("pathMap: %v\n", fsm.pathMap)
 	out = out + fmt.Sprintf("storageIDMap: %v\n", fsm.storageIDMap)
 	out = out + fmt.Sprintf("stash: %v\n", fsm.stash)
-	out = out + fmt.Sprintf("responseChannel: %v\n", fsm.responseChannel)
-	out = out + fmt.Sprintf("acks: %v\n", fsm.acks)
-	out = out + fmt.Sprintf("nacks: %v\n", fsm.nacks)
+	out = out + fmt.Sprintf("responseChannel: %v\n", &fsm.responseChannel)
+	out = out + fmt.Sprintf("evictions: %v\n", fsm.evictions)
 	out = out + fmt.Sprintf("position map: %v\n", fsm.positionMap)
 	return out
 }
@@ -84,6 +135,19 @@ func (fsm *shardNodeFSM) printStashSize() {
 	fmt.Println("stash size: ", len(fsm.stash))
 }
 
+// The requests that this replica replicated in an earlier term never get their responses,
+// since the first request of a block can not replicate its response after the replica loses the leadership.
+// They are forgotten when the replica replicates requests in a later term, so that the new requests of their blocks do not wait for them.
+// It also forgets the requests that a restarted replica applies again from its log.
+func (fsm *shardNodeFSM) forgetRequestsOfEarlierTerms(term uint64) {
+	fsm.requestLogMu.Lock()
+	defer fsm.requestLogMu.Unlock()
+	if term > fsm.requestLogTerm {
+		fsm.requestLog = make(map[string][]string)
+		fsm.requestLogTerm = term
+	}
+}
+
 func (fsm *shardNodeFSM) handleBatchReplicateRequestAndPathAndStorage(p BatchReplicateRequestAndPathAndStoragePayload) (isFirstMap map[string]bool) {
 	isFirstMap = make(map[string]bool)
 	for _, r := range p.Requests {
@@ -91,10 +155,15 @@ func (fsm *shardNodeFSM) handleBatchReplicateRequestAndPathAndStorage(p BatchRep
 		// This is to avoid the new leader to get stuck because of the old leader's requestLog
 		// If we don't do this, the new leader will think that it only should send fake requests for the blocks that are in the requestLog
 		if p.LeaderID == fsm.replicaID {
+			fsm.requestLogMu.Lock()
 			fsm.requestLog[r.RequestedBlock] = append(fsm.requestLog[r.RequestedBlock], r.RequestID)
+			fsm.requestLogMu.Unlock()
 		}
 		fsm.pathMap[r.RequestID] = r.Path
 		fsm.storageIDMap[r.RequestID] = r.StorageID
+		fsm.stashMu.Lock()
+		fsm.pendingRequests[r.RequestedBlock] = append(fsm.pendingRequests[r.RequestedBlock], r)
+		fsm.stashMu.Unlock()
 		if len(fsm.requestLog[r.RequestedBlock]) == 1 {
 			isFirstMap[r.RequestID] = true
 		} else {
@@ -104,46 +173,133 @@ func (fsm *shardNodeFSM) handleBatchReplicateRequestAndPathAndStorage(p BatchRep
 	return isFirstMap
 }
 
-func (fsm *shardNodeFSM) handleReplicateResponse(r ReplicateResponsePayload) string {
+type appliedWrite struct {
+	key       string
+	appliedAt time.Time
+}
+
+// It remembers the response of a write with an idempotency key.
+// The caller should hold stashMu.
+func (fsm *shardNodeFSM) recordAppliedWrite(key string, response blockResponse, appliedAt time.Time) {
+	fsm.appliedWrites[key] = response
+	fsm.appliedWritesOrder = append(fsm.appliedWritesOrder, appliedWrite{key: key, appliedAt: appliedAt})
+}
+
+// It forgets the writes with an idempotency key that were applied before forgetBefore.
+// The times are set by the leaders, so every replica forgets the same writes.
+// The caller should hold stashMu.
+func (fsm *shardNodeFSM) forgetAppliedWrites(forgetBefore time.Time) {
+	for len(fsm.appliedWritesOrder) != 0 && fsm.appliedWritesOrder[0].appliedAt.Before(forgetBefore) {
+		delete(fsm.appliedWrites, fsm.appliedWritesOrder[0].key)
+		fsm.appliedWritesOrder = fsm.appliedWritesOrder[1:]
+	}
+}
+
+// It applies the operation of a request to the state of the block and returns the response of the request.
+// A write whose idempotency key was applied in the idempotency window is not applied again and gets the response of the first write.
+// A write to a block that a transaction prepared is rejected, since the commit of the transaction would overwrite it.
+// The rejected write is not recorded for its idempotency key, so it can be retried.
+// The caller should hold stashMu and positionMapMu.
+func (fsm *shardNodeFSM) applyOperation(block string, state *stashState, inStash bool, position *positionState, operation ReplicateRequestAndPathAndStoragePayload, appliedAt time.Time) blockResponse {
+	appliedWriteKey := ""
+	if operation.IdempotencyKey != "" {
+		appliedWriteKey = block + "/" + operation.IdempotencyKey
+		if response, isDuplicate := fsm.appliedWrites[appliedWriteKey]; isDuplicate {
+			log.Debug().Msgf("Write with idempotency key %s for block %s was already applied", operation.IdempotencyKey, block)
+			return response
+		}
+	}
+	if operation.OpType != Read && fsm.isPrepared(block) {
+		log.Debug().Msgf("Rejecting write to block %s since a transaction prepared it", block)
+		return blockResponse{value: state.value, exists: !state.tombstone, version: position.version, rejected: true}
+	}
+	swapped := false
+	if operation.OpType == CompareAndSwap {
+		swapped = state.value == operation.Expected && (operation.ExpectedVersion == 0 || position.version == operation.ExpectedVersion)
+	}
+	if operation.OpType == Write || swapped {
+		if inStash {
+			state.logicalTime++
+		}
+		state.value = operation.NewValue
+		state.tombstone = false
+		position.version++
+	} else if operation.OpType == Delete && !state.tombstone {
+		if inStash {
+			state.logicalTime++
+		}
+		state.value = ""
+		state.tombstone = true
+		position.version++
+	}
+	response := blockResponse{value: state.value, exists: !state.tombstone, version: position.version, swapped: swapped}
+	if appliedWriteKey != "" {
+		fsm.recordAppliedWrite(appliedWriteKey, response, appliedAt)
+	}
+	return response
+}
+
+// A block that is not in the stash exists if it has a position, since it is in the storage then.
+// The tombstones of the deleted blocks and of the blocks that were never written stay in the stash until they are dropped.
+// The version of the block is kept in its position, so that it is not lost when the block is evicted.
+// The requests that are concurrent with the first request of the block are applied after it, in the order that they were replicated.
+func (fsm *shardNodeFSM) handleReplicateResponse(r ReplicateResponsePayload) blockResponse {
 	requestID := r.RequestID
 
 	fsm.stashMu.Lock()
+	fsm.positionMapMu.Lock()
+	fsm.forgetAppliedWrites(r.ForgetBefore)
+	position, hasPosition := fsm.positionMap[r.RequestedBlock]
 	stashState, exists := fsm.stash[r.RequestedBlock]
-	if exists {
-		if r.OpType == Write {
-			stashState.logicalTime++
-			stashState.value = r.NewValue
-			fsm.stash[r.RequestedBlock] = stashState
+	if !exists {
+		// The response of a block without a position comes from a random path, so it is not the value of the block.
+		if hasPosition {
+			stashState.value = r.Response
+		} else {
+			stashState.tombstone = true
 		}
-	} else {
-		response := r.Response
-		stashState := fsm.stash[r.RequestedBlock]
-		if r.OpType == Read {
-			stashState.value = response
-			fsm.stash[r.RequestedBlock] = stashState
-		} else if r.OpType == Write {
-			stashState.value = r.NewValue
-			fsm.stash[r.RequestedBlock] = stashState
+	}
+	response := fsm.applyOperation(r.RequestedBlock, &stashState, exists, &position, ReplicateRequestAndPathAndStoragePayload{
+		OpType:          r.OpType,
+		NewValue:        r.NewValue,
+		Expected:        r.Expected,
+		ExpectedVersion: r.ExpectedVersion,
+		IdempotencyKey:  r.IdempotencyKey,
+	}, r.AppliedAt)
+	concurrentResponses := make(map[string]blockResponse)
+	for _, concurrentRequest := range fsm.pendingRequests[r.RequestedBlock] {
+		if concurrentRequest.RequestID != requestID {
+			concurrentResponses[concurrentRequest.RequestID] = fsm.applyOperation(r.RequestedBlock, &stashState, exists, &position, concurrentRequest, r.AppliedAt)
 		}
 	}
-	stashValue := fsm.stash[r.RequestedBlock].value
-	fsm.stashMu.Unlock()
-	fsm.positionMapMu.Lock()
-	fsm.positionMap[r.RequestedBlock] = positionState{path: fsm.pathMap[requestID], storageID: fsm.storageIDMap[requestID]}
+	delete(fsm.pendingRequests, r.RequestedBlock)
+	fsm.stash[r.RequestedBlock] = stashState
+	position.path = fsm.pathMap[requestID]
+	position.storageID = fsm.storageIDMap[requestID]
+	fsm.setPosition(r.RequestedBlock, position)
+	// The concurrent requests that were not replicated with their operation only get the state of the block
+	stashValue := blockResponse{value: stashState.value, exists: !stashState.tombstone, version: position.version}
 	fsm.positionMapMu.Unlock()
+	fsm.stashMu.Unlock()
 	if fsm.replicaID == r.LeaderID {
 		for i := len(fsm.requestLog[r.RequestedBlock]) - 1; i >= 1; i-- { // We don't need to send the response to the first request
 			log.Debug().Msgf("Sending response to concurrent request number %d in requestLog for block %s", i, r.RequestedBlock)
 			timeout := time.After(5 * time.Second) // TODO: think about this in the batching scenario
 			responseChan, exists := fsm.responseChannel.Load(fsm.requestLog[r.RequestedBlock][i])
 			if !exists {
-				log.Fatal().Msgf("response channel for request %s does not exist", fsm.requestLog[r.RequestedBlock][i])
+				// A restarted replica applies the requests of its log again, but their clients are gone
+				log.Error().Msgf("response channel for request %s does not exist", fsm.requestLog[r.RequestedBlock][i])
+				continue
+			}
+			concurrentResponse, applied := concurrentResponses[fsm.requestLog[r.RequestedBlock][i]]
+			if !applied {
+				concurrentResponse = stashValue
 			}
 			select {
 			case <-timeout:
 				log.Error().Msgf("timeout in sending response to concurrent request number %d in requestLog for block %s", i, r.RequestedBlock)
 				continue
-			case responseChan.(chan string) <- stashValue:
+			case responseChan.(chan blockResponse) <- concurrentResponse:
 				log.Debug().Msgf("sent response to concurrent request number %d in requestLog for block %s", i, r.RequestedBlock)
 				delete(fsm.pathMap, fsm.requestLog[r.RequestedBlock][i])
 				delete(fsm.storageIDMap, fsm.requestLog[r.RequestedBlock][i])
@@ -154,61 +310,393 @@ func (fsm *shardNodeFSM) handleReplicateResponse(r ReplicateResponsePayload) str
 	delete(fsm.pathMap, requestID)
 	delete(fsm.storageIDMap, requestID)
 	fsm.responseChannel.Delete(requestID)
+	fsm.requestLogMu.Lock()
 	delete(fsm.requestLog, r.RequestedBlock)
-	return stashValue
+	fsm.requestLogMu.Unlock()
+	return response
 }
 
+// It marks the sent blocks as waiting for the acks of the eviction and drops the tombstones.
+// A dropped tombstone is not in the storage, so the block does not exist after its position is removed.
+// The blocks of an eviction are only sent once, so a retried or replayed request of the eviction changes nothing, even after it finished.
 func (fsm *shardNodeFSM) handleReplicateSentBlocks(r ReplicateSentBlocksPayload) {
 	log.Debug().Msgf("Aquiring lock for shardNodeFSM in handleReplicateSentBlocks")
 	fsm.stashMu.Lock()
+	fsm.positionMapMu.Lock()
 	log.Debug().Msgf("Aquired lock for shardNodeFSM in handleReplicateSentBlocks")
 	defer func() {
 		log.Debug().Msgf("Releasing lock for shardNodeFSM in handleReplicateSentBlocks")
+		fsm.positionMapMu.Unlock()
 		fsm.stashMu.Unlock()
 		log.Debug().Msgf("Released lock for shardNodeFSM in handleReplicateSentBlocks")
 	}()
 
-	for _, block := range r.SentBlocks {
-		stashState := fsm.stash[block]
-		stashState.logicalTime = 0
+	if _, exists := fsm.evictions[r.EvictionID]; exists {
+		log.Debug().Msgf("Ignoring the blocks that are sent again for eviction %s", r.EvictionID)
+		return
+	}
+	// A late request of a finished eviction would wait for acks that are never sent again
+	if _, finished := fsm.finishedEvictions[r.EvictionID]; finished {
+		log.Debug().Msgf("Ignoring the blocks that are sent for eviction %s that already finished", r.EvictionID)
+		return
+	}
+	var sentBlocks []SentBlock
+	for _, sentBlock := range r.SentBlocks {
+		stashState, exists := fsm.stash[sentBlock.Block]
+		if !exists {
+			continue
+		}
+		// The ack of an eviction only removes the value that was sent
+		if stashState.value == sentBlock.Value {
+			stashState.logicalTime = 0
+		} else if stashState.logicalTime == 0 {
+			stashState.logicalTime = 1
+		}
 		stashState.waitingStatus = true
-		fsm.stash[block] = stashState
+		fsm.stash[sentBlock.Block] = stashState
+		sentBlocks = append(sentBlocks, sentBlock)
+	}
+	fsm.evictions[r.EvictionID] = sentBlocks
+	for _, block := range r.DroppedBlocks {
+		// The block could have been written or prepared after it was picked
+		if !fsm.isDroppable(block) {
+			continue
+		}
+		delete(fsm.stash, block)
+		fsm.deletePosition(block)
 	}
 }
 
-// It keeps the nacked blocks and deletes not changed acked blocks.
-// If an acked block was changed during the eviction, it will keep it.
-func (fsm *shardNodeFSM) handleLocalAcksNacksReplicationChanges(requestID string) {
-	log.Debug().Msgf("Aquiring lock for shardNodeFSM in handleLocalAcksNacksReplicationChanges")
+// It returns true if the block is a tombstone that can be dropped from the stash.
+// The caller should hold stashMu.
+func (fsm *shardNodeFSM) isDroppable(block string) bool {
+	stashState, exists := fsm.stash[block]
+	return exists && stashState.tombstone && !stashState.waitingStatus && !fsm.isPrepared(block)
+}
+
+// It applies the acks and nacks of an eviction and removes the eviction.
+// The acked blocks that did not change during the eviction are removed from the stash,
+// and the other blocks of the eviction can be evicted again.
+// The acks and nacks of an eviction that already got them are ignored, so a retried or replayed ack does not remove a block again.
+func (fsm *shardNodeFSM) handleReplicateAcksNacks(r ReplicateAcksNacksPayload) {
+	log.Debug().Msgf("Aquiring lock for shardNodeFSM in handleReplicateAcksNacks")
 	fsm.stashMu.Lock()
-	log.Debug().Msgf("Aquired lock for shardNodeFSM in handleLocalAcksNacksReplicationChanges")
+	log.Debug().Msgf("Aquired lock for shardNodeFSM in handleReplicateAcksNacks")
 	defer func() {
-		log.Debug().Msgf("Releasing lock for shardNodeFSM in handleLocalAcksNacksReplicationChanges")
+		log.Debug().Msgf("Releasing lock for shardNodeFSM in handleReplicateAcksNacks")
 		fsm.stashMu.Unlock()
-		log.Debug().Msgf("Released lock for shardNodeFSM in handleLocalAcksNacksReplicationChanges")
+		log.Debug().Msgf("Released lock for shardNodeFSM in handleReplicateAcksNacks")
 	}()
 
-	for _, block := range fsm.acks[requestID] {
-		stashState := fsm.stash[block]
-		if stashState.logicalTime == 0 {
-			delete(fsm.stash, block)
+	sentBlocks, exists := fsm.evictions[r.EvictionID]
+	if !exists {
+		if _, finished := fsm.finishedEvictions[r.EvictionID]; finished {
+			log.Debug().Msgf("Ignoring the acks and nacks of eviction %s that already got them", r.EvictionID)
+		} else {
+			log.Debug().Msgf("Ignoring the acks and nacks of eviction %s that did not send blocks", r.EvictionID)
 		}
+		return
 	}
-	for _, block := range fsm.nacks[requestID] {
-		stashState := fsm.stash[block]
+	delete(fsm.evictions, r.EvictionID)
+	fsm.recordFinishedEviction(r.EvictionID, r.TimedOut)
+	acked := make(map[string]bool)
+	for _, block := range r.AckedBlocks {
+		acked[block] = true
+	}
+	for _, sentBlock := range sentBlocks {
+		stashState, exists := fsm.stash[sentBlock.Block]
+		if !exists {
+			continue
+		}
+		if acked[sentBlock.Block] && stashState.logicalTime == 0 {
+			delete(fsm.stash, sentBlock.Block)
+			continue
+		}
+		// The storage has an old value or does not have the block, so the block should be evicted or dropped again
 		stashState.waitingStatus = false
-		fsm.stash[block] = stashState
+		fsm.stash[sentBlock.Block] = stashState
 	}
-	delete(fsm.acks, requestID)
-	delete(fsm.nacks, requestID)
 }
 
-func (fsm *shardNodeFSM) handleReplicateAcksNacks(r ReplicateAcksNacksPayload) {
-	requestID := uuid.New().String()
-	fsm.acks[requestID] = r.AckedBlocks
-	fsm.nacks[requestID] = r.NackedBlocks
+// It remembers how an eviction finished, and forgets the oldest finished eviction if the window is full.
+// The caller should hold stashMu.
+func (fsm *shardNodeFSM) recordFinishedEviction(evictionID string, timedOut bool) {
+	fsm.finishedEvictions[evictionID] = timedOut
+	fsm.finishedEvictionsOrder = append(fsm.finishedEvictionsOrder, evictionID)
+	if len(fsm.finishedEvictionsOrder) > finishedEvictionsWindowSize {
+		delete(fsm.finishedEvictions, fsm.finishedEvictionsOrder[0])
+		fsm.finishedEvictionsOrder = fsm.finishedEvictionsOrder[1:]
+	}
+}
+
+// It returns whether the eviction got its acks and nacks and whether it timed out.
+// An eviction that finished before the last finishedEvictionsWindowSize evictions is not known.
+func (fsm *shardNodeFSM) getFinishedEviction(evictionID string) (timedOut bool, finished bool) {
+	fsm.stashMu.Lock()
+	defer fsm.stashMu.Unlock()
+	timedOut, finished = fsm.finishedEvictions[evictionID]
+	return timedOut, finished
+}
+
+// It returns the blocks that were sent for the eviction, or false if the eviction is not waiting for its acks.
+func (fsm *shardNodeFSM) getSentBlocks(evictionID string) ([]SentBlock, bool) {
+	fsm.stashMu.Lock()
+	defer fsm.stashMu.Unlock()
+	sentBlocks, exists := fsm.evictions[evictionID]
+	return sentBlocks, exists
+}
+
+// From now on, the blocks that do not belong to this shard node on the new ring are redirected.
+// The begin of a migration that already began is ignored, since it is replicated again if the router retries.
+func (fsm *shardNodeFSM) handleReplicateBeginMigration(r ReplicateBeginMigrationPayload) {
+	fsm.ownershipMu.Lock()
+	defer fsm.ownershipMu.Unlock()
+	if fsm.migration != nil && fsm.migration.id == r.MigrationID {
+		return
+	}
+	fsm.migration = &migrationState{
+		id:                  r.MigrationID,
+		shardNodeIDs:        r.ShardNodeIDs,
+		virtualNodes:        r.VirtualNodes,
+		destinationID:       r.DestinationID,
+		destinationReplicas: r.DestinationReplicas,
+		previous:            fsm.migration,
+		previousOwnership:   fsm.ownership,
+	}
+	fsm.ownership = newOwnershipRing(r.ShardNodeIDs, r.VirtualNodes, r.OwnerID)
+}
+
+func (fsm *shardNodeFSM) handleReplicateMigratedBlocks(r ReplicateMigratedBlocksPayload) {
+	log.Debug().Msgf("Aquiring lock for shardNodeFSM in handleReplicateMigratedBlocks")
+	fsm.stashMu.Lock()
+	fsm.positionMapMu.Lock()
+	log.Debug().Msgf("Aquired lock for shardNodeFSM in handleReplicateMigratedBlocks")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for shardNodeFSM in handleReplicateMigratedBlocks")
+		fsm.positionMapMu.Unlock()
+		fsm.stashMu.Unlock()
+		log.Debug().Msgf("Released lock for shardNodeFSM in handleReplicateMigratedBlocks")
+	}()
+	for _, block := range r.Blocks {
+		fsm.setPosition(block.Block, positionState{path: block.Path, storageID: block.StorageID, version: block.Version})
+		if block.InStash {
+			fsm.stash[block.Block] = stashState{value: block.Value, tombstone: block.Tombstone}
+		}
+	}
+}
 
-	go fsm.handleLocalAcksNacksReplicationChanges(requestID)
+// The removed blocks have been handed off to their new shard node.
+// It is ignored if the migration is not in progress, for example if it was aborted.
+func (fsm *shardNodeFSM) handleReplicateFinishMigration(r ReplicateFinishMigrationPayload) {
+	fsm.ownershipMu.Lock()
+	if fsm.migration == nil || fsm.migration.id != r.MigrationID || fsm.migration.finished {
+		fsm.ownershipMu.Unlock()
+		return
+	}
+	fsm.migration.finished = true
+	fsm.migration.previous = nil
+	fsm.migration.previousOwnership = nil
+	fsm.ownershipMu.Unlock()
+
+	log.Debug().Msgf("Aquiring lock for shardNodeFSM in handleReplicateFinishMigration")
+	fsm.stashMu.Lock()
+	fsm.positionMapMu.Lock()
+	log.Debug().Msgf("Aquired lock for shardNodeFSM in handleReplicateFinishMigration")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for shardNodeFSM in handleReplicateFinishMigration")
+		fsm.positionMapMu.Unlock()
+		fsm.stashMu.Unlock()
+		log.Debug().Msgf("Released lock for shardNodeFSM in handleReplicateFinishMigration")
+	}()
+	for _, block := range r.RemovedBlocks {
+		fsm.deletePosition(block)
+		delete(fsm.stash, block)
+	}
+}
+
+// The shard node owns the blocks of the aborted migration again, since they were never removed.
+func (fsm *shardNodeFSM) handleReplicateAbortMigration(r ReplicateAbortMigrationPayload) {
+	fsm.ownershipMu.Lock()
+	defer fsm.ownershipMu.Unlock()
+	if fsm.migration == nil || fsm.migration.id != r.MigrationID || fsm.migration.finished {
+		return
+	}
+	fsm.ownership = fsm.migration.previousOwnership
+	fsm.migration = fsm.migration.previous
+}
+
+// It keeps the first decision for a migration to this shard node and returns whether the migration is committed.
+func (fsm *shardNodeFSM) handleReplicateMigrationDecision(r ReplicateMigrationDecisionPayload) bool {
+	fsm.ownershipMu.Lock()
+	defer fsm.ownershipMu.Unlock()
+	committed, decided := fsm.migrationDecisions[r.MigrationID]
+	if !decided {
+		committed = r.Commit
+		fsm.migrationDecisions[r.MigrationID] = committed
+	}
+	return committed
+}
+
+// It returns a copy of the last migration from this shard node, or false if there is none.
+func (fsm *shardNodeFSM) getMigration() (migrationState, bool) {
+	fsm.ownershipMu.RLock()
+	defer fsm.ownershipMu.RUnlock()
+	if fsm.migration == nil {
+		return migrationState{}, false
+	}
+	return *fsm.migration, true
+}
+
+func (fsm *shardNodeFSM) handleReplicateAddStorage(r ReplicateAddStoragePayload) {
+	fsm.addedStoragesMu.Lock()
+	defer fsm.addedStoragesMu.Unlock()
+	if _, exists := fsm.addedStorages[r.StorageID]; exists {
+		return
+	}
+	fsm.addedStorages[r.StorageID] = addedStorage{oramNodeID: r.ORAMNodeID, addedAt: r.AddedAt}
+}
+
+// It prepares a write of a transaction and returns false if the write can not be prepared.
+// A block can only be prepared by one transaction at a time, so that the transactions commit in the same order on every shard node.
+// The block should be in the stash, since the prepared blocks are not evicted until the transaction is decided.
+func (fsm *shardNodeFSM) handleReplicatePrepareTransaction(r ReplicatePrepareTransactionPayload) bool {
+	log.Debug().Msgf("Aquiring lock for shardNodeFSM in handleReplicatePrepareTransaction")
+	fsm.stashMu.Lock()
+	fsm.preparedMu.Lock()
+	log.Debug().Msgf("Aquired lock for shardNodeFSM in handleReplicatePrepareTransaction")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for shardNodeFSM in handleReplicatePrepareTransaction")
+		fsm.preparedMu.Unlock()
+		fsm.stashMu.Unlock()
+		log.Debug().Msgf("Released lock for shardNodeFSM in handleReplicatePrepareTransaction")
+	}()
+	stashState, exists := fsm.stash[r.Block]
+	if !exists {
+		return false
+	}
+	if transactionID, exists := fsm.preparedBlocks[r.Block]; exists && transactionID != r.TransactionID {
+		return false
+	}
+	if _, exists := fsm.preparedWrites[r.TransactionID]; !exists {
+		fsm.preparedWrites[r.TransactionID] = make(map[string]string)
+	}
+	fsm.preparedWrites[r.TransactionID][r.Block] = r.Value
+	fsm.preparedBlocks[r.Block] = r.TransactionID
+	if len(r.CoordinatorReplicas) != 0 {
+		fsm.preparedCoordinators[r.TransactionID] = r.CoordinatorReplicas
+	}
+	// An ack of an eviction that started before the prepare should not remove the block from the stash
+	stashState.logicalTime++
+	fsm.stash[r.Block] = stashState
+	return true
+}
+
+// It applies the prepared writes of the committed transactions and drops the prepared writes of the aborted ones.
+// The transactions that were not prepared on this shard node are ignored.
+func (fsm *shardNodeFSM) handleReplicateTransactionDecisions(r ReplicateTransactionDecisionsPayload) {
+	log.Debug().Msgf("Aquiring lock for shardNodeFSM in handleReplicateTransactionDecisions")
+	fsm.stashMu.Lock()
+	fsm.preparedMu.Lock()
+	fsm.positionMapMu.Lock()
+	log.Debug().Msgf("Aquired lock for shardNodeFSM in handleReplicateTransactionDecisions")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for shardNodeFSM in handleReplicateTransactionDecisions")
+		fsm.positionMapMu.Unlock()
+		fsm.preparedMu.Unlock()
+		fsm.stashMu.Unlock()
+		log.Debug().Msgf("Released lock for shardNodeFSM in handleReplicateTransactionDecisions")
+	}()
+	for _, transactionID := range r.Committed {
+		for block, value := range fsm.preparedWrites[transactionID] {
+			stashState := fsm.stash[block]
+			stashState.logicalTime++
+			stashState.value = value
+			stashState.tombstone = false
+			fsm.stash[block] = stashState
+			position := fsm.positionMap[block]
+			position.version++
+			fsm.setPosition(block, position)
+			delete(fsm.preparedBlocks, block)
+		}
+		delete(fsm.preparedWrites, transactionID)
+		delete(fsm.preparedCoordinators, transactionID)
+	}
+	for _, transactionID := range r.Aborted {
+		for block := range fsm.preparedWrites[transactionID] {
+			delete(fsm.preparedBlocks, block)
+		}
+		delete(fsm.preparedWrites, transactionID)
+		delete(fsm.preparedCoordinators, transactionID)
+	}
+}
+
+type transactionDecision struct {
+	transactionID string
+	decidedAt     time.Time
+}
+
+// It keeps the first decision for a transaction that this shard node coordinates and returns whether the transaction is committed.
+// It forgets the decisions made before r.ForgetBefore, so a participant should ask for a decision before then.
+func (fsm *shardNodeFSM) handleReplicateTransactionDecision(r ReplicateTransactionDecisionPayload) bool {
+	fsm.preparedMu.Lock()
+	defer fsm.preparedMu.Unlock()
+	for len(fsm.transactionDecisionsOrder) != 0 && fsm.transactionDecisionsOrder[0].decidedAt.Before(r.ForgetBefore) {
+		delete(fsm.transactionDecisions, fsm.transactionDecisionsOrder[0].transactionID)
+		fsm.transactionDecisionsOrder = fsm.transactionDecisionsOrder[1:]
+	}
+	committed, decided := fsm.transactionDecisions[r.TransactionID]
+	if !decided {
+		committed = r.Commit
+		fsm.transactionDecisions[r.TransactionID] = committed
+		fsm.transactionDecisionsOrder = append(fsm.transactionDecisionsOrder, transactionDecision{transactionID: r.TransactionID, decidedAt: r.DecidedAt})
+	}
+	return committed
+}
+
+// It returns the transactions that have prepared writes and are not decided yet.
+func (fsm *shardNodeFSM) getPreparedTransactions() []string {
+	fsm.preparedMu.Lock()
+	defer fsm.preparedMu.Unlock()
+	transactionIDs := make([]string, 0, len(fsm.preparedWrites))
+	for transactionID := range fsm.preparedWrites {
+		transactionIDs = append(transactionIDs, transactionID)
+	}
+	return transactionIDs
+}
+
+// It returns the replicas of the shard node that records the decision of a prepared transaction, or nil if it has none.
+func (fsm *shardNodeFSM) getTransactionCoordinator(transactionID string) []ShardNodeReplicaPayload {
+	fsm.preparedMu.Lock()
+	defer fsm.preparedMu.Unlock()
+	return fsm.preparedCoordinators[transactionID]
+}
+
+// It returns true if a transaction has prepared a write for the block and is not decided yet.
+func (fsm *shardNodeFSM) isPrepared(block string) bool {
+	fsm.preparedMu.Lock()
+	defer fsm.preparedMu.Unlock()
+	_, exists := fsm.preparedBlocks[block]
+	return exists
+}
+
+// It returns true if the shard node does not own the block and requests for it should go to another shard node.
+func (fsm *shardNodeFSM) isRedirected(block string) bool {
+	fsm.ownershipMu.RLock()
+	defer fsm.ownershipMu.RUnlock()
+	return fsm.ownership != nil && !fsm.ownership.owns(block)
+}
+
+// A block is busy if it has requests in progress, a prepared write, or it is waiting for the ack of an eviction.
+func (fsm *shardNodeFSM) isBlockBusy(block string) bool {
+	fsm.requestLogMu.Lock()
+	hasRequests := len(fsm.requestLog[block]) != 0
+	fsm.requestLogMu.Unlock()
+	if hasRequests || fsm.isPrepared(block) {
+		return true
+	}
+	fsm.stashMu.Lock()
+	defer fsm.stashMu.Unlock()
+	return fsm.stash[block].waitingStatus
 }
 
 func (fsm *shardNodeFSM) Apply(rLog *raft.Log) interface{} {
@@ -226,6 +714,9 @@ func (fsm *shardNodeFSM) Apply(rLog *raft.Log) interface{} {
 			if err != nil {
 				return fmt.Errorf("could not unmarshall the request replication command; %s", err)
 			}
+			if requestReplicationPayload.LeaderID == fsm.replicaID {
+				fsm.forgetRequestsOfEarlierTerms(rLog.Term)
+			}
 			return fsm.handleBatchReplicateRequestAndPathAndStorage(requestReplicationPayload)
 		} else if command.Type == ReplicateResponseCommand {
 			log.Debug().Msgf("got replication command for replicate response")
@@ -251,6 +742,78 @@ func (fsm *shardNodeFSM) Apply(rLog *raft.Log) interface{} {
 				return fmt.Errorf("could not unmarshall the acks/nacks replication command; %s", err)
 			}
 			fsm.handleReplicateAcksNacks(payload)
+		} else if command.Type == ReplicateBeginMigrationCommand {
+			log.Debug().Msgf("got replication command for replicate begin migration")
+			var payload ReplicateBeginMigrationPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the begin migration replication command; %s", err)
+			}
+			fsm.handleReplicateBeginMigration(payload)
+		} else if command.Type == ReplicateMigratedBlocksCommand {
+			log.Debug().Msgf("got replication command for replicate migrated blocks")
+			var payload ReplicateMigratedBlocksPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the migrated blocks replication command; %s", err)
+			}
+			fsm.handleReplicateMigratedBlocks(payload)
+		} else if command.Type == ReplicateFinishMigrationCommand {
+			log.Debug().Msgf("got replication command for replicate finish migration")
+			var payload ReplicateFinishMigrationPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the finish migration replication command; %s", err)
+			}
+			fsm.handleReplicateFinishMigration(payload)
+		} else if command.Type == ReplicateAbortMigrationCommand {
+			log.Debug().Msgf("got replication command for replicate abort migration")
+			var payload ReplicateAbortMigrationPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the abort migration replication command; %s", err)
+			}
+			fsm.handleReplicateAbortMigration(payload)
+		} else if command.Type == ReplicateMigrationDecisionCommand {
+			log.Debug().Msgf("got replication command for replicate migration decision")
+			var payload ReplicateMigrationDecisionPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the migration decision replication command; %s", err)
+			}
+			return fsm.handleReplicateMigrationDecision(payload)
+		} else if command.Type == ReplicateAddStorageCommand {
+			log.Debug().Msgf("got replication command for replicate add storage")
+			var payload ReplicateAddStoragePayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the add storage replication command; %s", err)
+			}
+			fsm.handleReplicateAddStorage(payload)
+		} else if command.Type == ReplicatePrepareTransactionCommand {
+			log.Debug().Msgf("got replication command for replicate prepare transaction")
+			var payload ReplicatePrepareTransactionPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the prepare transaction replication command; %s", err)
+			}
+			return fsm.handleReplicatePrepareTransaction(payload)
+		} else if command.Type == ReplicateTransactionDecisionsCommand {
+			log.Debug().Msgf("got replication command for replicate transaction decisions")
+			var payload ReplicateTransactionDecisionsPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the transaction decisions replication command; %s", err)
+			}
+			fsm.handleReplicateTransactionDecisions(payload)
+		} else if command.Type == ReplicateTransactionDecisionCommand {
+			log.Debug().Msgf("got replication command for replicate transaction decision")
+			var payload ReplicateTransactionDecisionPayload
+			err := msgpack.Unmarshal(command.Payload, &payload)
+			if err != nil {
+				return fmt.Errorf("could not unmarshall the transaction decision replication command; %s", err)
+			}
+			return fsm.handleReplicateTransactionDecision(payload)
 		} else {
 			log.Error().Msgf("wrong command type")
 		}
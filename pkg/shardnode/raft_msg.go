@@ -2,6 +2,7 @@ package shardnode
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
@@ -13,6 +14,15 @@ const (
 	ReplicateResponseCommand
 	ReplicateSentBlocksCommand
 	ReplicateAcksNacksCommand
+	ReplicateBeginMigrationCommand
+	ReplicateMigratedBlocksCommand
+	ReplicateFinishMigrationCommand
+	ReplicateAddStorageCommand
+	ReplicatePrepareTransactionCommand
+	ReplicateTransactionDecisionsCommand
+	ReplicateAbortMigrationCommand
+	ReplicateMigrationDecisionCommand
+	ReplicateTransactionDecisionCommand
 )
 
 type Command struct {
@@ -25,6 +35,12 @@ type ReplicateRequestAndPathAndStoragePayload struct {
 	Path           int
 	StorageID      int
 	RequestID      string
+	// The operation of the request, it is applied after the first request of the block if the request is concurrent with it
+	OpType          OperationType
+	NewValue        string
+	Expected        string // only used by compare and swap
+	ExpectedVersion uint64 // only used by compare and swap
+	IdempotencyKey  string
 }
 
 type BatchReplicateRequestAndPathAndStoragePayload struct {
@@ -56,23 +72,33 @@ func newRequestReplicationCommand(requests []ReplicateRequestAndPathAndStoragePa
 }
 
 type ReplicateResponsePayload struct {
-	RequestedBlock string
-	Response       string
-	NewValue       string
-	OpType         OperationType
-	RequestID      string
-	LeaderID       int
+	RequestedBlock  string
+	Response        string
+	NewValue        string
+	OpType          OperationType
+	RequestID       string
+	LeaderID        int
+	Expected        string    // only used by compare and swap
+	ExpectedVersion uint64    // only used by compare and swap, zero if the version is not compared
+	IdempotencyKey  string    // empty if the write should not be deduplicated
+	AppliedAt       time.Time // the leader sets it when it proposes the command, so every replica forgets the same writes
+	ForgetBefore    time.Time // the writes with an idempotency key that were applied before it are forgotten
 }
 
-func newResponseReplicationCommand(response string, requestID string, block string, newValue string, opType OperationType, leaderID int) ([]byte, error) {
+func newResponseReplicationCommand(response string, requestID string, block string, newValue string, opType OperationType, leaderID int, condition writeCondition, idempotencyKey string, appliedAt time.Time, forgetBefore time.Time) ([]byte, error) {
 	responseReplicationPayload, err := msgpack.Marshal(
 		&ReplicateResponsePayload{
-			Response:       response,
-			RequestedBlock: block,
-			NewValue:       newValue,
-			OpType:         opType,
-			RequestID:      requestID,
-			LeaderID:       leaderID,
+			Response:        response,
+			RequestedBlock:  block,
+			NewValue:        newValue,
+			OpType:          opType,
+			RequestID:       requestID,
+			LeaderID:        leaderID,
+			Expected:        condition.expected,
+			ExpectedVersion: condition.expectedVersion,
+			IdempotencyKey:  idempotencyKey,
+			AppliedAt:       appliedAt,
+			ForgetBefore:    forgetBefore,
 		},
 	)
 	if err != nil {
@@ -90,14 +116,25 @@ func newResponseReplicationCommand(response string, requestID string, block stri
 	return responseReplicationCommand, nil
 }
 
+// It is a block with the value and path that were sent to the oram node.
+type SentBlock struct {
+	Block string
+	Value string
+	Path  int
+}
+
 type ReplicateSentBlocksPayload struct {
-	SentBlocks []string
+	EvictionID    string // the eviction of the oram node that the blocks are sent for
+	SentBlocks    []SentBlock
+	DroppedBlocks []string // the tombstones that are removed instead of being evicted
 }
 
-func newSentBlocksReplicationCommand(sentBlocks []string) ([]byte, error) {
+func newSentBlocksReplicationCommand(evictionID string, sentBlocks []SentBlock, droppedBlocks []string) ([]byte, error) {
 	payload, err := msgpack.Marshal(
 		&ReplicateSentBlocksPayload{
-			SentBlocks: sentBlocks,
+			EvictionID:    evictionID,
+			SentBlocks:    sentBlocks,
+			DroppedBlocks: droppedBlocks,
 		},
 	)
 	if err != nil {
@@ -116,15 +153,19 @@ func newSentBlocksReplicationCommand(sentBlocks []string) ([]byte, error) {
 }
 
 type ReplicateAcksNacksPayload struct {
+	EvictionID   string
 	AckedBlocks  []string
 	NackedBlocks []string
+	TimedOut     bool // the shard node nacks every block of the eviction since it timed out
 }
 
-func newAcksNacksReplicationCommand(ackBlocks []string, nackBlocks []string) ([]byte, error) {
+func newAcksNacksReplicationCommand(evictionID string, ackBlocks []string, nackBlocks []string, timedOut bool) ([]byte, error) {
 	payload, err := msgpack.Marshal(
 		&ReplicateAcksNacksPayload{
+			EvictionID:   evictionID,
 			AckedBlocks:  ackBlocks,
 			NackedBlocks: nackBlocks,
+			TimedOut:     timedOut,
 		},
 	)
 	if err != nil {
@@ -141,3 +182,276 @@ func newAcksNacksReplicationCommand(ackBlocks []string, nackBlocks []string) ([]
 	}
 	return command, nil
 }
+
+type ShardNodeReplicaPayload struct {
+	ReplicaID int
+	IP        string
+	Port      int
+}
+
+type ReplicateBeginMigrationPayload struct {
+	MigrationID         string
+	ShardNodeIDs        []int
+	VirtualNodes        int
+	OwnerID             int
+	DestinationID       int
+	DestinationReplicas []ShardNodeReplicaPayload
+}
+
+func newBeginMigrationReplicationCommand(migrationID string, shardNodeIDs []int, virtualNodes int, ownerID int, destinationID int, destinationReplicas []ShardNodeReplicaPayload) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateBeginMigrationPayload{
+			MigrationID:         migrationID,
+			ShardNodeIDs:        shardNodeIDs,
+			VirtualNodes:        virtualNodes,
+			OwnerID:             ownerID,
+			DestinationID:       destinationID,
+			DestinationReplicas: destinationReplicas,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the begin migration replication payload; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateBeginMigrationCommand,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the begin migration replication command; %s", err)
+	}
+	return command, nil
+}
+
+type MigratedBlockPayload struct {
+	Block     string
+	Path      int
+	StorageID int
+	InStash   bool
+	Value     string
+	Tombstone bool
+	Version   uint64
+}
+
+type ReplicateMigratedBlocksPayload struct {
+	Blocks []MigratedBlockPayload
+}
+
+func newMigratedBlocksReplicationCommand(blocks []MigratedBlockPayload) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateMigratedBlocksPayload{
+			Blocks: blocks,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the migrated blocks replication payload; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateMigratedBlocksCommand,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the migrated blocks replication command; %s", err)
+	}
+	return command, nil
+}
+
+type ReplicateFinishMigrationPayload struct {
+	MigrationID   string
+	RemovedBlocks []string
+}
+
+func newFinishMigrationReplicationCommand(migrationID string, removedBlocks []string) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateFinishMigrationPayload{
+			MigrationID:   migrationID,
+			RemovedBlocks: removedBlocks,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the finish migration replication payload; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateFinishMigrationCommand,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the finish migration replication command; %s", err)
+	}
+	return command, nil
+}
+
+type ReplicateAbortMigrationPayload struct {
+	MigrationID string
+}
+
+func newAbortMigrationReplicationCommand(migrationID string) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateAbortMigrationPayload{
+			MigrationID: migrationID,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the abort migration replication payload; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateAbortMigrationCommand,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the abort migration replication command; %s", err)
+	}
+	return command, nil
+}
+
+type ReplicateMigrationDecisionPayload struct {
+	MigrationID string
+	Commit      bool
+}
+
+func newMigrationDecisionReplicationCommand(migrationID string, commit bool) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateMigrationDecisionPayload{
+			MigrationID: migrationID,
+			Commit:      commit,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the migration decision replication payload; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateMigrationDecisionCommand,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the migration decision replication command; %s", err)
+	}
+	return command, nil
+}
+
+type ReplicateAddStoragePayload struct {
+	StorageID  int
+	ORAMNodeID int
+	AddedAt    time.Time // the leader sets it when it proposes the command, so every replica ramps up the storage the same way
+}
+
+func newAddStorageReplicationCommand(storageID int, oramNodeID int, addedAt time.Time) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateAddStoragePayload{
+			StorageID:  storageID,
+			ORAMNodeID: oramNodeID,
+			AddedAt:    addedAt,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the add storage replication payload; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateAddStorageCommand,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the add storage replication command; %s", err)
+	}
+	return command, nil
+}
+
+type ReplicatePrepareTransactionPayload struct {
+	TransactionID       string
+	Block               string
+	Value               string
+	CoordinatorReplicas []ShardNodeReplicaPayload // the replicas of the shard node that records the decision of the transaction
+}
+
+func newPrepareTransactionReplicationCommand(transactionID string, block string, value string, coordinatorReplicas []ShardNodeReplicaPayload) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicatePrepareTransactionPayload{
+			TransactionID:       transactionID,
+			Block:               block,
+			Value:               value,
+			CoordinatorReplicas: coordinatorReplicas,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the prepare transaction replication payload; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicatePrepareTransactionCommand,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the prepare transaction replication command; %s", err)
+	}
+	return command, nil
+}
+
+type ReplicateTransactionDecisionsPayload struct {
+	Committed []string
+	Aborted   []string
+}
+
+func newTransactionDecisionsReplicationCommand(committed []string, aborted []string) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateTransactionDecisionsPayload{
+			Committed: committed,
+			Aborted:   aborted,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the transaction decisions replication payload; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateTransactionDecisionsCommand,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the transaction decisions replication command; %s", err)
+	}
+	return command, nil
+}
+
+type ReplicateTransactionDecisionPayload struct {
+	TransactionID string
+	Commit        bool
+	DecidedAt     time.Time // the leader sets it when it proposes the command, so every replica forgets the same decisions
+	ForgetBefore  time.Time // the decisions made before it are forgotten
+}
+
+func newTransactionDecisionReplicationCommand(transactionID string, commit bool, decidedAt time.Time, forgetBefore time.Time) ([]byte, error) {
+	payload, err := msgpack.Marshal(
+		&ReplicateTransactionDecisionPayload{
+			TransactionID: transactionID,
+			Commit:        commit,
+			DecidedAt:     decidedAt,
+			ForgetBefore:  forgetBefore,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the transaction decision replication payload; %s", err)
+	}
+	command, err := msgpack.Marshal(
+		&Command{
+			Type:    ReplicateTransactionDecisionCommand,
+			Payload: payload,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal the transaction decision replication command; %s", err)
+	}
+	return command, nil
+}
@@ -1,9 +1,12 @@
 package shardnode
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/raft"
 )
 
 func TestHandleBatchReplicateRequestAndPathAndStorageToEmptyFSM(t *testing.T) {
@@ -123,7 +126,7 @@ type responseMessage struct {
 // This helper function gets a map of requestID to its channel.
 // It waits for all channels to recieve the determined response.
 // It will timeout if at least one channel doesn't recieve the response.
-func checkWaitingChannelsHelper(t *testing.T, waitChannels sync.Map, expectedResponse string) {
+func checkWaitingChannelsHelper(t *testing.T, waitChannels *sync.Map, expectedResponse string) {
 	waitingSet := make(map[string]bool) // keeps the request in the set until a response for request is recieved from channel
 	agg := make(chan responseMessage)
 	var keys []string
@@ -134,11 +137,11 @@ func checkWaitingChannelsHelper(t *testing.T, waitChannels sync.Map, expectedRes
 	for _, key := range keys {
 		waitingSet[key] = true
 		chAny, _ := waitChannels.Load(key)
-		go func(requestID string, c chan string) {
+		go func(requestID string, c chan blockResponse) {
 			for msg := range c {
-				agg <- responseMessage{requestID: requestID, response: msg}
+				agg <- responseMessage{requestID: requestID, response: msg.value}
 			}
-		}(key, chAny.(chan string))
+		}(key, chAny.(chan blockResponse))
 	}
 	for {
 		if len(waitingSet) == 0 {
@@ -156,32 +159,72 @@ func checkWaitingChannelsHelper(t *testing.T, waitChannels sync.Map, expectedRes
 	}
 }
 
+func applyTestRequestReplication(t *testing.T, fsm *shardNodeFSM, term uint64, leaderID int, requests ...ReplicateRequestAndPathAndStoragePayload) map[string]bool {
+	command, err := newRequestReplicationCommand(requests, leaderID)
+	if err != nil {
+		t.Fatalf("could not create the request replication command; %s", err)
+	}
+	return fsm.Apply(&raft.Log{Type: raft.LogCommand, Term: term, Data: command}).(map[string]bool)
+}
+
+func TestApplyRequestReplicationForgetsTheRequestsOfAnEarlierTerm(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	applyTestRequestReplication(t, shardNodeFSM, 1, 0, ReplicateRequestAndPathAndStoragePayload{RequestedBlock: "block", RequestID: "request1"})
+	isFirstMap := applyTestRequestReplication(t, shardNodeFSM, 1, 0, ReplicateRequestAndPathAndStoragePayload{RequestedBlock: "block", RequestID: "request2"})
+	if isFirstMap["request2"] {
+		t.Errorf("expected request2 not to be the first request of the block in the same term")
+	}
+	// request1 and request2 lost their leader before they got their responses
+	isFirstMap = applyTestRequestReplication(t, shardNodeFSM, 3, 0, ReplicateRequestAndPathAndStoragePayload{RequestedBlock: "block", RequestID: "request3"})
+	if !isFirstMap["request3"] || len(shardNodeFSM.requestLog["block"]) != 1 {
+		t.Errorf("expected request3 to be the first request of the block in a later term but the requestLog is %v", shardNodeFSM.requestLog["block"])
+	}
+}
+
+func TestHandleReplicateResponseSkipsConcurrentRequestsWithoutResponseChannel(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.requestLog["block"] = []string{"request1", "request2"}
+	shardNodeFSM.stash["block"] = stashState{value: "value"}
+	done := make(chan blockResponse)
+	go func() {
+		done <- shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request1", "", "", Read, 0))
+	}()
+	select {
+	case response := <-done:
+		if response.value != "value" || len(shardNodeFSM.requestLog["block"]) != 0 {
+			t.Errorf("expected the response of request1 and an empty requestLog but got %v, %v", response, shardNodeFSM.requestLog)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the response without waiting for the missing response channel")
+	}
+}
+
 // In this case all the go routines should get the value that resides in stash.
 // The stash value has priority over the response value.
 func TestHandleReplicateResponseWhenValueInStashReturnsCorrectReadValueToAllWaitingRequests(t *testing.T) {
 	shardNodeFSM := newShardNodeFSM(0)
 	shardNodeFSM.requestLog["block"] = []string{"request1", "request2", "request3"}
-	shardNodeFSM.responseChannel.Store("request2", make(chan string))
-	shardNodeFSM.responseChannel.Store("request3", make(chan string))
+	shardNodeFSM.responseChannel.Store("request2", make(chan blockResponse))
+	shardNodeFSM.responseChannel.Store("request3", make(chan blockResponse))
 	shardNodeFSM.stash["block"] = stashState{value: "test_value"}
 
 	payload := createTestReplicateResponsePayload("block", "request1", "response", "value", Read, 0)
 	go shardNodeFSM.handleReplicateResponse(payload)
 
-	checkWaitingChannelsHelper(t, shardNodeFSM.responseChannel, "test_value")
+	checkWaitingChannelsHelper(t, &shardNodeFSM.responseChannel, "test_value")
 }
 
 func TestHandleReplicateResponseWhenValueInStashReturnsCorrectWriteValueToAllWaitingRequests(t *testing.T) {
 	shardNodeFSM := newShardNodeFSM(0)
 	shardNodeFSM.requestLog["block"] = []string{"request1", "request2", "request3"}
-	shardNodeFSM.responseChannel.Store("request2", make(chan string))
-	shardNodeFSM.responseChannel.Store("request3", make(chan string))
+	shardNodeFSM.responseChannel.Store("request2", make(chan blockResponse))
+	shardNodeFSM.responseChannel.Store("request3", make(chan blockResponse))
 	shardNodeFSM.stash["block"] = stashState{value: "test_value"}
 
 	payload := createTestReplicateResponsePayload("block", "request1", "response", "value_write", Write, 0)
 	go shardNodeFSM.handleReplicateResponse(payload)
 
-	checkWaitingChannelsHelper(t, shardNodeFSM.responseChannel, "value_write")
+	checkWaitingChannelsHelper(t, &shardNodeFSM.responseChannel, "value_write")
 
 	if shardNodeFSM.stash["block"].value != "value_write" {
 		t.Errorf("The stash value should be equal to \"value_write\" after Write request, but it's equal to %s", shardNodeFSM.stash["block"].value)
@@ -190,14 +233,15 @@ func TestHandleReplicateResponseWhenValueInStashReturnsCorrectWriteValueToAllWai
 
 func TestHandleReplicateResponseWhenValueNotInStashReturnsResponseToAllWaitingRequests(t *testing.T) {
 	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.positionMap["block"] = positionState{path: 1, storageID: 0} // the block is in the storage
 	shardNodeFSM.requestLog["block"] = []string{"request1", "request2", "request3"}
-	shardNodeFSM.responseChannel.Store("request2", make(chan string))
-	shardNodeFSM.responseChannel.Store("request3", make(chan string))
+	shardNodeFSM.responseChannel.Store("request2", make(chan blockResponse))
+	shardNodeFSM.responseChannel.Store("request3", make(chan blockResponse))
 
 	payload := createTestReplicateResponsePayload("block", "request1", "response_from_oramnode", "", Read, 0)
 	go shardNodeFSM.handleReplicateResponse(payload)
 
-	checkWaitingChannelsHelper(t, shardNodeFSM.responseChannel, "response_from_oramnode")
+	checkWaitingChannelsHelper(t, &shardNodeFSM.responseChannel, "response_from_oramnode")
 
 	if shardNodeFSM.stash["block"].value != "response_from_oramnode" {
 		t.Errorf("The stash value should be equal to \"response_from_oramnode\" after Write request, but it's equal to %s", shardNodeFSM.stash["block"].value)
@@ -207,13 +251,13 @@ func TestHandleReplicateResponseWhenValueNotInStashReturnsResponseToAllWaitingRe
 func TestHandleReplicateResponseWhenValueNotInStashReturnsWriteResponseToAllWaitingRequests(t *testing.T) {
 	shardNodeFSM := newShardNodeFSM(0)
 	shardNodeFSM.requestLog["block"] = []string{"request1", "request2", "request3"}
-	shardNodeFSM.responseChannel.Store("request2", make(chan string))
-	shardNodeFSM.responseChannel.Store("request3", make(chan string))
+	shardNodeFSM.responseChannel.Store("request2", make(chan blockResponse))
+	shardNodeFSM.responseChannel.Store("request3", make(chan blockResponse))
 
 	payload := createTestReplicateResponsePayload("block", "request1", "response", "write_val", Write, 0)
 	go shardNodeFSM.handleReplicateResponse(payload)
 
-	checkWaitingChannelsHelper(t, shardNodeFSM.responseChannel, "write_val")
+	checkWaitingChannelsHelper(t, &shardNodeFSM.responseChannel, "write_val")
 
 	if shardNodeFSM.stash["block"].value != "write_val" {
 		t.Errorf("The stash value should be equal to \"write_val\" after Write request, but it's equal to %s", shardNodeFSM.stash["block"].value)
@@ -223,8 +267,8 @@ func TestHandleReplicateResponseWhenValueNotInStashReturnsWriteResponseToAllWait
 func TestHandleReplicateResponseWhenNotLeaderDoesNotWriteOnChannels(t *testing.T) {
 	shardNodeFSM := newShardNodeFSM(0)
 	shardNodeFSM.requestLog["block"] = []string{"request1", "request2"}
-	shardNodeFSM.responseChannel.Store("request1", make(chan string))
-	shardNodeFSM.responseChannel.Store("request2", make(chan string))
+	shardNodeFSM.responseChannel.Store("request1", make(chan blockResponse))
+	shardNodeFSM.responseChannel.Store("request2", make(chan blockResponse))
 	shardNodeFSM.stash["block"] = stashState{value: "test_value"}
 
 	payload := createTestReplicateResponsePayload("block", "request1", "response", "", Read, 1)
@@ -234,12 +278,427 @@ func TestHandleReplicateResponseWhenNotLeaderDoesNotWriteOnChannels(t *testing.T
 		ch1Any, _ := shardNodeFSM.responseChannel.Load("request1")
 		ch2Any, _ := shardNodeFSM.responseChannel.Load("request2")
 		select {
-		case <-ch1Any.(chan string):
+		case <-ch1Any.(chan blockResponse):
 			t.Errorf("The followers in the raft cluster should not send messages on channels!")
-		case <-ch2Any.(chan string):
+		case <-ch2Any.(chan blockResponse):
 			t.Errorf("The followers in the raft cluster should not send messages on channels!")
 		case <-time.After(1 * time.Second):
 			return
 		}
 	}
 }
+
+func TestHandleReplicatePrepareTransactionFailsIfAnotherTransactionPreparedTheBlock(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash["block"] = stashState{value: "value"}
+	if !shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t1", Block: "block", Value: "v1"}) {
+		t.Errorf("expected the first transaction to prepare the block")
+	}
+	if shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t2", Block: "block", Value: "v2"}) {
+		t.Errorf("expected the second transaction not to prepare a prepared block")
+	}
+	if shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t2", Block: "missing", Value: "v2"}) {
+		t.Errorf("expected the prepare to fail for a block that is not in the stash")
+	}
+	if shardNodeFSM.stash["block"].value != "value" {
+		t.Errorf("expected the prepared write not to change the stash before the commit, but the value is %s", shardNodeFSM.stash["block"].value)
+	}
+}
+
+func TestHandleReplicateTransactionDecisionsAppliesCommittedAndDropsAbortedWrites(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash["block1"] = stashState{value: "value1"}
+	shardNodeFSM.stash["block2"] = stashState{value: "value2"}
+	shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t1", Block: "block1", Value: "new1"})
+	shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t2", Block: "block2", Value: "new2"})
+	shardNodeFSM.handleReplicateTransactionDecisions(ReplicateTransactionDecisionsPayload{Committed: []string{"t1"}, Aborted: []string{"t2", "unknown"}})
+	if shardNodeFSM.stash["block1"].value != "new1" {
+		t.Errorf("expected the committed write to be applied, but the value is %s", shardNodeFSM.stash["block1"].value)
+	}
+	if shardNodeFSM.stash["block2"].value != "value2" {
+		t.Errorf("expected the aborted write to be dropped, but the value is %s", shardNodeFSM.stash["block2"].value)
+	}
+	if shardNodeFSM.positionMap["block1"].version != 1 || shardNodeFSM.positionMap["block2"].version != 0 {
+		t.Errorf("expected only the committed write to change the version")
+	}
+	if shardNodeFSM.isPrepared("block1") || shardNodeFSM.isPrepared("block2") || len(shardNodeFSM.preparedWrites) != 0 {
+		t.Errorf("expected no prepared writes after the decisions")
+	}
+}
+
+func TestHandleReplicateResponseRejectsWritesToAPreparedBlock(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash["block"] = stashState{value: "old"}
+	shardNodeFSM.positionMap["block"] = positionState{version: 1}
+	shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t1", Block: "block", Value: "transaction"})
+	compareAndSwap := createTestReplicateResponsePayload("block", "request3", "", "swapped", CompareAndSwap, 0)
+	compareAndSwap.Expected = "old"
+	for _, payload := range []ReplicateResponsePayload{
+		createTestReplicateResponsePayload("block", "request1", "", "write", Write, 0),
+		createTestReplicateResponsePayload("block", "request2", "", "", Delete, 0),
+		compareAndSwap,
+	} {
+		response := shardNodeFSM.handleReplicateResponse(payload)
+		if !response.rejected || response.swapped || response.value != "old" || response.version != 1 {
+			t.Errorf("expected the operation %d to be rejected but got %v", payload.OpType, response)
+		}
+	}
+	if response := shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request4", "", "", Read, 0)); response.rejected || response.value != "old" {
+		t.Errorf("expected the read of the prepared block to get the old value but got %v", response)
+	}
+	shardNodeFSM.handleReplicateTransactionDecisions(ReplicateTransactionDecisionsPayload{Committed: []string{"t1"}})
+	if shardNodeFSM.stash["block"].value != "transaction" || shardNodeFSM.positionMap["block"].version != 2 {
+		t.Errorf("expected only the write of the transaction to be applied but got %v, version %d", shardNodeFSM.stash["block"], shardNodeFSM.positionMap["block"].version)
+	}
+	if response := shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request5", "", "write", Write, 0)); response.rejected || response.value != "write" {
+		t.Errorf("expected the write to be applied after the commit but got %v", response)
+	}
+}
+
+func TestHandleReplicateTransactionDecisionForgetsTheDecisionsMadeBeforeForgetBefore(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	start := time.Now()
+	if !shardNodeFSM.handleReplicateTransactionDecision(ReplicateTransactionDecisionPayload{TransactionID: "t1", Commit: true, DecidedAt: start, ForgetBefore: start.Add(-time.Minute)}) {
+		t.Errorf("expected t1 to be committed")
+	}
+	if !shardNodeFSM.handleReplicateTransactionDecision(ReplicateTransactionDecisionPayload{TransactionID: "t1", Commit: false, DecidedAt: start.Add(time.Second), ForgetBefore: start.Add(-time.Minute)}) {
+		t.Errorf("expected t1 to stay committed")
+	}
+	shardNodeFSM.handleReplicateTransactionDecision(ReplicateTransactionDecisionPayload{TransactionID: "t2", Commit: false, DecidedAt: start.Add(2 * time.Minute), ForgetBefore: start.Add(time.Minute)})
+	if _, decided := shardNodeFSM.transactionDecisions["t1"]; decided || len(shardNodeFSM.transactionDecisionsOrder) != 1 {
+		t.Errorf("expected the decision of t1 to be forgotten, but the decisions are %v", shardNodeFSM.transactionDecisions)
+	}
+}
+
+func TestHandleReplicateResponseDeleteLeavesTombstoneInStash(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash["block"] = stashState{value: "value"}
+	response := shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request1", "", "", Delete, 0))
+	if response.exists || response.value != "" {
+		t.Errorf("expected the deleted block not to exist but got %v", response)
+	}
+	if !shardNodeFSM.stash["block"].tombstone || shardNodeFSM.stash["block"].logicalTime != 1 {
+		t.Errorf("expected a tombstone in the stash but got %v", shardNodeFSM.stash["block"])
+	}
+	response = shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request2", "", "new", Write, 0))
+	if !response.exists || response.value != "new" || shardNodeFSM.stash["block"].tombstone {
+		t.Errorf("expected the written block to exist but got %v", response)
+	}
+}
+
+func TestHandleReplicateResponseIgnoresResponseForBlockWithoutPosition(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	response := shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request1", "value_from_random_path", "", Read, 0))
+	if response.exists || response.value != "" {
+		t.Errorf("expected a block that was never written not to exist but got %v", response)
+	}
+	if !shardNodeFSM.stash["block"].tombstone {
+		t.Errorf("expected a tombstone in the stash for a block that was never written")
+	}
+}
+
+func TestHandleReplicateResponseBumpsVersionOnlyWhenTheBlockChanges(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	response := shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request1", "", "value", Write, 0))
+	if response.version != 1 {
+		t.Errorf("expected version 1 after the first write but got %d", response.version)
+	}
+	response = shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request2", "", "", Read, 0))
+	if response.version != 1 || response.value != "value" {
+		t.Errorf("expected a read not to change the version but got %v", response)
+	}
+	shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request3", "", "", Delete, 0))
+	response = shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request4", "", "", Delete, 0))
+	if response.version != 2 {
+		t.Errorf("expected only the first delete to change the version but got %d", response.version)
+	}
+}
+
+func TestHandleReplicateResponseKeepsVersionOfEvictedBlock(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.positionMap["block"] = positionState{path: 1, storageID: 0, version: 5}
+	shardNodeFSM.pathMap["request1"] = 2
+	response := shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request1", "value_from_oramnode", "", Read, 0))
+	if response.version != 5 || response.value != "value_from_oramnode" {
+		t.Errorf("expected the version of the evicted block to be kept but got %v", response)
+	}
+	if shardNodeFSM.positionMap["block"].path != 2 || shardNodeFSM.positionMap["block"].version != 5 {
+		t.Errorf("expected the new path with the old version but got %v", shardNodeFSM.positionMap["block"])
+	}
+}
+
+func TestHandleReplicateResponseCompareAndSwap(t *testing.T) {
+	casPayload := func(requestID string, expected string, newValue string, expectedVersion uint64) ReplicateResponsePayload {
+		payload := createTestReplicateResponsePayload("block", requestID, "", newValue, CompareAndSwap, 0)
+		payload.Expected = expected
+		payload.ExpectedVersion = expectedVersion
+		return payload
+	}
+	shardNodeFSM := newShardNodeFSM(0)
+	response := shardNodeFSM.handleReplicateResponse(casPayload("request1", "", "1", 0))
+	if !response.swapped || response.value != "1" || response.version != 1 {
+		t.Errorf("expected the swap of a missing block with an empty expected value but got %v", response)
+	}
+	response = shardNodeFSM.handleReplicateResponse(casPayload("request2", "0", "2", 0))
+	if response.swapped || response.value != "1" || response.version != 1 {
+		t.Errorf("expected no swap for a different value but got %v", response)
+	}
+	response = shardNodeFSM.handleReplicateResponse(casPayload("request3", "1", "2", 2))
+	if response.swapped || response.value != "1" {
+		t.Errorf("expected no swap for a different version but got %v", response)
+	}
+	response = shardNodeFSM.handleReplicateResponse(casPayload("request4", "1", "2", 1))
+	if !response.swapped || response.value != "2" || response.version != 2 {
+		t.Errorf("expected the swap for the expected value and version but got %v", response)
+	}
+	if shardNodeFSM.stash["block"].value != "2" {
+		t.Errorf("expected the swapped value in the stash but got %s", shardNodeFSM.stash["block"].value)
+	}
+}
+
+func TestHandleReplicateResponseAppliesConcurrentRequestsAfterTheFirstOne(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash["block"] = stashState{value: "old"}
+	shardNodeFSM.handleBatchReplicateRequestAndPathAndStorage(BatchReplicateRequestAndPathAndStoragePayload{
+		Requests: []ReplicateRequestAndPathAndStoragePayload{
+			{RequestedBlock: "block", RequestID: "request1", OpType: Read},
+			{RequestedBlock: "block", RequestID: "request2", OpType: Write, NewValue: "new"},
+			{RequestedBlock: "block", RequestID: "request3", OpType: CompareAndSwap, Expected: "old", NewValue: "swapped"},
+			{RequestedBlock: "block", RequestID: "request4", OpType: Read},
+		},
+	})
+	responseChannels := make(map[string]chan blockResponse)
+	for _, requestID := range []string{"request2", "request3", "request4"} {
+		responseChannels[requestID] = make(chan blockResponse, 1)
+		shardNodeFSM.responseChannel.Store(requestID, responseChannels[requestID])
+	}
+	response := shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request1", "", "", Read, 0))
+	if response.value != "old" {
+		t.Errorf("expected the first read to get the old value but got %v", response)
+	}
+	expected := map[string]blockResponse{
+		"request2": {value: "new", exists: true, version: 1},
+		"request3": {value: "new", exists: true, version: 1},
+		"request4": {value: "new", exists: true, version: 1},
+	}
+	for requestID, expectedResponse := range expected {
+		select {
+		case concurrentResponse := <-responseChannels[requestID]:
+			if concurrentResponse != expectedResponse {
+				t.Errorf("expected %v for %s but got %v", expectedResponse, requestID, concurrentResponse)
+			}
+		case <-time.After(1 * time.Second):
+			t.Errorf("timeout occured, failed to recieve the value on the channel of %s", requestID)
+		}
+	}
+	if shardNodeFSM.stash["block"].value != "new" || len(shardNodeFSM.pendingRequests["block"]) != 0 {
+		t.Errorf("expected the concurrent write to be applied and the pending requests to be removed but got %v, %v", shardNodeFSM.stash["block"], shardNodeFSM.pendingRequests["block"])
+	}
+}
+
+func TestHandleReplicateResponseAppliesConcurrentRequestsOnFollowers(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(1)
+	shardNodeFSM.handleBatchReplicateRequestAndPathAndStorage(BatchReplicateRequestAndPathAndStoragePayload{
+		Requests: []ReplicateRequestAndPathAndStoragePayload{
+			{RequestedBlock: "block", RequestID: "request1", OpType: Write, NewValue: "first"},
+			{RequestedBlock: "block", RequestID: "request2", OpType: Write, NewValue: "second"},
+		},
+		LeaderID: 0,
+	})
+	shardNodeFSM.handleReplicateResponse(createTestReplicateResponsePayload("block", "request1", "", "first", Write, 0))
+	if shardNodeFSM.stash["block"].value != "second" || shardNodeFSM.positionMap["block"].version != 2 {
+		t.Errorf("expected the follower to apply the concurrent write but got %v, %v", shardNodeFSM.stash["block"], shardNodeFSM.positionMap["block"])
+	}
+}
+
+func TestHandleReplicateResponseDoesNotApplyARetriedWriteTwice(t *testing.T) {
+	keyedPayload := func(requestID string, value string, op OperationType, key string) ReplicateResponsePayload {
+		payload := createTestReplicateResponsePayload("block", requestID, "", value, op, 0)
+		payload.IdempotencyKey = key
+		return payload
+	}
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.handleReplicateResponse(keyedPayload("request1", "1", Write, "key1"))
+	shardNodeFSM.handleReplicateResponse(keyedPayload("request2", "2", Write, "key2"))
+	response := shardNodeFSM.handleReplicateResponse(keyedPayload("request3", "1", Write, "key1"))
+	if response.value != "1" || response.version != 1 {
+		t.Errorf("expected the retry to get the response of the first write but got %v", response)
+	}
+	if shardNodeFSM.stash["block"].value != "2" || shardNodeFSM.positionMap["block"].version != 2 {
+		t.Errorf("expected the retry not to be applied but got %v, %v", shardNodeFSM.stash["block"], shardNodeFSM.positionMap["block"])
+	}
+
+	cas := keyedPayload("request4", "3", CompareAndSwap, "key3")
+	cas.Expected = "2"
+	shardNodeFSM.handleReplicateResponse(cas)
+	cas.RequestID = "request5"
+	response = shardNodeFSM.handleReplicateResponse(cas)
+	if !response.swapped || response.value != "3" || response.version != 3 {
+		t.Errorf("expected the retried swap to get the response of the first swap but got %v", response)
+	}
+}
+
+func TestHandleReplicateResponseForgetsTheWritesAppliedBeforeForgetBefore(t *testing.T) {
+	start := time.Now()
+	keyedPayload := func(requestID string, value string, key string, appliedAt time.Time) ReplicateResponsePayload {
+		payload := createTestReplicateResponsePayload("block", requestID, "", value, Write, 0)
+		payload.IdempotencyKey = key
+		payload.AppliedAt = appliedAt
+		payload.ForgetBefore = appliedAt.Add(-time.Minute)
+		return payload
+	}
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.handleReplicateResponse(keyedPayload("request1", "1", "key1", start))
+	response := shardNodeFSM.handleReplicateResponse(keyedPayload("request2", "1", "key1", start.Add(30*time.Second)))
+	if response.version != 1 {
+		t.Errorf("expected the retry in the window not to be applied but got version %d", response.version)
+	}
+	shardNodeFSM.handleReplicateResponse(keyedPayload("request3", "2", "key2", start.Add(2*time.Minute)))
+	if _, exists := shardNodeFSM.appliedWrites["block/key1"]; exists || len(shardNodeFSM.appliedWritesOrder) != 1 {
+		t.Errorf("expected only the write of key2 to be kept but got %v", shardNodeFSM.appliedWritesOrder)
+	}
+	response = shardNodeFSM.handleReplicateResponse(keyedPayload("request4", "1", "key1", start.Add(2*time.Minute)))
+	if response.version != 3 || shardNodeFSM.stash["block"].value != "1" {
+		t.Errorf("expected the retry after the window to be applied again but got %v", response)
+	}
+}
+
+func TestHandleReplicateSentBlocksDropsOnlyDroppableTombstones(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash = map[string]stashState{
+		"deleted": {tombstone: true},
+		"waiting": {tombstone: true, waitingStatus: true},
+		"written": {value: "value"},
+	}
+	for block := range shardNodeFSM.stash {
+		shardNodeFSM.positionMap[block] = positionState{path: 1, storageID: 0}
+	}
+	shardNodeFSM.handleReplicateSentBlocks(ReplicateSentBlocksPayload{DroppedBlocks: []string{"deleted", "waiting", "written"}})
+	if _, exists := shardNodeFSM.stash["deleted"]; exists {
+		t.Errorf("expected the tombstone to be dropped from the stash")
+	}
+	if _, exists := shardNodeFSM.positionMap["deleted"]; exists {
+		t.Errorf("expected the position of the dropped tombstone to be removed")
+	}
+	if _, exists := shardNodeFSM.stash["waiting"]; !exists {
+		t.Errorf("expected the tombstone that waits for an ack to stay in the stash")
+	}
+	if _, exists := shardNodeFSM.stash["written"]; !exists {
+		t.Errorf("expected the block that is not a tombstone to stay in the stash")
+	}
+}
+
+func TestHandleReplicateSentBlocksKeepsTheBlocksThatChangedSinceTheyWereRead(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash = map[string]stashState{
+		"unchanged": {value: "value", logicalTime: 3},
+		"changed":   {value: "new", logicalTime: 3},
+	}
+	shardNodeFSM.handleReplicateSentBlocks(ReplicateSentBlocksPayload{
+		EvictionID: "eviction",
+		SentBlocks: []SentBlock{{Block: "unchanged", Value: "value"}, {Block: "changed", Value: "old"}, {Block: "removed", Value: "value"}},
+	})
+	if shardNodeFSM.stash["unchanged"].logicalTime != 0 || !shardNodeFSM.stash["unchanged"].waitingStatus {
+		t.Errorf("expected the unchanged block to wait with logical time zero but got %v", shardNodeFSM.stash["unchanged"])
+	}
+	if shardNodeFSM.stash["changed"].logicalTime == 0 || !shardNodeFSM.stash["changed"].waitingStatus {
+		t.Errorf("expected the changed block to wait with a non zero logical time but got %v", shardNodeFSM.stash["changed"])
+	}
+	if _, exists := shardNodeFSM.stash["removed"]; exists {
+		t.Errorf("expected the removed block not to be added to the stash")
+	}
+	if len(shardNodeFSM.evictions["eviction"]) != 2 {
+		t.Errorf("expected only the blocks in the stash to be recorded but got %v", shardNodeFSM.evictions["eviction"])
+	}
+}
+
+func TestHandleReplicateSentBlocksIgnoresAnEvictionThatWasAlreadySent(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash = map[string]stashState{
+		"a": {value: "a"},
+		"b": {value: "b"},
+	}
+	shardNodeFSM.handleReplicateSentBlocks(ReplicateSentBlocksPayload{EvictionID: "eviction", SentBlocks: []SentBlock{{Block: "a", Value: "a"}}})
+	shardNodeFSM.handleReplicateSentBlocks(ReplicateSentBlocksPayload{EvictionID: "eviction", SentBlocks: []SentBlock{{Block: "b", Value: "b"}}})
+	if shardNodeFSM.stash["b"].waitingStatus {
+		t.Errorf("expected the blocks of a repeated eviction to be ignored")
+	}
+	if len(shardNodeFSM.evictions["eviction"]) != 1 || shardNodeFSM.evictions["eviction"][0].Block != "a" {
+		t.Errorf("expected the eviction to keep its first blocks but got %v", shardNodeFSM.evictions["eviction"])
+	}
+}
+
+func TestHandleReplicateSentBlocksIgnoresAFinishedEviction(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash = map[string]stashState{
+		"a":         {value: "a"},
+		"tombstone": {tombstone: true},
+	}
+	shardNodeFSM.positionMap["tombstone"] = positionState{}
+	shardNodeFSM.recordFinishedEviction("eviction", true)
+	shardNodeFSM.handleReplicateSentBlocks(ReplicateSentBlocksPayload{EvictionID: "eviction", SentBlocks: []SentBlock{{Block: "a", Value: "a"}}, DroppedBlocks: []string{"tombstone"}})
+	if shardNodeFSM.stash["a"].waitingStatus {
+		t.Errorf("expected the blocks of a finished eviction to be ignored")
+	}
+	if _, exists := shardNodeFSM.evictions["eviction"]; exists {
+		t.Errorf("expected a finished eviction not to wait for acks again")
+	}
+	if _, exists := shardNodeFSM.stash["tombstone"]; !exists {
+		t.Errorf("expected a finished eviction not to drop tombstones")
+	}
+}
+
+func TestHandleReplicateAcksNacksKeepsChangedBlocksForAnotherEviction(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash = map[string]stashState{
+		"unchanged": {value: "value", waitingStatus: true},
+		"changed":   {value: "value", waitingStatus: true, logicalTime: 1},
+		"nacked":    {value: "value", waitingStatus: true},
+	}
+	shardNodeFSM.evictions["eviction"] = []SentBlock{{Block: "unchanged"}, {Block: "changed"}, {Block: "nacked"}}
+	shardNodeFSM.handleReplicateAcksNacks(ReplicateAcksNacksPayload{EvictionID: "eviction", AckedBlocks: []string{"unchanged", "changed"}, NackedBlocks: []string{"nacked"}})
+	if _, exists := shardNodeFSM.stash["unchanged"]; exists {
+		t.Errorf("expected the acked block to be removed from the stash")
+	}
+	if shardNodeFSM.stash["changed"].waitingStatus {
+		t.Errorf("expected the block that changed during the eviction to stop waiting")
+	}
+	if shardNodeFSM.stash["nacked"].waitingStatus {
+		t.Errorf("expected the nacked block to stop waiting")
+	}
+	if _, exists := shardNodeFSM.evictions["eviction"]; exists {
+		t.Errorf("expected the eviction to be removed after its acks")
+	}
+}
+
+func TestHandleReplicateAcksNacksIgnoresAcksOfAFinishedEviction(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	shardNodeFSM.stash = map[string]stashState{
+		"block": {value: "value", waitingStatus: true},
+	}
+	shardNodeFSM.evictions["eviction"] = []SentBlock{{Block: "block"}}
+	shardNodeFSM.handleReplicateAcksNacks(ReplicateAcksNacksPayload{EvictionID: "eviction"})
+	// The block is sent again for another eviction before the acks of the first one are retried
+	shardNodeFSM.stash["block"] = stashState{value: "value", waitingStatus: true}
+	shardNodeFSM.evictions["another"] = []SentBlock{{Block: "block"}}
+	shardNodeFSM.handleReplicateAcksNacks(ReplicateAcksNacksPayload{EvictionID: "eviction", AckedBlocks: []string{"block"}})
+	if state, exists := shardNodeFSM.stash["block"]; !exists || !state.waitingStatus {
+		t.Errorf("expected the retried acks of a finished eviction to be ignored")
+	}
+}
+
+func TestRecordFinishedEvictionForgetsTheOldestEvictionsIfTheWindowIsFull(t *testing.T) {
+	shardNodeFSM := newShardNodeFSM(0)
+	for i := 0; i < finishedEvictionsWindowSize+1; i++ {
+		shardNodeFSM.recordFinishedEviction(fmt.Sprintf("eviction%d", i), i == 1)
+	}
+	if _, finished := shardNodeFSM.getFinishedEviction("eviction0"); finished {
+		t.Errorf("expected the oldest eviction to be forgotten")
+	}
+	if timedOut, finished := shardNodeFSM.getFinishedEviction("eviction1"); !finished || !timedOut {
+		t.Errorf("expected eviction1 to be finished and timed out")
+	}
+	if len(shardNodeFSM.finishedEvictions) != finishedEvictionsWindowSize || len(shardNodeFSM.finishedEvictionsOrder) != finishedEvictionsWindowSize {
+		t.Errorf("expected %d finished evictions but got %d", finishedEvictionsWindowSize, len(shardNodeFSM.finishedEvictions))
+	}
+}
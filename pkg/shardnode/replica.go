@@ -0,0 +1,129 @@
+package shardnode
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/admin"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
+	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+// Replica is a running shard node replica.
+// Unlike StartServer, StartReplica does not block and the replica can be stopped,
+// so that the tests can run many replicas in one process and crash them.
+type Replica struct {
+	server     *shardNodeServer
+	grpcServer *grpc.Server
+}
+
+type ReplicaConfig struct {
+	ShardNodeID        int
+	ReplicaID          int
+	Listener           net.Listener // the replica serves its grpc services on it
+	OramNodeRPCClients map[int]ReplicaRPCClientMap
+	Parameters         config.Parameters
+	Storages           []config.RedisEndpoint
+	ParametersPath     string // the parameters file is not watched if it is empty
+
+	// The raft node uses the default config if it is nil. Its local id is the replica id.
+	// The snapshots should be disabled in it, since the shard node FSM can not restore a snapshot.
+	RaftConfig    *raft.Config
+	RaftTransport raft.Transport
+	// A replica that is restarted with the stores of a stopped replica applies its log again.
+	RaftLogs      raft.LogStore
+	RaftStable    raft.StableStore
+	RaftSnapshots raft.SnapshotStore
+	Bootstrap     *raft.Configuration // the replica bootstraps the raft cluster with it if it is not nil
+
+	Faults      *faults.Injector  // the faults of the replica, fault injection is disabled if it is nil
+	DialOptions []grpc.DialOption // they are added to the default options when the replica dials other shard nodes
+}
+
+// It creates the server of the replica and starts its background loops.
+// The injector faults the RPCs of the replica, and fault injection is disabled if it is nil.
+func newReplica(shardNodeServerID int, replicaID int, r *raft.Raft, fsm *shardNodeFSM, oramNodeRPCClients map[int]ReplicaRPCClientMap, parameters config.Parameters, storages []config.RedisEndpoint, parametersPath string, injector *faults.Injector, dialOptions []grpc.DialOption) *Replica {
+	storageORAMNodeMap := make(map[int]int)
+	for _, storage := range storages {
+		storageORAMNodeMap[storage.ID] = storage.ORAMNodeID
+	}
+	shardnodeServer := newShardNodeServer(shardNodeServerID, replicaID, r, fsm, oramNodeRPCClients, storageORAMNodeMap, parameters.TreeHeight, newBatchManager(time.Duration(parameters.BatchTimout)*time.Millisecond))
+	shardnodeServer.storageRampUp = time.Duration(parameters.StorageRampUp) * time.Millisecond
+	shardnodeServer.parameters = parameters
+	if parameters.Shift > 0 {
+		shardnodeServer.storageShift = parameters.Shift
+	}
+	shardnodeServer.faults = injector
+	shardnodeServer.dialOptions = dialOptions
+	go shardnodeServer.sendBatchesForever()
+	go shardnodeServer.nackTimedOutEvictionsForever(newEvictionTimer(time.Duration(parameters.EvictionTimeout) * time.Millisecond))
+	go shardnodeServer.resolveInterruptedMigrationsForever(time.Duration(parameters.MigrationTimeout) * time.Millisecond)
+	go shardnodeServer.resolveTimedOutTransactionsForever(shardnodeServer.getPrepareTimeout())
+
+	go func() {
+		for {
+			select {
+			case <-time.After(1 * time.Second):
+				shardnodeServer.shardNodeFSM.printStashSize()
+			case <-shardnodeServer.stop:
+				return
+			}
+		}
+	}()
+
+	interceptors := []grpc.UnaryServerInterceptor{rpc.ContextPropagationUnaryServerInterceptor()}
+	// The interceptor locks the injector on every RPC, so it is only installed if fault injection is enabled.
+	if injector != nil {
+		interceptors = append(interceptors, injector.UnaryServerInterceptor())
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	pb.RegisterShardNodeServer(grpcServer, shardnodeServer)
+	admin.RegisterAdminServer(grpcServer, shardnodeServer, parametersPath, injector)
+	return &Replica{server: shardnodeServer, grpcServer: grpcServer}
+}
+
+// StartReplica starts a replica with the raft transport and stores of the config, and serves it on the listener of the config.
+func StartReplica(c ReplicaConfig) (*Replica, error) {
+	raftConfig := raft.DefaultConfig()
+	if c.RaftConfig != nil {
+		configCopy := *c.RaftConfig
+		raftConfig = &configCopy
+	}
+	raftConfig.Logger = hclog.New(&hclog.LoggerOptions{Output: log.Logger})
+	raftConfig.LocalID = raft.ServerID(strconv.Itoa(c.ReplicaID))
+
+	fsm := newShardNodeFSM(c.ReplicaID)
+	r, err := raft.NewRaft(raftConfig, fsm, c.RaftLogs, c.RaftStable, c.RaftSnapshots, c.RaftTransport)
+	if err != nil {
+		return nil, err
+	}
+	if c.Bootstrap != nil {
+		// A replica that restarts has its configuration in its log, so the bootstrap fails and is not needed
+		r.BootstrapCluster(*c.Bootstrap)
+	}
+	replica := newReplica(c.ShardNodeID, c.ReplicaID, r, fsm, c.OramNodeRPCClients, c.Parameters, c.Storages, c.ParametersPath, c.Faults, c.DialOptions)
+	go replica.grpcServer.Serve(c.Listener)
+	return replica, nil
+}
+
+// Stop stops the replica like a crash.
+// The grpc server stops without waiting for the running requests, the raft node shuts down and the background loops end.
+func (r *Replica) Stop() {
+	close(r.server.stop)
+	r.grpcServer.Stop()
+	err := r.server.raftNode.Shutdown().Error()
+	if err != nil {
+		log.Error().Msgf("Could not shut down the raft node of shard node replica %d; %s", r.server.replicaID, err)
+	}
+}
+
+func (r *Replica) IsLeader() bool {
+	return r.server.raftNode.State() == raft.Leader
+}
@@ -0,0 +1,72 @@
+package shardnode
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/commonerrs"
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+)
+
+// It sets the position of the block and adds the block to the key index if it is new.
+// The caller should hold positionMapMu.
+func (fsm *shardNodeFSM) setPosition(block string, position positionState) {
+	if _, exists := fsm.positionMap[block]; !exists {
+		fsm.keyIndex.insert(block)
+	}
+	fsm.positionMap[block] = position
+}
+
+// It removes the position of the block and removes the block from the key index.
+// The caller should hold positionMapMu.
+func (fsm *shardNodeFSM) deletePosition(block string) {
+	if _, exists := fsm.positionMap[block]; !exists {
+		return
+	}
+	delete(fsm.positionMap, block)
+	fsm.keyIndex.delete(block)
+}
+
+// It returns up to limit blocks that start with the prefix, in order.
+// The tombstones and the blocks that are redirected to another shard node are skipped.
+func (fsm *shardNodeFSM) scan(prefix string, limit int) (blocks []string) {
+	fsm.ownershipMu.RLock()
+	ownership := fsm.ownership
+	fsm.ownershipMu.RUnlock()
+
+	log.Debug().Msgf("Aquiring lock for shardNodeFSM in scan")
+	fsm.stashMu.Lock()
+	fsm.positionMapMu.RLock()
+	log.Debug().Msgf("Aquired lock for shardNodeFSM in scan")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for shardNodeFSM in scan")
+		fsm.positionMapMu.RUnlock()
+		fsm.stashMu.Unlock()
+		log.Debug().Msgf("Released lock for shardNodeFSM in scan")
+	}()
+	fsm.keyIndex.ascend(prefix, func(block string) bool {
+		if len(blocks) >= limit || !strings.HasPrefix(block, prefix) {
+			return false
+		}
+		if !fsm.stash[block].tombstone && (ownership == nil || ownership.owns(block)) {
+			blocks = append(blocks, block)
+		}
+		return true
+	})
+	return blocks
+}
+
+// Scan returns the blocks of the shard node that start with the prefix.
+// It only reads the position map, so the storage does not see the scan.
+func (s *shardNodeServer) Scan(ctx context.Context, request *pb.ScanRequest) (*pb.ScanReply, error) {
+	if s.raftNode.State() != raft.Leader {
+		return nil, fmt.Errorf(commonerrs.NotTheLeaderError)
+	}
+	log.Debug().Msgf("Received scan request for prefix %s with limit %d", request.Prefix, request.Limit)
+	blocks := s.shardNodeFSM.scan(request.Prefix, int(request.Limit))
+	log.Debug().Msgf("Returning %d blocks for the scan of prefix %s", len(blocks), request.Prefix)
+	return &pb.ScanReply{Blocks: blocks}, nil
+}
@@ -0,0 +1,68 @@
+package shardnode
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/hashicorp/raft"
+)
+
+func TestSetAndDeletePositionKeepTheKeyIndexInOrder(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	for _, block := range []string{"c", "a", "b", "a"} {
+		fsm.setPosition(block, positionState{})
+	}
+	if !reflect.DeepEqual(fsm.keyIndex.keys(), []string{"a", "b", "c"}) {
+		t.Errorf("expected the key index [a b c] but got %v", fsm.keyIndex.keys())
+	}
+	fsm.deletePosition("b")
+	fsm.deletePosition("missing")
+	if !reflect.DeepEqual(fsm.keyIndex.keys(), []string{"a", "c"}) {
+		t.Errorf("expected the key index [a c] but got %v", fsm.keyIndex.keys())
+	}
+	if _, exists := fsm.positionMap["b"]; exists {
+		t.Errorf("expected the position of b to be deleted")
+	}
+}
+
+func TestScanReturnsExistingBlocksWithThePrefixInOrder(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	for _, block := range []string{"user/3", "user/1", "user/2", "user/4", "order/1", "users"} {
+		fsm.setPosition(block, positionState{})
+	}
+	fsm.stash["user/2"] = stashState{tombstone: true}
+	blocks := fsm.scan("user/", 10)
+	if !reflect.DeepEqual(blocks, []string{"user/1", "user/3", "user/4"}) {
+		t.Errorf("expected [user/1 user/3 user/4] but got %v", blocks)
+	}
+	blocks = fsm.scan("user/", 2)
+	if !reflect.DeepEqual(blocks, []string{"user/1", "user/3"}) {
+		t.Errorf("expected the scan to stop at the limit but got %v", blocks)
+	}
+}
+
+func TestScanSkipsRedirectedBlocks(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	fsm.ownership = newOwnershipRing([]int{0, 1}, 10, 0)
+	var owned []string
+	for _, block := range []string{"a", "b", "c", "d", "e", "f"} {
+		fsm.setPosition(block, positionState{})
+		if fsm.ownership.owns(block) {
+			owned = append(owned, block)
+		}
+	}
+	blocks := fsm.scan("", 10)
+	if !reflect.DeepEqual(blocks, owned) {
+		t.Errorf("expected only the owned blocks %v but got %v", owned, blocks)
+	}
+}
+
+func TestScanReturnsErrorForNonLeaderRaftPeer(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), nil, map[int]int{0: 0}, 5, newBatchManager(1))
+	_, err := s.Scan(context.Background(), &shardnodepb.ScanRequest{Prefix: "a", Limit: 10})
+	if err == nil {
+		t.Errorf("expected Scan to fail on a follower")
+	}
+}
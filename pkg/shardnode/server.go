@@ -5,13 +5,15 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	pb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
 	"github.com/dsg-uwaterloo/treebeard/pkg/commonerrs"
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
-	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
 	"github.com/dsg-uwaterloo/treebeard/pkg/storage"
 	"github.com/hashicorp/raft"
 	"github.com/rs/zerolog/log"
@@ -27,9 +29,16 @@ type shardNodeServer struct {
 	raftNode           *raft.Raft
 	shardNodeFSM       *shardNodeFSM
 	oramNodeClients    RPCClientMap
-	storageORAMNodeMap map[int]int // map of storageID to responsible oramNodeID
+	storageORAMNodeMap map[int]int // map of storageID to responsible oramNodeID for the storages at the start
 	storageTreeHeight  int
+	storageShift       int               // the shift of the storage trees, one for binary trees
+	storageRampUp      time.Duration     // how long an added storage takes to get its full share of blocks
+	parameters         config.Parameters // the batch timeout in it is not updated at runtime, the batch manager has the current one
 	batchManager       *batchManager
+	migrationMu        sync.RWMutex  // queries hold it for reading and migrations for writing
+	stop               chan struct{} // it is closed when the replica stops to end the background loops
+	faults             *faults.Injector
+	dialOptions        []grpc.DialOption // they are added to the default options when the server dials other shard nodes
 }
 
 func newShardNodeServer(shardNodeServerID int, replicaID int, raftNode *raft.Raft, fsm *shardNodeFSM, oramNodeRPCClients RPCClientMap, storageORAMNodeMap map[int]int, storageTreeHeight int, batchManager *batchManager) *shardNodeServer {
@@ -42,6 +51,8 @@ func newShardNodeServer(shardNodeServerID int, replicaID int, raftNode *raft.Raf
 		batchManager:       batchManager,
 		storageORAMNodeMap: storageORAMNodeMap,
 		storageTreeHeight:  storageTreeHeight,
+		storageShift:       1,
+		stop:               make(chan struct{}),
 	}
 }
 
@@ -50,20 +61,29 @@ type OperationType int
 const (
 	Read = iota
 	Write
+	Delete
+	CompareAndSwap
 )
 
+// It is the condition of a compare and swap request.
+// The swap is applied if the block has the expected value and, if expectedVersion is not zero, the expected version.
+type writeCondition struct {
+	expected        string
+	expectedVersion uint64
+}
+
 // For the initial request of a block, it returns the path and storageID from the position map.
 // For other requests, it returns a random path and storageID and a random block.
 func (s *shardNodeServer) getWhatToSendBasedOnRequest(ctx context.Context, block string, requestID string, isFirst bool) (blockToRequest string, path int, storageID int) {
 	log.Debug().Msgf("Getting path and storageID based on request for block %s and requestID %s", block, requestID)
 	if !isFirst {
-		path, storageID = storage.GetRandomPathAndStorageID(s.storageTreeHeight, len(s.storageORAMNodeMap))
+		path, storageID = s.getRandomPathAndStorageID()
 		return block + strconv.Itoa(rand.Int()), path, storageID
 	} else {
 		s.shardNodeFSM.positionMapMu.RLock()
 		defer s.shardNodeFSM.positionMapMu.RUnlock()
 		if _, exists := s.shardNodeFSM.positionMap[block]; !exists {
-			path, storageID = storage.GetRandomPathAndStorageID(s.storageTreeHeight, len(s.storageORAMNodeMap))
+			path, storageID = s.getRandomPathAndStorageID()
 			return block, path, storageID
 		} else {
 			return block, s.shardNodeFSM.positionMap[block].path, s.shardNodeFSM.positionMap[block].storageID
@@ -73,24 +93,28 @@ func (s *shardNodeServer) getWhatToSendBasedOnRequest(ctx context.Context, block
 
 // It creates a channel for receiving the response from the raft FSM for the current requestID.
 // The response channel should be buffered so that we don't block the raft FSM even if the client is not reading from the channel right now.
-func (s *shardNodeServer) createResponseChannelForBatch(readRequests []*pb.ReadRequest, writeRequests []*pb.WriteRequest) map[string]chan string {
-	channelMap := make(map[string]chan string)
+func (s *shardNodeServer) createResponseChannelForBatch(readRequests []*pb.ReadRequest, writeRequests []*pb.WriteRequest) map[string]chan blockResponse {
+	channelMap := make(map[string]chan blockResponse)
 	for _, req := range readRequests {
-		channelMap[req.RequestId] = make(chan string, 1)
+		channelMap[req.RequestId] = make(chan blockResponse, 1)
 		s.shardNodeFSM.responseChannel.Store(req.RequestId, channelMap[req.RequestId])
 	}
 	for _, req := range writeRequests {
-		channelMap[req.RequestId] = make(chan string, 1)
+		channelMap[req.RequestId] = make(chan blockResponse, 1)
 		s.shardNodeFSM.responseChannel.Store(req.RequestId, channelMap[req.RequestId])
 	}
 	return channelMap
 }
 
-// It periodically sends batches.
+// It periodically sends batches until the server stops.
 func (s *shardNodeServer) sendBatchesForever() {
 	for {
-		<-time.After(s.batchManager.batchTimeout)
-		s.sendCurrentBatches()
+		select {
+		case <-time.After(s.batchManager.getBatchTimeout()):
+			s.sendCurrentBatches()
+		case <-s.stop:
+			return
+		}
 	}
 }
 
@@ -123,7 +147,7 @@ func (s *shardNodeServer) sendCurrentBatches() {
 			continue
 		}
 		waitingBatchCount++
-		oramNodeReplicaMap := s.oramNodeClients[s.storageORAMNodeMap[storageID]]
+		oramNodeReplicaMap := s.oramNodeClients[s.getORAMNodeID(storageID)]
 		go s.batchManager.asyncBatchRequests(context.Background(), storageID, requests, oramNodeReplicaMap, batchRequestResponseChan)
 	}
 
@@ -151,9 +175,18 @@ func (s *shardNodeServer) sendCurrentBatches() {
 	}
 }
 
+func writeRequestOperationType(writeRequest *pb.WriteRequest) OperationType {
+	if writeRequest.Delete {
+		return Delete
+	} else if writeRequest.Compare {
+		return CompareAndSwap
+	}
+	return Write
+}
+
 func (s *shardNodeServer) getRequestReplicationBlocks(readRequests []*pb.ReadRequest, writeRequests []*pb.WriteRequest) (requestReplicationBlocks []ReplicateRequestAndPathAndStoragePayload) {
 	for _, readRequest := range readRequests {
-		newPath, newStorageID := storage.GetRandomPathAndStorageID(s.storageTreeHeight, len(s.storageORAMNodeMap))
+		newPath, newStorageID := s.getRandomPathAndStorageID()
 		requestReplicationBlocks = append(requestReplicationBlocks, ReplicateRequestAndPathAndStoragePayload{
 			RequestedBlock: readRequest.Block,
 			RequestID:      readRequest.RequestId,
@@ -162,12 +195,22 @@ func (s *shardNodeServer) getRequestReplicationBlocks(readRequests []*pb.ReadReq
 		})
 	}
 	for _, writeRequest := range writeRequests {
-		newPath, newStorageID := storage.GetRandomPathAndStorageID(s.storageTreeHeight, len(s.storageORAMNodeMap))
+		newPath, newStorageID := s.getRandomPathAndStorageID()
+		// The write of a transaction is prepared instead of being applied, so it is applied like a read
+		var opType OperationType = Read
+		if writeRequest.TransactionId == "" {
+			opType = writeRequestOperationType(writeRequest)
+		}
 		requestReplicationBlocks = append(requestReplicationBlocks, ReplicateRequestAndPathAndStoragePayload{
-			RequestedBlock: writeRequest.Block,
-			RequestID:      writeRequest.RequestId,
-			Path:           newPath,
-			StorageID:      newStorageID,
+			RequestedBlock:  writeRequest.Block,
+			RequestID:       writeRequest.RequestId,
+			Path:            newPath,
+			StorageID:       newStorageID,
+			OpType:          opType,
+			NewValue:        writeRequest.Value,
+			Expected:        writeRequest.Expected,
+			ExpectedVersion: writeRequest.ExpectedVersion,
+			IdempotencyKey:  writeRequest.IdempotencyKey,
 		})
 	}
 	return requestReplicationBlocks
@@ -177,11 +220,33 @@ type finalResponse struct {
 	requestId string
 	value     string
 	opType    OperationType
+	success   bool // false if the write of a transaction could not be prepared, the compare and swap did not match or the write was rejected
+	exists    bool
+	version   uint64
 	err       error
 }
 
-func (s *shardNodeServer) query(ctx context.Context, block string, requestID string, isFirst bool, newVal string, opType OperationType, raftResponseChannel chan string, finalResponseChannel chan finalResponse) {
+// If transactionID is set, the write is prepared after the block is read instead of being applied.
+// The oram node sees the same accesses as for any other write.
+// The default time that the response of a write with an idempotency key is kept.
+// It is much longer than the default request timeout of the client, which bounds how long the client retries a write.
+const defaultIdempotencyWindow = 10 * time.Minute
+
+// A retry of a write is applied only once if it is replicated within the idempotency window after the first write.
+// A retry that comes later is applied again, so the window should be longer than the client retries a write.
+func (s *shardNodeServer) getIdempotencyWindow() time.Duration {
+	if s.parameters.IdempotencyWindow <= 0 {
+		return defaultIdempotencyWindow
+	}
+	return time.Duration(s.parameters.IdempotencyWindow) * time.Millisecond
+}
+
+func (s *shardNodeServer) query(ctx context.Context, block string, requestID string, isFirst bool, newVal string, opType OperationType, condition writeCondition, transactionID string, coordinatorReplicas []ShardNodeReplicaPayload, idempotencyKey string, raftResponseChannel chan blockResponse, finalResponseChannel chan finalResponse) {
 	tracer := otel.Tracer("")
+	responseOpType := opType
+	if transactionID != "" {
+		responseOpType = Read
+	}
 
 	blockToRequest, path, storageID := s.getWhatToSendBasedOnRequest(ctx, block, requestID, isFirst)
 	var replyValue string
@@ -193,8 +258,14 @@ func (s *shardNodeServer) query(ctx context.Context, block string, requestID str
 	waitOnReplySpan.End()
 
 	if isFirst {
+		err := s.faults.Hit(faults.BeforeReplicateResponse)
+		if err != nil {
+			finalResponseChannel <- finalResponse{requestId: requestID, value: "", opType: opType, err: fmt.Errorf("query stopped before the response was replicated; %s", err)}
+			return
+		}
 		log.Debug().Msgf("Adding response to response channel for block %s", blockToRequest)
-		responseReplicationCommand, err := newResponseReplicationCommand(replyValue, requestID, block, newVal, opType, s.replicaID)
+		now := time.Now()
+		responseReplicationCommand, err := newResponseReplicationCommand(replyValue, requestID, block, newVal, responseOpType, s.replicaID, condition, idempotencyKey, now, now.Add(-s.getIdempotencyWindow()))
 		if err != nil {
 			finalResponseChannel <- finalResponse{requestId: requestID, value: "", opType: opType, err: fmt.Errorf("could not create response replication command; %s", err)}
 			return
@@ -207,14 +278,40 @@ func (s *shardNodeServer) query(ctx context.Context, block string, requestID str
 			finalResponseChannel <- finalResponse{requestId: requestID, value: "", opType: opType, err: fmt.Errorf("could not apply log to the FSM; %s", err)}
 			return
 		}
-		response := responseApplyFuture.Response().(string)
-		log.Debug().Msgf("Got is first response from response channel for block %s; value: %s", block, response)
-		finalResponseChannel <- finalResponse{requestId: requestID, value: response, opType: opType, err: nil}
+		response := responseApplyFuture.Response().(blockResponse)
+		log.Debug().Msgf("Got is first response from response channel for block %s; value: %s", block, response.value)
+		s.finishQuery(ctx, block, requestID, response, newVal, opType, transactionID, coordinatorReplicas, finalResponseChannel)
 		return
 	}
 	responseValue := <-raftResponseChannel
-	log.Debug().Msgf("Got response from response channel for block %s; value: %s", block, responseValue)
-	finalResponseChannel <- finalResponse{requestId: requestID, value: responseValue, opType: opType, err: nil}
+	log.Debug().Msgf("Got response from response channel for block %s; value: %s", block, responseValue.value)
+	s.finishQuery(ctx, block, requestID, responseValue, newVal, opType, transactionID, coordinatorReplicas, finalResponseChannel)
+}
+
+// It prepares the write if it is part of a transaction and sends the final response.
+func (s *shardNodeServer) finishQuery(ctx context.Context, block string, requestID string, response blockResponse, newVal string, opType OperationType, transactionID string, coordinatorReplicas []ShardNodeReplicaPayload, finalResponseChannel chan finalResponse) {
+	if opType != Write || transactionID == "" {
+		success := !response.rejected
+		if opType == CompareAndSwap {
+			success = response.swapped
+		}
+		finalResponseChannel <- finalResponse{requestId: requestID, value: response.value, opType: opType, success: success, exists: response.exists, version: response.version, err: nil}
+		return
+	}
+	prepareCommand, err := newPrepareTransactionReplicationCommand(transactionID, block, newVal, coordinatorReplicas)
+	if err != nil {
+		finalResponseChannel <- finalResponse{requestId: requestID, value: "", opType: opType, err: fmt.Errorf("could not create prepare transaction replication command; %s", err)}
+		return
+	}
+	prepareApplyFuture := s.raftNode.Apply(prepareCommand, 0)
+	err = prepareApplyFuture.Error()
+	if err != nil {
+		finalResponseChannel <- finalResponse{requestId: requestID, value: "", opType: opType, err: fmt.Errorf("could not apply log to the FSM; %s", err)}
+		return
+	}
+	prepared := prepareApplyFuture.Response().(bool)
+	log.Debug().Msgf("Prepared write of transaction %s for block %s: %t", transactionID, block, prepared)
+	finalResponseChannel <- finalResponse{requestId: requestID, value: response.value, opType: opType, success: prepared, exists: response.exists, version: response.version, err: nil}
 }
 
 func (s *shardNodeServer) queryBatch(ctx context.Context, request *pb.RequestBatch) (reply *pb.ReplyBatch, err error) {
@@ -224,15 +321,25 @@ func (s *shardNodeServer) queryBatch(ctx context.Context, request *pb.RequestBat
 	tracer := otel.Tracer("")
 	ctx, querySpan := tracer.Start(ctx, "shardnode query")
 
+	// A migration can not start between checking the redirected blocks and adding the requests to the request log.
+	s.migrationMu.RLock()
+	request, redirected := s.separateRedirectedRequests(request)
+	if len(request.ReadRequests) == 0 && len(request.WriteRequests) == 0 {
+		s.migrationMu.RUnlock()
+		querySpan.End()
+		return redirected, nil
+	}
 	responseChannel := s.createResponseChannelForBatch(request.ReadRequests, request.WriteRequests)
 	requestReplicationBlocks := s.getRequestReplicationBlocks(request.ReadRequests, request.WriteRequests)
 	requestReplicationCommand, err := newRequestReplicationCommand(requestReplicationBlocks, s.replicaID)
 	if err != nil {
+		s.migrationMu.RUnlock()
 		return nil, fmt.Errorf("could not create request replication command; %s", err)
 	}
 	_, requestReplicationSpan := tracer.Start(ctx, "apply request replication")
 	requestApplyFuture := s.raftNode.Apply(requestReplicationCommand, 0)
 	err = requestApplyFuture.Error()
+	s.migrationMu.RUnlock()
 	requestReplicationSpan.End()
 	if err != nil {
 		return nil, fmt.Errorf("could not apply log to the FSM; %s", err)
@@ -241,27 +348,32 @@ func (s *shardNodeServer) queryBatch(ctx context.Context, request *pb.RequestBat
 
 	finalResponseChan := make(chan finalResponse)
 	for _, readRequest := range request.ReadRequests {
-		go s.query(ctx, readRequest.Block, readRequest.RequestId, isFirstMap[readRequest.RequestId], "", Read, responseChannel[readRequest.RequestId], finalResponseChan)
+		go s.query(ctx, readRequest.Block, readRequest.RequestId, isFirstMap[readRequest.RequestId], "", Read, writeCondition{}, "", nil, "", responseChannel[readRequest.RequestId], finalResponseChan)
 	}
 	for _, writeRequest := range request.WriteRequests {
-		go s.query(ctx, writeRequest.Block, writeRequest.RequestId, isFirstMap[writeRequest.RequestId], writeRequest.Value, Write, responseChannel[writeRequest.RequestId], finalResponseChan)
+		opType := writeRequestOperationType(writeRequest)
+		var condition writeCondition
+		if opType == CompareAndSwap {
+			condition = writeCondition{expected: writeRequest.Expected, expectedVersion: writeRequest.ExpectedVersion}
+		}
+		go s.query(ctx, writeRequest.Block, writeRequest.RequestId, isFirstMap[writeRequest.RequestId], writeRequest.Value, opType, condition, writeRequest.TransactionId, getCoordinatorReplicas(writeRequest), writeRequest.IdempotencyKey, responseChannel[writeRequest.RequestId], finalResponseChan)
 	}
 
-	var readReplies []*pb.ReadReply
-	var writeReplies []*pb.WriteReply
+	readReplies := redirected.ReadReplies
+	writeReplies := redirected.WriteReplies
 	for i := 0; i < len(request.ReadRequests)+len(request.WriteRequests); i++ {
 		response := <-finalResponseChan
 		if response.err != nil {
 			return nil, fmt.Errorf("could not get response from the oramnode; %s", response.err)
 		}
 		if response.opType == Read {
-			readReplies = append(readReplies, &pb.ReadReply{RequestId: response.requestId, Value: response.value})
+			readReplies = append(readReplies, &pb.ReadReply{RequestId: response.requestId, Value: response.value, Exists: response.exists, Version: response.version})
 		} else {
-			writeReplies = append(writeReplies, &pb.WriteReply{RequestId: response.requestId, Success: true})
+			writeReplies = append(writeReplies, &pb.WriteReply{RequestId: response.requestId, Success: response.success, Value: response.value, Version: response.version})
 		}
 	}
 	querySpan.End()
-	return &pb.ReplyBatch{ReadReplies: readReplies, WriteReplies: writeReplies}, nil
+	return &pb.ReplyBatch{ReadReplies: readReplies, WriteReplies: writeReplies, Ring: redirected.Ring}, nil
 }
 
 func (s *shardNodeServer) BatchQuery(ctx context.Context, request *pb.RequestBatch) (*pb.ReplyBatch, error) {
@@ -275,8 +387,12 @@ func (s *shardNodeServer) BatchQuery(ctx context.Context, request *pb.RequestBat
 }
 
 // It gets maxBlocks from the stash to send to the requesting oram node.
-// The blocks should be for the same path and storageID.
-func (s *shardNodeServer) getBlocksForSend(maxBlocks int, storageID int) (blocksToReturn []*pb.Block, blocks []string) {
+// It only returns blocks of the storageID that are not waiting for an ack.
+// The blocks with pending requests are not sent, since their responses may change the block after it is sent,
+// and the ack of the old value would then remove the new value from the stash.
+// Blocks whose path shares a deeper prefix with one of the eviction paths are preferred,
+// since the oram node can place them further away from the root.
+func (s *shardNodeServer) getBlocksForSend(maxBlocks int, paths []int, storageID int) (blocksToReturn []*pb.Block, blocks []string) {
 	log.Debug().Msgf("Aquiring lock for shard node FSM in getBlocksForSend")
 	s.shardNodeFSM.stashMu.Lock()
 	s.shardNodeFSM.positionMapMu.RLock()
@@ -288,26 +404,112 @@ func (s *shardNodeServer) getBlocksForSend(maxBlocks int, storageID int) (blocks
 		log.Debug().Msgf("Released lock for shard node FSM in getBlocksForSend")
 	}()
 
-	counter := 0
+	type candidateBlock struct {
+		block string
+		depth int
+	}
+	var candidates []candidateBlock
 	for block, stashState := range s.shardNodeFSM.stash {
-		if counter == int(maxBlocks) {
-			break
+		// The prepared blocks stay in the stash until their transaction is decided
+		if stashState.waitingStatus || stashState.tombstone || len(s.shardNodeFSM.pendingRequests[block]) != 0 || s.shardNodeFSM.isPrepared(block) {
+			continue
 		}
-		blocksToReturn = append(blocksToReturn, &pb.Block{Block: block, Value: stashState.value, Path: int32(s.shardNodeFSM.positionMap[block].path)})
-		blocks = append(blocks, block)
-		counter++
+		position, exists := s.shardNodeFSM.positionMap[block]
+		if !exists || position.storageID != storageID {
+			continue
+		}
+		depth := 0
+		for _, path := range paths {
+			commonDepth := storage.GetCommonPathDepth(position.path, path, s.storageTreeHeight, s.storageShift)
+			if commonDepth > depth {
+				depth = commonDepth
+			}
+		}
+		candidates = append(candidates, candidateBlock{block: block, depth: depth})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].depth == candidates[j].depth {
+			return candidates[i].block < candidates[j].block
+		}
+		return candidates[i].depth > candidates[j].depth
+	})
 
+	for i := 0; i < len(candidates) && i < maxBlocks; i++ {
+		block := candidates[i].block
+		blocksToReturn = append(blocksToReturn, &pb.Block{Block: block, Value: s.shardNodeFSM.stash[block].value, Path: int32(s.shardNodeFSM.positionMap[block].path)})
+		blocks = append(blocks, block)
 	}
-	log.Debug().Msgf("Sending blocks %v for storageID %d", blocks, storageID)
+	log.Debug().Msgf("Sending blocks %v for paths %v and storageID %d", blocks, paths, storageID)
 	return blocksToReturn, blocks
 }
 
+// It returns the tombstones of the storage that can be dropped from the stash.
+// The blocks with requests in progress are kept, since their requests may still need the tombstone.
+func (s *shardNodeServer) getTombstonesToDrop(storageID int) (blocks []string) {
+	s.shardNodeFSM.requestLogMu.Lock()
+	var busyBlocks []string
+	for block, requests := range s.shardNodeFSM.requestLog {
+		if len(requests) != 0 {
+			busyBlocks = append(busyBlocks, block)
+		}
+	}
+	s.shardNodeFSM.requestLogMu.Unlock()
+
+	log.Debug().Msgf("Aquiring lock for shard node FSM in getTombstonesToDrop")
+	s.shardNodeFSM.stashMu.Lock()
+	s.shardNodeFSM.positionMapMu.RLock()
+	log.Debug().Msgf("Aquired lock for shard node FSM in getTombstonesToDrop")
+	defer func() {
+		log.Debug().Msgf("Releasing lock for shard node FSM in getTombstonesToDrop")
+		s.shardNodeFSM.stashMu.Unlock()
+		s.shardNodeFSM.positionMapMu.RUnlock()
+		log.Debug().Msgf("Released lock for shard node FSM in getTombstonesToDrop")
+	}()
+	busy := make(map[string]bool)
+	for _, block := range busyBlocks {
+		busy[block] = true
+	}
+	for block := range s.shardNodeFSM.stash {
+		if busy[block] || !s.shardNodeFSM.isDroppable(block) || s.shardNodeFSM.positionMap[block].storageID != storageID {
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
 // It sends blocks to the oram node for eviction.
+// The tombstones of the storage are dropped at the same time, so the deleted blocks leave the stash like the evicted ones.
+// The blocks are recorded under the eviction id, so a retried or replayed request of the eviction gets the same blocks.
+// A request that reuses the id of a finished eviction gets no blocks, since its acks were already applied.
 func (s *shardNodeServer) SendBlocks(ctx context.Context, request *pb.SendBlocksRequest) (*pb.SendBlocksReply, error) {
+	if request.EvictionId == "" {
+		return nil, fmt.Errorf("the request does not have an eviction id")
+	}
+	if request.Replay {
+		return s.sendReplayedBlocks(request.EvictionId)
+	}
+	if sentBlocks, exists := s.shardNodeFSM.getSentBlocks(request.EvictionId); exists {
+		log.Debug().Msgf("Sending the recorded blocks of eviction %s again", request.EvictionId)
+		return &pb.SendBlocksReply{Blocks: sentBlocksToProto(sentBlocks)}, nil
+	}
+	if timedOut, finished := s.shardNodeFSM.getFinishedEviction(request.EvictionId); finished {
+		log.Debug().Msgf("Ignoring the request of eviction %s since it already finished", request.EvictionId)
+		return &pb.SendBlocksReply{Finished: true, TimedOut: timedOut}, nil
+	}
 
-	blocksToReturn, blocks := s.getBlocksForSend(int(request.MaxBlocks), int(request.StorageId))
+	var paths []int
+	for _, path := range request.Paths {
+		paths = append(paths, int(path))
+	}
+	blocksToReturn, _ := s.getBlocksForSend(int(request.MaxBlocks), paths, int(request.StorageId))
+	droppedBlocks := s.getTombstonesToDrop(int(request.StorageId))
 
-	sentBlocksReplicationCommand, err := newSentBlocksReplicationCommand(blocks)
+	var sentBlocks []SentBlock
+	for _, block := range blocksToReturn {
+		sentBlocks = append(sentBlocks, SentBlock{Block: block.Block, Value: block.Value, Path: int(block.Path)})
+	}
+	sentBlocksReplicationCommand, err := newSentBlocksReplicationCommand(request.EvictionId, sentBlocks, droppedBlocks)
 	if err != nil {
 		return nil, fmt.Errorf("could not create sent blocks replication command; %s", err)
 	}
@@ -316,12 +518,52 @@ func (s *shardNodeServer) SendBlocks(ctx context.Context, request *pb.SendBlocks
 		return nil, fmt.Errorf("could not apply log to the FSM; %s", err)
 	}
 
-	return &pb.SendBlocksReply{Blocks: blocksToReturn}, nil
+	// A concurrent request of the same eviction may have been applied first, so the recorded blocks are returned
+	sentBlocks, exists := s.shardNodeFSM.getSentBlocks(request.EvictionId)
+	if !exists {
+		if timedOut, finished := s.shardNodeFSM.getFinishedEviction(request.EvictionId); finished {
+			return &pb.SendBlocksReply{Finished: true, TimedOut: timedOut}, nil
+		}
+		return nil, fmt.Errorf("the eviction %s finished before its blocks were sent", request.EvictionId)
+	}
+	return &pb.SendBlocksReply{Blocks: sentBlocksToProto(sentBlocks)}, nil
+}
+
+// It answers the oram node before it replays an eviction.
+// The replay writes the recorded blocks only if the eviction is still outstanding,
+// since the blocks of a finished eviction may have changed or been evicted again after they were nacked.
+// Only the leader answers, after it applied the committed logs, so that it does not miss a finished eviction.
+func (s *shardNodeServer) sendReplayedBlocks(evictionID string) (*pb.SendBlocksReply, error) {
+	if s.raftNode.State() != raft.Leader {
+		return nil, fmt.Errorf(commonerrs.NotTheLeaderError)
+	}
+	err := s.raftNode.Barrier(0).Error()
+	if err != nil {
+		return nil, fmt.Errorf("could not apply the committed logs; %s", err)
+	}
+	if sentBlocks, exists := s.shardNodeFSM.getSentBlocks(evictionID); exists {
+		log.Debug().Msgf("Sending the recorded blocks of eviction %s for its replay", evictionID)
+		return &pb.SendBlocksReply{Blocks: sentBlocksToProto(sentBlocks)}, nil
+	}
+	// An eviction that is not known anymore finished long ago, and its blocks may be the only copies in the storage
+	timedOut, _ := s.shardNodeFSM.getFinishedEviction(evictionID)
+	log.Debug().Msgf("Eviction %s is not outstanding anymore, timed out: %t", evictionID, timedOut)
+	return &pb.SendBlocksReply{Finished: true, TimedOut: timedOut}, nil
 }
 
-// It gets the acks and nacks from the oram node.
+func sentBlocksToProto(sentBlocks []SentBlock) (blocks []*pb.Block) {
+	for _, sentBlock := range sentBlocks {
+		blocks = append(blocks, &pb.Block{Block: sentBlock.Block, Value: sentBlock.Value, Path: int32(sentBlock.Path)})
+	}
+	return blocks
+}
+
+// It gets the acks and nacks of an eviction from the oram node.
 // Ackes and Nackes get replicated to be handled in the raft layer.
 func (s *shardNodeServer) AckSentBlocks(ctx context.Context, reply *pb.AckSentBlocksRequest) (*pb.AckSentBlocksReply, error) {
+	if reply.EvictionId == "" {
+		return nil, fmt.Errorf("the request does not have an eviction id")
+	}
 	var ackedBlocks []string
 	var nackedBlocks []string
 	for _, ack := range reply.Acks {
@@ -333,9 +575,13 @@ func (s *shardNodeServer) AckSentBlocks(ctx context.Context, reply *pb.AckSentBl
 			nackedBlocks = append(nackedBlocks, block)
 		}
 	}
-	log.Debug().Msgf("Received acks %v and nacks %v", ackedBlocks, nackedBlocks)
+	log.Debug().Msgf("Received acks %v and nacks %v for eviction %s", ackedBlocks, nackedBlocks, reply.EvictionId)
+	if _, finished := s.shardNodeFSM.getFinishedEviction(reply.EvictionId); finished {
+		log.Debug().Msgf("Ignoring the acks and nacks of eviction %s since it already finished", reply.EvictionId)
+		return &pb.AckSentBlocksReply{Success: true}, nil
+	}
 
-	acksNacksReplicationCommand, err := newAcksNacksReplicationCommand(ackedBlocks, nackedBlocks)
+	acksNacksReplicationCommand, err := newAcksNacksReplicationCommand(reply.EvictionId, ackedBlocks, nackedBlocks, false)
 	if err != nil {
 		return nil, fmt.Errorf("could not create acks/nacks replication command")
 	}
@@ -363,7 +609,8 @@ func (s *shardNodeServer) JoinRaftVoter(ctx context.Context, joinRaftVoterReques
 	return &pb.JoinRaftVoterReply{Success: true}, nil
 }
 
-func StartServer(shardNodeServerID int, bindIp string, advertiseIp string, rpcPort int, replicaID int, raftPort int, joinAddr string, oramNodeRPCClients map[int]ReplicaRPCClientMap, parameters config.Parameters, storages []config.RedisEndpoint, configsPath string) {
+// The fault rules are only used if faultInjection is true, and the faults can not be injected otherwise.
+func StartServer(shardNodeServerID int, bindIp string, advertiseIp string, rpcPort int, replicaID int, raftPort int, joinAddr string, oramNodeRPCClients map[int]ReplicaRPCClientMap, parameters config.Parameters, storages []config.RedisEndpoint, configsPath string, faultRules []config.FaultRule, faultInjection bool) {
 	isFirst := joinAddr == ""
 	shardNodeFSM := newShardNodeFSM(replicaID)
 	r, err := startRaftServer(isFirst, bindIp, advertiseIp, replicaID, raftPort, shardNodeFSM)
@@ -393,21 +640,10 @@ func StartServer(shardNodeServerID int, bindIp string, advertiseIp string, rpcPo
 	if err != nil {
 		log.Fatal().Msgf("failed to listen: %v", err)
 	}
-	storageORAMNodeMap := make(map[int]int)
-	for _, storage := range storages {
-		storageORAMNodeMap[storage.ID] = storage.ORAMNodeID
+	injector, err := faults.NewConfigInjector(faultRules, faultInjection, shardNodeServerID, replicaID)
+	if err != nil {
+		log.Fatal().Msgf("invalid fault rules; %v", err)
 	}
-	shardnodeServer := newShardNodeServer(shardNodeServerID, replicaID, r, shardNodeFSM, oramNodeRPCClients, storageORAMNodeMap, parameters.TreeHeight, newBatchManager(time.Duration(parameters.BatchTimout)*time.Millisecond))
-	go shardnodeServer.sendBatchesForever()
-
-	go func() {
-		for {
-			time.Sleep(1 * time.Second)
-			shardnodeServer.shardNodeFSM.printStashSize()
-		}
-	}()
-
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(rpc.ContextPropagationUnaryServerInterceptor()))
-	pb.RegisterShardNodeServer(grpcServer, shardnodeServer)
-	grpcServer.Serve(lis)
+	replica := newReplica(shardNodeServerID, replicaID, r, shardNodeFSM, oramNodeRPCClients, parameters, storages, config.ParametersPath(configsPath), injector, nil)
+	replica.grpcServer.Serve(lis)
 }
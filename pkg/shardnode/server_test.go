@@ -192,8 +192,18 @@ func TestSendCurrentBatchesIgnoresEmptyQueues(t *testing.T) {
 	}
 }
 
+// The blocks that have a position are in the storage, so their reads return the value from the oram node.
+func addBlocksToStorage(s *shardNodeServer, blocks ...string) {
+	s.shardNodeFSM.positionMapMu.Lock()
+	defer s.shardNodeFSM.positionMapMu.Unlock()
+	for _, block := range blocks {
+		s.shardNodeFSM.positionMap[block] = positionState{path: 0, storageID: 0}
+	}
+}
+
 func TestQueryBatchReturnsResponseRecievedFromOramNode(t *testing.T) {
 	s := startLeaderRaftNodeServer(t, 1, false)
+	addBlocksToStorage(s, "a", "b")
 
 	readRequests := []*shardnodepb.ReadRequest{
 		{Block: "a", RequestId: "request1"},
@@ -237,6 +247,7 @@ func TestQueryBatchReturnsResponseRecievedFromOramNode(t *testing.T) {
 
 func TestQueryBatchReturnsResponseRecievedFromOramNodeWithBatching(t *testing.T) {
 	s := startLeaderRaftNodeServer(t, 3, true)
+	addBlocksToStorage(s, "a", "b", "c")
 
 	responseChan := make(chan string)
 	for _, el := range []string{"a", "b", "c"} {
@@ -319,6 +330,7 @@ func TestQueryBatchCleansTempValuesInFSMAfterExecution(t *testing.T) {
 
 func TestQueryBatchAddsReadValueToStash(t *testing.T) {
 	s := startLeaderRaftNodeServer(t, 1, false)
+	addBlocksToStorage(s, "a")
 	requestBatch := &shardnodepb.RequestBatch{
 		ReadRequests: []*shardnodepb.ReadRequest{
 			{Block: "a", RequestId: "request1"},
@@ -383,93 +395,235 @@ func TestGetBlocksForSendReturnsAtMostMaxBlocksFromTheStash(t *testing.T) {
 	s.shardNodeFSM.positionMap["block5"] = positionState{path: 0, storageID: 0}
 	s.shardNodeFSM.positionMap["block6"] = positionState{path: 0, storageID: 0}
 
-	_, blocks := s.getBlocksForSend(4, 0)
+	_, blocks := s.getBlocksForSend(4, []int{0}, 0)
 	if len(blocks) != 4 {
 		t.Errorf("expected 4 blocks but got: %d blocks", len(blocks))
 	}
 }
 
-// func TestGetBlocksForSendReturnsOnlyBlocksForPathAndStorageID(t *testing.T) {
-// 	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), make(RPCClientMap), map[int]int{0: 0, 1: 1, 2: 2, 3: 3}, 5, newBatchManager(1))
-// 	s.shardNodeFSM.stash = map[string]stashState{
-// 		"block1": {value: "block1", logicalTime: 0, waitingStatus: false},
-// 		"block2": {value: "block2", logicalTime: 0, waitingStatus: false},
-// 		"block3": {value: "block3", logicalTime: 0, waitingStatus: false},
-// 	}
-// 	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
-// 	s.shardNodeFSM.positionMap["block2"] = positionState{path: 1, storageID: 2}
-// 	s.shardNodeFSM.positionMap["block3"] = positionState{path: 0, storageID: 0}
-
-// 	_, blocks := s.getBlocksForSend(4, 0)
-// 	for _, block := range blocks {
-// 		if block == "block2" {
-// 			t.Errorf("getBlocks should only return blocks for the path and storageID")
-// 		}
-// 	}
-// }
+func TestGetBlocksForSendDoesNotReturnBlocksWithPendingRequests(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), make(RPCClientMap), map[int]int{0: 0}, 5, newBatchManager(1))
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1"},
+		"block2": {value: "block2"},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block2"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.pendingRequests["block1"] = []ReplicateRequestAndPathAndStoragePayload{{RequestedBlock: "block1", RequestID: "request1", OpType: Write}}
 
-// func TestGetBlocksForSendDoesNotReturnsWaitingBlocks(t *testing.T) {
-// 	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), make(RPCClientMap), map[int]int{0: 0, 1: 1, 2: 2, 3: 3}, 5, newBatchManager(1))
-// 	s.shardNodeFSM.stash = map[string]stashState{
-// 		"block1": {value: "block1", logicalTime: 0, waitingStatus: true},
-// 		"block2": {value: "block2", logicalTime: 0, waitingStatus: false},
-// 		"block3": {value: "block3", logicalTime: 0, waitingStatus: false},
-// 	}
-// 	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
-// 	s.shardNodeFSM.positionMap["block2"] = positionState{path: 0, storageID: 0}
-// 	s.shardNodeFSM.positionMap["block3"] = positionState{path: 0, storageID: 0}
-
-// 	_, blocks := s.getBlocksForSend(4, []int{0}, 0)
-// 	for _, block := range blocks {
-// 		if block == "block1" {
-// 			t.Errorf("getBlocks should only return blocks with the waitingStatus equal to false")
-// 		}
-// 	}
-// }
+	_, blocks := s.getBlocksForSend(4, []int{0}, 0)
+	if len(blocks) != 1 || blocks[0] != "block2" {
+		t.Errorf("expected only block2 to be sent but got %v", blocks)
+	}
+}
 
-// func TestSendBlocksReturnsStashBlocks(t *testing.T) {
-// 	s := startLeaderRaftNodeServer(t, 1, false)
-// 	s.shardNodeFSM.stash = map[string]stashState{
-// 		"block1": {value: "block1", logicalTime: 0, waitingStatus: false},
-// 		"block2": {value: "block2", logicalTime: 0, waitingStatus: false},
-// 		"block3": {value: "block3", logicalTime: 0, waitingStatus: false},
-// 	}
-// 	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
-// 	s.shardNodeFSM.positionMap["block2"] = positionState{path: 1, storageID: 0}
-// 	s.shardNodeFSM.positionMap["block3"] = positionState{path: 0, storageID: 0}
+func TestGetBlocksForSendReturnsOnlyBlocksForStorageID(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), make(RPCClientMap), map[int]int{0: 0, 1: 1, 2: 2, 3: 3}, 5, newBatchManager(1))
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1", logicalTime: 0, waitingStatus: false},
+		"block2": {value: "block2", logicalTime: 0, waitingStatus: false},
+		"block3": {value: "block3", logicalTime: 0, waitingStatus: false},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block2"] = positionState{path: 1, storageID: 2}
+	s.shardNodeFSM.positionMap["block3"] = positionState{path: 0, storageID: 0}
 
-// 	blocks, err := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{MaxBlocks: 3, Paths: []int32{0, 1}, StorageId: 0})
-// 	if err != nil {
-// 		t.Errorf("Expected successful execution of SendBlocks")
-// 	}
-// 	if len(blocks.Blocks) != 3 {
-// 		t.Errorf("Expected all values from the stash to return")
-// 	}
-// }
+	_, blocks := s.getBlocksForSend(4, []int{0}, 0)
+	for _, block := range blocks {
+		if block == "block2" {
+			t.Errorf("getBlocks should only return blocks for the storageID")
+		}
+	}
+}
 
-// func TestSendBlocksMarksSentBlocksAsWaitingAndZeroLogicalTime(t *testing.T) {
-// 	s := startLeaderRaftNodeServer(t, 1, false)
-// 	s.shardNodeFSM.stash = map[string]stashState{
-// 		"block1": {value: "block1", logicalTime: 0, waitingStatus: false},
-// 		"block2": {value: "block2", logicalTime: 0, waitingStatus: false},
-// 		"block3": {value: "block3", logicalTime: 0, waitingStatus: false},
-// 	}
-// 	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
-// 	s.shardNodeFSM.positionMap["block2"] = positionState{path: 0, storageID: 0}
-// 	s.shardNodeFSM.positionMap["block3"] = positionState{path: 0, storageID: 0}
+func TestGetBlocksForSendDoesNotReturnsWaitingBlocks(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), make(RPCClientMap), map[int]int{0: 0, 1: 1, 2: 2, 3: 3}, 5, newBatchManager(1))
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1", logicalTime: 0, waitingStatus: true},
+		"block2": {value: "block2", logicalTime: 0, waitingStatus: false},
+		"block3": {value: "block3", logicalTime: 0, waitingStatus: false},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block2"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block3"] = positionState{path: 0, storageID: 0}
 
-// 	blocks, _ := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{MaxBlocks: 3, Paths: []int32{0}, StorageId: 0})
-// 	s.shardNodeFSM.stashMu.Lock()
-// 	for _, block := range blocks.Blocks {
-// 		if s.shardNodeFSM.stash[block.Block].waitingStatus == false {
-// 			t.Errorf("sent blocks should get marked as waiting")
-// 		}
-// 		if s.shardNodeFSM.stash[block.Block].logicalTime != 0 {
-// 			t.Errorf("sent blocks should have logicalTime zero")
-// 		}
-// 	}
-// 	s.shardNodeFSM.stashMu.Unlock()
-// }
+	_, blocks := s.getBlocksForSend(4, []int{0}, 0)
+	for _, block := range blocks {
+		if block == "block1" {
+			t.Errorf("getBlocks should only return blocks with the waitingStatus equal to false")
+		}
+	}
+}
+
+func TestGetBlocksForSendPrefersBlocksDeeperInEvictionPaths(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), make(RPCClientMap), map[int]int{0: 0}, 3, newBatchManager(1))
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1", logicalTime: 0, waitingStatus: false},
+		"block2": {value: "block2", logicalTime: 0, waitingStatus: false},
+		"block3": {value: "block3", logicalTime: 0, waitingStatus: false},
+		"block4": {value: "block4", logicalTime: 0, waitingStatus: false},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 4, storageID: 0}
+	s.shardNodeFSM.positionMap["block2"] = positionState{path: 2, storageID: 0}
+	s.shardNodeFSM.positionMap["block3"] = positionState{path: 1, storageID: 0}
+	s.shardNodeFSM.positionMap["block4"] = positionState{path: 3, storageID: 0}
+
+	_, blocks := s.getBlocksForSend(2, []int{1}, 0)
+	if len(blocks) != 2 || blocks[0] != "block3" || blocks[1] != "block2" {
+		t.Errorf("expected blocks [block3 block2] but got %v", blocks)
+	}
+}
+
+func TestSendBlocksReturnsStashBlocks(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1", logicalTime: 0, waitingStatus: false},
+		"block2": {value: "block2", logicalTime: 0, waitingStatus: false},
+		"block3": {value: "block3", logicalTime: 0, waitingStatus: false},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block2"] = positionState{path: 1, storageID: 0}
+	s.shardNodeFSM.positionMap["block3"] = positionState{path: 0, storageID: 0}
+
+	blocks, err := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{MaxBlocks: 3, Paths: []int32{0, 1}, StorageId: 0, EvictionId: "eviction"})
+	if err != nil {
+		t.Errorf("Expected successful execution of SendBlocks")
+	}
+	if len(blocks.Blocks) != 3 {
+		t.Errorf("Expected all values from the stash to return")
+	}
+}
+
+func TestSendBlocksMarksSentBlocksAsWaitingAndZeroLogicalTime(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1", logicalTime: 0, waitingStatus: false},
+		"block2": {value: "block2", logicalTime: 0, waitingStatus: false},
+		"block3": {value: "block3", logicalTime: 0, waitingStatus: false},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block2"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block3"] = positionState{path: 0, storageID: 0}
+
+	blocks, _ := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{MaxBlocks: 3, Paths: []int32{0}, StorageId: 0, EvictionId: "eviction"})
+	s.shardNodeFSM.stashMu.Lock()
+	for _, block := range blocks.Blocks {
+		if s.shardNodeFSM.stash[block.Block].waitingStatus == false {
+			t.Errorf("sent blocks should get marked as waiting")
+		}
+		if s.shardNodeFSM.stash[block.Block].logicalTime != 0 {
+			t.Errorf("sent blocks should have logicalTime zero")
+		}
+	}
+	s.shardNodeFSM.stashMu.Unlock()
+}
+
+func TestSendBlocksReturnsTheSameBlocksForARetriedEviction(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1"},
+		"block2": {value: "block2"},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block2"] = positionState{path: 0, storageID: 0}
+
+	first, err := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{MaxBlocks: 1, Paths: []int32{0}, StorageId: 0, EvictionId: "eviction"})
+	if err != nil {
+		t.Fatalf("expected successful execution of SendBlocks but got %s", err)
+	}
+	retried, err := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{MaxBlocks: 1, Paths: []int32{0}, StorageId: 0, EvictionId: "eviction"})
+	if err != nil {
+		t.Fatalf("expected successful execution of the retried SendBlocks but got %s", err)
+	}
+	if len(first.Blocks) != 1 || len(retried.Blocks) != 1 || first.Blocks[0].Block != retried.Blocks[0].Block {
+		t.Errorf("expected the retried eviction to get the same blocks but got %v and %v", first.Blocks, retried.Blocks)
+	}
+	s.shardNodeFSM.stashMu.Lock()
+	for block, state := range s.shardNodeFSM.stash {
+		if block != first.Blocks[0].Block && state.waitingStatus {
+			t.Errorf("expected the retried eviction not to send block %s", block)
+		}
+	}
+	s.shardNodeFSM.stashMu.Unlock()
+}
+
+func TestSendBlocksReturnsErrorWithoutAnEvictionID(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	_, err := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{MaxBlocks: 1, Paths: []int32{0}, StorageId: 0})
+	if err == nil {
+		t.Errorf("expected SendBlocks to fail without an eviction id")
+	}
+}
+
+func TestSendBlocksForAReplayReturnsTheRecordedBlocksOfAnOutstandingEviction(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.evictions["eviction"] = []SentBlock{{Block: "block1", Value: "value1", Path: 0}}
+	reply, err := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{EvictionId: "eviction", Replay: true})
+	if err != nil {
+		t.Fatalf("expected successful execution of SendBlocks but got %s", err)
+	}
+	if reply.Finished || len(reply.Blocks) != 1 || reply.Blocks[0].Block != "block1" {
+		t.Errorf("expected the recorded blocks of the eviction but got %v", reply)
+	}
+}
+
+func TestSendBlocksForAReplayReturnsHowTheEvictionFinished(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "value1", waitingStatus: true},
+	}
+	s.shardNodeFSM.evictions["timedout"] = []SentBlock{{Block: "block1", Value: "value1", Path: 0}}
+	s.shardNodeFSM.handleReplicateAcksNacks(ReplicateAcksNacksPayload{EvictionID: "timedout", TimedOut: true})
+	s.shardNodeFSM.evictions["acked"] = []SentBlock{{Block: "block1", Value: "value1", Path: 0}}
+	s.shardNodeFSM.handleReplicateAcksNacks(ReplicateAcksNacksPayload{EvictionID: "acked", AckedBlocks: []string{"block1"}})
+
+	for evictionID, expectedTimedOut := range map[string]bool{"timedout": true, "acked": false, "unknown": false} {
+		reply, err := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{EvictionId: evictionID, Replay: true})
+		if err != nil {
+			t.Fatalf("expected successful execution of SendBlocks but got %s", err)
+		}
+		if !reply.Finished || reply.TimedOut != expectedTimedOut || len(reply.Blocks) != 0 {
+			t.Errorf("expected eviction %s to be finished with timed out %t but got %v", evictionID, expectedTimedOut, reply)
+		}
+	}
+}
+
+func TestSendBlocksSendsNoBlocksForAFinishedEviction(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1"},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.recordFinishedEviction("eviction", true)
+
+	reply, err := s.SendBlocks(context.Background(), &shardnodepb.SendBlocksRequest{MaxBlocks: 1, Paths: []int32{0}, StorageId: 0, EvictionId: "eviction"})
+	if err != nil {
+		t.Fatalf("expected successful execution of SendBlocks but got %s", err)
+	}
+	if !reply.Finished || !reply.TimedOut || len(reply.Blocks) != 0 {
+		t.Errorf("expected no blocks for a finished eviction but got %v", reply)
+	}
+	if s.shardNodeFSM.stash["block1"].waitingStatus {
+		t.Errorf("expected the block not to wait for the acks of a finished eviction")
+	}
+}
+
+func TestAckSentBlocksIgnoresTheAcksOfAFinishedEviction(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1", waitingStatus: true},
+	}
+	s.shardNodeFSM.evictions["another"] = []SentBlock{{Block: "block1", Value: "block1"}}
+	s.shardNodeFSM.recordFinishedEviction("eviction", false)
+
+	reply, err := s.AckSentBlocks(context.Background(), &shardnodepb.AckSentBlocksRequest{EvictionId: "eviction", Acks: []*shardnodepb.Ack{{Block: "block1", IsAck: true}}})
+	if err != nil || !reply.Success {
+		t.Fatalf("expected the acks of a finished eviction to be accepted but got %s", err)
+	}
+	if _, exists := s.shardNodeFSM.stash["block1"]; !exists {
+		t.Errorf("expected the acks of a finished eviction not to remove the block")
+	}
+}
 
 // func TestAckSentBlocksRemovesAckedBlocksFromStash(t *testing.T) {
 // 	s := startLeaderRaftNodeServer(t, 1, false)
@@ -523,3 +677,178 @@ func TestGetBlocksForSendReturnsAtMostMaxBlocksFromTheStash(t *testing.T) {
 // 		}
 // 	}
 // }
+
+func TestGetBlocksForSendSkipsPreparedBlocks(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), make(RPCClientMap), map[int]int{0: 0}, 3, newBatchManager(1))
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {value: "block1"},
+		"block2": {value: "block2"},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block2"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t1", Block: "block1", Value: "new"})
+
+	_, blocks := s.getBlocksForSend(2, []int{0}, 0)
+	if len(blocks) != 1 || blocks[0] != "block2" {
+		t.Errorf("expected only block2 to be sent but got %v", blocks)
+	}
+}
+
+func TestDecideTransactionsCommitsPreparedWrites(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash["block1"] = stashState{value: "old"}
+	s.shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t1", Block: "block1", Value: "new"})
+	reply, err := s.DecideTransactions(context.Background(), &shardnodepb.TransactionDecisions{Committed: []string{"t1"}})
+	if err != nil || !reply.Success {
+		t.Errorf("expected DecideTransactions to succeed but got %v, %v", reply, err)
+	}
+	s.shardNodeFSM.stashMu.Lock()
+	defer s.shardNodeFSM.stashMu.Unlock()
+	if s.shardNodeFSM.stash["block1"].value != "new" {
+		t.Errorf("expected the committed write to be in the stash, but the value is %s", s.shardNodeFSM.stash["block1"].value)
+	}
+}
+
+func TestDecideTransactionsReturnsErrorForNonLeaderRaftPeer(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), nil, map[int]int{0: 0}, 5, newBatchManager(1))
+	_, err := s.DecideTransactions(context.Background(), &shardnodepb.TransactionDecisions{Committed: []string{"t1"}})
+	if err == nil {
+		t.Errorf("expected DecideTransactions to fail on a follower")
+	}
+}
+
+func TestResolveTimedOutTransactionsAbortsTransactionsWithoutACoordinatorPreparedForLongerThanTheTimeout(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash["block1"] = stashState{value: "old"}
+	s.shardNodeFSM.stash["block2"] = stashState{value: "old"}
+	s.shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t1", Block: "block1", Value: "new"})
+	waitingSince := make(map[string]time.Time)
+	if err := s.resolveTimedOutTransactions(waitingSince, 50*time.Millisecond); err != nil {
+		t.Errorf("expected no error but got %s", err)
+	}
+	if !s.shardNodeFSM.isPrepared("block1") {
+		t.Errorf("expected the transaction to stay prepared before the timeout")
+	}
+	time.Sleep(50 * time.Millisecond)
+	s.shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t2", Block: "block2", Value: "new"})
+	if err := s.resolveTimedOutTransactions(waitingSince, 50*time.Millisecond); err != nil {
+		t.Errorf("expected no error but got %s", err)
+	}
+	if s.shardNodeFSM.isPrepared("block1") || !s.shardNodeFSM.isPrepared("block2") {
+		t.Errorf("expected only the timed out transaction to be aborted")
+	}
+	if _, exists := waitingSince["t1"]; exists {
+		t.Errorf("expected the aborted transaction to be forgotten")
+	}
+	s.shardNodeFSM.stashMu.Lock()
+	defer s.shardNodeFSM.stashMu.Unlock()
+	if s.shardNodeFSM.stash["block1"].value != "old" {
+		t.Errorf("expected the aborted write to be dropped, but the value is %s", s.shardNodeFSM.stash["block1"].value)
+	}
+}
+
+func TestResolveTimedOutTransactionsGetsTheDecisionOfTheCoordinator(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash["block1"] = stashState{value: "old"}
+	s.shardNodeFSM.stash["block2"] = stashState{value: "old"}
+	coordinator, replicas := startDecidingDestination(t, map[string]bool{"t1": true})
+	s.shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t1", Block: "block1", Value: "new", CoordinatorReplicas: replicas})
+	s.shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t2", Block: "block2", Value: "new", CoordinatorReplicas: replicas})
+	waitingSince := map[string]time.Time{"t1": time.Now().Add(-time.Second), "t2": time.Now().Add(-time.Second)}
+	if err := s.resolveTimedOutTransactions(waitingSince, 50*time.Millisecond); err != nil {
+		t.Errorf("expected no error but got %s", err)
+	}
+	if s.shardNodeFSM.isPrepared("block1") || s.shardNodeFSM.isPrepared("block2") {
+		t.Errorf("expected both transactions to be decided")
+	}
+	coordinator.mu.Lock()
+	if committed, decided := coordinator.decisions["t2"]; !decided || committed {
+		t.Errorf("expected the coordinator to record the abort of t2")
+	}
+	coordinator.mu.Unlock()
+	s.shardNodeFSM.stashMu.Lock()
+	defer s.shardNodeFSM.stashMu.Unlock()
+	if s.shardNodeFSM.stash["block1"].value != "new" {
+		t.Errorf("expected the write of the committed transaction to be applied, but the value is %s", s.shardNodeFSM.stash["block1"].value)
+	}
+	if s.shardNodeFSM.stash["block2"].value != "old" {
+		t.Errorf("expected the write of the aborted transaction to be dropped, but the value is %s", s.shardNodeFSM.stash["block2"].value)
+	}
+}
+
+func TestResolveTimedOutTransactionsKeepsATransactionPreparedIfTheCoordinatorCanNotBeReached(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.shardNodeFSM.stash["block1"] = stashState{value: "old"}
+	port, err := freeport.GetFreePort()
+	if err != nil {
+		t.Fatalf("unable to get free port")
+	}
+	s.shardNodeFSM.handleReplicatePrepareTransaction(ReplicatePrepareTransactionPayload{TransactionID: "t1", Block: "block1", Value: "new", CoordinatorReplicas: []ShardNodeReplicaPayload{{ReplicaID: 0, IP: "127.0.0.1", Port: port}}})
+	waitingSince := map[string]time.Time{"t1": time.Now().Add(-time.Second)}
+	if err := s.resolveTimedOutTransactions(waitingSince, 50*time.Millisecond); err != nil {
+		t.Errorf("expected no error but got %s", err)
+	}
+	if !s.shardNodeFSM.isPrepared("block1") {
+		t.Errorf("expected the transaction to stay prepared until the coordinator decides it")
+	}
+	if _, exists := waitingSince["t1"]; !exists {
+		t.Errorf("expected the transaction to be resolved again later")
+	}
+}
+
+func TestDecideTransactionKeepsTheFirstDecision(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	reply, err := s.DecideTransaction(context.Background(), &shardnodepb.DecideTransactionRequest{TransactionId: "t1", Commit: true})
+	if err != nil || !reply.Committed {
+		t.Errorf("expected the transaction to be committed but got %v, %v", reply, err)
+	}
+	reply, err = s.DecideTransaction(context.Background(), &shardnodepb.DecideTransactionRequest{TransactionId: "t1", Commit: false})
+	if err != nil || !reply.Committed {
+		t.Errorf("expected the transaction to stay committed but got %v, %v", reply, err)
+	}
+	reply, err = s.DecideTransaction(context.Background(), &shardnodepb.DecideTransactionRequest{TransactionId: "t2", Commit: false})
+	if err != nil || reply.Committed {
+		t.Errorf("expected the transaction to be aborted but got %v, %v", reply, err)
+	}
+}
+
+func TestGetTombstonesToDropReturnsTombstonesOfTheStorage(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), make(RPCClientMap), map[int]int{0: 0, 1: 1}, 3, newBatchManager(1))
+	s.shardNodeFSM.stash = map[string]stashState{
+		"block1": {tombstone: true},
+		"block2": {tombstone: true},
+		"block3": {tombstone: true},
+		"block4": {value: "value"},
+	}
+	s.shardNodeFSM.positionMap["block1"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block2"] = positionState{path: 0, storageID: 1}
+	s.shardNodeFSM.positionMap["block3"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.positionMap["block4"] = positionState{path: 0, storageID: 0}
+	s.shardNodeFSM.requestLog["block3"] = []string{"request1"}
+
+	blocks := s.getTombstonesToDrop(0)
+	if len(blocks) != 1 || blocks[0] != "block1" {
+		t.Errorf("expected only block1 to be dropped but got %v", blocks)
+	}
+	_, sent := s.getBlocksForSend(3, []int{0}, 0)
+	if len(sent) != 1 || sent[0] != "block4" {
+		t.Errorf("expected the tombstones not to be sent for eviction but got %v", sent)
+	}
+}
+
+func TestQueryBatchDeleteMakesBlockNotExist(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	addBlocksToStorage(s, "a")
+	reply, err := s.queryBatch(context.Background(), &shardnodepb.RequestBatch{ReadRequests: []*shardnodepb.ReadRequest{{Block: "a", RequestId: "request1"}}})
+	if err != nil || !reply.ReadReplies[0].Exists {
+		t.Errorf("expected a block in the storage to exist but got %v, %v", reply, err)
+	}
+	reply, err = s.queryBatch(context.Background(), &shardnodepb.RequestBatch{WriteRequests: []*shardnodepb.WriteRequest{{Block: "a", RequestId: "request2", Delete: true}}})
+	if err != nil || !reply.WriteReplies[0].Success {
+		t.Errorf("expected the delete to succeed but got %v, %v", reply, err)
+	}
+	reply, err = s.queryBatch(context.Background(), &shardnodepb.RequestBatch{ReadRequests: []*shardnodepb.ReadRequest{{Block: "a", RequestId: "request3"}}})
+	if err != nil || reply.ReadReplies[0].Exists || reply.ReadReplies[0].Value != "" {
+		t.Errorf("expected the deleted block not to exist but got %v, %v", reply, err)
+	}
+}
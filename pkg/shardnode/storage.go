@@ -0,0 +1,85 @@
+package shardnode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	oramnodepb "github.com/dsg-uwaterloo/treebeard/api/oramnode"
+	pb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/commonerrs"
+	"github.com/dsg-uwaterloo/treebeard/pkg/storage"
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+)
+
+// addedStorage is a storage that was added after the shard node started.
+type addedStorage struct {
+	oramNodeID int
+	addedAt    time.Time
+}
+
+// It returns the oram node that is responsible for the storage.
+func (s *shardNodeServer) getORAMNodeID(storageID int) int {
+	if oramNodeID, exists := s.storageORAMNodeMap[storageID]; exists {
+		return oramNodeID
+	}
+	s.shardNodeFSM.addedStoragesMu.RLock()
+	defer s.shardNodeFSM.addedStoragesMu.RUnlock()
+	return s.shardNodeFSM.addedStorages[storageID].oramNodeID
+}
+
+// It returns the weight of each storage for choosing where a block goes next.
+// The weight of an added storage grows from zero to one during the ramp up,
+// so the new storage gets its share of the blocks gradually.
+// The weights do not depend on the blocks, so the choice stays oblivious.
+func (s *shardNodeServer) getStorageWeights() map[int]float64 {
+	weights := make(map[int]float64)
+	for storageID := range s.storageORAMNodeMap {
+		weights[storageID] = 1
+	}
+	s.shardNodeFSM.addedStoragesMu.RLock()
+	defer s.shardNodeFSM.addedStoragesMu.RUnlock()
+	for storageID, added := range s.shardNodeFSM.addedStorages {
+		if s.storageRampUp <= 0 {
+			weights[storageID] = 1
+			continue
+		}
+		weight := float64(time.Since(added.addedAt)) / float64(s.storageRampUp)
+		if weight > 1 {
+			weight = 1
+		}
+		weights[storageID] = weight
+	}
+	return weights
+}
+
+func (s *shardNodeServer) getRandomPathAndStorageID() (path int, storageID int) {
+	return storage.GetRandomPathAndWeightedStorageID(s.storageTreeHeight, s.getStorageWeights())
+}
+
+// AddStorage adds a new storage to the oram node and starts placing blocks in it.
+// The oram node replicas connect to the storage and the oram node leader builds its tree before the shard node uses it.
+func (s *shardNodeServer) AddStorage(ctx context.Context, request *pb.AddStorageRequest) (*pb.AddStorageReply, error) {
+	if s.raftNode.State() != raft.Leader {
+		return nil, fmt.Errorf(commonerrs.NotTheLeaderError)
+	}
+	log.Debug().Msgf("Received add storage request %v", request)
+	oramNodeReplicaMap, exists := s.oramNodeClients[int(request.OramNodeId)]
+	if !exists {
+		return nil, fmt.Errorf("oram node %d does not exist", request.OramNodeId)
+	}
+	err := oramNodeReplicaMap.addStorageToAllOramNodeReplicas(ctx, &oramnodepb.AddStorageRequest{StorageId: request.StorageId, Ip: request.Ip, Port: request.Port})
+	if err != nil {
+		return nil, err
+	}
+	command, err := newAddStorageReplicationCommand(int(request.StorageId), int(request.OramNodeId), time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("could not create add storage replication command; %s", err)
+	}
+	err = s.raftNode.Apply(command, 0).Error()
+	if err != nil {
+		return nil, fmt.Errorf("could not apply log to the FSM; %s", err)
+	}
+	return &pb.AddStorageReply{Success: true}, nil
+}
@@ -0,0 +1,108 @@
+package shardnode
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	oramnodepb "github.com/dsg-uwaterloo/treebeard/api/oramnode"
+	shardnodepb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/hashicorp/raft"
+)
+
+func TestHandleReplicateAddStorageKeepsFirstAddition(t *testing.T) {
+	fsm := newShardNodeFSM(0)
+	addedAt := time.Now().Add(-time.Minute)
+	fsm.handleReplicateAddStorage(ReplicateAddStoragePayload{StorageID: 4, ORAMNodeID: 1, AddedAt: addedAt})
+	fsm.handleReplicateAddStorage(ReplicateAddStoragePayload{StorageID: 4, ORAMNodeID: 1, AddedAt: time.Now()})
+	if fsm.addedStorages[4].oramNodeID != 1 || !fsm.addedStorages[4].addedAt.Equal(addedAt) {
+		t.Errorf("adding a storage again should not restart its ramp up")
+	}
+}
+
+func TestAddStorageReplicationCommandCarriesTheAdditionTime(t *testing.T) {
+	addedAt := time.Now().Add(-time.Minute)
+	command, err := newAddStorageReplicationCommand(4, 1, addedAt)
+	if err != nil {
+		t.Fatalf("could not create the add storage replication command; %s", err)
+	}
+	// Every replica applies the same log, so they should all ramp up the storage from the time of the proposal.
+	for replicaID := 0; replicaID < 2; replicaID++ {
+		fsm := newShardNodeFSM(replicaID)
+		fsm.Apply(&raft.Log{Type: raft.LogCommand, Data: command})
+		if !fsm.addedStorages[4].addedAt.Equal(addedAt) {
+			t.Errorf("expected replica %d to add the storage at %v but got %v", replicaID, addedAt, fsm.addedStorages[4].addedAt)
+		}
+	}
+}
+
+func TestGetORAMNodeIDReturnsOramNodeOfAddedStorages(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), nil, map[int]int{0: 0, 1: 1}, 5, newBatchManager(1))
+	s.shardNodeFSM.handleReplicateAddStorage(ReplicateAddStoragePayload{StorageID: 2, ORAMNodeID: 1})
+	if s.getORAMNodeID(1) != 1 || s.getORAMNodeID(2) != 1 {
+		t.Errorf("expected storages 1 and 2 to belong to oram node 1")
+	}
+}
+
+func TestGetStorageWeightsRampsUpAddedStorages(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), nil, map[int]int{0: 0}, 5, newBatchManager(1))
+	s.storageRampUp = time.Minute
+	s.shardNodeFSM.addedStorages[1] = addedStorage{oramNodeID: 0, addedAt: time.Now().Add(-30 * time.Second)}
+	s.shardNodeFSM.addedStorages[2] = addedStorage{oramNodeID: 0, addedAt: time.Now().Add(-time.Hour)}
+	weights := s.getStorageWeights()
+	if weights[0] != 1 || weights[2] != 1 {
+		t.Errorf("expected full weight for the initial storage and the ramped up storage but got %v", weights)
+	}
+	if weights[1] < 0.45 || weights[1] > 0.55 {
+		t.Errorf("expected half weight for a storage in the middle of its ramp up but got %f", weights[1])
+	}
+}
+
+func TestGetStorageWeightsWithoutRampUpGivesFullWeight(t *testing.T) {
+	s := newShardNodeServer(0, 0, &raft.Raft{}, newShardNodeFSM(0), nil, map[int]int{0: 0}, 5, newBatchManager(1))
+	s.shardNodeFSM.handleReplicateAddStorage(ReplicateAddStoragePayload{StorageID: 1, ORAMNodeID: 0})
+	if weights := s.getStorageWeights(); weights[1] != 1 {
+		t.Errorf("expected full weight without a ramp up but got %v", weights)
+	}
+}
+
+func TestAddStorageAddsStorageToOramNodeReplicasAndFSM(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	var addedReplicas []int
+	for replicaID, client := range s.oramNodeClients[0] {
+		replicaID := replicaID
+		client.ClientAPI.(*mockOramNodeClient).addStorageFunc = func(request *oramnodepb.AddStorageRequest) (*oramnodepb.AddStorageReply, error) {
+			addedReplicas = append(addedReplicas, replicaID)
+			return &oramnodepb.AddStorageReply{Success: true}, nil
+		}
+	}
+	_, err := s.AddStorage(context.Background(), &shardnodepb.AddStorageRequest{StorageId: 3, OramNodeId: 0, Ip: "localhost", Port: 6379})
+	if err != nil {
+		t.Errorf("expected successful add storage but got %s", err)
+	}
+	if len(addedReplicas) != 2 {
+		t.Errorf("expected the storage to be added to both oram node replicas but got %v", addedReplicas)
+	}
+	s.shardNodeFSM.addedStoragesMu.RLock()
+	defer s.shardNodeFSM.addedStoragesMu.RUnlock()
+	if _, exists := s.shardNodeFSM.addedStorages[3]; !exists {
+		t.Errorf("expected storage 3 in the FSM")
+	}
+}
+
+func TestAddStorageDoesNotAddStorageIfAnOramNodeReplicaFails(t *testing.T) {
+	s := startLeaderRaftNodeServer(t, 1, false)
+	s.oramNodeClients[0][1].ClientAPI.(*mockOramNodeClient).addStorageFunc = func(request *oramnodepb.AddStorageRequest) (*oramnodepb.AddStorageReply, error) {
+		return nil, fmt.Errorf("unreachable")
+	}
+	_, err := s.AddStorage(context.Background(), &shardnodepb.AddStorageRequest{StorageId: 3, OramNodeId: 0, Ip: "localhost", Port: 6379})
+	if err == nil {
+		t.Errorf("expected an error when an oram node replica can not add the storage")
+	}
+	s.shardNodeFSM.addedStoragesMu.RLock()
+	defer s.shardNodeFSM.addedStoragesMu.RUnlock()
+	if _, exists := s.shardNodeFSM.addedStorages[3]; exists {
+		t.Errorf("storage 3 should not be used before every oram node replica has it")
+	}
+}
@@ -0,0 +1,194 @@
+package shardnode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "github.com/dsg-uwaterloo/treebeard/api/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/commonerrs"
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+)
+
+// DecideTransactions commits or aborts the transactions whose writes were prepared in an epoch.
+// The router sends the decisions of every transaction to every shard node,
+// so the decisions do not show which shard nodes a transaction used.
+func (s *shardNodeServer) DecideTransactions(ctx context.Context, request *pb.TransactionDecisions) (*pb.DecideTransactionsReply, error) {
+	if s.raftNode.State() != raft.Leader {
+		return nil, fmt.Errorf(commonerrs.NotTheLeaderError)
+	}
+	log.Debug().Msgf("Received transaction decisions %v", request)
+	if len(request.Committed) == 0 && len(request.Aborted) == 0 {
+		return &pb.DecideTransactionsReply{Success: true}, nil
+	}
+	command, err := newTransactionDecisionsReplicationCommand(request.Committed, request.Aborted)
+	if err != nil {
+		return nil, fmt.Errorf("could not create transaction decisions replication command; %s", err)
+	}
+	err = s.raftNode.Apply(command, 0).Error()
+	if err != nil {
+		return nil, fmt.Errorf("could not apply log to the FSM; %s", err)
+	}
+	return &pb.DecideTransactionsReply{Success: true}, nil
+}
+
+// DecideTransaction records the decision of a transaction that this shard node coordinates.
+// The first decision is final and every request gets it, like in DecideMigration.
+// The router records the commit of a transaction before it sends the decisions,
+// and a participant that does not get the decision in time asks for an abort, so both get the same outcome.
+func (s *shardNodeServer) DecideTransaction(ctx context.Context, request *pb.DecideTransactionRequest) (*pb.DecideTransactionReply, error) {
+	if s.raftNode.State() != raft.Leader {
+		return nil, fmt.Errorf(commonerrs.NotTheLeaderError)
+	}
+	if request.TransactionId == "" {
+		return nil, fmt.Errorf("the transaction id should not be empty")
+	}
+	now := time.Now()
+	command, err := newTransactionDecisionReplicationCommand(request.TransactionId, request.Commit, now, now.Add(-transactionDecisionRetention*s.getPrepareTimeout()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create transaction decision replication command; %s", err)
+	}
+	future := s.raftNode.Apply(command, 0)
+	err = future.Error()
+	if err != nil {
+		return nil, fmt.Errorf("could not apply log to the FSM; %s", err)
+	}
+	committed := future.Response().(bool)
+	log.Debug().Msgf("Transaction %s is committed: %t", request.TransactionId, committed)
+	return &pb.DecideTransactionReply{Committed: committed}, nil
+}
+
+// It returns the replicas of the coordinator of the transaction of a write, or nil if the write has none.
+func getCoordinatorReplicas(writeRequest *pb.WriteRequest) []ShardNodeReplicaPayload {
+	var coordinatorReplicas []ShardNodeReplicaPayload
+	for _, replica := range writeRequest.CoordinatorReplicas {
+		coordinatorReplicas = append(coordinatorReplicas, ShardNodeReplicaPayload{ReplicaID: int(replica.ReplicaId), IP: replica.Ip, Port: int(replica.Port)})
+	}
+	return coordinatorReplicas
+}
+
+const (
+	// The default time that a transaction can stay prepared without a decision.
+	defaultPrepareTimeout = 1 * time.Minute
+	// A coordinator keeps a decision for this many prepare timeouts.
+	// The participants ask for the decision after one prepare timeout,
+	// so only a participant that can not reach the coordinator for longer could get a different outcome.
+	transactionDecisionRetention = 10
+)
+
+func (s *shardNodeServer) getPrepareTimeout() time.Duration {
+	if s.parameters.PrepareTimeout <= 0 {
+		return defaultPrepareTimeout
+	}
+	return time.Duration(s.parameters.PrepareTimeout) * time.Millisecond
+}
+
+// It asks the coordinator of the transaction to abort it, and returns true if the coordinator has committed it instead.
+func (s *shardNodeServer) resolveTransaction(ctx context.Context, transactionID string, coordinatorReplicas []ShardNodeReplicaPayload) (bool, error) {
+	var endpoints []config.ShardNodeEndpoint
+	for _, replica := range coordinatorReplicas {
+		endpoints = append(endpoints, config.ShardNodeEndpoint{ReplicaID: replica.ReplicaID, IP: replica.IP, Port: replica.Port})
+	}
+	clients, err := startShardNodeRPCClients(endpoints, s.dialOptions...)
+	if err != nil {
+		return false, fmt.Errorf("could not connect to the coordinator of transaction %s; %s", transactionID, err)
+	}
+	defer clients.close()
+	reply, err := clients.decideTransactionOnAllReplicas(ctx, &pb.DecideTransactionRequest{TransactionId: transactionID, Commit: false})
+	if err != nil {
+		return false, fmt.Errorf("could not get the decision of transaction %s; %s", transactionID, err)
+	}
+	return reply.Committed, nil
+}
+
+// It decides the prepared transactions that the leader has seen for longer than the timeout.
+// waitingSince keeps the time that the leader first saw each prepared transaction.
+// A router that crashes or fails to send the decisions would otherwise keep the blocks prepared forever,
+// since a prepared block is never evicted and can not be prepared by another transaction.
+// A transaction gets the decision of its coordinator, and a transaction without a coordinator is aborted.
+// A transaction whose coordinator can not be reached stays prepared until the next call.
+func (s *shardNodeServer) resolveTimedOutTransactions(waitingSince map[string]time.Time, timeout time.Duration) error {
+	prepared := make(map[string]bool)
+	var timedOut []string
+	for _, transactionID := range s.shardNodeFSM.getPreparedTransactions() {
+		prepared[transactionID] = true
+		since, exists := waitingSince[transactionID]
+		if !exists {
+			waitingSince[transactionID] = time.Now()
+			continue
+		}
+		if time.Since(since) >= timeout {
+			timedOut = append(timedOut, transactionID)
+		}
+	}
+	for transactionID := range waitingSince {
+		if !prepared[transactionID] {
+			delete(waitingSince, transactionID)
+		}
+	}
+	if len(timedOut) == 0 {
+		return nil
+	}
+	var committed []string
+	var aborted []string
+	for _, transactionID := range timedOut {
+		coordinatorReplicas := s.shardNodeFSM.getTransactionCoordinator(transactionID)
+		if len(coordinatorReplicas) == 0 {
+			aborted = append(aborted, transactionID)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout/4)
+		isCommitted, err := s.resolveTransaction(ctx, transactionID, coordinatorReplicas)
+		cancel()
+		if err != nil {
+			log.Error().Msgf("Could not resolve the timed out transaction %s; %s", transactionID, err)
+			continue
+		}
+		if isCommitted {
+			committed = append(committed, transactionID)
+		} else {
+			aborted = append(aborted, transactionID)
+		}
+	}
+	if len(committed) == 0 && len(aborted) == 0 {
+		return nil
+	}
+	log.Debug().Msgf("Committing timed out transactions %v and aborting timed out transactions %v", committed, aborted)
+	command, err := newTransactionDecisionsReplicationCommand(committed, aborted)
+	if err != nil {
+		return fmt.Errorf("could not create transaction decisions replication command; %s", err)
+	}
+	err = s.raftNode.Apply(command, 0).Error()
+	if err != nil {
+		return fmt.Errorf("could not apply log to the FSM; %s", err)
+	}
+	for _, transactionID := range append(committed, aborted...) {
+		delete(waitingSince, transactionID)
+	}
+	return nil
+}
+
+func (s *shardNodeServer) resolveTimedOutTransactionsForever(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultPrepareTimeout
+	}
+	waitingSince := make(map[string]time.Time)
+	for {
+		select {
+		case <-time.After(timeout / 4):
+		case <-s.stop:
+			return
+		}
+		if s.raftNode.State() != raft.Leader {
+			// A new leader waits for the full timeout again, since it does not know when the transactions were prepared.
+			waitingSince = make(map[string]time.Time)
+			continue
+		}
+		err := s.resolveTimedOutTransactions(waitingSince, timeout)
+		if err != nil {
+			log.Error().Msgf("Could not resolve the timed out transactions; %s", err)
+		}
+	}
+}
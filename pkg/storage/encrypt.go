@@ -3,10 +3,13 @@ package storage
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"strconv"
 )
 
 func Encrypt(s string, key []byte) (string, error) {
@@ -31,6 +34,30 @@ func Encrypt(s string, key []byte) (string, error) {
 	return hex.EncodeToString(nonce) + hex.EncodeToString(ciphertext), nil
 }
 
+// It encrypts the dummy block of a bucket with a nonce that is derived from the bucket, offset and epoch.
+// The oram node can recompute the dummy blocks that it reads, which is needed for the XOR read mode.
+// The nonce is unique as long as every write of a bucket uses a new epoch.
+func encryptDummy(bucketID int, offset int, epoch int64, key []byte) (string, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.Itoa(bucketID) + ":" + strconv.Itoa(offset) + ":" + strconv.FormatInt(epoch, 10)))
+	nonce := mac.Sum(nil)[:12]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := "b" + strconv.Itoa(bucketID) + "d" + strconv.Itoa(offset)
+	ciphertext := aesgcm.Seal(nil, nonce, []byte(plaintext), nil)
+
+	return hex.EncodeToString(nonce) + hex.EncodeToString(ciphertext), nil
+}
+
 func Decrypt(s string, key []byte) (string, error) {
 	ciphertext, err := hex.DecodeString(s)
 	if err != nil {
@@ -0,0 +1,354 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
+	"github.com/rs/zerolog/log"
+)
+
+// It is a bucket with the same layout as in redis.
+// The metadata has the offset and the key of every block, or __null__ if the block was read.
+type memoryBucket struct {
+	values      []string
+	metadata    []string
+	accessCount int
+	epoch       int64
+}
+
+// It is one storage shard, like one redis instance.
+// Every call of the handler changes it atomically, like the scripts of the redis handler.
+type memoryStorage struct {
+	mu      sync.Mutex
+	buckets map[int]*memoryBucket
+}
+
+// MemoryBackend keeps the storage shards in memory instead of redis.
+// The handlers that share a backend share its storages, like the replicas of an ORAM node that connect to the same redis.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	storages map[int]*memoryStorage // map of storage id to storage
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{storages: make(map[int]*memoryStorage)}
+}
+
+func (b *MemoryBackend) getStorage(storageID int) *memoryStorage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.storages[storageID]; !exists {
+		b.storages[storageID] = &memoryStorage{buckets: make(map[int]*memoryBucket)}
+	}
+	return b.storages[storageID]
+}
+
+// MemoryStorageHandler is a storage handler that keeps the tree in a MemoryBackend.
+// It is used to run the ORAM nodes in tests without redis.
+type MemoryStorageHandler struct {
+	treeHeight int
+	Z          int
+	S          int
+	shift      int
+	backend    *MemoryBackend
+	storages   map[int]*memoryStorage // map of storage id to storage
+	storageMus map[int]*sync.Mutex    // map of storage id to mutex
+	storagesMu sync.RWMutex
+	key        []byte
+	faults     *faults.Injector // it fails the batch calls like the pipelines of the redis handler
+}
+
+func NewMemoryStorageHandler(treeHeight int, Z int, S int, shift int, backend *MemoryBackend, redisEndpoints []config.RedisEndpoint) *MemoryStorageHandler {
+	m := &MemoryStorageHandler{
+		treeHeight: treeHeight,
+		Z:          Z,
+		S:          S,
+		shift:      shift,
+		backend:    backend,
+		storages:   make(map[int]*memoryStorage),
+		storageMus: make(map[int]*sync.Mutex),
+		key:        []byte("passphrasewhichneedstobe32bytes!"),
+	}
+	for _, endpoint := range redisEndpoints {
+		m.storages[endpoint.ID] = backend.getStorage(endpoint.ID)
+		m.storageMus[endpoint.ID] = &sync.Mutex{}
+	}
+	return m
+}
+
+// SetFaults sets the injector that fails the batch calls of the handler.
+func (m *MemoryStorageHandler) SetFaults(injector *faults.Injector) {
+	m.faults = injector
+}
+
+func (m *MemoryStorageHandler) checkPipelineFault() error {
+	err := m.faults.Hit(faults.StoragePipeline)
+	if err != nil {
+		return fmt.Errorf("unable to execute the pipeline; %s", err)
+	}
+	return nil
+}
+
+func (m *MemoryStorageHandler) GetMaxAccessCount() int {
+	return m.S
+}
+
+func (m *MemoryStorageHandler) getStorage(storageID int) *memoryStorage {
+	m.storagesMu.RLock()
+	defer m.storagesMu.RUnlock()
+	return m.storages[storageID]
+}
+
+func (m *MemoryStorageHandler) LockStorage(storageID int) {
+	log.Debug().Msgf("Aquiring lock for storage %d", storageID)
+	m.storagesMu.RLock()
+	mu := m.storageMus[storageID]
+	m.storagesMu.RUnlock()
+	mu.Lock()
+	log.Debug().Msgf("Aquired lock for storage %d", storageID)
+}
+
+func (m *MemoryStorageHandler) UnlockStorage(storageID int) {
+	log.Debug().Msgf("Releasing lock for storage %d", storageID)
+	m.storagesMu.RLock()
+	mu := m.storageMus[storageID]
+	m.storagesMu.RUnlock()
+	mu.Unlock()
+	log.Debug().Msgf("Released lock for storage %d", storageID)
+}
+
+// InitDatabase builds the tree of every storage that does not have one yet.
+func (m *MemoryStorageHandler) InitDatabase() error {
+	m.storagesMu.RLock()
+	defer m.storagesMu.RUnlock()
+	for _, storage := range m.storages {
+		err := m.initStorage(storage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorageHandler) initStorage(storage *memoryStorage) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.buckets) != 0 {
+		return nil
+	}
+	for bucketID := 1; bucketID < int(math.Pow(2, float64(m.treeHeight))); bucketID++ {
+		values, metadatas, epoch, err := newBucket(bucketID, m.Z, m.S, m.key, nil, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		storage.buckets[bucketID] = &memoryBucket{values: values, metadata: metadatas, epoch: epoch}
+	}
+	return nil
+}
+
+// AddStorage adds a storage of the backend while the handler is running.
+// Adding a storage that the handler already has does nothing.
+func (m *MemoryStorageHandler) AddStorage(endpoint config.RedisEndpoint, initialize bool) error {
+	m.storagesMu.Lock()
+	defer m.storagesMu.Unlock()
+	if _, exists := m.storages[endpoint.ID]; exists {
+		return nil
+	}
+	storage := m.backend.getStorage(endpoint.ID)
+	if initialize {
+		err := m.initStorage(storage)
+		if err != nil {
+			return err
+		}
+	}
+	m.storages[endpoint.ID] = storage
+	m.storageMus[endpoint.ID] = &sync.Mutex{}
+	return nil
+}
+
+// It returns the valid real and dummy blocks of the bucket with their offsets.
+func (b *memoryBucket) blockOffsets() (map[string]int, error) {
+	offsets := make(map[string]int)
+	for _, entry := range b.metadata {
+		pos, block, err := parseMetadataBlock(entry)
+		if err != nil {
+			return nil, err
+		}
+		if pos == -1 {
+			continue
+		}
+		offsets[block] = pos
+	}
+	return offsets, nil
+}
+
+// It reads the block at the offset, invalidates it and counts the access, like the read scripts.
+func (b *memoryBucket) readBlock(offset int) string {
+	for i, entry := range b.metadata {
+		pos, _, err := parseMetadataBlock(entry)
+		if err == nil && pos == offset {
+			b.metadata[i] = "__null__"
+		}
+	}
+	b.accessCount++
+	if offset < 0 || offset >= len(b.values) {
+		return ""
+	}
+	return b.values[offset]
+}
+
+func (m *MemoryStorageHandler) BatchGetBlockOffset(bucketIDs []int, storageID int, blocks []string) (offsets map[int]BlockOffsetStatus, err error) {
+	if err := m.checkPipelineFault(); err != nil {
+		return nil, err
+	}
+	storage := m.getStorage(storageID)
+	storage.mu.Lock()
+	allBlockMap := make(map[int]map[string]int)
+	for _, bucketID := range bucketIDs {
+		allBlockMap[bucketID], err = storage.buckets[bucketID].blockOffsets()
+		if err != nil {
+			storage.mu.Unlock()
+			return nil, err
+		}
+	}
+	storage.mu.Unlock()
+	return getBlockOffsetStatuses(bucketIDs, allBlockMap, blocks)
+}
+
+func (m *MemoryStorageHandler) BatchGetAccessCount(bucketIDs []int, storageID int) (counts map[int]int, err error) {
+	if err := m.checkPipelineFault(); err != nil {
+		return nil, err
+	}
+	storage := m.getStorage(storageID)
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	counts = make(map[int]int)
+	for _, bucketID := range bucketIDs {
+		counts[bucketID] = storage.buckets[bucketID].accessCount
+	}
+	return counts, nil
+}
+
+func (m *MemoryStorageHandler) BatchReadBucket(bucketIDs []int, storageID int) (blocks map[int]map[string]string, err error) {
+	if err := m.checkPipelineFault(); err != nil {
+		return nil, err
+	}
+	storage := m.getStorage(storageID)
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	blocks = make(map[int]map[string]string)
+	for _, bucketID := range bucketIDs {
+		bucket := storage.buckets[bucketID]
+		offsets, err := bucket.blockOffsets()
+		if err != nil {
+			return nil, err
+		}
+		blocks[bucketID] = make(map[string]string)
+		for block, pos := range offsets {
+			if strings.HasPrefix(block, "dummy") {
+				continue
+			}
+			blocks[bucketID][block], err = Decrypt(bucket.values[pos], m.key)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return blocks, nil
+}
+
+func (m *MemoryStorageHandler) BatchWriteBucket(storageID int, readBucketBlocksList map[int]map[string]string, shardNodeBlocks map[string]BlockInfo) (writtenBlocks map[string]string, err error) {
+	if err := m.checkPipelineFault(); err != nil {
+		return nil, err
+	}
+	storage := m.getStorage(storageID)
+	writtenBlocks = make(map[string]string)
+	bucketToValidBlocksMap := getBucketToValidBlocksMap(shardNodeBlocks, m.treeHeight, m.shift)
+	newBuckets := make(map[int]*memoryBucket)
+	for bucketID, readBucketBlocks := range readBucketBlocksList {
+		values, metadatas, epoch, err := newBucket(bucketID, m.Z, m.S, m.key, readBucketBlocks, bucketToValidBlocksMap[bucketID], shardNodeBlocks, writtenBlocks)
+		if err != nil {
+			return nil, err
+		}
+		newBuckets[bucketID] = &memoryBucket{values: values, metadata: metadatas, epoch: epoch}
+	}
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	for bucketID, bucket := range newBuckets {
+		storage.buckets[bucketID] = bucket
+	}
+	return writtenBlocks, nil
+}
+
+func (m *MemoryStorageHandler) BatchReadBlock(offsets map[int]int, storageID int) (values map[int]string, err error) {
+	if err := m.checkPipelineFault(); err != nil {
+		return nil, err
+	}
+	storage := m.getStorage(storageID)
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	values = make(map[int]string)
+	for bucketID, offset := range offsets {
+		values[bucketID], err = Decrypt(storage.buckets[bucketID].readBlock(offset), m.key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (m *MemoryStorageHandler) BatchReadBlockXOR(groups []XORGroup, storageID int) (values []string, err error) {
+	if err := m.checkPipelineFault(); err != nil {
+		return nil, err
+	}
+	storage := m.getStorage(storageID)
+	values = make([]string, len(groups))
+	for i, group := range groups {
+		var buckets []int
+		var epochs []int64
+		var xored []byte
+		storage.mu.Lock()
+		for bucketID, offset := range group.Offsets {
+			bucket := storage.buckets[bucketID]
+			xored = xorInto(xored, []byte(bucket.readBlock(offset)))
+			buckets = append(buckets, bucketID)
+			epochs = append(epochs, bucket.epoch)
+		}
+		storage.mu.Unlock()
+		values[i], err = recoverRealBlock(group, buckets, xored, epochs, m.key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (m *MemoryStorageHandler) GetBucketsInPaths(paths []int) (bucketIDs []int, err error) {
+	return getBucketsInPaths(paths, m.treeHeight, m.shift), nil
+}
+
+func (m *MemoryStorageHandler) GetRandomStorageID() int {
+	m.storagesMu.RLock()
+	defer m.storagesMu.RUnlock()
+	index := rand.Intn(len(m.storages))
+	for storageID := range m.storages {
+		if index == 0 {
+			return storageID
+		}
+		index--
+	}
+	return -1
+}
+
+func (m *MemoryStorageHandler) GetMultipleReverseLexicographicPaths(evictionCount int, count int) (paths []int) {
+	paths = make([]int, count)
+	for i := 0; i < count; i++ {
+		paths[i] = GetNextReverseLexicographicPath(evictionCount+i, m.treeHeight)
+	}
+	return paths
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
+)
+
+func newTestMemoryStorageHandler(t *testing.T, backend *MemoryBackend) *MemoryStorageHandler {
+	m := NewMemoryStorageHandler(3, 1, 9, 1, backend, []config.RedisEndpoint{{ID: 0}})
+	if err := m.InitDatabase(); err != nil {
+		t.Fatalf("expected the tree to be initialized but got %s", err)
+	}
+	return m
+}
+
+func TestMemoryStorageHandlerReadsTheWrittenBlocks(t *testing.T) {
+	m := newTestMemoryStorageHandler(t, NewMemoryBackend())
+	written, err := m.BatchWriteBucket(0, map[int]map[string]string{1: {}, 2: {}, 4: {"usr4": "value4"}}, map[string]BlockInfo{"usr1": {Value: "value1", Path: 1}})
+	if err != nil || written["usr4"] != "value4" || written["usr1"] != "value1" {
+		t.Fatalf("expected usr4 and usr1 to be written but got %v, %v", written, err)
+	}
+	buckets, _ := m.BatchReadBucket([]int{4}, 0)
+	if buckets[4]["usr4"] != "value4" {
+		t.Errorf("expected bucket 4 to have usr4 but got %v", buckets[4])
+	}
+	offsets, err := m.BatchGetBlockOffset([]int{4}, 0, []string{"usr4"})
+	if err != nil || !offsets[4].IsReal {
+		t.Fatalf("expected usr4 to be found but got %v, %v", offsets, err)
+	}
+	values, err := m.BatchReadBlock(map[int]int{4: offsets[4].Offset}, 0)
+	if err != nil || values[4] != "value4" {
+		t.Errorf("expected value4 but got %v, %v", values, err)
+	}
+	offsets, _ = m.BatchGetBlockOffset([]int{4}, 0, []string{"usr4"})
+	if offsets[4].IsReal {
+		t.Errorf("expected usr4 to be invalidated after the read")
+	}
+	counts, _ := m.BatchGetAccessCount([]int{4}, 0)
+	if counts[4] != 1 {
+		t.Errorf("expected access count 1 but got %d", counts[4])
+	}
+}
+
+func TestMemoryStorageHandlerReadBlockXORReturnsRealBlockOfEachGroup(t *testing.T) {
+	m := newTestMemoryStorageHandler(t, NewMemoryBackend())
+	m.BatchWriteBucket(0, map[int]map[string]string{4: {"usr4": "value4"}}, map[string]BlockInfo{})
+	offsets, _ := m.BatchGetBlockOffset([]int{4, 2, 1, 5}, 0, []string{"usr4"})
+	groups := []XORGroup{
+		{Offsets: map[int]int{4: offsets[4].Offset, 2: offsets[2].Offset, 1: offsets[1].Offset}, RealBucket: 4},
+		{Offsets: map[int]int{5: offsets[5].Offset}},
+	}
+	values, err := m.BatchReadBlockXOR(groups, 0)
+	if err != nil || len(values) != 2 || values[0] != "value4" || values[1] != "" {
+		t.Errorf("expected [value4 \"\"] but got %v, %v", values, err)
+	}
+}
+
+func TestMemoryStorageHandlersShareTheStoragesOfTheirBackend(t *testing.T) {
+	backend := NewMemoryBackend()
+	first := newTestMemoryStorageHandler(t, backend)
+	first.BatchWriteBucket(0, map[int]map[string]string{4: {"usr4": "value4"}}, map[string]BlockInfo{})
+	second := newTestMemoryStorageHandler(t, backend)
+	buckets, _ := second.BatchReadBucket([]int{4}, 0)
+	if buckets[4]["usr4"] != "value4" {
+		t.Errorf("expected the second handler to see usr4 and the tree not to be initialized again but got %v", buckets[4])
+	}
+}
+
+func TestMemoryStorageHandlerFailsPipelineWithInjectedFault(t *testing.T) {
+	m := newTestMemoryStorageHandler(t, NewMemoryBackend())
+	injector := faults.NewInjector(nil)
+	injector.Add(faults.Rule{Point: faults.StoragePipeline, Action: faults.Drop, Count: 1})
+	m.SetFaults(injector)
+	if _, err := m.BatchWriteBucket(0, map[int]map[string]string{4: {"usr4": "value4"}}, map[string]BlockInfo{}); err == nil {
+		t.Errorf("expected the first pipeline to fail")
+	}
+	buckets, err := m.BatchReadBucket([]int{4}, 0)
+	if err != nil || len(buckets[4]) != 0 {
+		t.Errorf("expected the failed write not to change the bucket but got %v, %v", buckets[4], err)
+	}
+}
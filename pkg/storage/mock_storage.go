@@ -1,19 +1,30 @@
 package storage
 
+import (
+	"sync"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+)
+
 type MockStorageHandler struct {
 	levelCount                int
 	maxAccessCount            int
+	storageMus                map[int]*sync.Mutex
+	storageMusMu              sync.Mutex
 	customBatchGetBlockOffset func(bucketIDs []int, storageID int, blocks []string) (offsets map[int]BlockOffsetStatus, err error)
 	customBatchGetAccessCount func(bucketIDs []int, storageID int) (counts map[int]int, err error)
 	customBatchReadBucket     func(bucketIDs []int, storageID int) (blocks map[int]map[string]string, err error)
 	customBatchWriteBucket    func(storageID int, readBucketBlocksList map[int]map[string]string, shardNodeBlocks map[string]BlockInfo) (writtenBlocks map[string]string, err error)
 	customBatchReadBlock      func(offsets map[int]int, storageID int) (values map[int]string, err error)
+	customBatchReadBlockXOR   func(groups []XORGroup, storageID int) (values []string, err error)
+	customAddStorage          func(endpoint config.RedisEndpoint, initialize bool) error
 }
 
 func NewMockStorageHandler(levelCount int, maxAccessCount int) *MockStorageHandler {
 	return &MockStorageHandler{
 		levelCount:     levelCount,
 		maxAccessCount: maxAccessCount,
+		storageMus:     make(map[int]*sync.Mutex),
 		customBatchGetBlockOffset: func(bucketIDs []int, storageID int, blocks []string) (offsets map[int]BlockOffsetStatus, err error) {
 			return nil, nil
 		},
@@ -29,6 +40,12 @@ func NewMockStorageHandler(levelCount int, maxAccessCount int) *MockStorageHandl
 		customBatchReadBlock: func(offsets map[int]int, storageID int) (values map[int]string, err error) {
 			return nil, nil
 		},
+		customBatchReadBlockXOR: func(groups []XORGroup, storageID int) (values []string, err error) {
+			return make([]string, len(groups)), nil
+		},
+		customAddStorage: func(endpoint config.RedisEndpoint, initialize bool) error {
+			return nil
+		},
 	}
 }
 
@@ -36,10 +53,21 @@ func (m *MockStorageHandler) GetMaxAccessCount() int {
 	return m.maxAccessCount
 }
 
+func (m *MockStorageHandler) getStorageMu(storageID int) *sync.Mutex {
+	m.storageMusMu.Lock()
+	defer m.storageMusMu.Unlock()
+	if _, exists := m.storageMus[storageID]; !exists {
+		m.storageMus[storageID] = &sync.Mutex{}
+	}
+	return m.storageMus[storageID]
+}
+
 func (m *MockStorageHandler) LockStorage(storageID int) {
+	m.getStorageMu(storageID).Lock()
 }
 
 func (m *MockStorageHandler) UnlockStorage(storageID int) {
+	m.getStorageMu(storageID).Unlock()
 }
 
 func (m *MockStorageHandler) BatchGetBlockOffset(bucketIDs []int, storageID int, blocks []string) (offsets map[int]BlockOffsetStatus, err error) {
@@ -87,6 +115,24 @@ func (m *MockStorageHandler) WithCustomBatchReadBlockFunc(f func(offsets map[int
 	return m
 }
 
+func (m *MockStorageHandler) BatchReadBlockXOR(groups []XORGroup, storageID int) (values []string, err error) {
+	return m.customBatchReadBlockXOR(groups, storageID)
+}
+
+func (m *MockStorageHandler) WithCustomBatchReadBlockXORFunc(f func(groups []XORGroup, storageID int) (values []string, err error)) *MockStorageHandler {
+	m.customBatchReadBlockXOR = f
+	return m
+}
+
+func (m *MockStorageHandler) AddStorage(endpoint config.RedisEndpoint, initialize bool) error {
+	return m.customAddStorage(endpoint, initialize)
+}
+
+func (m *MockStorageHandler) WithCustomAddStorageFunc(f func(endpoint config.RedisEndpoint, initialize bool) error) *MockStorageHandler {
+	m.customAddStorage = f
+	return m
+}
+
 func (m *MockStorageHandler) GetBucketsInPaths(paths []int) (bucketIDs []int, err error) {
 	return []int{1, 2, 3, 4}, nil
 }
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The scripts are loaded once per storage, so that they can be called with EVALSHA in pipelines.
+// Each script runs atomically, so a crash never leaves a bucket with data that does not match its metadata.
+
+// It invalidates the metadata entry of the block at the offset and increments the access count of the bucket.
+const invalidateBlockLua = `
+local function invalidateBlock(metadataKey, offset)
+	local metadata = redis.call('HGETALL', metadataKey)
+	for j = 1, #metadata, 2 do
+		local field, value = metadata[j], metadata[j + 1]
+		if field ~= 'accessCount' and field ~= 'epoch' and string.match(value, '^%d+') == offset then
+			redis.call('HSET', metadataKey, field, '__null__')
+		end
+	end
+	redis.call('HINCRBY', metadataKey, 'accessCount', 1)
+end
+`
+
+// It reads the block at the offset of a bucket and invalidates it.
+// KEYS has the data key and the metadata key of the bucket, and ARGV has the offset.
+var readBlockScript = redis.NewScript(invalidateBlockLua + `
+local block = redis.call('HGET', KEYS[1], ARGV[1])
+invalidateBlock(KEYS[2], ARGV[1])
+return block
+`)
+
+// It replaces the data and the metadata of a bucket and resets its access count.
+// KEYS has the data key and the metadata key of the bucket.
+// ARGV has the epoch, the number of values, the values and then the metadata.
+var writeBucketScript = redis.NewScript(`
+redis.call('DEL', KEYS[1], KEYS[2])
+local valueCount = tonumber(ARGV[2])
+for i = 1, valueCount do
+	redis.call('HSET', KEYS[1], tostring(i - 1), ARGV[2 + i])
+end
+for i = 3 + valueCount, #ARGV do
+	redis.call('HSET', KEYS[2], tostring(i - 3 - valueCount), ARGV[i])
+end
+redis.call('HSET', KEYS[2], 'accessCount', 0, 'epoch', ARGV[1])
+return 1
+`)
+
+// It reads the block at the offset of each bucket, invalidates it and increments the access count of the bucket.
+// KEYS has the data key and the metadata key of each bucket, and ARGV has the offset of each bucket.
+// It returns the XOR of the blocks followed by the epoch of each bucket.
+// The blocks are padded with zero bytes to the length of the longest block.
+// The blocks are XORed four bytes at a time with the bit library of Redis, so the script does not block Redis for long.
+var readXORScript = redis.NewScript(invalidateBlockLua + `
+local xored = {}
+local length = 0
+local epochs = {}
+for i = 1, #ARGV do
+	local dataKey, metadataKey, offset = KEYS[2 * i - 1], KEYS[2 * i], ARGV[i]
+	local block = redis.call('HGET', dataKey, offset) or ''
+	for j = 1, #block, 4 do
+		local b1, b2, b3, b4 = string.byte(block, j, j + 3)
+		local word = bit.bor(bit.lshift(b1, 24), bit.lshift(b2 or 0, 16), bit.lshift(b3 or 0, 8), b4 or 0)
+		local k = (j + 3) / 4
+		xored[k] = bit.bxor(xored[k] or 0, word)
+	end
+	if #block > length then
+		length = #block
+	end
+	invalidateBlock(metadataKey, offset)
+	epochs[i] = redis.call('HGET', metadataKey, 'epoch') or '0'
+end
+
+local chars = {}
+for k = 1, #xored do
+	local word = xored[k]
+	chars[k] = string.char(bit.band(bit.rshift(word, 24), 255), bit.band(bit.rshift(word, 16), 255), bit.band(bit.rshift(word, 8), 255), bit.band(word, 255))
+end
+local result = {string.sub(table.concat(chars), 1, length)}
+for i = 1, #epochs do
+	result[i + 1] = epochs[i]
+end
+return result
+`)
+
+var scripts = []*redis.Script{readBlockScript, writeBucketScript, readXORScript}
+
+// It loads the scripts into the storage if they are not loaded yet.
+func (s *StorageHandler) loadScripts(storageID int) error {
+	s.scriptsLoadedMu.Lock()
+	defer s.scriptsLoadedMu.Unlock()
+	if s.scriptsLoaded[storageID] {
+		return nil
+	}
+	for _, script := range scripts {
+		err := script.Load(context.Background(), s.getStorageClient(storageID)).Err()
+		if err != nil {
+			return err
+		}
+	}
+	s.scriptsLoaded[storageID] = true
+	return nil
+}
+
+// If the storage restarted and lost its scripts, they get loaded again on the next call.
+func (s *StorageHandler) handleScriptError(storageID int, err error) {
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		s.scriptsLoadedMu.Lock()
+		s.scriptsLoaded[storageID] = false
+		s.scriptsLoadedMu.Unlock()
+	}
+}
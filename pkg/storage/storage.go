@@ -4,13 +4,16 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
 	"github.com/dsg-uwaterloo/treebeard/pkg/utils"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
@@ -26,7 +29,13 @@ type StorageHandler struct {
 	shift      int
 	storages   map[int]*redis.Client // map of storage id to redis client
 	storageMus map[int]*sync.Mutex   // map of storage id to mutex
+	storagesMu sync.RWMutex          // storages can be added while the handler is running
 	key        []byte
+
+	scriptsLoaded   map[int]bool // map of storage id to whether the lua scripts are loaded
+	scriptsLoadedMu sync.Mutex
+
+	faults *faults.Injector // it fails the pipelines in the tests, and it is nil otherwise
 }
 
 type BlockInfo struct {
@@ -56,46 +65,112 @@ func NewStorageHandler(treeHeight int, Z int, S int, shift int, redisEndpoints [
 		storages:   storages,
 		storageMus: storageMus,
 		key:        []byte("passphrasewhichneedstobe32bytes!"),
+
+		scriptsLoaded: make(map[int]bool),
 	}
 	return s
 }
 
+// SetFaults sets the injector that fails the pipelines of the handler.
+func (s *StorageHandler) SetFaults(injector *faults.Injector) {
+	s.faults = injector
+}
+
+// It executes the pipeline, unless an injected fault fails it.
+func (s *StorageHandler) execPipeline(ctx context.Context, pipe redis.Pipeliner) error {
+	err := s.faults.Hit(faults.StoragePipeline)
+	if err != nil {
+		return fmt.Errorf("unable to execute the pipeline; %s", err)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
 func (s *StorageHandler) GetMaxAccessCount() int {
 	return s.S
 }
 
+func (s *StorageHandler) getStorageClient(storageID int) *redis.Client {
+	s.storagesMu.RLock()
+	defer s.storagesMu.RUnlock()
+	return s.storages[storageID]
+}
+
+func (s *StorageHandler) getStorageMu(storageID int) *sync.Mutex {
+	s.storagesMu.RLock()
+	defer s.storagesMu.RUnlock()
+	return s.storageMus[storageID]
+}
+
 func (s *StorageHandler) LockStorage(storageID int) {
 	log.Debug().Msgf("Aquiring lock for storage %d", storageID)
-	s.storageMus[storageID].Lock()
+	s.getStorageMu(storageID).Lock()
 	log.Debug().Msgf("Aquired lock for storage %d", storageID)
 }
 
 func (s *StorageHandler) UnlockStorage(storageID int) {
 	log.Debug().Msgf("Releasing lock for storage %d", storageID)
-	s.storageMus[storageID].Unlock()
+	s.getStorageMu(storageID).Unlock()
 	log.Debug().Msgf("Released lock for storage %d", storageID)
 }
 
 func (s *StorageHandler) InitDatabase() error {
 	log.Debug().Msgf("Initializing the redis database")
-	for _, client := range s.storages {
-		// Do not reinitialize the database if it is already initialized
-		dbsize, err := client.DBSize(context.Background()).Result()
+	s.storagesMu.RLock()
+	storages := make(map[int]*redis.Client)
+	for storageID, client := range s.storages {
+		storages[storageID] = client
+	}
+	s.storagesMu.RUnlock()
+	for storageID, client := range storages {
+		err := s.loadScripts(storageID)
 		if err != nil {
-			return err
+			return fmt.Errorf("unable to load scripts; %s", err)
 		}
-		if dbsize == (int64((math.Pow(float64(s.shift+1), float64(s.treeHeight))))-1)*2 {
-			continue
-		}
-		err = client.FlushAll(context.Background()).Err()
+		err = s.initStorage(client)
 		if err != nil {
 			return err
 		}
-		err = s.databaseInit(client)
+	}
+	return nil
+}
+
+// It builds the tree of a storage, unless the storage already has a tree.
+func (s *StorageHandler) initStorage(client *redis.Client) error {
+	dbsize, err := client.DBSize(context.Background()).Result()
+	if err != nil {
+		return err
+	}
+	if dbsize == (int64((math.Pow(float64(s.shift+1), float64(s.treeHeight))))-1)*2 {
+		return nil
+	}
+	err = client.FlushAll(context.Background()).Err()
+	if err != nil {
+		return err
+	}
+	return s.databaseInit(client)
+}
+
+// AddStorage connects to a new storage while the handler is running.
+// If initialize is true, it also builds the tree of the storage.
+// Adding a storage that the handler already has does nothing.
+func (s *StorageHandler) AddStorage(endpoint config.RedisEndpoint, initialize bool) error {
+	log.Debug().Msgf("Adding storage %d at %s:%d", endpoint.ID, endpoint.IP, endpoint.Port)
+	s.storagesMu.Lock()
+	defer s.storagesMu.Unlock()
+	if _, exists := s.storages[endpoint.ID]; exists {
+		return nil
+	}
+	client := getClient(endpoint.IP, endpoint.Port)
+	if initialize {
+		err := s.initStorage(client)
 		if err != nil {
-			return err
+			client.Close()
+			return fmt.Errorf("unable to initialize storage %d; %s", endpoint.ID, err)
 		}
 	}
+	s.storages[endpoint.ID] = client
+	s.storageMus[endpoint.ID] = &sync.Mutex{}
 	return nil
 }
 
@@ -111,6 +186,12 @@ func (s *StorageHandler) BatchGetBlockOffset(bucketIDs []int, storageID int, blo
 		log.Debug().Msgf("Error getting meta data")
 		return nil, err
 	}
+	return getBlockOffsetStatuses(bucketIDs, allBlockMap, blocks)
+}
+
+// It returns the offset of the requested block in every bucket that has it, and the offset of a random valid dummy in the other buckets.
+// allBlockMap has the valid blocks of every bucket with their offsets.
+func getBlockOffsetStatuses(bucketIDs []int, allBlockMap map[int]map[string]int, blocks []string) (blockoffsetStatuses map[int]BlockOffsetStatus, err error) {
 	blockoffsetStatuses = make(map[int]BlockOffsetStatus)
 	for _, bucketID := range bucketIDs {
 		blockoffsetStatuses[bucketID] = BlockOffsetStatus{
@@ -131,16 +212,22 @@ func (s *StorageHandler) BatchGetBlockOffset(bucketIDs []int, storageID int, blo
 			}
 		}
 		if blockoffsetStatuses[bucketID].Offset == -1 {
-			log.Debug().Msgf("Did not find any block in bucket %d, returning dummy block", bucketID)
-			if pos, exist := blockMap["dummy1"]; exist {
-				blockoffsetStatuses[bucketID] = BlockOffsetStatus{
-					Offset:     pos,
-					IsReal:     false,
-					BlockFound: "dummy1",
+			log.Debug().Msgf("Did not find any block in bucket %d, returning a random valid dummy block", bucketID)
+			var dummies []string
+			for block := range blockMap {
+				if strings.HasPrefix(block, "dummy") {
+					dummies = append(dummies, block)
 				}
-			} else {
+			}
+			if len(dummies) == 0 {
 				log.Error().Msgf("Did not find valid dummy block in bucket %d", bucketID)
-				return nil, err
+				return nil, fmt.Errorf("no valid dummy block in bucket %d", bucketID)
+			}
+			dummy := dummies[rand.Intn(len(dummies))]
+			blockoffsetStatuses[bucketID] = BlockOffsetStatus{
+				Offset:     blockMap[dummy],
+				IsReal:     false,
+				BlockFound: dummy,
 			}
 		}
 	}
@@ -151,7 +238,7 @@ func (s *StorageHandler) BatchGetBlockOffset(bucketIDs []int, storageID int, blo
 // This is helpful to know when to do an early reshuffle.
 func (s *StorageHandler) BatchGetAccessCount(bucketIDs []int, storageID int) (counts map[int]int, err error) {
 	ctx := context.Background()
-	pipe := s.storages[storageID].Pipeline()
+	pipe := s.getStorageClient(storageID).Pipeline()
 	resultsMap := make(map[int]*redis.StringCmd)
 	counts = make(map[int]int)
 	// Iterate over each bucketID
@@ -162,7 +249,7 @@ func (s *StorageHandler) BatchGetAccessCount(bucketIDs []int, storageID int) (co
 	}
 
 	// Execute the pipeline for all bucketIDs
-	_, err = pipe.Exec(ctx)
+	err = s.execPipeline(ctx, pipe)
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +279,7 @@ func (s *StorageHandler) BatchGetAccessCount(bucketIDs []int, storageID int) (co
 func (s *StorageHandler) BatchReadBucket(bucketIDs []int, storageID int) (blocks map[int]map[string]string, err error) {
 	metadataMap, err := s.BatchGetAllMetaData(bucketIDs, storageID)
 	results := make(map[int]map[string]*redis.StringCmd)
-	pipe := s.storages[storageID].Pipeline()
+	pipe := s.getStorageClient(storageID).Pipeline()
 	ctx := context.Background()
 	for bucketID, metadata := range metadataMap {
 		i := 0
@@ -215,7 +302,7 @@ func (s *StorageHandler) BatchReadBucket(bucketIDs []int, storageID int) (blocks
 			dummyCount++
 		}
 	}
-	_, err = pipe.Exec(ctx)
+	err = s.execPipeline(ctx, pipe)
 	if err != nil {
 		return nil, err
 	}
@@ -238,11 +325,11 @@ func (s *StorageHandler) BatchReadBucket(bucketIDs []int, storageID int) (blocks
 }
 
 // creates a map of bucketIDs to blocks that can go in that bucket
-func (s *StorageHandler) getBucketToValidBlocksMap(shardNodeBlocks map[string]BlockInfo) map[int][]string {
+func getBucketToValidBlocksMap(shardNodeBlocks map[string]BlockInfo, treeHeight int, shift int) map[int][]string {
 	bucketToValidBlocksMap := make(map[int][]string)
 	for key, blockInfo := range shardNodeBlocks {
-		leafID := int(math.Pow(2, float64(s.treeHeight-1)) + float64(blockInfo.Path) - 1)
-		for bucketId := leafID; bucketId > 0; bucketId = bucketId >> s.shift {
+		leafID := int(math.Pow(2, float64(treeHeight-1)) + float64(blockInfo.Path) - 1)
+		for bucketId := leafID; bucketId > 0; bucketId = bucketId >> shift {
 			bucketToValidBlocksMap[bucketId] = append(bucketToValidBlocksMap[bucketId], key)
 		}
 	}
@@ -251,122 +338,63 @@ func (s *StorageHandler) getBucketToValidBlocksMap(shardNodeBlocks map[string]Bl
 
 // It writes blocks to multiple buckets in a single storage shard.
 func (s *StorageHandler) BatchWriteBucket(storageID int, readBucketBlocksList map[int]map[string]string, shardNodeBlocks map[string]BlockInfo) (writtenBlocks map[string]string, err error) {
-	pipe := s.storages[storageID].Pipeline()
+	err = s.loadScripts(storageID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load scripts; %s", err)
+	}
+	pipe := s.getStorageClient(storageID).Pipeline()
 	ctx := context.Background()
-	dataResults := make(map[int]*redis.BoolCmd)
-	metadataResults := make(map[int]*redis.BoolCmd)
+	results := make(map[int]*redis.Cmd)
 	writtenBlocks = make(map[string]string)
 
 	log.Debug().Msgf("buckets from readBucketBlocksList: %v", readBucketBlocksList)
 	log.Debug().Msgf("shardNodeBlocks: %v", shardNodeBlocks)
 
-	bucketToValidBlocksMap := s.getBucketToValidBlocksMap(shardNodeBlocks)
+	bucketToValidBlocksMap := getBucketToValidBlocksMap(shardNodeBlocks, s.treeHeight, s.shift)
 
 	for bucketID, readBucketBlocks := range readBucketBlocksList {
-		values := make([]string, s.Z+s.S)
-		metadatas := make([]string, s.Z+s.S)
-		realIndex := make([]int, s.Z+s.S)
-		for k := 0; k < s.Z+s.S; k++ {
-			// Generate a random number between 0 and 9
-			realIndex[k] = k
-		}
-		shuffleArray(realIndex)
-		i := 0
-		for key, value := range readBucketBlocks {
-			if strings.HasPrefix(key, "dummy") {
-				continue
-			}
-			if i < s.Z {
-				writtenBlocks[key] = value
-				values[realIndex[i]], err = Encrypt(value, s.key)
-				if err != nil {
-					return nil, err
-				}
-				metadatas[i] = strconv.Itoa(realIndex[i]) + key
-				i++
-				// pos_map is updated in server?
-			} else {
-				break
-			}
-		}
-		for _, key := range bucketToValidBlocksMap[bucketID] {
-			if strings.HasPrefix(key, "dummy") {
-				continue
-			}
-			if i < s.Z {
-				writtenBlocks[key] = shardNodeBlocks[key].Value
-				values[realIndex[i]], err = Encrypt(shardNodeBlocks[key].Value, s.key)
-				if err != nil {
-					return nil, err
-				}
-				metadatas[i] = strconv.Itoa(realIndex[i]) + key
-				i++
-			} else {
-				break
-			}
-		}
-		dummyCount := 1
-		for ; i < s.Z+s.S; i++ {
-			dummyID := "dummy" + strconv.Itoa(dummyCount)
-			dummyString := "b" + strconv.Itoa(bucketID) + "d" + strconv.Itoa(i)
-			dummyString, err = Encrypt(dummyString, s.key)
-			if err != nil {
-				log.Error().Msgf("Error encrypting data")
-				return nil, err
-			}
-			// push dummy to array
-			values[realIndex[i]] = dummyString
-			// push meta data of dummies to array
-			metadatas[i] = strconv.Itoa(realIndex[i]) + dummyID
-			dummyCount++
+		values, metadatas, epoch, err := newBucket(bucketID, s.Z, s.S, s.key, readBucketBlocks, bucketToValidBlocksMap[bucketID], shardNodeBlocks, writtenBlocks)
+		if err != nil {
+			return nil, err
 		}
-		dataResults[i], metadataResults[i] = s.BatchPushDataAndMetadata(bucketID, values, metadatas, pipe)
+		results[bucketID] = s.BatchPushDataAndMetadata(bucketID, values, metadatas, epoch, pipe)
 	}
-	_, err = pipe.Exec(ctx)
+	err = s.execPipeline(ctx, pipe)
 	if err != nil {
+		s.handleScriptError(storageID, err)
 		return nil, err
 	}
-	for _, dataCmd := range dataResults {
-		_, err := dataCmd.Result()
-		if err != nil {
-			if err != redis.Nil {
-				return nil, err
-			}
-			return nil, err
-		}
-	}
-	for _, metadataCmd := range dataResults {
-		_, err := metadataCmd.Result()
+	for bucketID, cmd := range results {
+		err := cmd.Err()
 		if err != nil {
-			if err != redis.Nil {
-				return nil, err
-			}
-			return nil, err
+			return nil, fmt.Errorf("unable to write bucket %d; %s", bucketID, err)
 		}
 	}
 	return writtenBlocks, nil
 }
 
 // It reads multiple blocks from multiple buckets and returns the values.
+// Each block is read, invalidated and counted as an access of its bucket in a single script call.
 func (s *StorageHandler) BatchReadBlock(bucketOffsets map[int]int, storageID int) (values map[int]string, err error) {
+	err = s.loadScripts(storageID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load scripts; %s", err)
+	}
 	ctx := context.Background()
-	pipe := s.storages[storageID].Pipeline()
-	resultsMap := make(map[int]*redis.StringCmd)
+	pipe := s.getStorageClient(storageID).Pipeline()
+	resultsMap := make(map[int]*redis.Cmd)
 	for bucketID, offset := range bucketOffsets {
-		// Issue HGET commands for the value stored in the current bucketID
-		cmd := pipe.HGet(ctx, strconv.Itoa(bucketID), strconv.Itoa(offset))
-
-		// Store the map of results for the current bucketID in the resultsMap
-		resultsMap[bucketID] = cmd
+		resultsMap[bucketID] = readBlockScript.EvalSha(ctx, pipe, []string{strconv.Itoa(bucketID), strconv.Itoa(-1 * bucketID)}, offset)
 	}
-	_, err = pipe.Exec(ctx)
-	if err != nil {
+	err = s.execPipeline(ctx, pipe)
+	if err != nil && err != redis.Nil {
+		s.handleScriptError(storageID, err)
 		log.Debug().Msgf("error executing batch read block pipe: %v", err)
 		return nil, err
 	}
 	values = make(map[int]string)
 	for bucketID, cmd := range resultsMap {
-		block, err := cmd.Result()
+		block, err := cmd.Text()
 		if err != nil && err != redis.Nil {
 			return nil, err
 		}
@@ -377,42 +405,20 @@ func (s *StorageHandler) BatchReadBlock(bucketOffsets map[int]int, storageID int
 		}
 		values[bucketID] = value
 	}
-	invalidateMap := make(map[int]*redis.IntCmd)
-	bucketIDs := make([]int, len(bucketOffsets))
-	for bucketID := range bucketOffsets {
-		bucketIDs = append(bucketIDs, bucketID)
-	}
-	metadataMap, err := s.BatchGetAllMetaData(bucketIDs, storageID)
-	if err != nil {
-		return nil, err
-	}
-	for bucketID, offset := range bucketOffsets {
-		// Issue HGET commands to invalidate value for current bucketID
-		metadata := metadataMap[bucketID]
-		for _, pos := range metadata {
-			if pos == offset {
-				cmd := pipe.HSet(ctx, strconv.Itoa(-1*bucketID), strconv.Itoa(pos), "__null__")
-				invalidateMap[bucketID] = cmd
-			}
-		}
-		cmd := pipe.HIncrBy(ctx, strconv.Itoa(-1*bucketID), "accessCount", 1)
-		invalidateMap[bucketID] = cmd
-	}
-	_, err = pipe.Exec(ctx)
-	if err != nil {
-		log.Debug().Msgf("error executing batch read block pipe: %v", err)
-		return nil, err
-	}
 	return values, nil
 }
 
 // GetBucketsInPaths return all the bucket ids for the passed paths.
 func (s *StorageHandler) GetBucketsInPaths(paths []int) (bucketIDs []int, err error) {
 	log.Debug().Msgf("Getting buckets in paths %v", paths)
+	return getBucketsInPaths(paths, s.treeHeight, s.shift), nil
+}
+
+func getBucketsInPaths(paths []int, treeHeight int, shift int) (bucketIDs []int) {
 	buckets := make(IntSet)
 	for i := 0; i < len(paths); i++ {
-		leafID := int(math.Pow(2, float64(s.treeHeight-1)) + float64(paths[i]) - 1)
-		for bucketId := leafID; bucketId > 0; bucketId = bucketId >> s.shift {
+		leafID := int(math.Pow(2, float64(treeHeight-1)) + float64(paths[i]) - 1)
+		for bucketId := leafID; bucketId > 0; bucketId = bucketId >> shift {
 			if buckets.Contains(bucketId) {
 				break
 			} else {
@@ -426,7 +432,24 @@ func (s *StorageHandler) GetBucketsInPaths(paths []int) (bucketIDs []int, err er
 		bucketIDs[i] = key
 		i++
 	}
-	return bucketIDs, nil
+	return bucketIDs
+}
+
+// It returns the number of buckets that the two paths share, starting from the root.
+// A block that is mapped to pathA can be placed at most this deep in pathB.
+// The parent of a bucket is found with the same shift as getBucketsInPaths.
+func GetCommonPathDepth(pathA int, pathB int, treeHeight int, shift int) (depth int) {
+	leafA := int(math.Pow(2, float64(treeHeight-1))) + pathA - 1
+	leafB := int(math.Pow(2, float64(treeHeight-1))) + pathB - 1
+	for bucketID := leafA; bucketID > 0; bucketID = bucketID >> shift {
+		depth++
+	}
+	for leafA != leafB && depth > 0 {
+		leafA = leafA >> shift
+		leafB = leafB >> shift
+		depth--
+	}
+	return depth
 }
 
 // It returns valid randomly chosen path and storageID.
@@ -438,8 +461,32 @@ func GetRandomPathAndStorageID(treeHeight int, storageCount int) (path int, stor
 	return randomPath, randomStorage
 }
 
+// It returns a valid random path and a storageID chosen with probability proportional to its weight.
+func GetRandomPathAndWeightedStorageID(treeHeight int, storageWeights map[int]float64) (path int, storageID int) {
+	log.Debug().Msgf("Getting random path and weighted storage id")
+	paths := int(math.Pow(2, float64(treeHeight-1)))
+	randomPath := rand.Intn(paths) + 1
+	var storageIDs []int
+	totalWeight := 0.0
+	for storageID, weight := range storageWeights {
+		storageIDs = append(storageIDs, storageID)
+		totalWeight += weight
+	}
+	sort.Ints(storageIDs)
+	point := rand.Float64() * totalWeight
+	for _, storageID := range storageIDs {
+		point -= storageWeights[storageID]
+		if point < 0 {
+			return randomPath, storageID
+		}
+	}
+	return randomPath, storageIDs[len(storageIDs)-1]
+}
+
 func (s *StorageHandler) GetRandomStorageID() int {
 	log.Debug().Msgf("Getting random storage id")
+	s.storagesMu.RLock()
+	defer s.storagesMu.RUnlock()
 	index := rand.Intn(len(s.storages))
 	for storageID := range s.storages {
 		if index == 0 {
@@ -5,6 +5,7 @@ import (
 	"math"
 	"math/rand"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -38,36 +39,16 @@ func (s *StorageHandler) databaseInit(redisClient *redis.Client) (err error) {
 	pipe := redisClient.Pipeline()
 	pipeCount := 0
 	for bucketID := 1; bucketID < int(math.Pow(2, float64(s.treeHeight))); bucketID++ {
-		values := make([]string, s.Z+s.S)
-		metadatas := make([]string, s.Z+s.S)
-		realIndex := make([]int, s.Z+s.S)
-		for k := 0; k < s.Z+s.S; k++ {
-			// Generate a random number between 0 and 9
-			realIndex[k] = k
-		}
-		// userID of dummies
-		dummyCount := 1
-		// initialize value array
-		shuffleArray(realIndex)
-		for i := 0; i < s.Z+s.S; i++ {
-			dummyID := "dummy" + strconv.Itoa(dummyCount)
-			dummyString := "b" + strconv.Itoa(bucketID) + "d" + strconv.Itoa(realIndex[i])
-			dummyString, err = Encrypt(dummyString, s.key)
-			if err != nil {
-				log.Error().Msgf("Error encrypting data")
-				return err
-			}
-			// push dummy to array
-			values[realIndex[i]] = dummyString
-			// push meta data of dummies to array
-			metadatas[i] = strconv.Itoa(realIndex[i]) + dummyID
-			dummyCount++
+		values, metadatas, epoch, err := newBucket(bucketID, s.Z, s.S, s.key, nil, nil, nil, nil)
+		if err != nil {
+			log.Error().Msgf("Error encrypting data")
+			return err
 		}
 		// push content of value array and meta data array
-		s.BatchPushDataAndMetadata(bucketID, values, metadatas, pipe)
+		s.BatchPushDataAndMetadata(bucketID, values, metadatas, epoch, pipe)
 		pipeCount++
 		if pipeCount == 10000 || bucketID == int(math.Pow(2, float64(s.treeHeight)))-1 {
-			_, err = pipe.Exec(context.Background())
+			err = s.execPipeline(context.Background(), pipe)
 			if err != nil {
 				log.Error().Msgf("Error pushing values to db: %v", err)
 				return err
@@ -79,23 +60,73 @@ func (s *StorageHandler) databaseInit(redisClient *redis.Client) (err error) {
 	return nil
 }
 
-func (s *StorageHandler) BatchPushDataAndMetadata(bucketId int, valueData []string, valueMetadata []string, pipe redis.Pipeliner) (dataCmd *redis.BoolCmd, metadataCmd *redis.BoolCmd) {
-	ctx := context.Background()
-	kvpMapData := make(map[string]interface{})
-	for i := 0; i < len(valueData); i++ {
-		kvpMapData[strconv.Itoa(i)] = valueData[i]
+// It returns the values and the metadata of a bucket at random offsets, and the epoch of the bucket.
+// The bucket keeps up to Z real blocks from readBucketBlocks and then from the validBlocks of the shard node, and dummies fill the rest.
+// The real blocks that are placed in the bucket are added to writtenBlocks.
+func newBucket(bucketID int, Z int, S int, key []byte, readBucketBlocks map[string]string, validBlocks []string, shardNodeBlocks map[string]BlockInfo, writtenBlocks map[string]string) (values []string, metadatas []string, epoch int64, err error) {
+	values = make([]string, Z+S)
+	metadatas = make([]string, Z+S)
+	realIndex := make([]int, Z+S)
+	for k := 0; k < Z+S; k++ {
+		realIndex[k] = k
 	}
-
-	kvpMapMetadata := make(map[string]interface{})
-	for i := 0; i < len(valueMetadata); i++ {
-		kvpMapMetadata[strconv.Itoa(i)] = valueMetadata[i]
+	shuffleArray(realIndex)
+	i := 0
+	for block, value := range readBucketBlocks {
+		if strings.HasPrefix(block, "dummy") {
+			continue
+		}
+		if i >= Z {
+			break
+		}
+		writtenBlocks[block] = value
+		values[realIndex[i]], err = Encrypt(value, key)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		metadatas[i] = strconv.Itoa(realIndex[i]) + block
+		i++
 	}
-	kvpMapMetadata["accessCount"] = 0
-
-	dataCmd = pipe.HMSet(ctx, strconv.Itoa(bucketId), kvpMapData)
-	metadataCmd = pipe.HMSet(ctx, strconv.Itoa(-1*bucketId), kvpMapMetadata)
+	for _, block := range validBlocks {
+		if strings.HasPrefix(block, "dummy") {
+			continue
+		}
+		if i >= Z {
+			break
+		}
+		writtenBlocks[block] = shardNodeBlocks[block].Value
+		values[realIndex[i]], err = Encrypt(shardNodeBlocks[block].Value, key)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		metadatas[i] = strconv.Itoa(realIndex[i]) + block
+		i++
+	}
+	dummyCount := 1
+	epoch = rand.Int63()
+	for ; i < Z+S; i++ {
+		dummyID := "dummy" + strconv.Itoa(dummyCount)
+		values[realIndex[i]], err = encryptDummy(bucketID, realIndex[i], epoch, key)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		metadatas[i] = strconv.Itoa(realIndex[i]) + dummyID
+		dummyCount++
+	}
+	return values, metadatas, epoch, nil
+}
 
-	return dataCmd, metadataCmd
+// The epoch of a bucket changes with every write, and it is used to encrypt the dummy blocks of the bucket.
+// The data and the metadata are written atomically by a script, so the scripts should be loaded before calling it.
+func (s *StorageHandler) BatchPushDataAndMetadata(bucketId int, valueData []string, valueMetadata []string, epoch int64, pipe redis.Pipeliner) (cmd *redis.Cmd) {
+	args := []interface{}{epoch, len(valueData)}
+	for _, value := range valueData {
+		args = append(args, value)
+	}
+	for _, metadata := range valueMetadata {
+		args = append(args, metadata)
+	}
+	return writeBucketScript.EvalSha(context.Background(), pipe, []string{strconv.Itoa(bucketId), strconv.Itoa(-1 * bucketId)}, args...)
 }
 
 func parseMetadataBlock(block string) (pos int, key string, err error) {
@@ -127,7 +158,7 @@ func parseMetadataBlocks(bucketMetadata map[int]*redis.MapStringStringCmd) (bloc
 		}
 		allBlockOffsets[bucketID] = make(map[string]int)
 		for redisKey, block := range result {
-			if redisKey == "accessCount" {
+			if redisKey == "accessCount" || redisKey == "epoch" {
 				continue
 			}
 			pos, blockKey, err := parseMetadataBlock(block)
@@ -149,12 +180,12 @@ func (s *StorageHandler) BatchGetAllMetaData(bucketIDs []int, storageID int) (ma
 	ctx := context.Background()
 	// TODO: write a function to check for duplicate blocks here
 	startTime := time.Now()
-	pipe := s.storages[storageID].Pipeline()
+	pipe := s.getStorageClient(storageID).Pipeline()
 	results := make(map[int]*redis.MapStringStringCmd)
 	for _, bucketID := range bucketIDs {
 		results[bucketID] = pipe.HGetAll(ctx, strconv.Itoa(-1*bucketID))
 	}
-	_, err := pipe.Exec(ctx)
+	err := s.execPipeline(ctx, pipe)
 	if err != nil {
 		return nil, err
 	}
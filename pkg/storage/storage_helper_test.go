@@ -85,7 +85,7 @@ func TestBatchGetAllMetaDataReturnsAllBucketOffsets(t *testing.T) {
 	storageHandler := NewStorageHandler(3, 1, 9, 1, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}})
 	storageHandler.InitDatabase()
 	pipe := storageHandler.storages[0].Pipeline()
-	storageHandler.BatchPushDataAndMetadata(1, []string{"user1", "user2", "user3"}, []string{"2user5", "3user2"}, pipe)
+	storageHandler.BatchPushDataAndMetadata(1, []string{"user1", "user2", "user3"}, []string{"2user5", "3user2"}, 0, pipe)
 	_, err := pipe.Exec(context.Background())
 	if err != nil {
 		t.Errorf("error pushing data and metadata")
@@ -111,7 +111,7 @@ func TestBatchPushDataAndMetadataResetsAccessCount(t *testing.T) {
 	storageHandler := NewStorageHandler(3, 1, 9, 1, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}})
 	storageHandler.InitDatabase()
 	pipe := storageHandler.storages[0].Pipeline()
-	storageHandler.BatchPushDataAndMetadata(1, []string{"user1", "user2", "user3"}, []string{"2user5", "3user2"}, pipe)
+	storageHandler.BatchPushDataAndMetadata(1, []string{"user1", "user2", "user3"}, []string{"2user5", "3user2"}, 0, pipe)
 	_, err := pipe.Exec(context.Background())
 	if err != nil {
 		t.Errorf("error pushing data and metadata")
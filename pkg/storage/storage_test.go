@@ -39,6 +39,46 @@ func TestGetNextReverseLexicographicPath(t *testing.T) {
 	}
 }
 
+func TestGetCommonPathDepth(t *testing.T) {
+	testCases := []struct {
+		pathA         int
+		pathB         int
+		expectedDepth int
+	}{
+		{1, 1, 3},
+		{1, 2, 2},
+		{1, 3, 1},
+		{2, 4, 1},
+		{3, 4, 2},
+	}
+	for _, testCase := range testCases {
+		depth := GetCommonPathDepth(testCase.pathA, testCase.pathB, 3, 1)
+		if depth != testCase.expectedDepth {
+			t.Errorf("expected depth %d for paths %d and %d, but got %d", testCase.expectedDepth, testCase.pathA, testCase.pathB, depth)
+		}
+	}
+}
+
+func TestGetCommonPathDepthUsesTheShift(t *testing.T) {
+	// With a shift of 2 and height 5, the leaves 16 to 31 have the parents 4 to 7 and the root 1
+	testCases := []struct {
+		pathA         int
+		pathB         int
+		expectedDepth int
+	}{
+		{1, 1, 3},
+		{1, 4, 2},
+		{1, 5, 1},
+		{16, 13, 2},
+	}
+	for _, testCase := range testCases {
+		depth := GetCommonPathDepth(testCase.pathA, testCase.pathB, 5, 2)
+		if depth != testCase.expectedDepth {
+			t.Errorf("expected depth %d for paths %d and %d with shift 2, but got %d", testCase.expectedDepth, testCase.pathA, testCase.pathB, depth)
+		}
+	}
+}
+
 func TestGetMultipleReverseLexicographicPaths(t *testing.T) {
 	pathCount := 5
 	currentEvictionCount := 1
@@ -110,6 +150,52 @@ func TestBatchReadBlock(t *testing.T) {
 	}
 }
 
+func TestBatchReadBlockInvalidatesReadBlockAndIncrementsAccessCount(t *testing.T) {
+	storageID := 0
+	s := NewStorageHandler(3, 1, 9, 1, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}})
+	s.InitDatabase()
+	s.BatchWriteBucket(storageID, map[int]map[string]string{3: {"usr3": "value3"}}, map[string]BlockInfo{})
+	metadatas, _ := s.BatchGetAllMetaData([]int{3}, storageID)
+	offset := metadatas[3]["usr3"]
+	values, err := s.BatchReadBlock(map[int]int{3: offset}, storageID)
+	if err != nil {
+		t.Errorf("expected BatchReadBlock to succeed but got %s", err)
+	}
+	if values[3] != "value3" {
+		t.Errorf("expected value3 but got %s", values[3])
+	}
+	metadatas, _ = s.BatchGetAllMetaData([]int{3}, storageID)
+	if _, exists := metadatas[3]["usr3"]; exists {
+		t.Errorf("expected usr3 to be invalidated after the read")
+	}
+	if len(metadatas[3]) != 9 {
+		t.Errorf("expected only the read block to be invalidated but got %v", metadatas[3])
+	}
+	counts, _ := s.BatchGetAccessCount([]int{3}, storageID)
+	if counts[3] != 1 {
+		t.Errorf("expected access count 1 but got %d", counts[3])
+	}
+}
+
+func TestBatchWriteBucketReplacesAllBucketMetadata(t *testing.T) {
+	storageID := 0
+	s := NewStorageHandler(3, 1, 9, 1, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}})
+	s.InitDatabase()
+	s.storages[storageID].HSet(context.Background(), "-2", "stale", "0usr9")
+	s.BatchWriteBucket(storageID, map[int]map[string]string{2: {"usr2": "value2"}}, map[string]BlockInfo{})
+	metadata, err := s.storages[storageID].HGetAll(context.Background(), "-2").Result()
+	if err != nil {
+		t.Errorf("error getting metadata; %s", err)
+	}
+	if _, exists := metadata["stale"]; exists {
+		t.Errorf("expected the stale metadata to be removed")
+	}
+	// 10 blocks, accessCount and epoch
+	if len(metadata) != 12 || metadata["accessCount"] != "0" {
+		t.Errorf("expected a fresh bucket metadata but got %v", metadata)
+	}
+}
+
 func TestBatchGetBlockOffset(t *testing.T) {
 	bucketIDs := []int{1, 2, 3, 4, 5}
 	s := NewStorageHandler(4, 1, 9, 1, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}})
@@ -174,3 +260,112 @@ func TestRandom(t *testing.T) {
 	toWriteBlocks := map[int]map[string]string{1: {"usr1": "value1"}, 2: {"usr2": "value2"}, 3: {"usr3": "value3"}, 4: {"usr4": "value4"}, 5: {"usr5": "value5"}}
 	s.BatchWriteBucket(0, toWriteBlocks, map[string]BlockInfo{})
 }
+
+func TestBatchReadBlockXORReturnsRealBlockOfEachGroup(t *testing.T) {
+	storageID := 0
+	s := NewStorageHandler(3, 1, 9, 1, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}})
+	s.InitDatabase()
+	s.BatchWriteBucket(storageID, map[int]map[string]string{4: {"usr4": "value4"}}, map[string]BlockInfo{})
+	offsets, err := s.BatchGetBlockOffset([]int{4, 2, 1, 5}, storageID, []string{"usr4"})
+	if err != nil {
+		t.Errorf("error getting block offsets; %s", err)
+	}
+	countsBefore, _ := s.BatchGetAccessCount([]int{4, 2, 1, 5}, storageID)
+	groups := []XORGroup{
+		{Offsets: map[int]int{4: offsets[4].Offset, 2: offsets[2].Offset, 1: offsets[1].Offset}, RealBucket: 4},
+		{Offsets: map[int]int{5: offsets[5].Offset}},
+	}
+	values, err := s.BatchReadBlockXOR(groups, storageID)
+	if err != nil {
+		t.Errorf("expected BatchReadBlockXOR to succeed but got %s", err)
+	}
+	if len(values) != 2 || values[0] != "value4" || values[1] != "" {
+		t.Errorf("expected [value4 \"\"] but got %v", values)
+	}
+	counts, _ := s.BatchGetAccessCount([]int{4, 2, 1, 5}, storageID)
+	for _, bucketID := range []int{4, 2, 1, 5} {
+		if counts[bucketID] != countsBefore[bucketID]+1 {
+			t.Errorf("expected access count %d for bucket %d but got %d", countsBefore[bucketID]+1, bucketID, counts[bucketID])
+		}
+	}
+	metadata, _ := s.BatchGetAllMetaData([]int{4}, storageID)
+	if _, exists := metadata[4]["usr4"]; exists {
+		t.Errorf("expected usr4 to be invalidated after the read")
+	}
+}
+
+func TestReadXORScriptXorsBlocksOfDifferentLengths(t *testing.T) {
+	storageID := 0
+	s := NewStorageHandler(3, 1, 9, 1, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}})
+	client := s.getStorageClient(storageID)
+	ctx := context.Background()
+	blocks := []string{"\xff\x01\x80abc", "\x0f\xf0\x80", ""}
+	var keys []string
+	var offsets []interface{}
+	for i, block := range blocks {
+		dataKey, metadataKey := "xor-test-"+strconv.Itoa(i), "xor-test-metadata-"+strconv.Itoa(i)
+		client.Del(ctx, dataKey, metadataKey)
+		client.HSet(ctx, dataKey, "0", block)
+		keys = append(keys, dataKey, metadataKey)
+		offsets = append(offsets, "0")
+	}
+	result, err := readXORScript.Run(ctx, client, keys, offsets...).Slice()
+	if err != nil {
+		t.Fatalf("expected the script to succeed but got %s", err)
+	}
+	expected := "\xf0\xf1\x00abc"
+	if len(result) != 4 || result[0].(string) != expected {
+		t.Errorf("expected the XOR %q followed by three epochs but got %q", expected, result)
+	}
+}
+
+func TestAddStorageInitializesTreeOfNewStorage(t *testing.T) {
+	s := NewStorageHandler(3, 1, 9, 1, []config.RedisEndpoint{})
+	err := s.AddStorage(config.RedisEndpoint{ID: 2, IP: "localhost", Port: 6379}, true)
+	if err != nil {
+		t.Fatalf("expected storage to be added but got %s", err)
+	}
+	dbsize, err := s.getStorageClient(2).DBSize(context.Background()).Result()
+	if err != nil || dbsize != 14 {
+		t.Errorf("expected a tree with 7 buckets in the new storage but got %d keys", dbsize)
+	}
+	if s.GetRandomStorageID() != 2 {
+		t.Errorf("expected the new storage to be used")
+	}
+	counts, err := s.BatchGetAccessCount([]int{1, 2}, 2)
+	if err != nil || len(counts) != 2 {
+		t.Errorf("expected to read the buckets of the new storage but got %v, %v", counts, err)
+	}
+}
+
+func TestAddStorageDoesNothingForExistingStorage(t *testing.T) {
+	s := NewStorageHandler(3, 1, 9, 1, []config.RedisEndpoint{{ID: 0, IP: "localhost", Port: 6379}})
+	client := s.getStorageClient(0)
+	err := s.AddStorage(config.RedisEndpoint{ID: 0, IP: "localhost", Port: 6380}, false)
+	if err != nil || s.getStorageClient(0) != client {
+		t.Errorf("adding an existing storage should keep the existing client")
+	}
+}
+
+func TestGetRandomPathAndWeightedStorageIDSkipsZeroWeights(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		path, storageID := GetRandomPathAndWeightedStorageID(3, map[int]float64{0: 1, 1: 0, 2: 1})
+		if storageID == 1 {
+			t.Errorf("storage 1 has zero weight and should not be chosen")
+		}
+		if path < 1 || path > 4 {
+			t.Errorf("path %d is not valid for a tree of height 3", path)
+		}
+	}
+}
+
+func TestGetRandomPathAndWeightedStorageIDFollowsWeights(t *testing.T) {
+	counts := make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		_, storageID := GetRandomPathAndWeightedStorageID(3, map[int]float64{0: 1, 1: 0.25})
+		counts[storageID]++
+	}
+	if counts[1] < 1000 || counts[1] > 3000 {
+		t.Errorf("expected around 2000 placements on storage 1 but got %d", counts[1])
+	}
+}
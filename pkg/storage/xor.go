@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// XORGroup is a set of buckets whose blocks at the offsets are combined into a single value by the storage.
+// At most one of the blocks is real and the others are dummies.
+type XORGroup struct {
+	Offsets    map[int]int // map of bucket id to offset
+	RealBucket int         // the bucket id of the real block, zero if all the blocks are dummies
+}
+
+func xorInto(dst []byte, src []byte) []byte {
+	for len(dst) < len(src) {
+		dst = append(dst, 0)
+	}
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+	return dst
+}
+
+// BatchReadBlockXOR reads one value for each group instead of one value for each bucket.
+// Dummy blocks are encrypted with a nonce that depends on the bucket epoch,
+// so they are encrypted again and removed from the XOR to recover the real block.
+// It returns the value of the real block of each group, or an empty string if the group has no real block.
+func (s *StorageHandler) BatchReadBlockXOR(groups []XORGroup, storageID int) (values []string, err error) {
+	err = s.loadScripts(storageID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load scripts; %s", err)
+	}
+	ctx := context.Background()
+	pipe := s.getStorageClient(storageID).Pipeline()
+	type groupOrder struct {
+		buckets []int
+		cmd     interface{ Slice() ([]interface{}, error) }
+	}
+	orders := make([]groupOrder, len(groups))
+	for i, group := range groups {
+		var keys []string
+		var args []interface{}
+		for bucketID, offset := range group.Offsets {
+			orders[i].buckets = append(orders[i].buckets, bucketID)
+			keys = append(keys, strconv.Itoa(bucketID), strconv.Itoa(-1*bucketID))
+			args = append(args, offset)
+		}
+		orders[i].cmd = readXORScript.EvalSha(ctx, pipe, keys, args...)
+	}
+	err = s.execPipeline(ctx, pipe)
+	if err != nil {
+		s.handleScriptError(storageID, err)
+		return nil, fmt.Errorf("unable to execute the xor read pipeline; %s", err)
+	}
+
+	values = make([]string, len(groups))
+	for i, group := range groups {
+		result, err := orders[i].cmd.Slice()
+		if err != nil {
+			return nil, err
+		}
+		epochs := make([]int64, len(orders[i].buckets))
+		for j, bucketID := range orders[i].buckets {
+			epochs[j], err = strconv.ParseInt(result[j+1].(string), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid epoch for bucket %d; %s", bucketID, err)
+			}
+		}
+		values[i], err = recoverRealBlock(group, orders[i].buckets, []byte(result[0].(string)), epochs, s.key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// It removes the dummies of the buckets from the XOR of the group, and returns the decrypted real block.
+// The epochs are the epochs of the buckets when they were read. It returns an empty string if the group has no real block.
+func recoverRealBlock(group XORGroup, buckets []int, xored []byte, epochs []int64, key []byte) (string, error) {
+	for j, bucketID := range buckets {
+		if bucketID == group.RealBucket {
+			continue
+		}
+		dummy, err := encryptDummy(bucketID, group.Offsets[bucketID], epochs[j], key)
+		if err != nil {
+			return "", err
+		}
+		xored = xorInto(xored, []byte(dummy))
+	}
+	if group.RealBucket == 0 {
+		return "", nil
+	}
+	// the encrypted blocks are hex strings, so the zero bytes at the end are the padding
+	end := len(xored)
+	for end > 0 && xored[end-1] == 0 {
+		end--
+	}
+	value, err := Decrypt(string(xored[:end]), key)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt the real block of bucket %d; %s", group.RealBucket, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,512 @@
+// Package testcluster runs the routers, the shard nodes and the ORAM nodes of a cluster in one process for the tests.
+// The nodes talk over in-memory grpc connections and raft transports, and the ORAM trees are kept in memory,
+// so the tests need neither redis nor free ports. The replicas can be stopped and restarted to test the crashes.
+package testcluster
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dsg-uwaterloo/treebeard/pkg/config"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
+	"github.com/dsg-uwaterloo/treebeard/pkg/oramnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/router"
+	"github.com/dsg-uwaterloo/treebeard/pkg/shardnode"
+	"github.com/dsg-uwaterloo/treebeard/pkg/storage"
+	"github.com/dsg-uwaterloo/treebeard/pkg/treebeard"
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type Config struct {
+	Routers    int
+	ShardNodes int
+	OramNodes  int // every ORAM node has one storage
+	Replicas   int // the number of replicas of every shard node and ORAM node
+	Parameters config.Parameters
+	// The raft heartbeat and election timeout of the replicas.
+	// It is much shorter than the default, so that a new leader is elected quickly after a crash.
+	ElectionTimeout time.Duration
+}
+
+// DefaultConfig returns a config with one router, one shard node and one ORAM node with three replicas each.
+func DefaultConfig() Config {
+	return Config{
+		Routers:    1,
+		ShardNodes: 1,
+		OramNodes:  1,
+		Replicas:   3,
+		Parameters: config.Parameters{
+			MaxBlocksToSend:   5,
+			EvictionRate:      2,
+			EvictPathCount:    4,
+			BatchTimout:       2,
+			EpochTime:         10,
+			EpochTimeout:      3000,
+			Z:                 1,
+			S:                 9,
+			Shift:             1,
+			TreeHeight:        5,
+			RedisPipelineSize: 150,
+			MaxRequests:       1000,
+			BlockSize:         1024,
+		},
+		ElectionTimeout: 100 * time.Millisecond,
+	}
+}
+
+// It maps the addresses of the grpc servers to their in-memory listeners.
+// A server that restarts gets a new listener at the same address, so the connections to it are dialed again.
+type network struct {
+	mu        sync.Mutex
+	listeners map[string]*bufconn.Listener
+}
+
+func (n *network) listen(address string) *bufconn.Listener {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	listener := bufconn.Listen(1024 * 1024)
+	n.listeners[address] = listener
+	return listener
+}
+
+func (n *network) dial(ctx context.Context, address string) (net.Conn, error) {
+	n.mu.Lock()
+	listener, exists := n.listeners[address]
+	n.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("no server at %s", address)
+	}
+	return listener.DialContext(ctx)
+}
+
+func (n *network) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{grpc.WithContextDialer(n.dial)}
+}
+
+// It is the raft state of a replica that outlives its crashes, like the disk of a replica.
+type raftState struct {
+	address   raft.ServerAddress
+	transport *raft.InmemTransport
+	store     *raft.InmemStore
+	snapshots *raft.InmemSnapshotStore
+}
+
+type replica interface {
+	Stop()
+	IsLeader() bool
+}
+
+type replicaSlot struct {
+	address string // the address of the grpc server
+	raft    *raftState
+	faults  *faults.Injector // it is kept across the restarts, and a crash stops the replica
+	running replica          // nil if the replica is stopped
+}
+
+// It is a shard node or an ORAM node with its replicas.
+type group struct {
+	name     string
+	replicas []*replicaSlot
+	// It starts a replica, bootstrap is nil if the replica restarts.
+	start func(replicaID int, listener net.Listener, r *raftState, injector *faults.Injector, bootstrap *raft.Configuration) (replica, error)
+}
+
+// It connects the raft transport of the replica to the transports of the other replicas in both directions.
+func (g *group) connect(replicaID int) {
+	self := g.replicas[replicaID].raft
+	for otherID, other := range g.replicas {
+		if otherID == replicaID {
+			continue
+		}
+		self.transport.Connect(other.raft.address, other.raft.transport)
+		other.raft.transport.Connect(self.address, self.transport)
+	}
+}
+
+func (g *group) disconnect(replicaID int) {
+	self := g.replicas[replicaID].raft
+	self.transport.DisconnectAll()
+	for otherID, other := range g.replicas {
+		if otherID != replicaID {
+			other.raft.transport.Disconnect(self.address)
+		}
+	}
+}
+
+type routerSlot struct {
+	address string
+	running *router.Router // nil if the router is stopped
+	start   func(listener net.Listener) *router.Router
+}
+
+// Cluster is a running cluster. Its methods are safe to use from many goroutines.
+type Cluster struct {
+	config     Config
+	network    *network
+	mu         sync.Mutex
+	routers    []*routerSlot
+	shardNodes []*group
+	oramNodes  []*group
+}
+
+func (c *Cluster) raftConfig() *raft.Config {
+	raftConfig := raft.DefaultConfig()
+	raftConfig.HeartbeatTimeout = c.config.ElectionTimeout
+	raftConfig.ElectionTimeout = c.config.ElectionTimeout
+	raftConfig.LeaderLeaseTimeout = c.config.ElectionTimeout / 2
+	raftConfig.CommitTimeout = 5 * time.Millisecond
+	// The FSMs can not restore snapshots, so a restarted replica applies the whole log
+	raftConfig.SnapshotThreshold = math.MaxUint64
+	raftConfig.SnapshotInterval = 24 * time.Hour
+	return raftConfig
+}
+
+// It creates the replicas of a group with their raft state, and the configuration that bootstraps them.
+// A crash injected in a replica stops it with the stop function.
+func newGroup(name string, replicas int, start func(replicaID int, listener net.Listener, r *raftState, injector *faults.Injector, bootstrap *raft.Configuration) (replica, error), stop func(replicaID int) error) (*group, *raft.Configuration) {
+	g := &group{name: name, start: start}
+	bootstrap := &raft.Configuration{}
+	for replicaID := 0; replicaID < replicas; replicaID++ {
+		replicaID := replicaID
+		address, transport := raft.NewInmemTransport(raft.ServerAddress(name + "-raft-" + strconv.Itoa(replicaID)))
+		g.replicas = append(g.replicas, &replicaSlot{
+			address: name + "-" + strconv.Itoa(replicaID) + ":0",
+			raft:    &raftState{address: address, transport: transport, store: raft.NewInmemStore(), snapshots: raft.NewInmemSnapshotStore()},
+			faults: faults.NewInjector(func(point string) {
+				log.Debug().Msgf("Crashing replica %d of %s at %s", replicaID, name, point)
+				err := stop(replicaID)
+				if err != nil {
+					log.Error().Msgf("Could not crash replica %d of %s; %s", replicaID, name, err)
+				}
+			}),
+		})
+		bootstrap.Servers = append(bootstrap.Servers, raft.Server{ID: raft.ServerID(strconv.Itoa(replicaID)), Address: address})
+	}
+	for replicaID := range g.replicas {
+		g.connect(replicaID)
+	}
+	return g, bootstrap
+}
+
+// Start starts the cluster. It does not wait for the leaders to be elected, see WaitForLeaders.
+func Start(c Config) (*Cluster, error) {
+	cluster := &Cluster{config: c, network: &network{listeners: make(map[string]*bufconn.Listener)}}
+
+	var shardNodeEndpoints []config.ShardNodeEndpoint
+	var oramNodeEndpoints []config.OramNodeEndpoint
+	var redisEndpoints []config.RedisEndpoint
+	for shardNodeID := 0; shardNodeID < c.ShardNodes; shardNodeID++ {
+		for replicaID := 0; replicaID < c.Replicas; replicaID++ {
+			shardNodeEndpoints = append(shardNodeEndpoints, config.ShardNodeEndpoint{IP: "shardnode-" + strconv.Itoa(shardNodeID) + "-" + strconv.Itoa(replicaID), ID: shardNodeID, ReplicaID: replicaID})
+		}
+	}
+	for oramNodeID := 0; oramNodeID < c.OramNodes; oramNodeID++ {
+		for replicaID := 0; replicaID < c.Replicas; replicaID++ {
+			oramNodeEndpoints = append(oramNodeEndpoints, config.OramNodeEndpoint{IP: "oramnode-" + strconv.Itoa(oramNodeID) + "-" + strconv.Itoa(replicaID), ID: oramNodeID, ReplicaID: replicaID})
+		}
+		redisEndpoints = append(redisEndpoints, config.RedisEndpoint{ID: oramNodeID, ORAMNodeID: oramNodeID})
+	}
+	// The connections are dialed when they are first used, so the clients can be created before the servers
+	oramNodeClients, err := shardnode.StartOramNodeRPCClients(oramNodeEndpoints, cluster.network.dialOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the ORAM node clients; %s", err)
+	}
+	shardNodeClientsOfOramNodes, err := oramnode.StartShardNodeRPCClients(shardNodeEndpoints, cluster.network.dialOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the shard node clients of the ORAM nodes; %s", err)
+	}
+	shardNodeClientsOfRouters, err := router.StartShardNodeRPCClients(shardNodeEndpoints, cluster.network.dialOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the shard node clients of the routers; %s", err)
+	}
+
+	backend := storage.NewMemoryBackend()
+	var bootstraps []*raft.Configuration
+	for oramNodeID := 0; oramNodeID < c.OramNodes; oramNodeID++ {
+		oramNodeID := oramNodeID
+		storages := []config.RedisEndpoint{redisEndpoints[oramNodeID]}
+		g, bootstrap := newGroup("oramnode-"+strconv.Itoa(oramNodeID), c.Replicas, func(replicaID int, listener net.Listener, r *raftState, injector *faults.Injector, bootstrap *raft.Configuration) (replica, error) {
+			storageHandler := storage.NewMemoryStorageHandler(c.Parameters.TreeHeight, c.Parameters.Z, c.Parameters.S, c.Parameters.Shift, backend, storages)
+			err := storageHandler.InitDatabase()
+			if err != nil {
+				return nil, err
+			}
+			return oramnode.StartReplica(oramnode.ReplicaConfig{
+				OramNodeID:          oramNodeID,
+				ReplicaID:           replicaID,
+				Listener:            listener,
+				ShardNodeRPCClients: shardNodeClientsOfOramNodes,
+				Storage:             storageHandler,
+				Parameters:          c.Parameters,
+				RaftConfig:          cluster.raftConfig(),
+				RaftTransport:       r.transport,
+				RaftLogs:            r.store,
+				RaftStable:          r.store,
+				RaftSnapshots:       r.snapshots,
+				Bootstrap:           bootstrap,
+				Faults:              injector,
+			})
+		}, func(replicaID int) error {
+			return cluster.StopOramNodeReplica(oramNodeID, replicaID)
+		})
+		cluster.oramNodes = append(cluster.oramNodes, g)
+		bootstraps = append(bootstraps, bootstrap)
+	}
+	for shardNodeID := 0; shardNodeID < c.ShardNodes; shardNodeID++ {
+		shardNodeID := shardNodeID
+		g, bootstrap := newGroup("shardnode-"+strconv.Itoa(shardNodeID), c.Replicas, func(replicaID int, listener net.Listener, r *raftState, injector *faults.Injector, bootstrap *raft.Configuration) (replica, error) {
+			return shardnode.StartReplica(shardnode.ReplicaConfig{
+				ShardNodeID:        shardNodeID,
+				ReplicaID:          replicaID,
+				Listener:           listener,
+				OramNodeRPCClients: oramNodeClients,
+				Parameters:         c.Parameters,
+				Storages:           redisEndpoints,
+				RaftConfig:         cluster.raftConfig(),
+				RaftTransport:      r.transport,
+				RaftLogs:           r.store,
+				RaftStable:         r.store,
+				RaftSnapshots:      r.snapshots,
+				Bootstrap:          bootstrap,
+				Faults:             injector,
+				DialOptions:        cluster.network.dialOptions(),
+			})
+		}, func(replicaID int) error {
+			return cluster.StopShardNodeReplica(shardNodeID, replicaID)
+		})
+		cluster.shardNodes = append(cluster.shardNodes, g)
+		bootstraps = append(bootstraps, bootstrap)
+	}
+	for i, g := range append(append([]*group{}, cluster.oramNodes...), cluster.shardNodes...) {
+		for replicaID, slot := range g.replicas {
+			slot.running, err = g.start(replicaID, cluster.network.listen(slot.address), slot.raft, slot.faults, bootstraps[i])
+			if err != nil {
+				cluster.Close()
+				return nil, fmt.Errorf("could not start replica %d of %s; %s", replicaID, g.name, err)
+			}
+		}
+	}
+	for routerID := 0; routerID < c.Routers; routerID++ {
+		routerID := routerID
+		slot := &routerSlot{
+			address: "router-" + strconv.Itoa(routerID) + ":0",
+			start: func(listener net.Listener) *router.Router {
+				return router.StartRouter(listener, shardNodeClientsOfRouters, routerID, c.Parameters, "")
+			},
+		}
+		slot.running = slot.start(cluster.network.listen(slot.address))
+		cluster.routers = append(cluster.routers, slot)
+	}
+	return cluster, nil
+}
+
+// Close stops all the routers and replicas.
+func (c *Cluster) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, slot := range c.routers {
+		if slot.running != nil {
+			slot.running.Stop()
+			slot.running = nil
+		}
+	}
+	for _, g := range append(append([]*group{}, c.shardNodes...), c.oramNodes...) {
+		for replicaID, slot := range g.replicas {
+			if slot.running != nil {
+				slot.running.Stop()
+				slot.running = nil
+				g.disconnect(replicaID)
+			}
+		}
+	}
+}
+
+// RouterAddresses returns the addresses of the routers. They can only be dialed with DialOptions.
+func (c *Cluster) RouterAddresses() []string {
+	var addresses []string
+	for _, slot := range c.routers {
+		addresses = append(addresses, slot.address)
+	}
+	return addresses
+}
+
+// DialOptions returns the options that dial the in-memory connections of the cluster.
+func (c *Cluster) DialOptions() []grpc.DialOption {
+	return c.network.dialOptions()
+}
+
+// NewClient returns a client of all the routers of the cluster.
+func (c *Cluster) NewClient() (*treebeard.Client, error) {
+	clientConfig := treebeard.DefaultConfig(c.RouterAddresses()...)
+	clientConfig.RetryBackoff = 10 * time.Millisecond
+	clientConfig.EjectionDuration = 100 * time.Millisecond
+	clientConfig.HealthCheckInterval = 100 * time.Millisecond
+	clientConfig.DialOptions = c.DialOptions()
+	return treebeard.NewClient(clientConfig)
+}
+
+func (c *Cluster) getGroup(groups []*group, id int, replicaID int) (*group, error) {
+	if id < 0 || id >= len(groups) {
+		return nil, fmt.Errorf("there is no node %d", id)
+	}
+	if replicaID < 0 || replicaID >= len(groups[id].replicas) {
+		return nil, fmt.Errorf("%s has no replica %d", groups[id].name, replicaID)
+	}
+	return groups[id], nil
+}
+
+func (c *Cluster) stopReplica(groups []*group, id int, replicaID int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, err := c.getGroup(groups, id, replicaID)
+	if err != nil {
+		return err
+	}
+	slot := g.replicas[replicaID]
+	if slot.running == nil {
+		return fmt.Errorf("replica %d of %s is already stopped", replicaID, g.name)
+	}
+	slot.running.Stop()
+	slot.running = nil
+	g.disconnect(replicaID)
+	return nil
+}
+
+// It restarts a stopped replica with its raft log, so it applies the log again and catches up with the other replicas.
+func (c *Cluster) restartReplica(groups []*group, id int, replicaID int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, err := c.getGroup(groups, id, replicaID)
+	if err != nil {
+		return err
+	}
+	slot := g.replicas[replicaID]
+	if slot.running != nil {
+		return fmt.Errorf("replica %d of %s is running", replicaID, g.name)
+	}
+	// The stopped raft node closed its transport
+	_, slot.raft.transport = raft.NewInmemTransport(slot.raft.address)
+	g.connect(replicaID)
+	slot.running, err = g.start(replicaID, c.network.listen(slot.address), slot.raft, slot.faults, nil)
+	if err != nil {
+		return fmt.Errorf("could not restart replica %d of %s; %s", replicaID, g.name, err)
+	}
+	return nil
+}
+
+func (c *Cluster) faults(groups []*group, id int, replicaID int) (*faults.Injector, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, err := c.getGroup(groups, id, replicaID)
+	if err != nil {
+		return nil, err
+	}
+	return g.replicas[replicaID].faults, nil
+}
+
+// It returns the running replica that is the leader, or false if there is none.
+func (c *Cluster) leader(groups []*group, id int) (replicaID int, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id < 0 || id >= len(groups) {
+		return 0, false
+	}
+	for replicaID, slot := range groups[id].replicas {
+		if slot.running != nil && slot.running.IsLeader() {
+			return replicaID, true
+		}
+	}
+	return 0, false
+}
+
+// StopShardNodeReplica stops a replica like a crash. Its raft log is kept for the restart.
+func (c *Cluster) StopShardNodeReplica(shardNodeID int, replicaID int) error {
+	return c.stopReplica(c.shardNodes, shardNodeID, replicaID)
+}
+
+func (c *Cluster) RestartShardNodeReplica(shardNodeID int, replicaID int) error {
+	return c.restartReplica(c.shardNodes, shardNodeID, replicaID)
+}
+
+func (c *Cluster) ShardNodeLeader(shardNodeID int) (replicaID int, ok bool) {
+	return c.leader(c.shardNodes, shardNodeID)
+}
+
+// StopOramNodeReplica stops a replica like a crash. Its raft log and its storages are kept for the restart.
+// ShardNodeFaults returns the injector of the replica. Its rules and counts are kept when the replica restarts.
+func (c *Cluster) ShardNodeFaults(shardNodeID int, replicaID int) (*faults.Injector, error) {
+	return c.faults(c.shardNodes, shardNodeID, replicaID)
+}
+
+func (c *Cluster) StopOramNodeReplica(oramNodeID int, replicaID int) error {
+	return c.stopReplica(c.oramNodes, oramNodeID, replicaID)
+}
+
+func (c *Cluster) RestartOramNodeReplica(oramNodeID int, replicaID int) error {
+	return c.restartReplica(c.oramNodes, oramNodeID, replicaID)
+}
+
+func (c *Cluster) OramNodeLeader(oramNodeID int) (replicaID int, ok bool) {
+	return c.leader(c.oramNodes, oramNodeID)
+}
+
+// StopRouter stops a router. The requests that it did not answer are lost.
+// OramNodeFaults returns the injector of the replica and its storage. Its rules and counts are kept when the replica restarts.
+func (c *Cluster) OramNodeFaults(oramNodeID int, replicaID int) (*faults.Injector, error) {
+	return c.faults(c.oramNodes, oramNodeID, replicaID)
+}
+
+func (c *Cluster) StopRouter(routerID int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if routerID < 0 || routerID >= len(c.routers) || c.routers[routerID].running == nil {
+		return fmt.Errorf("router %d is not running", routerID)
+	}
+	c.routers[routerID].running.Stop()
+	c.routers[routerID].running = nil
+	return nil
+}
+
+func (c *Cluster) RestartRouter(routerID int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if routerID < 0 || routerID >= len(c.routers) || c.routers[routerID].running != nil {
+		return fmt.Errorf("router %d is not stopped", routerID)
+	}
+	slot := c.routers[routerID]
+	slot.running = slot.start(c.network.listen(slot.address))
+	return nil
+}
+
+// WaitForLeaders waits until every shard node and ORAM node has a leader.
+func (c *Cluster) WaitForLeaders(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		missing := ""
+		for id := range c.shardNodes {
+			if _, ok := c.ShardNodeLeader(id); !ok {
+				missing = "shard node " + strconv.Itoa(id)
+			}
+		}
+		for id := range c.oramNodes {
+			if _, ok := c.OramNodeLeader(id); !ok {
+				missing = "ORAM node " + strconv.Itoa(id)
+			}
+		}
+		if missing == "" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s has no leader after %s", missing, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
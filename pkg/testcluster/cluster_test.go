@@ -0,0 +1,332 @@
+package testcluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	routerpb "github.com/dsg-uwaterloo/treebeard/api/router"
+	"github.com/dsg-uwaterloo/treebeard/pkg/client"
+	"github.com/dsg-uwaterloo/treebeard/pkg/faults"
+	"github.com/dsg-uwaterloo/treebeard/pkg/linearizability"
+	"github.com/dsg-uwaterloo/treebeard/pkg/treebeard"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func startTestCluster(t *testing.T) *Cluster {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	cluster, err := Start(DefaultConfig())
+	if err != nil {
+		t.Fatalf("could not start the cluster; %s", err)
+	}
+	t.Cleanup(cluster.Close)
+	if err := cluster.WaitForLeaders(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return cluster
+}
+
+func newTestClient(t *testing.T, cluster *Cluster) *treebeard.Client {
+	client, err := cluster.NewClient()
+	if err != nil {
+		t.Fatalf("could not create the client; %s", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClusterAnswersRequests(t *testing.T) {
+	cluster := startTestCluster(t)
+	client := newTestClient(t, cluster)
+	ctx := context.Background()
+
+	if err := client.Put(ctx, "cat", "meow"); err != nil {
+		t.Fatalf("expected the put to succeed; %s", err)
+	}
+	value, err := client.Get(ctx, "cat")
+	if err != nil || value != "meow" {
+		t.Errorf("expected meow but got %s; %v", value, err)
+	}
+	if err := client.Delete(ctx, "cat"); err != nil {
+		t.Fatalf("expected the delete to succeed; %s", err)
+	}
+	_, err = client.Get(ctx, "cat")
+	if !errors.Is(err, treebeard.ErrNotFound) {
+		t.Errorf("expected cat not to be found after it is deleted but got %v", err)
+	}
+}
+
+func TestClusterKeepsTheBlocksAfterTheShardNodeLeaderRestarts(t *testing.T) {
+	cluster := startTestCluster(t)
+	client := newTestClient(t, cluster)
+	ctx := context.Background()
+
+	if err := client.Put(ctx, "cat", "meow"); err != nil {
+		t.Fatalf("expected the put to succeed; %s", err)
+	}
+	leader, _ := cluster.ShardNodeLeader(0)
+	if err := cluster.StopShardNodeReplica(0, leader); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.WaitForLeaders(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	value, err := client.Get(ctx, "cat")
+	if err != nil || value != "meow" {
+		t.Errorf("expected meow from the new leader but got %s; %v", value, err)
+	}
+	if err := cluster.RestartShardNodeReplica(0, leader); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Put(ctx, "cat", "purr"); err != nil {
+		t.Fatalf("expected the put to succeed after the restart; %s", err)
+	}
+	value, err = client.Get(ctx, "cat")
+	if err != nil || value != "purr" {
+		t.Errorf("expected purr but got %s; %v", value, err)
+	}
+}
+
+// It puts the values and then reads them until the point is faulted in the injector, and checks them after that.
+func checkValuesWhileFaulted(t *testing.T, cluster *Cluster, client *treebeard.Client, injector *faults.Injector, point string) {
+	ctx := context.Background()
+	values := map[string]string{"cat": "meow", "dog": "woof", "cow": "moo"}
+	for key, value := range values {
+		if err := client.Put(ctx, key, value); err != nil {
+			t.Fatalf("expected the put of %s to succeed; %s", key, err)
+		}
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for injector.Faulted(point) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to be faulted", point)
+		}
+		client.Get(ctx, "cat")
+	}
+	if err := cluster.WaitForLeaders(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range values {
+		got, err := client.Get(ctx, key)
+		if err != nil || got != value {
+			t.Errorf("expected %s for %s but got %s; %v", value, key, got, err)
+		}
+	}
+}
+
+func TestClusterRecoversTheEvictionAfterTheOramNodeLeaderCrashes(t *testing.T) {
+	for _, point := range []string{faults.AfterBeginEviction, faults.AfterWriteBuckets, faults.AfterSendAcks} {
+		t.Run(point, func(t *testing.T) {
+			cluster := startTestCluster(t)
+			client := newTestClient(t, cluster)
+
+			leader, _ := cluster.OramNodeLeader(0)
+			injector, err := cluster.OramNodeFaults(0, leader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			injector.Add(faults.Rule{Point: point, Action: faults.Crash, Count: 1})
+			checkValuesWhileFaulted(t, cluster, client, injector, point)
+			if _, ok := cluster.OramNodeLeader(0); !ok {
+				t.Errorf("expected a new ORAM node leader after the crash")
+			}
+			if err := cluster.RestartOramNodeReplica(0, leader); err != nil {
+				t.Errorf("expected the crashed replica to be stopped and restart; %s", err)
+			}
+		})
+	}
+}
+
+func TestClusterAnswersRequestsWhenReadPathsAreDropped(t *testing.T) {
+	cluster := startTestCluster(t)
+	client := newTestClient(t, cluster)
+
+	leader, _ := cluster.OramNodeLeader(0)
+	injector, err := cluster.OramNodeFaults(0, leader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	injector.Add(faults.Rule{Point: faults.ReadPath, Action: faults.Drop, Count: 3})
+	checkValuesWhileFaulted(t, cluster, client, injector, faults.ReadPath)
+}
+
+// The acks are dropped for longer than the ORAM node retries them, so the eviction is replayed and sends them again with the same eviction id.
+func TestClusterAnswersRequestsWhenTheAcksOfAnEvictionAreDropped(t *testing.T) {
+	cluster := startTestCluster(t)
+	client := newTestClient(t, cluster)
+
+	leader, _ := cluster.ShardNodeLeader(0)
+	injector, err := cluster.ShardNodeFaults(0, leader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	injector.Add(faults.Rule{Point: faults.AckSentBlocks, Action: faults.Drop, Count: 60})
+	checkValuesWhileFaulted(t, cluster, client, injector, faults.AckSentBlocks)
+}
+
+// The leaders are crashed while the requests run.
+// A shard node leader can crash between the read of a block on the ORAM node and the replication of its response,
+// and the new leader reads the block again from the ORAM node.
+func TestConcurrentRequestsAreLinearizableWhileLeadersCrash(t *testing.T) {
+	cluster := startTestCluster(t)
+	routers := newTestClient(t, cluster)
+	history := client.NewHistory()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		worker := worker
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+				block := fmt.Sprintf("block%d", (worker+i)%3)
+				if i%3 == 0 {
+					value := fmt.Sprintf("%d-%d", worker, i)
+					index := history.Begin(linearizability.Write, block, value)
+					history.End(index, "", routers.Put(ctx, block, value))
+				} else {
+					index := history.Begin(linearizability.Read, block, "")
+					value, err := routers.Get(ctx, block)
+					if errors.Is(err, treebeard.ErrNotFound) {
+						err = nil
+					}
+					history.End(index, value, err)
+				}
+				cancel()
+			}
+		}()
+	}
+
+	// The shard node leader crashes between the read of a block on the ORAM node and the replication of its response
+	for crash := 0; crash < 2; crash++ {
+		time.Sleep(time.Second)
+		leader, ok := cluster.ShardNodeLeader(0)
+		if !ok {
+			continue
+		}
+		injector, err := cluster.ShardNodeFaults(0, leader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		injector.Add(faults.Rule{Point: faults.BeforeReplicateResponse, Action: faults.Crash, Count: 1})
+		deadline := time.Now().Add(10 * time.Second)
+		for newLeader, ok := cluster.ShardNodeLeader(0); !ok || newLeader == leader; newLeader, ok = cluster.ShardNodeLeader(0) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the shard node leader to crash before it replicates a response")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		injector.Clear()
+		time.Sleep(time.Second)
+		if err := cluster.RestartShardNodeReplica(0, leader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second)
+	if leader, ok := cluster.OramNodeLeader(0); ok {
+		if err := cluster.StopOramNodeReplica(0, leader); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+		if err := cluster.RestartOramNodeReplica(0, leader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(2 * time.Second)
+	close(done)
+	wg.Wait()
+
+	operations := history.Operations()
+	returned := 0
+	for _, operation := range operations {
+		if !operation.Return.IsZero() {
+			returned++
+		}
+	}
+	if returned == 0 {
+		t.Fatalf("expected some requests to succeed")
+	}
+	if linearizable, block := linearizability.CheckRegisters(operations); !linearizable {
+		t.Errorf("expected the history to be linearizable, but the history of block %s is not", block)
+	}
+}
+
+// The decisions never reach one shard node, so it asks the coordinator of the transaction after its prepare times out.
+func TestClusterAppliesATransactionOnEveryShardNodeWhenTheDecisionsToOneShardNodeAreDropped(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	config := DefaultConfig()
+	config.ShardNodes = 2
+	config.Parameters.PrepareTimeout = 200
+	cluster, err := Start(config)
+	if err != nil {
+		t.Fatalf("could not start the cluster; %s", err)
+	}
+	t.Cleanup(cluster.Close)
+	if err := cluster.WaitForLeaders(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, cluster)
+	ctx := context.Background()
+	keys := []string{"cat", "dog", "cow", "owl", "bee", "fox", "eel", "ant"}
+	for _, key := range keys {
+		if err := client.Put(ctx, key, "old"); err != nil {
+			t.Fatalf("expected the put of %s to succeed; %s", key, err)
+		}
+	}
+	var injectors []*faults.Injector
+	for replicaID := 0; replicaID < config.Replicas; replicaID++ {
+		injector, err := cluster.ShardNodeFaults(1, replicaID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		injector.Add(faults.Rule{Point: faults.DecideTransactions, Action: faults.Drop})
+		injectors = append(injectors, injector)
+	}
+
+	conn, err := grpc.Dial(cluster.RouterAddresses()[0], append(cluster.DialOptions(), grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		t.Fatalf("could not dial the router; %s", err)
+	}
+	defer conn.Close()
+	request := &routerpb.TransactionRequest{}
+	for _, key := range keys {
+		request.WriteSet = append(request.WriteSet, &routerpb.WriteRequest{Block: key, Value: "new"})
+	}
+	reply, err := routerpb.NewRouterClient(conn).Transaction(ctx, request)
+	if err != nil || !reply.Committed {
+		t.Fatalf("expected the transaction to commit but got %v; %v", reply, err)
+	}
+	faulted := 0
+	for _, injector := range injectors {
+		faulted += injector.Faulted(faults.DecideTransactions)
+	}
+	if faulted == 0 {
+		t.Fatalf("expected the decisions to shard node 1 to be dropped")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for _, key := range keys {
+		for {
+			value, err := client.Get(ctx, key)
+			if err == nil && value == "new" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the committed value of %s but got %s; %v", key, value, err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Package treebeard is the client of the treebeard routers for the services that store blocks in treebeard.
+package treebeard
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	routerpb "github.com/dsg-uwaterloo/treebeard/api/router"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Config struct {
+	Routers          []string      // the addresses of the routers, host:port
+	RequestTimeout   time.Duration // it is used for the requests whose context has no deadline, zero means no timeout
+	MaxRetries       int           // the number of times a failed request is sent to another router
+	RetryBackoff     time.Duration // the wait before the first retry, it doubles after every retry
+	EjectionDuration time.Duration // how long a router that failed a request is avoided
+	// The routers are checked with the grpc health service every interval, zero disables the health checks.
+	// A router that fails a health check is avoided until it passes one.
+	HealthCheckInterval time.Duration
+	DialOptions         []grpc.DialOption
+}
+
+const (
+	defaultRequestTimeout      = 10 * time.Second
+	defaultMaxRetries          = 2
+	defaultRetryBackoff        = 50 * time.Millisecond
+	defaultEjectionDuration    = 5 * time.Second
+	defaultHealthCheckInterval = time.Second
+)
+
+// DefaultConfig returns the config with the default timeouts and retries for the routers.
+func DefaultConfig(routers ...string) Config {
+	return Config{
+		Routers:             routers,
+		RequestTimeout:      defaultRequestTimeout,
+		MaxRetries:          defaultMaxRetries,
+		RetryBackoff:        defaultRetryBackoff,
+		EjectionDuration:    defaultEjectionDuration,
+		HealthCheckInterval: defaultHealthCheckInterval,
+	}
+}
+
+// Client sends the requests of a service to the routers.
+// It is safe to use from many goroutines.
+type Client struct {
+	config   Config
+	pool     *routerPool
+	closed   bool
+	closedMu sync.RWMutex
+}
+
+func NewClient(config Config) (*Client, error) {
+	if len(config.Routers) == 0 {
+		return nil, fmt.Errorf("treebeard: at least one router is needed")
+	}
+	if config.MaxRetries < 0 {
+		return nil, fmt.Errorf("treebeard: the max retries should not be negative")
+	}
+	pool, err := newRouterPool(config.Routers, config.EjectionDuration, config.DialOptions)
+	if err != nil {
+		return nil, fmt.Errorf("treebeard: could not connect to the routers; %s", err)
+	}
+	if config.HealthCheckInterval > 0 {
+		pool.startHealthChecks(config.HealthCheckInterval)
+	}
+	return &Client{config: config, pool: pool}, nil
+}
+
+func (c *Client) Close() error {
+	c.closedMu.Lock()
+	defer c.closedMu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.pool.close()
+}
+
+// A request is retried on another router if the router is unavailable or timed out before the deadline of the request.
+func isRetryable(ctx context.Context, err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return true
+	case codes.DeadlineExceeded:
+		return ctx.Err() == nil
+	}
+	return false
+}
+
+// It sends the request to a router and retries it on the other routers.
+// The writes are sent with the same idempotency key on every attempt, so a retry of a write that was applied is not applied again.
+// The shard nodes keep the idempotency keys for the idempotency window (10 minutes by default),
+// so a write should not be retried for longer than that.
+func (c *Client) call(ctx context.Context, op string, block string, send func(ctx context.Context, api routerpb.RouterClient) error) error {
+	c.closedMu.RLock()
+	defer c.closedMu.RUnlock()
+	if c.closed {
+		return &Error{Op: op, Block: block, Kind: ErrClosed}
+	}
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline && c.config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.RequestTimeout)
+		defer cancel()
+	}
+
+	tried := make(map[*routerConn]bool)
+	backoff := c.config.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		router := c.pool.pick(tried)
+		if router == nil {
+			// Every router was tried, so the next attempts can go to any of them
+			tried = make(map[*routerConn]bool)
+			router = c.pool.pick(tried)
+		}
+		tried[router] = true
+		err = send(ctx, router.api)
+		c.pool.release(router)
+		if err == nil {
+			c.pool.markSuccess(router)
+			return nil
+		}
+		if !isRetryable(ctx, err) {
+			return newError(op, block, err)
+		}
+		c.pool.markFailure(router)
+		if attempt == c.config.MaxRetries {
+			break
+		}
+		log.Debug().Msgf("Retrying %s of block %s after error from router %s; %s", op, block, router.address, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return newError(op, block, ctx.Err())
+		}
+		backoff *= 2
+	}
+	return newError(op, block, err)
+}
+
+// Get returns the value of the block, or ErrNotFound if the block does not exist.
+func (c *Client) Get(ctx context.Context, block string) (string, error) {
+	var reply *routerpb.ReadReply
+	err := c.call(ctx, "get", block, func(ctx context.Context, api routerpb.RouterClient) (err error) {
+		reply, err = api.Read(ctx, &routerpb.ReadRequest{Block: block})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if !reply.Exists {
+		return "", &Error{Op: "get", Block: block, Kind: ErrNotFound}
+	}
+	return reply.Value, nil
+}
+
+// GetVersioned returns the value and the version of the block, or ErrNotFound if the block does not exist.
+func (c *Client) GetVersioned(ctx context.Context, block string) (value string, version uint64, err error) {
+	var reply *routerpb.ReadReply
+	err = c.call(ctx, "get", block, func(ctx context.Context, api routerpb.RouterClient) (err error) {
+		reply, err = api.Read(ctx, &routerpb.ReadRequest{Block: block})
+		return err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	if !reply.Exists {
+		return "", reply.Version, &Error{Op: "get", Block: block, Kind: ErrNotFound}
+	}
+	return reply.Value, reply.Version, nil
+}
+
+func (c *Client) Put(ctx context.Context, block string, value string) error {
+	var reply *routerpb.WriteReply
+	idempotencyKey := uuid.New().String()
+	err := c.call(ctx, "put", block, func(ctx context.Context, api routerpb.RouterClient) (err error) {
+		reply, err = api.Write(ctx, &routerpb.WriteRequest{Block: block, Value: value, IdempotencyKey: idempotencyKey})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !reply.Success {
+		return &Error{Op: "put", Block: block, Kind: ErrWriteFailed}
+	}
+	return nil
+}
+
+// Delete removes the block. Deleting a block that does not exist is not an error.
+func (c *Client) Delete(ctx context.Context, block string) error {
+	var reply *routerpb.DeleteReply
+	idempotencyKey := uuid.New().String()
+	err := c.call(ctx, "delete", block, func(ctx context.Context, api routerpb.RouterClient) (err error) {
+		reply, err = api.Delete(ctx, &routerpb.DeleteRequest{Block: block, IdempotencyKey: idempotencyKey})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !reply.Success {
+		return &Error{Op: "delete", Block: block, Kind: ErrWriteFailed}
+	}
+	return nil
+}
+
+func (c *Client) Exists(ctx context.Context, block string) (bool, error) {
+	var reply *routerpb.ExistsReply
+	err := c.call(ctx, "exists", block, func(ctx context.Context, api routerpb.RouterClient) (err error) {
+		reply, err = api.Exists(ctx, &routerpb.ExistsRequest{Block: block})
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return reply.Exists, nil
+}
+
+// CompareAndSwap writes newValue if the block has the expected value and, if expectedVersion is not zero, the expected version.
+// It returns the value and the version of the block after the request.
+// If it is retried after the first router applied it, the retry gets the reply of the first attempt.
+func (c *Client) CompareAndSwap(ctx context.Context, block string, expected string, newValue string, expectedVersion uint64) (swapped bool, value string, version uint64, err error) {
+	var reply *routerpb.CompareAndSwapReply
+	idempotencyKey := uuid.New().String()
+	err = c.call(ctx, "compare and swap", block, func(ctx context.Context, api routerpb.RouterClient) (err error) {
+		reply, err = api.CompareAndSwap(ctx, &routerpb.CompareAndSwapRequest{Block: block, Expected: expected, NewValue: newValue, ExpectedVersion: expectedVersion, IdempotencyKey: idempotencyKey})
+		return err
+	})
+	if err != nil {
+		return false, "", 0, err
+	}
+	return reply.Swapped, reply.Value, reply.Version, nil
+}
+
+// Scan returns up to limit blocks that exist and start with the prefix, in order.
+func (c *Client) Scan(ctx context.Context, prefix string, limit int) ([]string, error) {
+	var reply *routerpb.ScanReply
+	err := c.call(ctx, "scan", prefix, func(ctx context.Context, api routerpb.RouterClient) (err error) {
+		reply, err = api.Scan(ctx, &routerpb.ScanRequest{Prefix: prefix, Limit: int32(limit)})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Blocks, nil
+}
@@ -0,0 +1,291 @@
+package treebeard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	routerpb "github.com/dsg-uwaterloo/treebeard/api/router"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeRouter keeps the blocks in a map. If unavailable is set, it fails every request.
+type fakeRouter struct {
+	routerpb.UnimplementedRouterServer
+	mu          sync.Mutex
+	blocks      map[string]string
+	versions    map[string]uint64
+	requests    int
+	keys        []string // the idempotency keys of the writes that the router received
+	unavailable bool
+	delay       time.Duration
+	health      *health.Server // the router has no health service if it is nil
+}
+
+func newFakeRouter() *fakeRouter {
+	return &fakeRouter{blocks: make(map[string]string), versions: make(map[string]uint64)}
+}
+
+func (f *fakeRouter) start(ctx context.Context) error {
+	f.mu.Lock()
+	f.requests++
+	unavailable, delay := f.unavailable, f.delay
+	f.mu.Unlock()
+	if unavailable {
+		return status.Errorf(codes.Unavailable, "router is down")
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (f *fakeRouter) Read(ctx context.Context, in *routerpb.ReadRequest) (*routerpb.ReadReply, error) {
+	if err := f.start(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, exists := f.blocks[in.Block]
+	return &routerpb.ReadReply{Value: value, Exists: exists, Version: f.versions[in.Block]}, nil
+}
+
+func (f *fakeRouter) recordKey(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = append(f.keys, key)
+}
+
+func (f *fakeRouter) Write(ctx context.Context, in *routerpb.WriteRequest) (*routerpb.WriteReply, error) {
+	f.recordKey(in.IdempotencyKey)
+	if err := f.start(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks[in.Block] = in.Value
+	f.versions[in.Block]++
+	return &routerpb.WriteReply{Success: true}, nil
+}
+
+func (f *fakeRouter) Delete(ctx context.Context, in *routerpb.DeleteRequest) (*routerpb.DeleteReply, error) {
+	f.recordKey(in.IdempotencyKey)
+	if err := f.start(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.blocks, in.Block)
+	return &routerpb.DeleteReply{Success: true}, nil
+}
+
+func (f *fakeRouter) Exists(ctx context.Context, in *routerpb.ExistsRequest) (*routerpb.ExistsReply, error) {
+	if err := f.start(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, exists := f.blocks[in.Block]
+	return &routerpb.ExistsReply{Exists: exists}, nil
+}
+
+func (f *fakeRouter) CompareAndSwap(ctx context.Context, in *routerpb.CompareAndSwapRequest) (*routerpb.CompareAndSwapReply, error) {
+	f.recordKey(in.IdempotencyKey)
+	if err := f.start(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.blocks[in.Block] == in.Expected {
+		f.blocks[in.Block] = in.NewValue
+		f.versions[in.Block]++
+		return &routerpb.CompareAndSwapReply{Swapped: true, Value: in.NewValue, Version: f.versions[in.Block]}, nil
+	}
+	return &routerpb.CompareAndSwapReply{Value: f.blocks[in.Block], Version: f.versions[in.Block]}, nil
+}
+
+func (f *fakeRouter) Scan(ctx context.Context, in *routerpb.ScanRequest) (*routerpb.ScanReply, error) {
+	if err := f.start(ctx); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var blocks []string
+	for block := range f.blocks {
+		if strings.HasPrefix(block, in.Prefix) {
+			blocks = append(blocks, block)
+		}
+	}
+	sort.Strings(blocks)
+	return &routerpb.ScanReply{Blocks: blocks}, nil
+}
+
+func (f *fakeRouter) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// It starts the routers on in-memory listeners and returns a client config for them.
+// The routers share the blocks of the first router.
+func startFakeRouters(t *testing.T, routers ...*fakeRouter) Config {
+	listeners := make(map[string]*bufconn.Listener)
+	var addresses []string
+	for i, router := range routers {
+		router.blocks = routers[0].blocks
+		router.versions = routers[0].versions
+		listener := bufconn.Listen(1024 * 1024)
+		server := grpc.NewServer()
+		routerpb.RegisterRouterServer(server, router)
+		if router.health != nil {
+			healthpb.RegisterHealthServer(server, router.health)
+		}
+		go server.Serve(listener)
+		t.Cleanup(server.Stop)
+		address := "router" + string(rune('0'+i))
+		listeners[address] = listener
+		addresses = append(addresses, address)
+	}
+	config := DefaultConfig(addresses...)
+	config.RetryBackoff = time.Millisecond
+	config.DialOptions = []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return listeners[address].DialContext(ctx)
+		}),
+	}
+	return config
+}
+
+func startTestClient(t *testing.T, config Config) *Client {
+	c, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("could not create the client; %s", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClientPutGetDelete(t *testing.T) {
+	c := startTestClient(t, startFakeRouters(t, newFakeRouter()))
+	ctx := context.Background()
+	if err := c.Put(ctx, "a", "value"); err != nil {
+		t.Errorf("expected the put to succeed but got %v", err)
+	}
+	value, err := c.Get(ctx, "a")
+	if err != nil || value != "value" {
+		t.Errorf("expected value but got %s, %v", value, err)
+	}
+	if err := c.Delete(ctx, "a"); err != nil {
+		t.Errorf("expected the delete to succeed but got %v", err)
+	}
+	_, err = c.Get(ctx, "a")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after the delete but got %v", err)
+	}
+	exists, err := c.Exists(ctx, "a")
+	if err != nil || exists {
+		t.Errorf("expected a not to exist but got %t, %v", exists, err)
+	}
+}
+
+func TestClientCompareAndSwapAndScan(t *testing.T) {
+	c := startTestClient(t, startFakeRouters(t, newFakeRouter()))
+	ctx := context.Background()
+	swapped, _, version, err := c.CompareAndSwap(ctx, "counter", "", "1", 0)
+	if err != nil || !swapped || version != 1 {
+		t.Errorf("expected the swap to succeed but got %t, %d, %v", swapped, version, err)
+	}
+	swapped, value, _, err := c.CompareAndSwap(ctx, "counter", "0", "2", 0)
+	if err != nil || swapped || value != "1" {
+		t.Errorf("expected the swap to fail with the current value but got %t, %s, %v", swapped, value, err)
+	}
+	c.Put(ctx, "user/2", "b")
+	c.Put(ctx, "user/1", "a")
+	blocks, err := c.Scan(ctx, "user/", 10)
+	if err != nil || len(blocks) != 2 || blocks[0] != "user/1" || blocks[1] != "user/2" {
+		t.Errorf("expected [user/1 user/2] but got %v, %v", blocks, err)
+	}
+}
+
+func TestClientRetriesOnAnotherRouterAndEjectsTheFailedOne(t *testing.T) {
+	down := newFakeRouter()
+	down.unavailable = true
+	up := newFakeRouter()
+	c := startTestClient(t, startFakeRouters(t, down, up))
+	for i := 0; i < 10; i++ {
+		if err := c.Put(context.Background(), "a", "value"); err != nil {
+			t.Errorf("expected the put to be retried on the available router but got %v", err)
+		}
+	}
+	if down.requestCount() > 1 {
+		t.Errorf("expected the failed router to be ejected after one request but it got %d", down.requestCount())
+	}
+}
+
+func TestClientRetriesCompareAndSwapWithTheSameIdempotencyKey(t *testing.T) {
+	down := newFakeRouter()
+	down.unavailable = true
+	up := newFakeRouter()
+	config := startFakeRouters(t, down, up)
+	config.HealthCheckInterval = 0
+	c := startTestClient(t, config)
+	// The available router is ejected, so that the first attempt goes to the router that is down
+	for _, router := range c.pool.routers {
+		if router.address == "router1" {
+			c.pool.markFailure(router)
+		}
+	}
+	swapped, _, _, err := c.CompareAndSwap(context.Background(), "a", "", "1", 0)
+	if err != nil || !swapped {
+		t.Errorf("expected the swap to be retried on the available router but got %t, %v", swapped, err)
+	}
+	if len(down.keys) != 1 || len(up.keys) != 1 {
+		t.Fatalf("expected one attempt on every router but got %d and %d", len(down.keys), len(up.keys))
+	}
+	if down.keys[0] == "" || down.keys[0] != up.keys[0] {
+		t.Errorf("expected the attempts to have the same idempotency key but got %q and %q", down.keys[0], up.keys[0])
+	}
+}
+
+func TestClientSendsADifferentIdempotencyKeyForEveryWrite(t *testing.T) {
+	router := newFakeRouter()
+	c := startTestClient(t, startFakeRouters(t, router))
+	c.Put(context.Background(), "a", "1")
+	c.Put(context.Background(), "a", "1")
+	c.Delete(context.Background(), "a")
+	if len(router.keys) != 3 || router.keys[0] == router.keys[1] || router.keys[1] == router.keys[2] {
+		t.Errorf("expected a new idempotency key for every write but got %v", router.keys)
+	}
+}
+
+func TestClientReturnsErrTimeoutAfterTheRequestTimeout(t *testing.T) {
+	slow := newFakeRouter()
+	slow.delay = time.Second
+	config := startFakeRouters(t, slow)
+	config.RequestTimeout = 20 * time.Millisecond
+	c := startTestClient(t, config)
+	_, err := c.Get(context.Background(), "a")
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout but got %v", err)
+	}
+}
+
+func TestClientReturnsErrClosedAfterClose(t *testing.T) {
+	c := startTestClient(t, startFakeRouters(t, newFakeRouter()))
+	c.Close()
+	if err := c.Put(context.Background(), "a", "value"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed but got %v", err)
+	}
+}
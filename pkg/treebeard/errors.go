@@ -0,0 +1,64 @@
+package treebeard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The errors of the client. They can be checked with errors.Is on the errors that the client returns.
+var (
+	ErrNotFound        = errors.New("block not found")
+	ErrWriteFailed     = errors.New("write was not applied")
+	ErrTimeout         = errors.New("request timed out")
+	ErrUnavailable     = errors.New("no router is available")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrClosed          = errors.New("client is closed")
+	ErrRequestFailed   = errors.New("request failed") // the router returned another error
+)
+
+// Error is the error of a request. Kind is one of the errors of the client,
+// and Cause is the error that the router returned, if there is one.
+type Error struct {
+	Op    string
+	Block string
+	Kind  error
+	Cause error
+}
+
+func (e *Error) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("treebeard: %s %s: %s", e.Op, e.Block, e.Kind)
+	}
+	return fmt.Sprintf("treebeard: %s %s: %s; %s", e.Op, e.Block, e.Kind, e.Cause)
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Cause}
+}
+
+// It turns the error of a router call into an error of the client.
+func newError(op string, block string, err error) *Error {
+	var kind error
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Canceled:
+		kind = ErrTimeout
+	case codes.Unavailable:
+		kind = ErrUnavailable
+	case codes.InvalidArgument:
+		kind = ErrInvalidArgument
+	default:
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			kind = ErrTimeout
+		} else {
+			kind = ErrRequestFailed
+		}
+	}
+	return &Error{Op: op, Block: block, Kind: kind, Cause: err}
+}
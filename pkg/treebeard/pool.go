@@ -0,0 +1,168 @@
+package treebeard
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	routerpb "github.com/dsg-uwaterloo/treebeard/api/router"
+	"github.com/dsg-uwaterloo/treebeard/pkg/rpc"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type routerConn struct {
+	address             string
+	api                 routerpb.RouterClient
+	health              healthpb.HealthClient
+	conn                *grpc.ClientConn
+	outstanding         int       // the number of requests that were sent to the router and are not answered yet
+	unhealthyUntil      time.Time // the router failed a request, so it is not picked before this time
+	failingHealthChecks bool      // the last health check of the router failed
+}
+
+func (r *routerConn) isHealthy(now time.Time) bool {
+	return !r.failingHealthChecks && !now.Before(r.unhealthyUntil)
+}
+
+// routerPool keeps one connection to every router.
+// A router that fails a request is ejected for ejectionDuration, and a router that fails a health check
+// is ejected until it passes one. The requests go to the healthy router with the fewest outstanding requests.
+type routerPool struct {
+	mu               sync.Mutex
+	routers          []*routerConn
+	ejectionDuration time.Duration
+	stopHealthChecks chan struct{}
+	healthChecksDone sync.WaitGroup
+}
+
+func newRouterPool(addresses []string, ejectionDuration time.Duration, dialOptions []grpc.DialOption) (*routerPool, error) {
+	options := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(rpc.ContextPropagationUnaryClientInterceptor()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt64), grpc.MaxCallSendMsgSize(math.MaxInt64)),
+	}, dialOptions...)
+	pool := &routerPool{ejectionDuration: ejectionDuration, stopHealthChecks: make(chan struct{})}
+	for _, address := range addresses {
+		log.Debug().Msgf("Starting router client on %s", address)
+		conn, err := grpc.Dial(address, options...)
+		if err != nil {
+			pool.close()
+			return nil, err
+		}
+		pool.routers = append(pool.routers, &routerConn{address: address, api: routerpb.NewRouterClient(conn), health: healthpb.NewHealthClient(conn), conn: conn})
+	}
+	return pool, nil
+}
+
+// It picks the healthy router with the fewest outstanding requests that is not in tried, and counts the request as outstanding.
+// If every router that is not in tried is unhealthy, it picks one of them anyway, since it may have recovered.
+// It returns nil if every router was tried.
+func (p *routerPool) pick(tried map[*routerConn]bool) *routerConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var healthy, unhealthy []*routerConn
+	for _, router := range p.routers {
+		if tried[router] {
+			continue
+		}
+		if router.isHealthy(now) {
+			healthy = append(healthy, router)
+		} else {
+			unhealthy = append(unhealthy, router)
+		}
+	}
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = unhealthy
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	var least []*routerConn
+	for _, router := range candidates {
+		if len(least) == 0 || router.outstanding < least[0].outstanding {
+			least = []*routerConn{router}
+		} else if router.outstanding == least[0].outstanding {
+			least = append(least, router)
+		}
+	}
+	router := least[rand.Intn(len(least))]
+	router.outstanding++
+	return router
+}
+
+// It is called when the request that was sent to the router is answered.
+func (p *routerPool) release(router *routerConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	router.outstanding--
+}
+
+func (p *routerPool) markFailure(router *routerConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	log.Debug().Msgf("Ejecting router %s for %v", router.address, p.ejectionDuration)
+	router.unhealthyUntil = time.Now().Add(p.ejectionDuration)
+}
+
+func (p *routerPool) markSuccess(router *routerConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	router.unhealthyUntil = time.Time{}
+}
+
+// A router that does not have the health service is considered healthy.
+func (p *routerPool) checkHealth(router *routerConn, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	reply, err := router.health.Check(ctx, &healthpb.HealthCheckRequest{Service: routerpb.Router_ServiceDesc.ServiceName})
+	healthy := status.Code(err) == codes.Unimplemented || (err == nil && reply.Status == healthpb.HealthCheckResponse_SERVING)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if healthy == router.failingHealthChecks {
+		log.Debug().Msgf("Router %s is healthy: %t", router.address, healthy)
+	}
+	router.failingHealthChecks = !healthy
+}
+
+// It checks the health of every router every interval until the pool is closed.
+func (p *routerPool) startHealthChecks(interval time.Duration) {
+	for _, router := range p.routers {
+		p.healthChecksDone.Add(1)
+		go func(router *routerConn) {
+			defer p.healthChecksDone.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				p.checkHealth(router, interval)
+				select {
+				case <-ticker.C:
+				case <-p.stopHealthChecks:
+					return
+				}
+			}
+		}(router)
+	}
+}
+
+func (p *routerPool) close() error {
+	close(p.stopHealthChecks)
+	p.healthChecksDone.Wait()
+	var errs []error
+	for _, router := range p.routers {
+		if err := router.conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
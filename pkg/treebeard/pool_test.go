@@ -0,0 +1,67 @@
+package treebeard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	routerpb "github.com/dsg-uwaterloo/treebeard/api/router"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestPickPrefersTheHealthyRouterWithTheFewestOutstandingRequests(t *testing.T) {
+	busy := &routerConn{address: "busy", outstanding: 2}
+	idle := &routerConn{address: "idle", outstanding: 1}
+	ejected := &routerConn{address: "ejected", unhealthyUntil: time.Now().Add(time.Minute)}
+	pool := &routerPool{routers: []*routerConn{busy, idle, ejected}}
+	if router := pool.pick(map[*routerConn]bool{}); router != idle {
+		t.Errorf("expected the idle router but got %s", router.address)
+	}
+	// Both healthy routers have two outstanding requests now
+	if router := pool.pick(map[*routerConn]bool{busy: true, idle: true}); router != ejected {
+		t.Errorf("expected the ejected router when the others were tried but got %s", router.address)
+	}
+	if router := pool.pick(map[*routerConn]bool{busy: true, idle: true, ejected: true}); router != nil {
+		t.Errorf("expected no router when every router was tried but got %s", router.address)
+	}
+	pool.release(idle)
+	pool.release(idle)
+	if idle.outstanding != 0 {
+		t.Errorf("expected no outstanding requests after the release but got %d", idle.outstanding)
+	}
+}
+
+func TestHealthChecksEjectAndRestoreTheRouter(t *testing.T) {
+	router := newFakeRouter()
+	router.health = health.NewServer()
+	router.health.SetServingStatus(routerpb.Router_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	config := startFakeRouters(t, router)
+	config.HealthCheckInterval = 0
+	c := startTestClient(t, config)
+
+	conn := c.pool.routers[0]
+	c.pool.checkHealth(conn, time.Second)
+	if conn.isHealthy(time.Now()) {
+		t.Errorf("expected the router that is not serving to be ejected")
+	}
+	router.health.SetServingStatus(routerpb.Router_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	c.pool.checkHealth(conn, time.Second)
+	if !conn.isHealthy(time.Now()) {
+		t.Errorf("expected the router to be healthy after it passed a health check")
+	}
+	if err := c.Put(context.Background(), "a", "value"); err != nil {
+		t.Errorf("expected the put to succeed but got %v", err)
+	}
+}
+
+func TestHealthChecksTreatRoutersWithoutTheHealthServiceAsHealthy(t *testing.T) {
+	config := startFakeRouters(t, newFakeRouter())
+	config.HealthCheckInterval = 0
+	c := startTestClient(t, config)
+	conn := c.pool.routers[0]
+	c.pool.checkHealth(conn, time.Second)
+	if !conn.isHealthy(time.Now()) {
+		t.Errorf("expected a router without the health service to be healthy")
+	}
+}
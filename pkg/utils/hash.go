@@ -1,31 +1,65 @@
 package utils
 
 import (
+	"container/list"
 	"hash/fnv"
 	"sync"
 )
 
+type hashCacheEntry struct {
+	key  string
+	hash uint32
+}
+
 // Hahsher is thread-safe.
-// If you see contention on the mutex,
-// you may want to consider a sync.Map.
-// Since we only write to the map once per key,
-// and read from it many times, it might make sense.
+// It keeps the hashes of the most recently used keys,
+// so the cache does not grow with every key ever seen.
 type Hasher struct {
-	KnownHashes map[string]uint32
-	mu          sync.Mutex
+	cacheSize int
+	entries   map[string]*list.Element
+	recent    *list.List // the front is the most recently used key
+	mu        sync.Mutex
+}
+
+func NewHasher(cacheSize int) *Hasher {
+	return &Hasher{
+		cacheSize: cacheSize,
+		entries:   make(map[string]*list.Element),
+		recent:    list.New(),
+	}
+}
+
+func fnv32a(s string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(s))
+	return hash.Sum32()
 }
 
 // It calculates hash of a string.
-// If the hash is already known it uses KnownHashes.
+// If the hash is already known it uses the cache.
 func (h *Hasher) Hash(s string) uint32 {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	if hash, exists := h.KnownHashes[s]; exists {
-		return hash
+	if element, exists := h.entries[s]; exists {
+		h.recent.MoveToFront(element)
+		return element.Value.(*hashCacheEntry).hash
+	}
+	val := fnv32a(s)
+	if h.cacheSize <= 0 {
+		return val
+	}
+	h.entries[s] = h.recent.PushFront(&hashCacheEntry{key: s, hash: val})
+	if h.recent.Len() > h.cacheSize {
+		oldest := h.recent.Back()
+		h.recent.Remove(oldest)
+		delete(h.entries, oldest.Value.(*hashCacheEntry).key)
 	}
-	hash := fnv.New32a()
-	hash.Write([]byte(s))
-	val := hash.Sum32()
-	h.KnownHashes[s] = val
 	return val
 }
+
+// It returns the number of cached hashes.
+func (h *Hasher) CacheLen() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.recent.Len()
+}
@@ -24,7 +24,7 @@ var hashTestCases = []hashTest{
 }
 
 func TestHash(t *testing.T) {
-	hasher := utils.Hasher{KnownHashes: make(map[string]uint32)}
+	hasher := utils.NewHasher(4)
 	for _, test := range hashTestCases {
 		output := hasher.Hash(test.input)
 		if output != test.expectedHash {
@@ -33,3 +33,13 @@ func TestHash(t *testing.T) {
 		}
 	}
 }
+
+func TestHashKeepsOnlyRecentlyUsedKeys(t *testing.T) {
+	hasher := utils.NewHasher(2)
+	for _, key := range []string{"a", "b", "a", "c", "d"} {
+		hasher.Hash(key)
+	}
+	if hasher.CacheLen() != 2 {
+		t.Errorf("expected 2 cached hashes but got %d", hasher.CacheLen())
+	}
+}
@@ -0,0 +1,5 @@
+package utils
+
+// PaddingBlock is the block that the fake requests of the routers read.
+// Every shard node answers it, even after a scale out, and the clients can not write it.
+const PaddingBlock = "__padding__"
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// HashRing places keys on nodes with consistent hashing.
+// Every node has virtualNodes points on the ring, and a key belongs to the first point after its hash.
+// Adding or removing a node only moves the keys between the node and its neighbours on the ring.
+// HashRing is thread-safe.
+type HashRing struct {
+	virtualNodes int
+	points       []uint32       // sorted hashes of the virtual nodes
+	owners       map[uint32]int // map of virtual node hash to node id
+	nodes        map[int]bool
+	hasher       *Hasher
+	mu           sync.RWMutex
+}
+
+func NewHashRing(virtualNodes int, hasher *Hasher) *HashRing {
+	return &HashRing{
+		virtualNodes: virtualNodes,
+		owners:       make(map[uint32]int),
+		nodes:        make(map[int]bool),
+		hasher:       hasher,
+	}
+}
+
+// fnv32a of short similar strings is not spread well over the ring,
+// so the hashes are mixed with the finalizer of murmur3.
+func mix(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+func virtualNodeHash(nodeID int, replica int) uint32 {
+	return mix(fnv32a(strconv.Itoa(nodeID) + "#" + strconv.Itoa(replica)))
+}
+
+func (r *HashRing) AddNode(nodeID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nodes[nodeID] {
+		return
+	}
+	r.nodes[nodeID] = true
+	for i := 0; i < r.virtualNodes; i++ {
+		point := virtualNodeHash(nodeID, i)
+		// On a collision the point stays with the node that has the smaller id, so the ring does not depend on the order of the calls.
+		if owner, exists := r.owners[point]; exists {
+			if owner > nodeID {
+				r.owners[point] = nodeID
+			}
+			continue
+		}
+		r.owners[point] = nodeID
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+func (r *HashRing) RemoveNode(nodeID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.nodes[nodeID] {
+		return
+	}
+	delete(r.nodes, nodeID)
+	r.points = r.points[:0]
+	for point, owner := range r.owners {
+		if owner == nodeID {
+			delete(r.owners, point)
+		}
+	}
+	// The points that the removed node won on a collision go back to the other nodes.
+	for otherNodeID := range r.nodes {
+		for i := 0; i < r.virtualNodes; i++ {
+			point := virtualNodeHash(otherNodeID, i)
+			if owner, exists := r.owners[point]; !exists || owner > otherNodeID {
+				r.owners[point] = otherNodeID
+			}
+		}
+	}
+	for point := range r.owners {
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// It returns the sorted ids of the nodes on the ring.
+func (r *HashRing) Nodes() (nodeIDs []int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for nodeID := range r.nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Ints(nodeIDs)
+	return nodeIDs
+}
+
+func (r *HashRing) HasNode(nodeID int) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, exists := r.nodes[nodeID]
+	return exists
+}
+
+// It returns the node of the key, or -1 if the ring has no nodes.
+func (r *HashRing) GetNode(key string) (nodeID int) {
+	hash := mix(r.hasher.Hash(key))
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.points) == 0 {
+		return -1
+	}
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
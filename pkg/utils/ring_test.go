@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestGetNodeReturnsMinusOneForEmptyRing(t *testing.T) {
+	r := NewHashRing(10, NewHasher(10))
+	if r.GetNode("a") != -1 {
+		t.Errorf("expected -1 for a ring without nodes")
+	}
+}
+
+func TestHashRingSpreadsKeysOverNodes(t *testing.T) {
+	r := NewHashRing(100, NewHasher(0))
+	for nodeID := 0; nodeID < 4; nodeID++ {
+		r.AddNode(nodeID)
+	}
+	counts := make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		counts[r.GetNode("key"+strconv.Itoa(i))]++
+	}
+	for nodeID := 0; nodeID < 4; nodeID++ {
+		if counts[nodeID] < 1500 || counts[nodeID] > 3500 {
+			t.Errorf("expected about 2500 keys on node %d but got %d", nodeID, counts[nodeID])
+		}
+	}
+}
+
+func TestRemoveNodeOnlyMovesKeysOfRemovedNode(t *testing.T) {
+	r := NewHashRing(100, NewHasher(0))
+	for nodeID := 0; nodeID < 3; nodeID++ {
+		r.AddNode(nodeID)
+	}
+	before := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before[key] = r.GetNode(key)
+	}
+	r.RemoveNode(1)
+	for key, nodeID := range before {
+		after := r.GetNode(key)
+		if after == 1 || (nodeID != 1 && after != nodeID) {
+			t.Errorf("key %s moved from node %d to node %d", key, nodeID, after)
+		}
+	}
+}
+
+func TestNodesReturnsSortedNodeIDs(t *testing.T) {
+	ring := NewHashRing(10, NewHasher(100))
+	for _, nodeID := range []int{2, 0, 1} {
+		ring.AddNode(nodeID)
+	}
+	ring.RemoveNode(1)
+	nodes := ring.Nodes()
+	if len(nodes) != 2 || nodes[0] != 0 || nodes[1] != 2 {
+		t.Errorf("expected nodes [0 2] but got %v", nodes)
+	}
+}
+
+func TestHasNodeReturnsWhetherTheNodeIsOnTheRing(t *testing.T) {
+	r := NewHashRing(10, NewHasher(10))
+	r.AddNode(1)
+	if !r.HasNode(1) || r.HasNode(2) {
+		t.Errorf("expected only node 1 on the ring")
+	}
+	r.RemoveNode(1)
+	if r.HasNode(1) {
+		t.Errorf("expected node 1 to be removed")
+	}
+}
@@ -8,3 +8,6 @@ protoc --proto_path=$PROTOBUF_PATH --go_out=$PROTOBUF_PATH/shardnode --go_opt=pa
 
 mkdir -p $PROTOBUF_PATH/oramnode
 protoc --proto_path=$PROTOBUF_PATH --go_out=$PROTOBUF_PATH/oramnode --go_opt=paths=source_relative --go-grpc_out=$PROTOBUF_PATH/oramnode --go-grpc_opt=paths=source_relative oramnode.proto
+
+mkdir -p $PROTOBUF_PATH/admin
+protoc --proto_path=$PROTOBUF_PATH --go_out=$PROTOBUF_PATH/admin --go_opt=paths=source_relative --go-grpc_out=$PROTOBUF_PATH/admin --go-grpc_opt=paths=source_relative admin.proto